@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/redis/go-redis/v9"
+)
+
+// config is the limiters config file.
+type config struct {
+	Limiters map[string]limiterConfig `json:"limiters"`
+}
+
+// limiterConfig configures a limiter. Which fields apply depends on the
+// algorithm, as with the constructor's options.
+type limiterConfig struct {
+	Algorithm string   `json:"algorithm"`
+	Limit     int64    `json:"limit"`
+	Window    duration `json:"window"`
+	Burst     int64    `json:"burst"`
+	Rate      float64  `json:"rate"`
+}
+
+// duration is a time.Duration written like "1m30s" in JSON.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func loadConfig(path string) (config, error) {
+	var cfg config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(cfg.Limiters) == 0 {
+		return cfg, fmt.Errorf("%s: no limiters", path)
+	}
+	return cfg, nil
+}
+
+// limiters returns the configured limiters by name. Each limiter's keys
+// are namespaced by its name, so limiters don't share counts.
+func (cfg config) limiters(rdb redis.UniversalClient) (map[string]ratelimiter.Limiter, error) {
+	limiters := make(map[string]ratelimiter.Limiter, len(cfg.Limiters))
+	for name, lc := range cfg.Limiters {
+		l, err := lc.limiter(rdb, name)
+		if err != nil {
+			return nil, fmt.Errorf("limiter %q: %w", name, err)
+		}
+		limiters[name] = l
+	}
+	return limiters, nil
+}
+
+func (lc limiterConfig) limiter(rdb redis.UniversalClient, name string) (l ratelimiter.Limiter, err error) {
+	// The constructors panic on invalid settings
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	opts := []ratelimiter.Option{ratelimiter.WithNamespace("ratelimitd", name)}
+	if lc.Limit > 0 {
+		opts = append(opts, ratelimiter.WithLimit(lc.Limit))
+	}
+	if lc.Window > 0 {
+		opts = append(opts, ratelimiter.WithWindow(time.Duration(lc.Window)))
+	}
+	if lc.Burst > 0 {
+		opts = append(opts, ratelimiter.WithBurst(lc.Burst))
+	}
+	if lc.Rate > 0 {
+		opts = append(opts, ratelimiter.WithRate(lc.Rate))
+	}
+
+	switch lc.Algorithm {
+	case ratelimiter.AlgorithmFixedWindow:
+		return ratelimiter.NewFixedWindow(rdb, opts...), nil
+	case ratelimiter.AlgorithmSlidingLog:
+		return ratelimiter.NewSlidingLog(rdb, opts...), nil
+	case ratelimiter.AlgorithmSlidingCounter:
+		// Keeps both window counters in one slot on Redis Cluster
+		return ratelimiter.NewSlidingCounter(rdb, append(opts, ratelimiter.WithHashTags())...), nil
+	case ratelimiter.AlgorithmTokenBucket:
+		return ratelimiter.NewTokenBucket(rdb, opts...), nil
+	case ratelimiter.AlgorithmLeakyBucket:
+		return ratelimiter.NewLeakyBucket(rdb, opts...), nil
+	case ratelimiter.AlgorithmGCRA:
+		return ratelimiter.NewGCRA(rdb, opts...), nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %q", lc.Algorithm)
+}
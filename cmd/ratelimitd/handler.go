@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/redis/go-redis/v9"
+)
+
+// request is the body of the limiter endpoints.
+type request struct {
+	Key  string `json:"key"`
+	Cost int64  `json:"cost"`
+}
+
+// result is the answer of allow and check.
+type result struct {
+	Allowed      bool      `json:"allowed"`
+	Limit        int64     `json:"limit"`
+	Remaining    int64     `json:"remaining"`
+	ResetAt      time.Time `json:"reset_at"`
+	RetryAfterMS int64     `json:"retry_after_ms"`
+}
+
+func newHandler(rdb redis.UniversalClient, limiters map[string]ratelimiter.Limiter) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/limiters/{name}/{op}", func(w http.ResponseWriter, r *http.Request) {
+		l, ok := limiters[r.PathValue("name")]
+		if !ok {
+			httpError(w, http.StatusNotFound, "unknown limiter")
+			return
+		}
+		// A request without a cost costs one
+		req := request{Cost: 1}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil || req.Key == "" {
+			httpError(w, http.StatusBadRequest, "body must be JSON with a key")
+			return
+		}
+		if req.Cost <= 0 {
+			httpError(w, http.StatusBadRequest, "cost must be positive")
+			return
+		}
+
+		var res ratelimiter.Result
+		var err error
+		switch r.PathValue("op") {
+		case "allow":
+			res, err = l.AllowN(r.Context(), req.Key, req.Cost)
+		case "check":
+			res, err = l.Check(r.Context(), req.Key)
+		case "reset":
+			if err = l.Reset(r.Context(), req.Key); err == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		default:
+			httpError(w, http.StatusNotFound, "unknown operation")
+			return
+		}
+		if err != nil {
+			decisionError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result{
+			Allowed:      res.Allowed,
+			Limit:        res.Limit,
+			Remaining:    res.Remaining,
+			ResetAt:      res.ResetAt,
+			RetryAfterMS: res.RetryAfter.Milliseconds(),
+		})
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := ratelimiter.Healthy(r.Context(), rdb); err != nil {
+			httpError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// decisionError answers a request no decision could be made for: 400 Bad
+// Request for a key the limiter can't store, e.g. one with braces for a
+// sliding_counter limiter, 503 Service Unavailable when Redis failed and
+// 500 Internal Server Error otherwise.
+func decisionError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ratelimiter.ErrInvalidKey) {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.ErrorContext(r.Context(), "ratelimitd: no decision", "path", r.URL.Path, "error", err)
+	if errors.Is(err, ratelimiter.ErrStoreUnavailable) {
+		httpError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	httpError(w, http.StatusInternalServerError, err.Error())
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
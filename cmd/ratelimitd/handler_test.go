@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestHandler returns the handler of a fixed_window limiter "login"
+// allowing one request a minute and a sliding_counter limiter "api", on an
+// in-process Redis.
+func newTestHandler(t *testing.T) http.Handler {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	cfg := config{Limiters: map[string]limiterConfig{
+		"login": {Algorithm: "fixed_window", Limit: 1, Window: duration(time.Minute)},
+		"api":   {Algorithm: "sliding_counter", Limit: 10, Window: duration(time.Minute)},
+	}}
+	limiters, err := cfg.limiters(rdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newHandler(rdb, limiters)
+}
+
+// post posts body to path and returns the response.
+func post(h http.Handler, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	return w
+}
+
+func TestHandlerAllow(t *testing.T) {
+	h := newTestHandler(t)
+
+	for i, want := range []result{
+		{Allowed: true, Limit: 1, Remaining: 0},
+		{Allowed: false, Limit: 1, Remaining: 0},
+	} {
+		w := post(h, "/v1/limiters/login/allow", `{"key": "user:1"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i, w.Code)
+		}
+		var got result
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Allowed != want.Allowed || got.Limit != want.Limit || got.Remaining != want.Remaining {
+			t.Errorf("request %d = %+v, want %+v", i, got, want)
+		}
+		if got.ResetAt.IsZero() {
+			t.Errorf("request %d without reset_at", i)
+		}
+		if !got.Allowed && (got.RetryAfterMS <= 0 || got.RetryAfterMS > time.Minute.Milliseconds()) {
+			t.Errorf("request %d: retry_after_ms = %d, want within the window", i, got.RetryAfterMS)
+		}
+	}
+
+	if w := post(h, "/v1/limiters/login/reset", `{"key": "user:1"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("reset status = %d, want 204", w.Code)
+	}
+	if w := post(h, "/v1/limiters/login/check", `{"key": "user:1"}`); !strings.Contains(w.Body.String(), `"allowed":true`) {
+		t.Errorf("check after reset = %s, want allowed", w.Body)
+	}
+}
+
+func TestHandlerBadRequest(t *testing.T) {
+	h := newTestHandler(t)
+
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/v1/limiters/nope/allow", `{"key": "k"}`, http.StatusNotFound},
+		{"/v1/limiters/login/nope", `{"key": "k"}`, http.StatusNotFound},
+		{"/v1/limiters/login/allow", `{}`, http.StatusBadRequest},
+		{"/v1/limiters/login/allow", `{"key": "k", "cost": -1}`, http.StatusBadRequest},
+		// sliding_counter uses hash tags, which keys can't contain
+		{"/v1/limiters/api/allow", `{"key": "{user}"}`, http.StatusBadRequest},
+	} {
+		if w := post(h, tc.path, tc.body); w.Code != tc.want {
+			t.Errorf("POST %s %s = %d %s, want %d", tc.path, tc.body, w.Code, w.Body, tc.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Command ratelimitd serves the limiters to other processes on the host,
+// so services not written in Go can use them, with the daemon owning the
+// Redis connection pool:
+//
+//	ratelimitd -addr redis:6379 -config limiters.json -listen unix:/run/ratelimitd.sock
+//
+// A Unix socket is only accessible to the daemon's user unless -socket-mode
+// allows more, e.g. -socket-mode 0660 for its group. A socket left at its
+// path by a previous run is replaced, but any other file is an error.
+//
+// The limiters are named in the config file:
+//
+//	{
+//		"limiters": {
+//			"api":   {"algorithm": "token_bucket", "burst": 20, "rate": 10},
+//			"login": {"algorithm": "fixed_window", "limit": 5, "window": "1m"}
+//		}
+//	}
+//
+// and decide requests posted to their endpoints:
+//
+//	POST /v1/limiters/{name}/allow  {"key": "user:123", "cost": 1}
+//	POST /v1/limiters/{name}/check  {"key": "user:123"}
+//	POST /v1/limiters/{name}/reset  {"key": "user:123"}
+//	GET  /healthz
+//
+// The cost of allow defaults to 1 and must be positive, or the request is
+// answered 400 Bad Request, like one whose key the limiter can't store, e.g.
+// a key with braces for sliding_counter, which keeps its counters in one
+// Redis Cluster slot. allow and check answer 200 OK with the result
+// whether or not the request is allowed, e.g.
+//
+//	{"allowed": false, "limit": 5, "remaining": 0, "reset_at": "2025-01-02T15:04:05Z", "retry_after_ms": 41000}
+//
+// and 503 Service Unavailable when Redis can't be reached.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	addr := flag.String("addr", "localhost:6379", "Redis address, or comma separated Redis Cluster node or Sentinel addresses")
+	master := flag.String("sentinel", "", "Sentinel master name; -addr then lists the Sentinels")
+	user := flag.String("user", "", "Redis ACL username")
+	db := flag.Int("db", 0, "Redis database index")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	poolSize := flag.Int("pool", 0, "Redis connections per node; 0 for go-redis' default")
+	configPath := flag.String("config", "ratelimitd.json", "limiters config file")
+	listen := flag.String("listen", "localhost:8081", "address to serve on, or unix:<path> for a Unix socket")
+	socketMode := flag.String("socket-mode", "0600", "octal permissions of the Unix socket")
+	flag.Parse()
+
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		fmt.Fprintf(os.Stderr, "ratelimitd: invalid -socket-mode %q\n", *socketMode)
+		os.Exit(2)
+	}
+
+	opts := []ratelimiter.ClientOption{ratelimiter.WithDB(*db)}
+	if *master != "" {
+		opts = append(opts, ratelimiter.WithSentinel(*master))
+	}
+	// The password is read from the environment so it doesn't show up in ps
+	if password := os.Getenv("REDIS_PASSWORD"); *user != "" || password != "" {
+		opts = append(opts, ratelimiter.WithCredentials(*user, password))
+	}
+	if *useTLS {
+		opts = append(opts, ratelimiter.WithTLS(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	if *poolSize > 0 {
+		opts = append(opts, ratelimiter.WithPoolSize(*poolSize))
+	}
+	rdb := ratelimiter.NewClient(strings.Split(*addr, ","), opts...)
+	defer rdb.Close()
+
+	if err := serve(ctx, rdb, *configPath, *listen, os.FileMode(mode)); err != nil {
+		fmt.Fprintln(os.Stderr, "ratelimitd:", err)
+		os.Exit(1)
+	}
+}
+
+// serve serves the limiters configured in configPath on listen until ctx
+// is done. A Unix socket gets mode as its permissions.
+func serve(ctx context.Context, rdb redis.UniversalClient, configPath, listen string, mode os.FileMode) error {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := ratelimiter.Ping(ctx, rdb); err != nil {
+		return err
+	}
+
+	limiters, err := cfg.limiters(rdb)
+	if err != nil {
+		return err
+	}
+	ln, err := listener(listen, mode)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           newHandler(rdb, limiters),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("ratelimitd: serving", "addr", ln.Addr().String(), "limiters", len(limiters))
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listener listens on addr, a TCP address or unix:<path>. A socket file
+// left behind by a previous run is removed first, but no other kind of file.
+// The socket is created with mode rather than the permissions left by the
+// umask, so it is never accessible to more users, even briefly.
+func listener(addr string, mode os.FileMode) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	fi, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case fi.Mode().Type() != os.ModeSocket:
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	default:
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	var ln net.Listener
+	err = withUmask(^mode&0o777, func() (err error) {
+		ln, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The umask can only take permissions away, and isn't applied everywhere
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenerUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimitd.sock")
+
+	ln, err := listener("unix:"+path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep the socket file, as a process that died would
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0o600 {
+		t.Errorf("socket mode = %o, want 600", got)
+	}
+
+	// A socket left behind by a previous run is replaced
+	ln, err = listener("unix:"+path, 0o660)
+	if err != nil {
+		t.Fatalf("listener over a stale socket: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenerNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listener("unix:"+path, 0o600); err == nil {
+		ln.Close()
+		t.Fatal("listener replaced a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+}
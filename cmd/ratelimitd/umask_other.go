@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// withUmask runs fn. There is no umask to set outside Unix.
+func withUmask(_ os.FileMode, fn func() error) error {
+	return fn()
+}
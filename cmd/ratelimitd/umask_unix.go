@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// withUmask runs fn with the process umask set to mask. The umask is
+// process wide, so it is only used while nothing else creates files.
+func withUmask(mask os.FileMode, fn func() error) error {
+	defer syscall.Umask(syscall.Umask(int(mask)))
+	return fn()
+}
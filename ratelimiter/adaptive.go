@@ -0,0 +1,413 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AIMD controls how an Adaptive limiter changes its rate: additive increase
+// while requests succeed, multiplicative decrease when they fail.
+type AIMD struct {
+	// MinRate and MaxRate bound the rate in requests per second.
+	// Keys start at MaxRate.
+	MinRate float64
+	MaxRate float64
+	// Increase is added to the rate for every successful request.
+	Increase float64
+	// Decrease multiplies the rate for every failed request, e.g. 0.5
+	// halves it. It must be between 0 and 1.
+	Decrease float64
+}
+
+func (a AIMD) validate() error {
+	if !(a.MinRate > 0) || math.IsInf(a.MaxRate, 0) || !(a.MaxRate >= a.MinRate) {
+		return fmt.Errorf("ratelimiter: AIMD rates must satisfy 0 < MinRate <= MaxRate, got %v and %v", a.MinRate, a.MaxRate)
+	}
+	if !(a.Increase >= 0) {
+		return fmt.Errorf("ratelimiter: AIMD increase must not be negative, got %v", a.Increase)
+	}
+	if !(a.Decrease > 0 && a.Decrease < 1) {
+		return fmt.Errorf("ratelimiter: AIMD decrease must be between 0 and 1, got %v", a.Decrease)
+	}
+	return nil
+}
+
+// A token bucket whose refill rate is stored next to the tokens, so every
+// instance uses the rate learned from all of them.
+var adaptiveScript = newScript(`
+	local key = KEYS[1]
+	local capacity = tonumber(ARGV[1])
+	local initial_rate = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+	local ttl = tonumber(ARGV[5])
+
+	local tokens = tonumber(redis.call('HGET', key, 'tokens') or capacity)
+	local last = tonumber(redis.call('HGET', key, 'last') or now)
+	local rate = tonumber(redis.call('HGET', key, 'rate') or initial_rate)
+
+	tokens = math.min(capacity, tokens + (now - last) * rate)
+
+	local allowed = 0
+	if tokens >= cost then
+		tokens = tokens - cost
+		allowed = 1
+	end
+
+	redis.call('HSET', key, 'tokens', tokens, 'last', now, 'rate', rate)
+	redis.call('EXPIRE', key, ttl)
+
+	-- Floats are truncated when converted to Redis replies, so return them as strings
+	return {allowed, tostring(tokens), tostring(rate)}
+`)
+
+// Applies one outcome reported by the caller to the stored rate.
+var adaptiveReportScript = newScript(`
+	local key = KEYS[1]
+	local min_rate = tonumber(ARGV[1])
+	local max_rate = tonumber(ARGV[2])
+	local increase = tonumber(ARGV[3])
+	local decrease = tonumber(ARGV[4])
+	local success = ARGV[5] == '1'
+	local ttl = tonumber(ARGV[6])
+
+	local rate = tonumber(redis.call('HGET', key, 'rate') or max_rate)
+	if success then
+		rate = math.min(max_rate, rate + increase)
+	else
+		rate = math.max(min_rate, rate * decrease)
+	end
+
+	redis.call('HSET', key, 'rate', rate)
+	redis.call('EXPIRE', key, ttl)
+	return tostring(rate)
+`)
+
+// Adaptive limiter
+// Token bucket whose rate adapts to the health of what it protects, like
+// TCP congestion control: the caller reports the outcome of each request
+// with ReportResult, and the rate grows additively while requests succeed
+// and is cut multiplicatively when they fail. The rate is stored in Redis, or
+// the Store given with WithStore, so all instances converge on it. Useful for
+// client-side throttling against flaky upstreams.
+type Adaptive struct {
+	client redis.UniversalClient
+	aimd   AIMD
+	config
+}
+
+// NewAdaptive returns a limiter with bursts of up to WithBurst requests and
+// a rate adjusted within aimd.
+// It panics if aimd or the options are invalid.
+func NewAdaptive(client redis.UniversalClient, aimd AIMD, opts ...Option) *Adaptive {
+	if err := aimd.validate(); err != nil {
+		panic(err.Error())
+	}
+	cfg := newConfig(opts)
+	cfg.rate = aimd.MaxRate
+	cfg.mustValidateBucket()
+	cfg.preloadScripts(client)
+	return &Adaptive{client: client, aimd: aimd, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+// Only the burst of a LimitProvider limit is used; the rate is adaptive.
+func (l *Adaptive) forKey(ctx context.Context, key string) (*Adaptive, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmAdaptive, Key: key, Err: err}
+	}
+	return &Adaptive{client: l.client, aimd: l.aimd, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *Adaptive) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *Adaptive) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *Adaptive) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+func (l *Adaptive) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmAdaptive, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmAdaptive, key, res), nil
+}
+
+func (l *Adaptive) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("adaptive", key)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		var allowed bool
+		var tokens, rate float64
+		err := updateState(ctx, l.store, redisKey, func(st *adaptiveState, _ time.Duration) (bool, time.Duration) {
+			tokens, rate = l.refill(*st, now)
+			if allowed = tokens >= float64(n); allowed {
+				tokens -= float64(n)
+			}
+			*st = adaptiveState{Tokens: tokens, Last: seconds(now), Rate: rate}
+			return true, l.ttl()
+		})
+		if err != nil {
+			return Result{}, storeError(AlgorithmAdaptive, key, err)
+		}
+		return l.result(allowed, tokens, rate, n, now), nil
+	}
+	result, err := adaptiveScript.Run(ctx, l.client, []string{redisKey},
+		l.capacity, l.aimd.MaxRate, seconds(now), n, l.ttlSeconds()).Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmAdaptive, key, err)
+	}
+	// Redis Lua returns {allowed, tokens, rate} with allowed as int64 and the rest as strings
+	if len(result) != 3 {
+		return Result{}, replyError(AlgorithmAdaptive, key, result)
+	}
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return Result{}, replyError(AlgorithmAdaptive, key, result)
+	}
+	tokens, err := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+	if err != nil {
+		return Result{}, replyError(AlgorithmAdaptive, key, result)
+	}
+	rate, err := strconv.ParseFloat(fmt.Sprint(result[2]), 64)
+	if err != nil {
+		return Result{}, replyError(AlgorithmAdaptive, key, result)
+	}
+	return l.result(allowed == 1, tokens, rate, n, now), nil
+}
+
+// result builds the Result of a request costing n with tokens left at rate.
+func (l *Adaptive) result(allowed bool, tokens, rate float64, n int64, now time.Time) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int64(l.capacity),
+		Remaining: max(0, int64(tokens)),
+		ResetAt:   now.Add(durationOf((l.capacity - tokens) / rate)),
+	}
+	if !res.Allowed {
+		res.RetryAfter = durationOf((float64(n) - tokens) / rate)
+	}
+	return res
+}
+
+// ReportResult adjusts the rate for key after a request allowed by the
+// limiter finished: a nil err increases it by AIMD.Increase, any other
+// error multiplies it by AIMD.Decrease. It returns the new rate.
+func (l *Adaptive) ReportResult(ctx context.Context, key string, err error) (float64, error) {
+	l, ferr := l.forKey(ctx, key)
+	if ferr != nil {
+		return 0, ferr
+	}
+	redisKey, kerr := l.key("adaptive", key)
+	if kerr != nil {
+		return 0, kerr
+	}
+	if l.store != nil {
+		var rate float64
+		serr := updateState(ctx, l.store, redisKey, func(st *adaptiveState, _ time.Duration) (bool, time.Duration) {
+			rate = st.Rate
+			if rate == 0 {
+				rate = l.aimd.MaxRate
+			}
+			if err == nil {
+				rate = min(l.aimd.MaxRate, rate+l.aimd.Increase)
+			} else {
+				rate = max(l.aimd.MinRate, rate*l.aimd.Decrease)
+			}
+			st.Rate = rate
+			return true, l.ttl()
+		})
+		if serr != nil {
+			return 0, storeError(AlgorithmAdaptive, key, serr)
+		}
+		return rate, nil
+	}
+	success := "0"
+	if err == nil {
+		success = "1"
+	}
+	rate, rerr := adaptiveReportScript.Run(ctx, l.client, []string{redisKey},
+		l.aimd.MinRate, l.aimd.MaxRate, l.aimd.Increase, l.aimd.Decrease, success, l.ttlSeconds()).Float64()
+	if rerr != nil {
+		return 0, storeError(AlgorithmAdaptive, key, rerr)
+	}
+	return rate, nil
+}
+
+// Check reports the state for key without taking a token.
+func (l *Adaptive) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	tokens, rate, err := l.state(ctx, key, now)
+	if err != nil {
+		return Result{}, err
+	}
+	return l.result(tokens >= 1, tokens, rate, 1, now), nil
+}
+
+// Reset forgets the learned rate and refills the bucket for key.
+func (l *Adaptive) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("adaptive", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmAdaptive, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmAdaptive, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *Adaptive) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("adaptive", key)
+	if err != nil {
+		return State{}, err
+	}
+	var tokens, rate float64
+	var ttl time.Duration
+	if l.store != nil {
+		st, expiresIn, err := l.storedState(ctx, redisKey)
+		if err != nil {
+			return State{}, storeError(AlgorithmAdaptive, key, err)
+		}
+		tokens, rate = l.refill(st, now)
+		ttl = expiresIn
+	} else {
+		if tokens, rate, err = l.state(ctx, key, now); err != nil {
+			return State{}, err
+		}
+		if ttl, err = l.client.PTTL(ctx, redisKey).Result(); err != nil {
+			return State{}, storeError(AlgorithmAdaptive, key, err)
+		}
+	}
+	return State{
+		Algorithm: AlgorithmAdaptive,
+		Key:       key,
+		Limit:     int64(l.capacity),
+		Available: tokens,
+		Rate:      rate,
+		TTL:       max(0, ttl),
+	}, nil
+}
+
+// state reads the bucket for key and returns the tokens available at now
+// and the current rate, the same way the Lua script computes them.
+func (l *Adaptive) state(ctx context.Context, key string, now time.Time) (tokens, rate float64, err error) {
+	redisKey, err := l.key("adaptive", key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if l.store != nil {
+		st, _, err := l.storedState(ctx, redisKey)
+		if err != nil {
+			return 0, 0, storeError(AlgorithmAdaptive, key, err)
+		}
+		tokens, rate = l.refill(st, now)
+		return tokens, rate, nil
+	}
+	state, err := l.client.HMGet(ctx, redisKey, "tokens", "last", "rate").Result()
+	if err != nil {
+		return 0, 0, storeError(AlgorithmAdaptive, key, err)
+	}
+	rate, err = strconv.ParseFloat(fmt.Sprint(state[2]), 64)
+	if err != nil {
+		rate = l.aimd.MaxRate
+	}
+	tokens, err = strconv.ParseFloat(fmt.Sprint(state[0]), 64)
+	if err != nil {
+		return l.capacity, rate, nil
+	}
+	last, err := strconv.ParseFloat(fmt.Sprint(state[1]), 64)
+	if err != nil {
+		return l.capacity, rate, nil
+	}
+	return min(l.capacity, tokens+max(0, seconds(now)-last)*rate), rate, nil
+}
+
+// adaptiveState is the bucket of a key kept in a Store, last refilled at
+// Last in unix seconds, and its learned rate. A zero Last means there is no
+// bucket yet, and a zero Rate that no rate was learned yet.
+type adaptiveState struct {
+	Tokens float64 `json:"tokens"`
+	Last   float64 `json:"last"`
+	Rate   float64 `json:"rate"`
+}
+
+// refill returns the tokens of the bucket st at now and its rate, the same
+// way the Lua script computes them.
+func (l *Adaptive) refill(st adaptiveState, now time.Time) (tokens, rate float64) {
+	rate = st.Rate
+	if rate == 0 {
+		rate = l.aimd.MaxRate
+	}
+	if st.Last == 0 {
+		return l.capacity, rate
+	}
+	return min(l.capacity, st.Tokens+max(0, seconds(now)-st.Last)*rate), rate
+}
+
+// storedState reads the bucket kept in the Store for key and how long until
+// it expires.
+func (l *Adaptive) storedState(ctx context.Context, storeKey string) (adaptiveState, time.Duration, error) {
+	var st adaptiveState
+	var ttl time.Duration
+	err := updateState(ctx, l.store, storeKey, func(stored *adaptiveState, expiresIn time.Duration) (bool, time.Duration) {
+		st, ttl = *stored, expiresIn
+		return false, 0
+	})
+	return st, ttl, err
+}
+
+// ttl is ttlSeconds as a Duration.
+func (l *Adaptive) ttl() time.Duration {
+	return time.Duration(l.ttlSeconds()) * time.Second
+}
+
+// ttlSeconds keeps the learned rate at least until the bucket would have
+// refilled at the slowest rate.
+func (l *Adaptive) ttlSeconds() int64 {
+	return int64(math.Ceil(max(l.capacity/l.aimd.MinRate, l.maxIdle.Seconds())))
+}
@@ -0,0 +1,443 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Gradient controls how an AdaptiveConcurrency limiter changes its cap,
+// following the gradient algorithm of Netflix's concurrency-limits: the cap
+// shrinks when latency rises above its long term average, which means
+// requests are queueing in the backend, and grows while it does not.
+type Gradient struct {
+	// MinLimit and MaxLimit bound the number of requests in flight.
+	// Keys start at WithLimit.
+	MinLimit int64
+	MaxLimit int64
+	// Tolerance is how much latency may exceed its long term average
+	// before the cap shrinks, e.g. 1.5 tolerates 50% more. At least 1.
+	Tolerance float64
+	// Smoothing is how much of the change computed from a single sample
+	// is applied, between 0 (excluded) and 1.
+	Smoothing float64
+	// Window is the number of samples the long term average latency
+	// is taken over.
+	Window int
+}
+
+func (g Gradient) validate() error {
+	if g.MinLimit <= 0 || g.MaxLimit < g.MinLimit {
+		return fmt.Errorf("ratelimiter: gradient limits must satisfy 0 < MinLimit <= MaxLimit, got %d and %d", g.MinLimit, g.MaxLimit)
+	}
+	if !(g.Tolerance >= 1) || math.IsInf(g.Tolerance, 0) {
+		return fmt.Errorf("ratelimiter: gradient tolerance must be at least 1, got %v", g.Tolerance)
+	}
+	if !(g.Smoothing > 0 && g.Smoothing <= 1) {
+		return fmt.Errorf("ratelimiter: gradient smoothing must be in (0, 1], got %v", g.Smoothing)
+	}
+	if g.Window <= 0 {
+		return fmt.Errorf("ratelimiter: gradient window must be positive, got %d", g.Window)
+	}
+	return nil
+}
+
+// Like acquireScript, with the cap read from the hash the latency reports update.
+var adaptiveAcquireScript = newScript(`
+	local key = KEYS[1]
+	local limit_key = KEYS[2]
+	local initial = tonumber(ARGV[1])
+	local lease = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local id = ARGV[4]
+
+	local limit = math.floor(tonumber(redis.call('HGET', limit_key, 'limit') or initial))
+
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - lease)
+	local count = redis.call('ZCARD', key)
+	if count >= limit then
+		return {0, count, limit}
+	end
+
+	redis.call('ZADD', key, now, id)
+	redis.call('PEXPIRE', key, lease)
+	return {1, count + 1, limit}
+`)
+
+// Applies one latency sample to the stored cap. The long term latency is an
+// exponential average over window samples; the cap is scaled by how far the
+// sample is above it, plus a queue allowance of sqrt(limit) to keep probing
+// for more capacity.
+var latencyScript = newScript(`
+	local key = KEYS[1]
+	local initial = tonumber(ARGV[1])
+	local min_limit = tonumber(ARGV[2])
+	local max_limit = tonumber(ARGV[3])
+	local tolerance = tonumber(ARGV[4])
+	local smoothing = tonumber(ARGV[5])
+	local window = tonumber(ARGV[6])
+	local rtt = tonumber(ARGV[7])
+	local ttl = tonumber(ARGV[8])
+
+	local limit = tonumber(redis.call('HGET', key, 'limit') or initial)
+	local long = tonumber(redis.call('HGET', key, 'long') or rtt)
+	long = long + (rtt - long) / window
+
+	local gradient = 1
+	if rtt > 0 then
+		gradient = math.max(0.5, math.min(1, tolerance * long / rtt))
+	end
+	local target = limit * gradient + math.sqrt(limit)
+	limit = limit * (1 - smoothing) + target * smoothing
+	limit = math.max(min_limit, math.min(max_limit, limit))
+
+	redis.call('HSET', key, 'limit', tostring(limit), 'long', tostring(long))
+	redis.call('PEXPIRE', key, ttl)
+	return tostring(limit)
+`)
+
+// AdaptiveConcurrency limiter
+// Concurrency limiter whose cap adapts to the latency of the backend it
+// protects: the caller reports how long each request took with
+// ReportLatency, and the cap is cut when latency climbs (requests are
+// queueing) and raised while it stays flat. The cap is stored in Redis, or
+// the Store given with WithStore, so all instances back off together when
+// the backend degrades.
+type AdaptiveConcurrency struct {
+	client   redis.UniversalClient
+	gradient Gradient
+	config
+}
+
+// NewAdaptiveConcurrency returns a limiter allowing WithLimit requests in
+// flight per key at first, then a number adjusted within gradient.
+// It panics if gradient or the options are invalid.
+func NewAdaptiveConcurrency(client redis.UniversalClient, gradient Gradient, opts ...Option) *AdaptiveConcurrency {
+	if err := gradient.validate(); err != nil {
+		panic(err.Error())
+	}
+	cfg := newConfig(opts)
+	cfg.mustBeSlotSafe(client, "AdaptiveConcurrency")
+	if cfg.limit < gradient.MinLimit || cfg.limit > gradient.MaxLimit {
+		panic(fmt.Sprintf("ratelimiter: limit must be between %d and %d, got %d", gradient.MinLimit, gradient.MaxLimit, cfg.limit))
+	}
+	if cfg.lease < time.Millisecond {
+		panic(fmt.Sprintf("ratelimiter: lease timeout must be at least 1ms, got %s", cfg.lease))
+	}
+	cfg.preloadScripts(client)
+	return &AdaptiveConcurrency{client: client, gradient: gradient, config: cfg}
+}
+
+// forKey returns a copy of l using the initial limit that applies to key.
+func (l *AdaptiveConcurrency) forKey(ctx context.Context, key string) (*AdaptiveConcurrency, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmAdaptiveConcurrency, Key: key, Err: err}
+	}
+	cfg.limit = min(max(cfg.limit, l.gradient.MinLimit), l.gradient.MaxLimit)
+	return &AdaptiveConcurrency{client: l.client, gradient: l.gradient, config: cfg}, nil
+}
+
+// Acquire tries to take a slot for key, like Concurrency.Acquire.
+// Result.Limit is the current cap.
+func (l *AdaptiveConcurrency) Acquire(ctx context.Context, key string) (*Lease, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmAdaptiveConcurrency, Key: key, Err: err}
+	}
+	res, err := l.acquire(ctx, key, id)
+	if err != nil {
+		if res, err = l.storeFailed(ctx, key, err); err != nil {
+			return nil, err
+		}
+	} else {
+		res = l.decide(ctx, AlgorithmAdaptiveConcurrency, key, res)
+	}
+	return &Lease{limiter: l, key: key, id: id, res: res}, nil
+}
+
+func (l *AdaptiveConcurrency) acquire(ctx context.Context, key, id string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("aconc", key)
+	if err != nil {
+		return Result{}, err
+	}
+	limitKey, err := l.limitKey(key)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		// The cap is read before taking a slot, so a report in between may
+		// be applied to the next request only
+		c, _, err := l.storedCap(ctx, limitKey)
+		if err != nil {
+			return Result{}, storeError(AlgorithmAdaptiveConcurrency, key, err)
+		}
+		res, err := acquireStore(ctx, l.store, redisKey, id, int64(c.Limit), l.lease, now)
+		if err != nil {
+			return Result{}, storeError(AlgorithmAdaptiveConcurrency, key, err)
+		}
+		return res, nil
+	}
+	result, err := adaptiveAcquireScript.Run(ctx, l.client, []string{redisKey, limitKey},
+		l.limit, l.lease.Milliseconds(), now.UnixMilli(), id).Int64Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmAdaptiveConcurrency, key, err)
+	}
+	// Redis Lua returns {acquired, in flight, cap}
+	if len(result) != 3 {
+		return Result{}, replyError(AlgorithmAdaptiveConcurrency, key, result)
+	}
+	return Result{
+		Allowed:   result[0] == 1,
+		Limit:     result[2],
+		Remaining: max(0, result[2]-result[1]),
+		ResetAt:   now,
+	}, nil
+}
+
+// ReportLatency adjusts the cap for key with the latency d of a request
+// that held a slot. It returns the new cap.
+// Report only requests that reached the backend: failures that returned
+// early would make it look faster than it is.
+func (l *AdaptiveConcurrency) ReportLatency(ctx context.Context, key string, d time.Duration) (int64, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	g := l.gradient
+	limitKey, err := l.limitKey(key)
+	if err != nil {
+		return 0, err
+	}
+	rtt := float64(d.Microseconds()) / 1000
+	if l.store != nil {
+		var limit float64
+		err := updateState(ctx, l.store, limitKey, func(c *gradientState, _ time.Duration) (bool, time.Duration) {
+			if c.Limit == 0 {
+				c.Limit, c.Long = float64(l.limit), rtt
+			}
+			c.Limit, c.Long = g.apply(c.Limit, c.Long, rtt)
+			limit = c.Limit
+			return true, l.limitTTL()
+		})
+		if err != nil {
+			return 0, storeError(AlgorithmAdaptiveConcurrency, key, err)
+		}
+		return int64(limit), nil
+	}
+	limit, err := latencyScript.Run(ctx, l.client, []string{limitKey},
+		l.limit, g.MinLimit, g.MaxLimit, g.Tolerance, g.Smoothing, g.Window,
+		rtt, l.limitTTL().Milliseconds()).Float64()
+	if err != nil {
+		return 0, storeError(AlgorithmAdaptiveConcurrency, key, err)
+	}
+	return int64(limit), nil
+}
+
+// Heartbeat keeps the slot id holds for key from being reclaimed.
+// It returns ErrLeaseLost if the slot was already reclaimed or released.
+func (l *AdaptiveConcurrency) Heartbeat(ctx context.Context, key, id string) error {
+	redisKey, err := l.key("aconc", key)
+	if err != nil {
+		return err
+	}
+	held, err := heartbeat(ctx, l.client, l.store, redisKey, id, l.lease, l.clock.Now())
+	if err != nil {
+		return storeError(AlgorithmAdaptiveConcurrency, key, err)
+	}
+	if !held {
+		return &Error{Algorithm: AlgorithmAdaptiveConcurrency, Key: key, Err: ErrLeaseLost}
+	}
+	return nil
+}
+
+// Release frees the slot id holds for key.
+// Releasing a slot that is no longer held is a no-op.
+func (l *AdaptiveConcurrency) Release(ctx context.Context, key, id string) error {
+	redisKey, err := l.key("aconc", key)
+	if err != nil {
+		return err
+	}
+	if err := release(ctx, l.client, l.store, redisKey, id); err != nil {
+		return storeError(AlgorithmAdaptiveConcurrency, key, err)
+	}
+	return nil
+}
+
+// Check reports the number of free slots for key under the current cap
+// without taking one.
+func (l *AdaptiveConcurrency) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	count, limit, err := l.state(ctx, key, now)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:   count < limit,
+		Limit:     limit,
+		Remaining: max(0, limit-count),
+		ResetAt:   now,
+	}, nil
+}
+
+// Reset frees all slots for key and forgets the learned cap.
+func (l *AdaptiveConcurrency) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("aconc", key)
+	if err != nil {
+		return err
+	}
+	limitKey, err := l.limitKey(key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey, limitKey); err != nil {
+			return storeError(AlgorithmAdaptiveConcurrency, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey, limitKey).Err(); err != nil {
+		return storeError(AlgorithmAdaptiveConcurrency, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+// Limit is the current cap and Count the number of slots held with a live lease.
+func (l *AdaptiveConcurrency) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	count, limit, err := l.state(ctx, key, now)
+	if err != nil {
+		return State{}, err
+	}
+	limitKey, err := l.limitKey(key)
+	if err != nil {
+		return State{}, err
+	}
+	var ttl time.Duration
+	if l.store != nil {
+		_, ttl, err = l.storedCap(ctx, limitKey)
+	} else {
+		ttl, err = l.client.PTTL(ctx, limitKey).Result()
+	}
+	if err != nil {
+		return State{}, storeError(AlgorithmAdaptiveConcurrency, key, err)
+	}
+	return State{
+		Algorithm: AlgorithmAdaptiveConcurrency,
+		Key:       key,
+		Limit:     limit,
+		Count:     count,
+		TTL:       max(0, ttl),
+	}, nil
+}
+
+// state returns the holders of key whose lease has not expired at now
+// and the current cap.
+func (l *AdaptiveConcurrency) state(ctx context.Context, key string, now time.Time) (count, limit int64, err error) {
+	redisKey, err := l.key("aconc", key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if l.store != nil {
+		limitKey, err := l.limitKey(key)
+		if err != nil {
+			return 0, 0, err
+		}
+		if count, _, err = inFlightStore(ctx, l.store, redisKey, l.lease, now); err != nil {
+			return 0, 0, storeError(AlgorithmAdaptiveConcurrency, key, err)
+		}
+		c, _, err := l.storedCap(ctx, limitKey)
+		if err != nil {
+			return 0, 0, storeError(AlgorithmAdaptiveConcurrency, key, err)
+		}
+		return count, int64(c.Limit), nil
+	}
+	// Exclusive, like the script that removes scores up to and including now - lease
+	minScore := "(" + strconv.FormatInt(now.Add(-l.lease).UnixMilli(), 10)
+	count, err = l.client.ZCount(ctx, redisKey, minScore, "+inf").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, storeError(AlgorithmAdaptiveConcurrency, key, err)
+	}
+	limitKey, err := l.limitKey(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	stored, err := l.client.HGet(ctx, limitKey, "limit").Float64()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return count, l.limit, nil
+	case err != nil:
+		return 0, 0, storeError(AlgorithmAdaptiveConcurrency, key, err)
+	}
+	return count, int64(stored), nil
+}
+
+// gradientState is the cap learned for a key kept in a Store and the long
+// term average latency in ms it was computed with. A zero Limit means
+// nothing was learned yet.
+type gradientState struct {
+	Limit float64 `json:"limit"`
+	Long  float64 `json:"long"`
+}
+
+// apply returns the cap and long term latency after a sample of rtt ms,
+// the way latencyScript computes them.
+func (g Gradient) apply(limit, long, rtt float64) (float64, float64) {
+	long += (rtt - long) / float64(g.Window)
+	gradient := 1.0
+	if rtt > 0 {
+		gradient = max(0.5, min(1, g.Tolerance*long/rtt))
+	}
+	target := limit*gradient + math.Sqrt(limit)
+	limit = limit*(1-g.Smoothing) + target*g.Smoothing
+	return max(float64(g.MinLimit), min(float64(g.MaxLimit), limit)), long
+}
+
+// storedCap reads the cap learned for the key at limitKey from the Store,
+// the initial cap if nothing was learned yet, and how long until it expires.
+func (l *AdaptiveConcurrency) storedCap(ctx context.Context, limitKey string) (gradientState, time.Duration, error) {
+	var c gradientState
+	var ttl time.Duration
+	err := updateState(ctx, l.store, limitKey, func(stored *gradientState, expiresIn time.Duration) (bool, time.Duration) {
+		c, ttl = *stored, expiresIn
+		return false, 0
+	})
+	if c.Limit == 0 {
+		c.Limit = float64(l.limit)
+	}
+	c.Limit = math.Floor(c.Limit)
+	return c, ttl, err
+}
+
+// limitKey returns the Redis key of the cap learned for key.
+func (l *AdaptiveConcurrency) limitKey(key string) (string, error) {
+	return l.subKey("aconc", key, "limit")
+}
+
+// limitTTL keeps the learned cap for the lease timeout after the last
+// report, or longer with WithMaxIdle.
+func (l *AdaptiveConcurrency) limitTTL() time.Duration {
+	return l.idleTTL(l.lease)
+}
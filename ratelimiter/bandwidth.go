@@ -0,0 +1,133 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Bandwidth limiter
+// Enforces a request rate and a byte volume on the same key at once, e.g.
+// 100 requests per minute AND 50 MB per minute for an upload API. Both are
+// token buckets checked and consumed in one atomic step, so a request denied
+// by one dimension doesn't use up the other.
+type Bandwidth struct {
+	client   redis.UniversalClient
+	requests Limit
+	bytes    Limit
+	config
+}
+
+// NewBandwidth returns a limiter allowing requests requests and bytes bytes
+// per key, where bytes.Requests is the number of bytes per bytes.Window.
+// It panics if one of the limits is invalid, or on Redis Cluster without
+// WithHashTags.
+func NewBandwidth(client redis.UniversalClient, requests, bytes Limit, opts ...Option) *Bandwidth {
+	if err := requests.validate(); err != nil {
+		panic(err)
+	}
+	if err := bytes.validate(); err != nil {
+		panic(err)
+	}
+	cfg := newConfig(opts)
+	cfg.mustBeSlotSafe(client, "Bandwidth")
+	cfg.preloadScripts(client)
+	return &Bandwidth{client: client, requests: requests, bytes: bytes, config: cfg}
+}
+
+// Allow implements Limiter.
+// The request counts against the request rate only.
+func (l *Bandwidth) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN implements Limiter.
+// The request costs n requests and no bytes.
+func (l *Bandwidth) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmBandwidth, key, n); err != nil {
+		return Result{}, err
+	}
+	return l.allow(ctx, key, n, 0)
+}
+
+// AllowBytes reports whether one request transferring n bytes for key is
+// allowed right now, consuming from both the request and the byte budget.
+// Result describes the request budget; RetryAfter accounts for both.
+func (l *Bandwidth) AllowBytes(ctx context.Context, key string, n int64) (Result, error) {
+	return l.allow(ctx, key, 1, n)
+}
+
+func (l *Bandwidth) allow(ctx context.Context, key string, requests, bytes int64) (Result, error) {
+	now := l.clock.Now()
+	limits := []Limit{l.requests, l.bytes}
+	redisKeys, err := l.bucketKeys(key)
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, tokens, err := takeAll(ctx, l.client, l.store, AlgorithmBandwidth, key, redisKeys,
+		limits, []int64{requests, bytes}, now, l.maxIdle)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+
+	res := bucketsResult(allowed, tokens[:1], limits[:1], requests, now)
+	if !res.Allowed && tokens[1] < float64(bytes) {
+		res.RetryAfter = max(res.RetryAfter, l.bytes.refillTime(float64(bytes)-tokens[1]))
+	}
+	return l.decide(ctx, AlgorithmBandwidth, key, res), nil
+}
+
+// Check reports the request budget for key without counting a request.
+func (l *Bandwidth) Check(ctx context.Context, key string) (Result, error) {
+	redisKeys, err := l.bucketKeys(key)
+	if err != nil {
+		return Result{}, err
+	}
+	return checkAll(ctx, l.client, l.store, AlgorithmBandwidth, key, redisKeys[:1], []Limit{l.requests}, l.clock.Now())
+}
+
+// Reset refills both budgets for key.
+func (l *Bandwidth) Reset(ctx context.Context, key string) error {
+	redisKeys, err := l.bucketKeys(key)
+	if err != nil {
+		return err
+	}
+	if err := deleteBuckets(ctx, l.client, l.store, redisKeys...); err != nil {
+		return storeError(AlgorithmBandwidth, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+// The request and byte buckets are reported in State.Limits, in that order.
+func (l *Bandwidth) Inspect(ctx context.Context, key string) (State, error) {
+	st := State{Algorithm: AlgorithmBandwidth, Key: key}
+	keys, err := l.bucketKeys(key)
+	if err != nil {
+		return State{}, err
+	}
+	for i, limit := range []Limit{l.requests, l.bytes} {
+		ls, err := inspectLimit(ctx, l.client, l.store, keys[i], limit, l.clock.Now())
+		if err != nil {
+			return State{}, storeError(AlgorithmBandwidth, key, err)
+		}
+		ls.Algorithm = AlgorithmBandwidth
+		ls.Key = key
+		ls.Window = limit.Window
+		st.Limits = append(st.Limits, ls)
+	}
+	return st, nil
+}
+
+// bucketKeys returns the Redis keys of the request and byte buckets.
+func (l *Bandwidth) bucketKeys(key string) ([]string, error) {
+	requests, err := l.subKey("bw", key, "requests")
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := l.subKey("bw", key, "bytes")
+	if err != nil {
+		return nil, err
+	}
+	return []string{requests, bytes}, nil
+}
@@ -0,0 +1,37 @@
+package ratelimiter
+
+import "context"
+
+// BatchLimiter is implemented by limiters that can evaluate many keys
+// in a single round trip to the store.
+type BatchLimiter interface {
+	AllowMany(ctx context.Context, keys []string) ([]Result, error)
+}
+
+var (
+	_ BatchLimiter = (*FixedWindow)(nil)
+	_ BatchLimiter = (*TokenBucket)(nil)
+)
+
+// AllowMany checks one request for each key with l.
+// It uses a single round trip when l is a BatchLimiter and falls back
+// to one Allow per key otherwise. Results are in the order of keys.
+func AllowMany(ctx context.Context, l Limiter, keys []string) ([]Result, error) {
+	if b, ok := l.(BatchLimiter); ok {
+		return b.AllowMany(ctx, keys)
+	}
+	return allowEach(ctx, l, keys)
+}
+
+// allowEach checks one request for each key with l, one Allow per key.
+func allowEach(ctx context.Context, l Limiter, keys []string) ([]Result, error) {
+	results := make([]Result, len(keys))
+	for i, key := range keys {
+		res, err := l.Allow(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = res
+	}
+	return results, nil
+}
@@ -0,0 +1,280 @@
+// Package bolt implements a ratelimiter.Store on bbolt, an embedded
+// key/value database in a single file, so single-node daemons such as CLIs,
+// agents and edge gateways keep their limits across restarts without any
+// external service.
+//
+// Every operation runs in one bbolt transaction, which bbolt serializes, so
+// concurrent goroutines never lose each other's updates. Every limiter
+// accepting ratelimiter.WithStore runs on it. A bbolt file can only be
+// opened by one process at a time.
+//
+// bbolt syncs the file to disk on every write, which bounds a store to a
+// few hundred to a few thousand decisions per second depending on the disk.
+// Opening the database with NoSync lifts that bound, at the risk of losing
+// the latest updates on a crash.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// Names of the bbolt buckets holding counters, token buckets and the state
+// stored with Update.
+var (
+	countersBucket = []byte("ratelimiter.counters")
+	bucketsBucket  = []byte("ratelimiter.buckets")
+	stateBucket    = []byte("ratelimiter.state")
+)
+
+// Store is a ratelimiter.Store keeping limiter state in a bbolt database.
+type Store struct {
+	db    *bbolt.DB
+	clock ratelimiter.Clock
+}
+
+var _ ratelimiter.Store = (*Store)(nil)
+
+// New returns a Store keeping limiter state in db, creating its bbolt
+// buckets if needed. The caller opens and closes db, e.g.
+//
+//	db, err := bbolt.Open("ratelimit.db", 0o600, nil)
+func New(db *bbolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{countersBucket, bucketsBucket, stateBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db, clock: ratelimiter.SystemClock}, nil
+}
+
+// SetClock replaces the clock counters expire by. Pass the clock given to
+// the limiters with WithClock, so their windows end at the same time.
+func (s *Store) SetClock(clock ratelimiter.Clock) {
+	s.clock = clock
+}
+
+// Cleanup deletes expired state and returns how many keys it deleted.
+// Expired state is ignored anyway; call it periodically so keys that are
+// never seen again don't grow the file.
+func (s *Store) Cleanup(ctx context.Context) (int, error) {
+	now := s.clock.Now()
+	deleted := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{countersBucket, bucketsBucket, stateBucket} {
+			b := tx.Bucket(name)
+			// Deleting while iterating makes a cursor skip keys, so collect them first
+			var expired [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				if expires, ok := expiry(name, v); !ok || !now.Before(expires) {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				return ctx.Err()
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			deleted += len(expired)
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// Increment implements ratelimiter.Store.
+func (s *Store) Increment(ctx context.Context, key string, n int64, ttl time.Duration) (int64, time.Duration, error) {
+	now := s.clock.Now()
+	var count int64
+	var expires time.Time
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		counters := tx.Bucket(countersBucket)
+		count, expires = 0, now.Add(ttl)
+		if c, e, ok := decodeCounter(counters.Get([]byte(key))); ok && now.Before(e) {
+			count, expires = c, e
+		}
+		count += n
+		return counters.Put([]byte(key), encodeCounter(count, expires))
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, expires.Sub(now), nil
+}
+
+// Counter implements ratelimiter.Store.
+func (s *Store) Counter(ctx context.Context, key string) (int64, time.Duration, error) {
+	now := s.clock.Now()
+	var count int64
+	var left time.Duration
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c, expires, ok := decodeCounter(tx.Bucket(countersBucket).Get([]byte(key)))
+		if ok && now.Before(expires) {
+			count, left = c, expires.Sub(now)
+		}
+		return nil
+	})
+	return count, left, err
+}
+
+// TakeTokens implements ratelimiter.Store.
+func (s *Store) TakeTokens(ctx context.Context, key string, b ratelimiter.Bucket, cost float64, now time.Time) (bool, float64, error) {
+	var allowed bool
+	var tokens float64
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		buckets := tx.Bucket(bucketsBucket)
+		t, last, ok := s.decodeBucket(buckets.Get([]byte(key)))
+		if !ok {
+			// A bucket that doesn't exist is full
+			t, last = b.Capacity, now
+		}
+		allowed, tokens, last = b.Take(t, last, cost, now)
+		if !allowed {
+			return nil
+		}
+		return buckets.Put([]byte(key), encodeBucket(tokens, last, now.Add(b.TTL(tokens))))
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed, tokens, nil
+}
+
+// Tokens implements ratelimiter.Store.
+func (s *Store) Tokens(ctx context.Context, key string, b ratelimiter.Bucket, now time.Time) (float64, error) {
+	tokens := b.Capacity
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		if t, last, ok := s.decodeBucket(tx.Bucket(bucketsBucket).Get([]byte(key))); ok {
+			tokens = b.Tokens(t, last, now)
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+// Update implements ratelimiter.Store.
+func (s *Store) Update(ctx context.Context, key string, fn func(state []byte, expiresIn time.Duration) ([]byte, time.Duration)) error {
+	now := s.clock.Now()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		states := tx.Bucket(stateBucket)
+		state, expires, ok := decodeState(states.Get([]byte(key)))
+		if !ok || !now.Before(expires) {
+			state, expires = nil, now
+		}
+		next, ttl := fn(state, expires.Sub(now))
+		if next == nil {
+			return nil
+		}
+		return states.Put([]byte(key), encodeState(next, now.Add(ttl)))
+	})
+}
+
+// Delete implements ratelimiter.Store.
+func (s *Store) Delete(ctx context.Context, keys ...string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		for _, key := range keys {
+			for _, name := range [][]byte{countersBucket, bucketsBucket, stateBucket} {
+				if err := tx.Bucket(name).Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Values are big endian: a counter is its count and expiry (unix ms), and a
+// token bucket its tokens (float64 bits), last refill (unix ns) and expiry.
+// The expiry comes last in both, for decode. State stored with Update is its
+// expiry followed by the state.
+
+func encodeCounter(count int64, expires time.Time) []byte {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v, uint64(count))
+	binary.BigEndian.PutUint64(v[8:], uint64(expires.UnixMilli()))
+	return v
+}
+
+func encodeBucket(tokens float64, last, expires time.Time) []byte {
+	v := make([]byte, 24)
+	binary.BigEndian.PutUint64(v, math.Float64bits(tokens))
+	binary.BigEndian.PutUint64(v[8:], uint64(last.UnixNano()))
+	binary.BigEndian.PutUint64(v[16:], uint64(expires.UnixMilli()))
+	return v
+}
+
+func encodeState(state []byte, expires time.Time) []byte {
+	v := make([]byte, 8, 8+len(state))
+	binary.BigEndian.PutUint64(v, uint64(expires.UnixMilli()))
+	return append(v, state...)
+}
+
+// decodeState decodes state written by Update.
+// It reports false for a missing or malformed value.
+func decodeState(v []byte) ([]byte, time.Time, bool) {
+	if len(v) < 8 {
+		return nil, time.Time{}, false
+	}
+	return v[8:], time.UnixMilli(int64(binary.BigEndian.Uint64(v))), true
+}
+
+// expiry returns when a value of the bbolt bucket name expires.
+// It reports false for a malformed value.
+func expiry(name, v []byte) (time.Time, bool) {
+	if bytes.Equal(name, stateBucket) {
+		_, expires, ok := decodeState(v)
+		return expires, ok
+	}
+	_, expires, ok := decode(v)
+	return expires, ok
+}
+
+// decode returns the first field and the expiry of a value.
+// It reports false for a missing or malformed value.
+func decode(v []byte) (uint64, time.Time, bool) {
+	if len(v) != 16 && len(v) != 24 {
+		return 0, time.Time{}, false
+	}
+	expires := int64(binary.BigEndian.Uint64(v[len(v)-8:]))
+	return binary.BigEndian.Uint64(v), time.UnixMilli(expires), true
+}
+
+// decodeCounter decodes a counter written by Increment.
+func decodeCounter(v []byte) (int64, time.Time, bool) {
+	if len(v) != 16 {
+		return 0, time.Time{}, false
+	}
+	count, expires, ok := decode(v)
+	return int64(count), expires, ok
+}
+
+// decodeBucket decodes a bucket written by TakeTokens. It reports false for
+// a missing, expired or malformed bucket, which starts over full like in the
+// Lua scripts.
+func (s *Store) decodeBucket(v []byte) (float64, time.Time, bool) {
+	if len(v) != 24 {
+		return 0, time.Time{}, false
+	}
+	bits, expires, _ := decode(v)
+	tokens := math.Float64frombits(bits)
+	if !s.clock.Now().Before(expires) || math.IsNaN(tokens) || math.IsInf(tokens, 0) {
+		return 0, time.Time{}, false
+	}
+	return tokens, time.Unix(0, int64(binary.BigEndian.Uint64(v[8:]))), true
+}
@@ -0,0 +1,215 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChainLink is one limit of a Chain, applied to its own key, e.g. the
+// per-IP limit of a request. Name identifies it in Redis keys.
+type ChainLink struct {
+	Name  string
+	Limit Limit
+}
+
+// Chain limiter
+// Enforces several limits on different keys of the same request, e.g. per IP,
+// per user and per endpoint, in one round trip instead of one per limiter.
+// Each link is a token bucket like the limits of a MultiLimiter. On a single
+// Redis all links are evaluated in one Lua script, atomically, and tokens are
+// only taken when every link allows the request. On Redis Cluster or Ring,
+// where the keys live on different servers, the links are evaluated in one
+// pipeline, and the tokens taken by the links that allowed a denied request
+// are given back in a second one. With WithStore the links are taken from
+// in turn, and given back in the same way.
+type Chain struct {
+	client redis.UniversalClient
+	links  []ChainLink
+	config
+}
+
+// NewChain returns a limiter enforcing links, each on its own key.
+// It panics if links is empty, a name is empty or repeated, or a limit is invalid.
+func NewChain(client redis.UniversalClient, links []ChainLink, opts ...Option) *Chain {
+	if len(links) == 0 {
+		panic("ratelimiter: Chain needs at least one link")
+	}
+	names := make(map[string]bool, len(links))
+	for _, link := range links {
+		if link.Name == "" || names[link.Name] {
+			panic(fmt.Sprintf("ratelimiter: chain link names must be unique and non-empty, got %q", link.Name))
+		}
+		names[link.Name] = true
+		if err := link.Limit.validate(); err != nil {
+			panic(err)
+		}
+	}
+	cfg := newConfig(opts)
+	cfg.preloadScripts(client)
+	return &Chain{client: client, links: links, config: cfg}
+}
+
+// Allow reports whether one request is allowed by every link, keys[i]
+// being the key of the request for links[i].
+// The most restrictive link decides the Result.
+func (l *Chain) Allow(ctx context.Context, keys ...string) (Result, error) {
+	return l.AllowN(ctx, 1, keys...)
+}
+
+// AllowN is like Allow for a request costing n in every link.
+func (l *Chain) AllowN(ctx context.Context, n int64, keys ...string) (Result, error) {
+	key := strings.Join(keys, ":")
+	if err := costError(AlgorithmChain, key, n); err != nil {
+		return Result{}, err
+	}
+	redisKeys, err := l.bucketKeys(key, keys)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	limits := l.limits()
+
+	var allowed bool
+	var tokens []float64
+	if l.store == nil && sharded(l.client) {
+		allowed, tokens, err = l.takeEach(ctx, key, redisKeys, n, now)
+	} else {
+		costs := make([]int64, len(limits))
+		for i := range costs {
+			costs[i] = n
+		}
+		allowed, tokens, err = takeAll(ctx, l.client, l.store, AlgorithmChain, key, redisKeys, limits, costs, now, l.maxIdle)
+	}
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmChain, key, bucketsResult(allowed, tokens, limits, n, now)), nil
+}
+
+// takeEach is takeAll for keys on different servers: every bucket is
+// evaluated in one pipeline, and tokens are given back to the buckets that
+// allowed the request when another one denied it.
+func (l *Chain) takeEach(ctx context.Context, key string, keys []string, n int64, now time.Time) (bool, []float64, error) {
+	nowSeconds := seconds(now)
+	run := func() ([]*redis.Cmd, error) {
+		pipe := l.client.Pipeline()
+		cmds := make([]*redis.Cmd, len(keys))
+		for i, link := range l.links {
+			cmds[i] = multiLimitScript.EvalSha(ctx, pipe, keys[i:i+1],
+				nowSeconds, l.maxIdle.Milliseconds(), link.Limit.burst(), link.Limit.rate(), n)
+		}
+		_, err := pipe.Exec(ctx)
+		return cmds, err
+	}
+	cmds, err := run()
+	// Load the script once and retry rather than sending its source per link
+	if redis.HasErrorPrefix(err, "NOSCRIPT") {
+		if err := LoadScripts(ctx, l.client); err != nil {
+			return false, nil, storeError(AlgorithmChain, key, err)
+		}
+		cmds, err = run()
+	}
+	if err != nil {
+		return false, nil, storeError(AlgorithmChain, key, err)
+	}
+
+	allowed := true
+	taken := make([]bool, len(keys))
+	tokens := make([]float64, len(keys))
+	for i, cmd := range cmds {
+		result, err := cmd.Slice()
+		if err != nil {
+			return false, nil, storeError(AlgorithmChain, key, err)
+		}
+		if len(result) != 2 {
+			return false, nil, replyError(AlgorithmChain, key, result)
+		}
+		if tokens[i], err = strconv.ParseFloat(fmt.Sprint(result[1]), 64); err != nil {
+			return false, nil, replyError(AlgorithmChain, key, result)
+		}
+		taken[i] = result[0] == int64(1)
+		allowed = allowed && taken[i]
+	}
+	if allowed {
+		return true, tokens, nil
+	}
+
+	pipe := l.client.Pipeline()
+	for i, link := range l.links {
+		if taken[i] {
+			tokenBucketRefundScript.Eval(ctx, pipe, keys[i:i+1],
+				float64(link.Limit.burst()), link.Limit.rate(), nowSeconds, n, 0)
+			tokens[i] += float64(n)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, nil, storeError(AlgorithmChain, key, err)
+	}
+	return false, tokens, nil
+}
+
+// Check reports the state of every link for keys without taking tokens.
+func (l *Chain) Check(ctx context.Context, keys ...string) (Result, error) {
+	key := strings.Join(keys, ":")
+	redisKeys, err := l.bucketKeys(key, keys)
+	if err != nil {
+		return Result{}, err
+	}
+	return checkAll(ctx, l.client, l.store, AlgorithmChain, key, redisKeys, l.limits(), l.clock.Now())
+}
+
+// Reset refills the bucket of every link for keys.
+func (l *Chain) Reset(ctx context.Context, keys ...string) error {
+	key := strings.Join(keys, ":")
+	redisKeys, err := l.bucketKeys(key, keys)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKeys...); err != nil {
+			return storeError(AlgorithmChain, key, err)
+		}
+		return nil
+	}
+	// One DEL per key, as the keys may be on different servers
+	pipe := l.client.Pipeline()
+	for _, redisKey := range redisKeys {
+		pipe.Del(ctx, redisKey)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return storeError(AlgorithmChain, key, err)
+	}
+	return nil
+}
+
+// bucketKeys returns the Redis keys of the buckets for keys, one per link,
+// e.g. "chain:ip:203.0.113.7".
+func (l *Chain) bucketKeys(key string, keys []string) ([]string, error) {
+	if len(keys) != len(l.links) {
+		return nil, &Error{Algorithm: AlgorithmChain, Key: key,
+			Err: fmt.Errorf("ratelimiter: chain has %d links, got %d keys", len(l.links), len(keys))}
+	}
+	redisKeys := make([]string, len(keys))
+	for i, link := range l.links {
+		redisKey, err := l.key("chain", link.Name+":"+keys[i])
+		if err != nil {
+			return nil, err
+		}
+		redisKeys[i] = redisKey
+	}
+	return redisKeys, nil
+}
+
+// limits returns the limit of every link.
+func (l *Chain) limits() []Limit {
+	limits := make([]Limit, len(l.links))
+	for i, link := range l.links {
+		limits[i] = link.Limit
+	}
+	return limits
+}
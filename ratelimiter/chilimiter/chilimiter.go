@@ -0,0 +1,47 @@
+// Package chilimiter adapts the net/http middleware to chi routers. Route
+// groups mount the limiter they need, and limiters built on the same Redis
+// client share its connection pool:
+//
+//	strict := ratelimiter.NewFixedWindow(rdb, ratelimiter.WithLimit(5), ratelimiter.WithWindow(time.Minute))
+//	lenient := ratelimiter.NewTokenBucket(rdb, ratelimiter.WithBurst(100), ratelimiter.WithRate(10))
+//
+//	r := chi.NewRouter()
+//	r.With(chilimiter.New(strict)).Post("/login", login)
+//	r.Group(func(r chi.Router) {
+//		r.Use(chilimiter.New(lenient))
+//		r.Get("/search", search)
+//		r.Get("/items/{id}", item)
+//	})
+//
+// Keys are prefixed with the method and chi route pattern, e.g.
+// "GET /items/{id}:203.0.113.7", so each route of a group has its own
+// count and every item shares the count of its route.
+package chilimiter
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/moonorange/go_rate_limiter/ratelimiter/middleware"
+)
+
+// New returns middleware.HTTP keyed per chi route, see the package doc.
+// opts configure it like middleware.HTTP; pass
+// middleware.WithRouteKey(nil) to share each key's limit across the routes
+// of a group instead.
+func New(l ratelimiter.Limiter, opts ...middleware.Option) func(http.Handler) http.Handler {
+	return middleware.HTTP(l, append([]middleware.Option{middleware.WithRouteKey(RoutePattern)}, opts...)...)
+}
+
+// RoutePattern returns the chi route pattern that matched r, e.g.
+// "/items/{id}". It is complete in middleware mounted with With, Group or
+// Route, which run once the route is matched; in middleware added to the
+// root router with Use, which runs before routing, it is empty.
+func RoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	return rctx.RoutePattern()
+}
@@ -0,0 +1,53 @@
+package chilimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// get serves a GET request of path from 203.0.113.7 with h.
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestNew(t *testing.T) {
+	l := ratelimiter.NewFixedWindow(nil, ratelimiter.WithStore(ratelimiter.NewMemoryStore()),
+		ratelimiter.WithLimit(1), ratelimiter.WithWindow(time.Minute))
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("ok")) }
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(New(l))
+		r.Get("/search", ok)
+		r.Get("/items/{id}", ok)
+	})
+
+	for _, path := range []string{"/search", "/items/1"} {
+		w := get(r, path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want 200 as routes are counted separately", path, w.Code)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+			t.Errorf("GET %s: RateLimit-Remaining = %q, want 0", path, got)
+		}
+	}
+
+	// Every item shares the count of its route
+	w := get(r, "/items/2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("GET /items/2 = %d, want 429", w.Code)
+	}
+	for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "X-RateLimit-Limit", "Retry-After"} {
+		if w.Header().Get(name) == "" {
+			t.Errorf("429 without %s", name)
+		}
+	}
+}
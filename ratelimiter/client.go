@@ -0,0 +1,155 @@
+package ratelimiter
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ClientOption configures the Redis connection made by NewClient.
+type ClientOption func(*redis.UniversalOptions)
+
+// NewClient returns a Redis client for the limiters, connected to addrs:
+// a single address makes a plain client, several make a Redis Cluster
+// client, and WithSentinel makes a Sentinel failover client with addrs
+// listing the Sentinels. Scripts are loaded on every new connection, see
+// LoadScriptsOnConnect.
+//
+// Deployments needing settings not covered by the options can build their
+// client with go-redis directly; every constructor takes any
+// redis.UniversalClient.
+func NewClient(addrs []string, opts ...ClientOption) redis.UniversalClient {
+	opt := &redis.UniversalOptions{
+		Addrs:     addrs,
+		OnConnect: LoadScriptsOnConnect,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return redis.NewUniversalClient(opt)
+}
+
+// WithTLS connects over TLS with cfg, e.g. &tls.Config{ServerName: "redis.internal"}
+// or one carrying a client certificate for mutual TLS.
+func WithTLS(cfg *tls.Config) ClientOption {
+	return func(o *redis.UniversalOptions) { o.TLSConfig = cfg }
+}
+
+// WithCredentials authenticates every connection with AUTH. An empty
+// username authenticates as the default user, as with requirepass; otherwise
+// username is a Redis 6 ACL user, which needs the EVALSHA, EVAL, SCRIPT and
+// key commands of the limiters on their key pattern.
+func WithCredentials(username, password string) ClientOption {
+	return func(o *redis.UniversalOptions) {
+		o.Username = username
+		o.Password = password
+	}
+}
+
+// WithDB selects the logical database db, to keep limiter keys apart from
+// the application's. Redis Cluster only has database 0 and ignores it.
+func WithDB(db int) ClientOption {
+	return func(o *redis.UniversalOptions) { o.DB = db }
+}
+
+// WithDialer replaces how connections are opened, e.g. to go through a
+// proxy, a Unix socket or an SSH tunnel.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) ClientOption {
+	return func(o *redis.UniversalOptions) { o.Dialer = dial }
+}
+
+// WithSentinel connects to the master named master through the Sentinels
+// listed in the addresses given to NewClient.
+func WithSentinel(master string) ClientOption {
+	return func(o *redis.UniversalOptions) { o.MasterName = master }
+}
+
+// WithPoolSize caps the connections per Redis server. Each in-flight limiter
+// call holds one, so size it for the peak concurrency of requests; calls
+// beyond it wait up to the pool timeout of WithTimeouts.
+func WithPoolSize(n int) ClientOption {
+	return func(o *redis.UniversalOptions) { o.PoolSize = n }
+}
+
+// WithMinIdleConns keeps n connections open per Redis server, so bursts
+// don't pay for dialing (and a TLS handshake) on the request path.
+func WithMinIdleConns(n int) ClientOption {
+	return func(o *redis.UniversalOptions) { o.MinIdleConns = n }
+}
+
+// WithTimeouts bounds dialing, reading a reply, writing a command and
+// waiting for a free connection of the pool. Zero keeps the go-redis default.
+// Limiter calls sit on the request path, so these are usually far shorter
+// than the defaults, e.g. 100ms reads with WithOnStoreError deciding what
+// happens when they expire.
+func WithTimeouts(dial, read, write, pool time.Duration) ClientOption {
+	return func(o *redis.UniversalOptions) {
+		o.DialTimeout = dial
+		o.ReadTimeout = read
+		o.WriteTimeout = write
+		o.PoolTimeout = pool
+	}
+}
+
+// Ping checks that every Redis server behind client answers, every shard
+// of a cluster or ring.
+func Ping(ctx context.Context, client redis.UniversalClient) error {
+	err := forEachShard(ctx, client, func(ctx context.Context, shard redis.Cmdable) error {
+		return shard.Ping(ctx).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+// Healthy checks that every Redis server behind client answers and has
+// the scripts of the limiters loaded, e.g. for a readiness probe run after
+// LoadScripts. Limiters recover from missing scripts on their own, so a
+// failing Healthy after a restart of Redis only means the first requests
+// will send the scripts' source.
+func Healthy(ctx context.Context, client redis.UniversalClient) error {
+	hashes := make([]string, len(scripts))
+	for i, s := range scripts {
+		hashes[i] = s.Hash()
+	}
+	err := forEachShard(ctx, client, func(ctx context.Context, shard redis.Cmdable) error {
+		loaded, err := shard.ScriptExists(ctx, hashes...).Result()
+		if err != nil {
+			return err
+		}
+		missing := 0
+		for _, ok := range loaded {
+			if !ok {
+				missing++
+			}
+		}
+		if missing > 0 {
+			return fmt.Errorf("ratelimiter: %d of %d scripts not loaded", missing, len(hashes))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+// forEachShard calls fn with every Redis server behind client: the masters
+// of a cluster, the shards of a ring, or client itself.
+func forEachShard(ctx context.Context, client redis.UniversalClient, fn func(ctx context.Context, shard redis.Cmdable) error) error {
+	perShard := func(ctx context.Context, shard *redis.Client) error {
+		return fn(ctx, shard)
+	}
+	switch c := client.(type) {
+	case *redis.ClusterClient:
+		return c.ForEachMaster(ctx, perShard)
+	case *redis.Ring:
+		return c.ForEachShard(ctx, perShard)
+	}
+	return fn(ctx, client)
+}
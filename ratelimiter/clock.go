@@ -0,0 +1,42 @@
+package ratelimiter
+
+import "time"
+
+// Clock tells the time to limiters.
+// All algorithms read the current time through it, see WithClock.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+}
+
+// SystemClock is the default Clock, backed by the time package.
+var SystemClock Clock = systemClock{}
+
+// MonotonicClock is implemented by clocks that also tell monotonic time,
+// which moves forward at a steady pace whatever happens to the wall clock
+// (NTP steps, manual changes, a VM resumed with its clock jumped).
+// In-process limiters use it to measure elapsed time, so a wall clock step
+// neither refills them at once nor freezes them.
+type MonotonicClock interface {
+	Clock
+	// Monotonic returns the time elapsed since a fixed, arbitrary point.
+	Monotonic() time.Duration
+}
+
+// processStart is the reference point of SystemClock's monotonic time.
+var processStart = time.Now()
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (systemClock) Monotonic() time.Duration        { return time.Since(processStart) }
+
+// monotonic reads the monotonic time of c, or its wall time for clocks
+// that only tell the wall time, such as fake clocks in tests.
+func monotonic(c Clock) time.Duration {
+	if m, ok := c.(MonotonicClock); ok {
+		return m.Monotonic()
+	}
+	return time.Duration(c.Now().UnixNano())
+}
@@ -0,0 +1,183 @@
+//go:build integration
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// These tests run against a real Redis Cluster, e.g.
+//
+//	RATELIMITER_CLUSTER_ADDRS=localhost:7000,localhost:7001,localhost:7002 \
+//		go test -tags integration ./ratelimiter
+//
+// They write keys under a prefix unique to the run and delete them after.
+
+// newCluster connects to the cluster in RATELIMITER_CLUSTER_ADDRS, or skips
+// the test when it is not set. It returns the client and a key prefix for the test.
+func newCluster(t *testing.T) (*redis.ClusterClient, string) {
+	t.Helper()
+	addrs := os.Getenv("RATELIMITER_CLUSTER_ADDRS")
+	if addrs == "" {
+		t.Skip("RATELIMITER_CLUSTER_ADDRS not set")
+	}
+	ctx := context.Background()
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: strings.Split(addrs, ",")})
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Fatalf("connecting to the cluster: %v", err)
+	}
+
+	prefix := fmt.Sprintf("ratelimiter-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		defer client.Close()
+		err := client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			iter := master.Scan(ctx, 0, prefix+":*", 100).Iterator()
+			for iter.Next(ctx) {
+				if err := master.Del(ctx, iter.Val()).Err(); err != nil {
+					return err
+				}
+			}
+			return iter.Err()
+		})
+		if err != nil {
+			t.Errorf("deleting test keys: %v", err)
+		}
+	})
+	return client, prefix
+}
+
+// Keys of different users land on different slots, and the keys a limiter
+// updates together for one user on the same one.
+func TestClusterLimiters(t *testing.T) {
+	ctx := context.Background()
+	client, prefix := newCluster(t)
+	if err := LoadScripts(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []Option{WithKeyPrefix(prefix), WithHashTags()}
+	with := func(o ...Option) []Option { return append(append([]Option(nil), opts...), o...) }
+	perMinute := Limit{Requests: 3, Window: time.Minute}
+	limiters := map[string]Limiter{
+		"FixedWindow":    NewFixedWindow(client, with(WithLimit(3), WithWindow(time.Minute))...),
+		"SlidingLog":     NewSlidingLog(client, with(WithLimit(3), WithWindow(time.Minute))...),
+		"SlidingCounter": NewSlidingCounter(client, with(WithLimit(3), WithWindow(time.Minute))...),
+		"SlidingBuckets": NewSlidingBuckets(client, with(WithLimit(3), WithWindow(time.Minute))...),
+		"TokenBucket":    NewTokenBucket(client, with(WithRate(0.01), WithBurst(3))...),
+		"GCRA":           NewGCRA(client, with(WithRate(0.01), WithBurst(3))...),
+		"LeakyBucket":    NewLeakyBucket(client, with(WithRate(0.01), WithBurst(3))...),
+		"Quota":          NewQuota(client, Daily, with(WithLimit(3))...),
+		"MultiLimiter":   NewMultiLimiter(client, []Limit{perMinute, {Requests: 100, Window: time.Hour}}, opts...),
+		"Bandwidth":      NewBandwidth(client, perMinute, Limit{Requests: 1 << 20, Window: time.Minute}, opts...),
+		"Hierarchical":   NewHierarchical(client, Limit{Requests: 1000, Window: time.Minute}, perMinute, opts...).Child("tenant"),
+	}
+	for name, l := range limiters {
+		t.Run(name, func(t *testing.T) {
+			for user := range 20 {
+				key := fmt.Sprintf("user:%d", user)
+				for i := range 3 {
+					if res, err := l.Allow(ctx, key); err != nil || !res.Allowed {
+						t.Fatalf("Allow %d for %s = %+v, %v, want allowed", i, key, res, err)
+					}
+				}
+				if res, err := l.Allow(ctx, key); err != nil || res.Allowed {
+					t.Fatalf("Allow over the limit for %s = %+v, %v, want denied", key, res, err)
+				}
+				if err := l.Reset(ctx, key); err != nil {
+					t.Fatal(err)
+				}
+				if res, err := l.Check(ctx, key); err != nil || !res.Allowed {
+					t.Fatalf("Check after Reset for %s = %+v, %v, want allowed", key, res, err)
+				}
+			}
+		})
+	}
+}
+
+// Chain links live on different slots, so they are pipelined and the
+// tokens of a denied request are given back.
+func TestClusterChain(t *testing.T) {
+	ctx := context.Background()
+	client, prefix := newCluster(t)
+	l := NewChain(client, []ChainLink{
+		{Name: "ip", Limit: Limit{Requests: 1, Window: time.Minute}},
+		{Name: "user", Limit: Limit{Requests: 5, Window: time.Minute}},
+	}, WithKeyPrefix(prefix))
+
+	if res, err := l.Allow(ctx, "203.0.113.7", "alice"); err != nil || !res.Allowed {
+		t.Fatalf("Allow = %+v, %v, want allowed", res, err)
+	}
+	if res, err := l.Allow(ctx, "203.0.113.7", "alice"); err != nil || res.Allowed {
+		t.Fatalf("Allow over the ip limit = %+v, %v, want denied", res, err)
+	}
+	// The denied request gave its user token back
+	for i := range 4 {
+		if res, err := l.Allow(ctx, fmt.Sprintf("198.51.100.%d", i), "alice"); err != nil || !res.Allowed {
+			t.Fatalf("Allow %d from another ip = %+v, %v, want allowed", i, res, err)
+		}
+	}
+}
+
+// Scripts reach every master, and limiters reload them when a master lost
+// its script cache, as after a failover.
+func TestClusterScripts(t *testing.T) {
+	ctx := context.Background()
+	client, prefix := newCluster(t)
+	if err := LoadScripts(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, len(scripts))
+	for i, s := range scripts {
+		hashes[i] = s.Hash()
+	}
+	err := client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		exists, err := master.ScriptExists(ctx, hashes...).Result()
+		if err != nil {
+			return err
+		}
+		for i, ok := range exists {
+			if !ok {
+				return fmt.Errorf("script %s missing on %s", hashes[i], master.Options().Addr)
+			}
+		}
+		return master.ScriptFlush(ctx).Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewSlidingCounter(client, WithKeyPrefix(prefix), WithHashTags(), WithLimit(1), WithWindow(time.Minute))
+	for user := range 20 {
+		key := fmt.Sprintf("user:%d", user)
+		if res, err := l.Allow(ctx, key); err != nil || !res.Allowed {
+			t.Fatalf("Allow for %s after SCRIPT FLUSH = %+v, %v, want allowed", key, res, err)
+		}
+	}
+	results, err := NewFixedWindow(client, WithKeyPrefix(prefix), WithLimit(1), WithWindow(time.Minute)).
+		AllowMany(ctx, []string{"a", "b", "c", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Allowed || !results[1].Allowed || !results[2].Allowed || results[3].Allowed {
+		t.Errorf("AllowMany after SCRIPT FLUSH = %+v, want a, b and c allowed once", results)
+	}
+}
+
+// Limiters updating several keys in one script refuse a cluster client
+// without hash tags instead of failing every request with CROSSSLOT.
+func TestClusterNeedsHashTags(t *testing.T) {
+	client, _ := newCluster(t)
+	defer func() {
+		if recover() == nil {
+			t.Error("NewMultiLimiter on a cluster without WithHashTags didn't panic")
+		}
+	}()
+	NewMultiLimiter(client, []Limit{{Requests: 1, Window: time.Second}, {Requests: 10, Window: time.Minute}})
+}
@@ -0,0 +1,361 @@
+package ratelimiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultLeaseTimeout is how long a Concurrency slot stays held without a
+// heartbeat when WithLeaseTimeout is not given.
+const DefaultLeaseTimeout = 30 * time.Second
+
+// Holders are members of a sorted set scored by their last heartbeat.
+// Holders that stopped sending heartbeats, e.g. because the process crashed,
+// are removed before counting, so their slots are not leaked forever.
+var acquireScript = newScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local lease = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local id = ARGV[4]
+
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - lease)
+	local count = redis.call('ZCARD', key)
+	if count >= limit then
+		return {0, count}
+	end
+
+	redis.call('ZADD', key, now, id)
+	redis.call('PEXPIRE', key, lease)
+	return {1, count + 1}
+`)
+
+// A heartbeat only refreshes a slot that is still held, so a holder whose
+// lease already expired cannot sneak back in above the limit.
+var heartbeatScript = newScript(`
+	local key = KEYS[1]
+	local lease = tonumber(ARGV[1])
+	local now = tonumber(ARGV[2])
+	local id = ARGV[3]
+
+	local last = redis.call('ZSCORE', key, id)
+	if not last or tonumber(last) <= now - lease then
+		return 0
+	end
+	redis.call('ZADD', key, now, id)
+	redis.call('PEXPIRE', key, lease)
+	return 1
+`)
+
+// Concurrency limiter
+// Distributed semaphore capping the number of requests in flight for a key
+// rather than their rate, e.g. to protect a downstream with a limited number
+// of connections. Each holder sends heartbeats; a slot whose holder stopped
+// sending them for WithLeaseTimeout is freed automatically.
+type Concurrency struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewConcurrency returns a limiter allowing up to WithLimit requests in flight per key.
+// It panics if the options are invalid.
+func NewConcurrency(client redis.UniversalClient, opts ...Option) *Concurrency {
+	cfg := newConfig(opts)
+	if cfg.limit <= 0 {
+		panic(fmt.Sprintf("ratelimiter: limit must be positive, got %d", cfg.limit))
+	}
+	if cfg.lease < time.Millisecond {
+		panic(fmt.Sprintf("ratelimiter: lease timeout must be at least 1ms, got %s", cfg.lease))
+	}
+	cfg.preloadScripts(client)
+	return &Concurrency{client: client, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *Concurrency) forKey(ctx context.Context, key string) (*Concurrency, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmConcurrency, Key: key, Err: err}
+	}
+	return &Concurrency{client: l.client, config: cfg}, nil
+}
+
+// Acquire tries to take a slot for key. The returned Lease reports whether
+// it succeeded; if so the caller must Release it when done and send
+// Heartbeats more often than the lease timeout while the work runs.
+// Result.Remaining is the number of free slots and RetryAfter is not set,
+// since nobody knows when holders will finish.
+func (l *Concurrency) Acquire(ctx context.Context, key string) (*Lease, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmConcurrency, Key: key, Err: err}
+	}
+	res, err := l.acquire(ctx, key, id)
+	if err != nil {
+		if res, err = l.storeFailed(ctx, key, err); err != nil {
+			return nil, err
+		}
+	} else {
+		res = l.decide(ctx, AlgorithmConcurrency, key, res)
+	}
+	return &Lease{limiter: l, key: key, id: id, res: res}, nil
+}
+
+func (l *Concurrency) acquire(ctx context.Context, key, id string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("concurrency", key)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		res, err := acquireStore(ctx, l.store, redisKey, id, l.limit, l.lease, now)
+		if err != nil {
+			return Result{}, storeError(AlgorithmConcurrency, key, err)
+		}
+		return res, nil
+	}
+	result, err := acquireScript.Run(ctx, l.client, []string{redisKey},
+		l.limit, l.lease.Milliseconds(), now.UnixMilli(), id).Int64Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmConcurrency, key, err)
+	}
+	// Redis Lua returns {acquired, in flight}
+	if len(result) != 2 {
+		return Result{}, replyError(AlgorithmConcurrency, key, result)
+	}
+	return Result{
+		Allowed:   result[0] == 1,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-result[1]),
+		ResetAt:   now,
+	}, nil
+}
+
+// Heartbeat keeps the slot id holds for key from being reclaimed.
+// It returns ErrLeaseLost if the slot was already reclaimed or released.
+func (l *Concurrency) Heartbeat(ctx context.Context, key, id string) error {
+	redisKey, err := l.key("concurrency", key)
+	if err != nil {
+		return err
+	}
+	held, err := heartbeat(ctx, l.client, l.store, redisKey, id, l.lease, l.clock.Now())
+	if err != nil {
+		return storeError(AlgorithmConcurrency, key, err)
+	}
+	if !held {
+		return &Error{Algorithm: AlgorithmConcurrency, Key: key, Err: ErrLeaseLost}
+	}
+	return nil
+}
+
+// Release frees the slot id holds for key.
+// Releasing a slot that is no longer held is a no-op.
+func (l *Concurrency) Release(ctx context.Context, key, id string) error {
+	redisKey, err := l.key("concurrency", key)
+	if err != nil {
+		return err
+	}
+	if err := release(ctx, l.client, l.store, redisKey, id); err != nil {
+		return storeError(AlgorithmConcurrency, key, err)
+	}
+	return nil
+}
+
+// Check reports the number of free slots for key without taking one.
+func (l *Concurrency) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	count, err := l.inFlight(ctx, key, now)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:   count < l.limit,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-count),
+		ResetAt:   now,
+	}, nil
+}
+
+// Reset frees all slots for key.
+func (l *Concurrency) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("concurrency", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmConcurrency, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmConcurrency, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+// Count is the number of slots held with a live lease.
+func (l *Concurrency) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("concurrency", key)
+	if err != nil {
+		return State{}, err
+	}
+	var count int64
+	var ttl time.Duration
+	if l.store != nil {
+		if count, ttl, err = inFlightStore(ctx, l.store, redisKey, l.lease, now); err != nil {
+			return State{}, storeError(AlgorithmConcurrency, key, err)
+		}
+	} else {
+		if count, err = l.inFlight(ctx, key, now); err != nil {
+			return State{}, err
+		}
+		if ttl, err = l.client.PTTL(ctx, redisKey).Result(); err != nil {
+			return State{}, storeError(AlgorithmConcurrency, key, err)
+		}
+	}
+	return State{
+		Algorithm: AlgorithmConcurrency,
+		Key:       key,
+		Limit:     l.limit,
+		Count:     count,
+		TTL:       max(0, ttl),
+	}, nil
+}
+
+// inFlight counts the holders of key whose lease has not expired at now.
+func (l *Concurrency) inFlight(ctx context.Context, key string, now time.Time) (int64, error) {
+	redisKey, err := l.key("concurrency", key)
+	if err != nil {
+		return 0, err
+	}
+	if l.store != nil {
+		count, _, err := inFlightStore(ctx, l.store, redisKey, l.lease, now)
+		if err != nil {
+			return 0, storeError(AlgorithmConcurrency, key, err)
+		}
+		return count, nil
+	}
+	// Exclusive, like the script that removes scores up to and including now - lease
+	minScore := "(" + strconv.FormatInt(now.Add(-l.lease).UnixMilli(), 10)
+	count, err := l.client.ZCount(ctx, redisKey, minScore, "+inf").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, storeError(AlgorithmConcurrency, key, err)
+	}
+	return count, nil
+}
+
+// holders are the slot holders of a Concurrency or AdaptiveConcurrency key
+// kept in a Store: the last heartbeat of each in unix ms, by holder id.
+type holders map[string]int64
+
+// trim removes the holders whose lease expired at now, like the scripts do,
+// and returns how many are left.
+func (h holders) trim(lease time.Duration, now time.Time) int64 {
+	expired := now.Add(-lease).UnixMilli()
+	for id, last := range h {
+		if last <= expired {
+			delete(h, id)
+		}
+	}
+	return int64(len(h))
+}
+
+// acquireStore takes a slot for id among the holders kept in store at key,
+// the way acquireScript does.
+func acquireStore(ctx context.Context, store Store, key, id string, limit int64, lease time.Duration, now time.Time) (Result, error) {
+	var res Result
+	err := updateState(ctx, store, key, func(h *holders, _ time.Duration) (bool, time.Duration) {
+		count := h.trim(lease, now)
+		res = Result{Limit: limit, Remaining: max(0, limit-count), ResetAt: now}
+		if count >= limit {
+			return false, 0
+		}
+		if *h == nil {
+			*h = holders{}
+		}
+		(*h)[id] = now.UnixMilli()
+		res.Allowed, res.Remaining = true, limit-count-1
+		return true, lease
+	})
+	return res, err
+}
+
+// inFlightStore counts the holders kept in store at key whose lease has not
+// expired at now, and returns how long until they all expire.
+func inFlightStore(ctx context.Context, store Store, key string, lease time.Duration, now time.Time) (int64, time.Duration, error) {
+	var count int64
+	var ttl time.Duration
+	err := updateState(ctx, store, key, func(h *holders, expiresIn time.Duration) (bool, time.Duration) {
+		count, ttl = h.trim(lease, now), expiresIn
+		return false, 0
+	})
+	return count, ttl, err
+}
+
+// heartbeat refreshes the slot id holds at key, in store or else in Redis.
+// It reports false if the slot is no longer held.
+func heartbeat(ctx context.Context, client redis.UniversalClient, store Store, key, id string, lease time.Duration, now time.Time) (bool, error) {
+	if store == nil {
+		held, err := heartbeatScript.Run(ctx, client, []string{key}, lease.Milliseconds(), now.UnixMilli(), id).Int64()
+		return held == 1, err
+	}
+	var held bool
+	err := updateState(ctx, store, key, func(h *holders, _ time.Duration) (bool, time.Duration) {
+		// Like heartbeatScript, a holder whose lease expired can't come back
+		h.trim(lease, now)
+		if _, held = (*h)[id]; !held {
+			return false, 0
+		}
+		(*h)[id] = now.UnixMilli()
+		return true, lease
+	})
+	return held, err
+}
+
+// release frees the slot id holds at key, in store or else in Redis.
+func release(ctx context.Context, client redis.UniversalClient, store Store, key, id string) error {
+	if store == nil {
+		return client.ZRem(ctx, key, id).Err()
+	}
+	return updateState(ctx, store, key, func(h *holders, expiresIn time.Duration) (bool, time.Duration) {
+		if _, held := (*h)[id]; !held {
+			return false, 0
+		}
+		delete(*h, id)
+		return true, expiresIn
+	})
+}
+
+// randomID returns a random identifier, e.g. for a slot holder.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
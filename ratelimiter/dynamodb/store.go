@@ -0,0 +1,329 @@
+// Package dynamodb implements a ratelimiter.Store on Amazon DynamoDB, so
+// serverless deployments on AWS can use the same limiters without Redis.
+//
+// Counters are updated with conditional UpdateItem expressions (atomic ADD
+// while the window is live), and token buckets and the state of Update with
+// optimistic concurrency: the item is read, computed and written back on the
+// condition that its version didn't change. Every limiter accepting
+// ratelimiter.WithStore runs on it.
+//
+// The table needs a string partition key named "pk" and no sort key.
+// Enable DynamoDB's Time To Live on the "ttl" attribute so expired state is
+// deleted; until DynamoDB gets to it, expired state is ignored.
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// Attribute names. Names like "count" and "ttl" are reserved words in
+// expressions, so expressions refer to them by placeholders such as "#count".
+const (
+	attrKey     = "pk"
+	attrCount   = "count"
+	attrExpires = "expires" // unix ms, when the counter ends
+	attrTokens  = "tokens"
+	attrLast    = "last"  // unix ns, when the bucket was last refilled
+	attrState   = "state" // binary, stored with Update
+	attrVersion = "version"
+	attrTTL     = "ttl" // unix seconds, for DynamoDB's Time To Live
+)
+
+// updateRetries bounds how often an update is retried when another client
+// changed the item first.
+const updateRetries = 16
+
+// errConflict is returned when an update kept conflicting.
+var errConflict = errors.New("dynamodb: too many conflicting updates")
+
+// API is the part of the DynamoDB client the store uses.
+// It is implemented by *dynamodb.Client.
+type API interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// Store is a ratelimiter.Store keeping limiter state in a DynamoDB table.
+type Store struct {
+	client API
+	table  string
+	clock  ratelimiter.Clock
+}
+
+var _ ratelimiter.Store = (*Store)(nil)
+
+// New returns a Store keeping limiter state in table.
+func New(client API, table string) *Store {
+	return &Store{client: client, table: table, clock: ratelimiter.SystemClock}
+}
+
+// SetClock replaces the clock counters expire by. Pass the clock given to
+// the limiters with WithClock, so their windows end at the same time.
+func (s *Store) SetClock(clock ratelimiter.Clock) {
+	s.clock = clock
+}
+
+// Increment implements ratelimiter.Store.
+// A live counter is incremented with ADD; a missing or expired one is
+// replaced by a new counter, and whichever client loses that race retries.
+func (s *Store) Increment(ctx context.Context, key string, n int64, ttl time.Duration) (int64, time.Duration, error) {
+	for range updateRetries {
+		now := s.clock.Now()
+		out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                aws.String(s.table),
+			Key:                      s.key(key),
+			UpdateExpression:         aws.String("ADD #count :n"),
+			ConditionExpression:      aws.String("#expires > :now"),
+			ExpressionAttributeNames: map[string]string{"#count": attrCount, "#expires": attrExpires},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":n":   number(n),
+				":now": number(now.UnixMilli()),
+			},
+			ReturnValues: types.ReturnValueAllNew,
+		})
+		if err == nil {
+			count, _ := intAttr(out.Attributes, attrCount)
+			expires, _ := intAttr(out.Attributes, attrExpires)
+			return count, time.UnixMilli(expires).Sub(now), nil
+		}
+		if !conditionFailed(err) {
+			return 0, 0, err
+		}
+
+		expires := now.Add(ttl)
+		item := s.key(key)
+		item[attrCount] = number(n)
+		item[attrExpires] = number(expires.UnixMilli())
+		item[attrTTL] = number(expiry(expires))
+		_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                aws.String(s.table),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#expires) OR #expires <= :now"),
+			ExpressionAttributeNames: map[string]string{"#expires": attrExpires},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": number(now.UnixMilli()),
+			},
+		})
+		if err == nil {
+			return n, ttl, nil
+		}
+		if !conditionFailed(err) {
+			return 0, 0, err
+		}
+	}
+	return 0, 0, errConflict
+}
+
+// Counter implements ratelimiter.Store.
+func (s *Store) Counter(ctx context.Context, key string) (int64, time.Duration, error) {
+	item, err := s.get(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, ok1 := intAttr(item, attrCount)
+	expires, ok2 := intAttr(item, attrExpires)
+	left := time.UnixMilli(expires).Sub(s.clock.Now())
+	if !ok1 || !ok2 || left <= 0 {
+		return 0, 0, nil
+	}
+	return count, left, nil
+}
+
+// TakeTokens implements ratelimiter.Store.
+func (s *Store) TakeTokens(ctx context.Context, key string, b ratelimiter.Bucket, cost float64, now time.Time) (bool, float64, error) {
+	for range updateRetries {
+		item, err := s.get(ctx, key)
+		if err != nil {
+			return false, 0, err
+		}
+		tokens, last, ok := bucketState(item)
+		if !ok {
+			// A bucket that doesn't exist is full
+			tokens, last = b.Capacity, now
+		}
+		allowed, tokens, last := b.Take(tokens, last, cost, now)
+		if !allowed {
+			return false, tokens, nil
+		}
+
+		version, exists := intAttr(item, attrVersion)
+		next := s.key(key)
+		next[attrTokens] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(tokens, 'g', -1, 64)}
+		next[attrLast] = number(last.UnixNano())
+		next[attrVersion] = number(version + 1)
+		next[attrTTL] = number(expiry(now.Add(b.TTL(tokens))))
+		put := &dynamodb.PutItemInput{
+			TableName:                aws.String(s.table),
+			Item:                     next,
+			ExpressionAttributeNames: map[string]string{"#version": attrVersion},
+		}
+		if exists {
+			put.ConditionExpression = aws.String("#version = :version")
+			put.ExpressionAttributeValues = map[string]types.AttributeValue{":version": number(version)}
+		} else {
+			put.ConditionExpression = aws.String("attribute_not_exists(#version)")
+		}
+		_, err = s.client.PutItem(ctx, put)
+		if err == nil {
+			return true, tokens, nil
+		}
+		if !conditionFailed(err) {
+			return false, 0, err
+		}
+	}
+	return false, 0, errConflict
+}
+
+// Tokens implements ratelimiter.Store.
+func (s *Store) Tokens(ctx context.Context, key string, b ratelimiter.Bucket, now time.Time) (float64, error) {
+	item, err := s.get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	tokens, last, ok := bucketState(item)
+	if !ok {
+		return b.Capacity, nil
+	}
+	return b.Tokens(tokens, last, now), nil
+}
+
+// Update implements ratelimiter.Store.
+// The state expires at the "expires" attribute, like counters.
+func (s *Store) Update(ctx context.Context, key string, fn func(state []byte, expiresIn time.Duration) ([]byte, time.Duration)) error {
+	for range updateRetries {
+		item, err := s.get(ctx, key)
+		if err != nil {
+			return err
+		}
+		now := s.clock.Now()
+		var state []byte
+		var left time.Duration
+		v, ok := item[attrState].(*types.AttributeValueMemberB)
+		expires, _ := intAttr(item, attrExpires)
+		if ok && expires > now.UnixMilli() {
+			state, left = v.Value, time.UnixMilli(expires).Sub(now)
+		}
+		next, ttl := fn(state, left)
+		if next == nil {
+			return nil
+		}
+
+		version, exists := intAttr(item, attrVersion)
+		expiresAt := now.Add(ttl)
+		updated := s.key(key)
+		updated[attrState] = &types.AttributeValueMemberB{Value: next}
+		updated[attrExpires] = number(expiresAt.UnixMilli())
+		updated[attrVersion] = number(version + 1)
+		updated[attrTTL] = number(expiry(expiresAt))
+		put := &dynamodb.PutItemInput{
+			TableName:                aws.String(s.table),
+			Item:                     updated,
+			ExpressionAttributeNames: map[string]string{"#version": attrVersion},
+		}
+		if exists {
+			put.ConditionExpression = aws.String("#version = :version")
+			put.ExpressionAttributeValues = map[string]types.AttributeValue{":version": number(version)}
+		} else {
+			put.ConditionExpression = aws.String("attribute_not_exists(#version)")
+		}
+		_, err = s.client.PutItem(ctx, put)
+		if err == nil {
+			return nil
+		}
+		if !conditionFailed(err) {
+			return err
+		}
+	}
+	return errConflict
+}
+
+// Delete implements ratelimiter.Store.
+func (s *Store) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.table),
+			Key:       s.key(key),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get reads the item for key with a strongly consistent read, so an update
+// never starts from a stale copy. A missing item is nil.
+func (s *Store) get(ctx context.Context, key string) (map[string]types.AttributeValue, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            s.key(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Item, nil
+}
+
+// key returns the primary key of the item for key.
+func (s *Store) key(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{attrKey: &types.AttributeValueMemberS{Value: key}}
+}
+
+// bucketState returns the stored tokens and last refill of a bucket.
+// It reports false for a missing or malformed bucket, which starts over
+// full like in the Lua scripts.
+func bucketState(item map[string]types.AttributeValue) (float64, time.Time, bool) {
+	v, ok := item[attrTokens].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	tokens, err := strconv.ParseFloat(v.Value, 64)
+	if err != nil || math.IsNaN(tokens) || math.IsInf(tokens, 0) {
+		return 0, time.Time{}, false
+	}
+	last, ok := intAttr(item, attrLast)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return tokens, time.Unix(0, last), true
+}
+
+// intAttr returns the integer attribute name of item, if it is set.
+func intAttr(item map[string]types.AttributeValue, name string) (int64, bool) {
+	v, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v.Value, 10, 64)
+	return n, err == nil
+}
+
+// number returns a number attribute holding n.
+func number(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// expiry returns the Time To Live attribute for state expiring at t,
+// rounded up to whole seconds so DynamoDB never deletes it early.
+func expiry(t time.Time) int64 {
+	return (t.UnixMilli() + 999) / 1000
+}
+
+// conditionFailed reports whether err is a failed condition expression,
+// i.e. another client changed the item first.
+func conditionFailed(err error) bool {
+	var failed *types.ConditionalCheckFailedException
+	return errors.As(err, &failed)
+}
@@ -0,0 +1,189 @@
+// Package envoyrls implements Envoy's rate limit service,
+// envoy.service.ratelimit.v3.RateLimitService, on top of this module's
+// limiters, so Envoy, Istio or Contour deployments can point their global
+// rate limit filter at it instead of running a separate rate limit
+// service:
+//
+//	srv := grpc.NewServer()
+//	rlsv3.RegisterRateLimitServiceServer(srv, envoyrls.NewServer([]envoyrls.Rule{
+//		{Domain: "edge", Entries: []envoyrls.Entry{{Key: "remote_address"}}, Limiter: perIP},
+//		{Domain: "edge", Entries: []envoyrls.Entry{{Key: "path", Value: "/login"}, {Key: "remote_address"}}, Limiter: logins},
+//	}))
+package envoyrls
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Entry matches an entry of a descriptor sent by Envoy, e.g. the
+// "remote_address" entry of a remote_address action.
+type Entry struct {
+	Key string
+	// Value, if set, is the only value matched, e.g. "/login" for a
+	// "path" entry. Otherwise each value is limited separately.
+	Value string
+}
+
+// Rule decides the descriptors of a domain matching its entries.
+type Rule struct {
+	// Domain is the domain configured on Envoy's rate limit filter.
+	Domain string
+	// Entries match the entries of a descriptor, in order.
+	Entries []Entry
+	// Limiter decides the matched descriptors, keyed by the domain and the
+	// descriptor's entries, e.g. "edge:remote_address=203.0.113.7".
+	Limiter ratelimiter.Limiter
+	// Limit, if set, is reported to Envoy as the descriptor's current
+	// limit, which it announces in the X-RateLimit-Limit header. Its
+	// Window must be a second, minute, hour, day, week, month or year.
+	Limit ratelimiter.Limit
+}
+
+// Server implements rlsv3.RateLimitServiceServer.
+type Server struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	rules []Rule
+}
+
+// NewServer returns a rate limit service deciding descriptors with the
+// first of rules they match. It panics if a rule has no domain, entries or
+// limiter, or a Limit whose Window isn't a unit Envoy knows.
+func NewServer(rules []Rule) *Server {
+	for _, r := range rules {
+		if r.Domain == "" || len(r.Entries) == 0 || r.Limiter == nil {
+			panic("envoyrls: a rule needs a domain, entries and a limiter")
+		}
+		if _, ok := unit(r.Limit.Window); r.Limit.Requests > 0 && !ok {
+			panic("envoyrls: the window of a rule's limit must be a second, minute, hour, day, week, month or year")
+		}
+	}
+	return &Server{rules: rules}
+}
+
+// ShouldRateLimit implements rlsv3.RateLimitServiceServer. Every descriptor
+// is decided, costing the hits_addend of the descriptor or the request, and
+// the request is over the limit if any of them is. Descriptors no rule
+// matches are not limited, and the limit overrides descriptors may carry
+// are ignored: limits are those of the rules' limiters. An error deciding
+// a descriptor fails the call with codes.Unavailable, for Envoy to apply
+// its failure_mode_deny setting.
+func (s *Server) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	resp := &rlsv3.RateLimitResponse{
+		OverallCode: rlsv3.RateLimitResponse_OK,
+		Statuses:    make([]*rlsv3.RateLimitResponse_DescriptorStatus, len(req.GetDescriptors())),
+	}
+	for i, d := range req.GetDescriptors() {
+		st, err := s.decide(ctx, req, d)
+		if err != nil {
+			return nil, err
+		}
+		if st.Code == rlsv3.RateLimitResponse_OVER_LIMIT {
+			resp.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		resp.Statuses[i] = st
+	}
+	return resp, nil
+}
+
+// decide decides one descriptor of req.
+func (s *Server) decide(ctx context.Context, req *rlsv3.RateLimitRequest, d *ratelimitv3.RateLimitDescriptor) (*rlsv3.RateLimitResponse_DescriptorStatus, error) {
+	rule, ok := s.match(req.GetDomain(), d)
+	if !ok {
+		return &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK}, nil
+	}
+	cost := int64(req.GetHitsAddend())
+	if d.GetHitsAddend() != nil {
+		cost = int64(d.GetHitsAddend().GetValue())
+	}
+	if cost == 0 {
+		// Unset, as Envoy only sends it for costs other than one
+		cost = 1
+	}
+	res, err := rule.Limiter.AllowN(ctx, key(req.GetDomain(), d), cost)
+	if err != nil {
+		if errors.Is(err, ratelimiter.ErrStoreUnavailable) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	st := &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code:           rlsv3.RateLimitResponse_OK,
+		LimitRemaining: uint32(min(max(res.Remaining, 0), math.MaxUint32)),
+	}
+	if !res.Allowed {
+		st.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+	}
+	if reset := time.Until(res.ResetAt); !res.ResetAt.IsZero() && reset > 0 {
+		st.DurationUntilReset = durationpb.New(reset)
+	}
+	if u, ok := unit(rule.Limit.Window); rule.Limit.Requests > 0 && ok {
+		st.CurrentLimit = &rlsv3.RateLimitResponse_RateLimit{
+			RequestsPerUnit: uint32(min(rule.Limit.Requests, math.MaxUint32)),
+			Unit:            u,
+		}
+	}
+	return st, nil
+}
+
+// match returns the first rule matching a descriptor of domain.
+func (s *Server) match(domain string, d *ratelimitv3.RateLimitDescriptor) (Rule, bool) {
+	entries := d.GetEntries()
+rules:
+	for _, r := range s.rules {
+		if r.Domain != domain || len(r.Entries) != len(entries) {
+			continue
+		}
+		for i, e := range r.Entries {
+			if e.Key != entries[i].GetKey() || (e.Value != "" && e.Value != entries[i].GetValue()) {
+				continue rules
+			}
+		}
+		return r, true
+	}
+	return Rule{}, false
+}
+
+// key returns the limiter key of a descriptor of domain, e.g.
+// "edge:path=/login:remote_address=203.0.113.7".
+func key(domain string, d *ratelimitv3.RateLimitDescriptor) string {
+	var b strings.Builder
+	b.WriteString(domain)
+	for _, e := range d.GetEntries() {
+		b.WriteString(":" + e.GetKey() + "=" + e.GetValue())
+	}
+	return b.String()
+}
+
+// unit returns the Envoy unit of a window.
+func unit(window time.Duration) (rlsv3.RateLimitResponse_RateLimit_Unit, bool) {
+	const day = 24 * time.Hour
+	switch window {
+	case time.Second:
+		return rlsv3.RateLimitResponse_RateLimit_SECOND, true
+	case time.Minute:
+		return rlsv3.RateLimitResponse_RateLimit_MINUTE, true
+	case time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_HOUR, true
+	case day:
+		return rlsv3.RateLimitResponse_RateLimit_DAY, true
+	case 7 * day:
+		return rlsv3.RateLimitResponse_RateLimit_WEEK, true
+	case 30 * day:
+		return rlsv3.RateLimitResponse_RateLimit_MONTH, true
+	case 365 * day:
+		return rlsv3.RateLimitResponse_RateLimit_YEAR, true
+	}
+	return rlsv3.RateLimitResponse_RateLimit_UNKNOWN, false
+}
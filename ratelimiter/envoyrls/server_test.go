@@ -0,0 +1,109 @@
+package envoyrls
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// descriptor returns a descriptor of alternating entry keys and values.
+func descriptor(kv ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+// unavailable is a Limiter whose store can't be reached.
+type unavailable struct{ ratelimiter.Limiter }
+
+func (unavailable) AllowN(context.Context, string, int64) (ratelimiter.Result, error) {
+	return ratelimiter.Result{}, ratelimiter.ErrStoreUnavailable
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	ctx := context.Background()
+	limit := ratelimiter.Limit{Requests: 2, Window: time.Minute}
+	l := ratelimiter.NewFixedWindow(nil, ratelimiter.WithStore(ratelimiter.NewMemoryStore()),
+		ratelimiter.WithLimit(limit.Requests), ratelimiter.WithWindow(limit.Window))
+	srv := NewServer([]Rule{{
+		Domain:  "edge",
+		Entries: []Entry{{Key: "path", Value: "/login"}, {Key: "remote_address"}},
+		Limiter: l,
+		Limit:   limit,
+	}})
+	login := descriptor("path", "/login", "remote_address", "203.0.113.7")
+	other := descriptor("path", "/search", "remote_address", "203.0.113.7")
+
+	resp, err := srv.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{login, other},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.OverallCode != rlsv3.RateLimitResponse_OK {
+		t.Fatalf("first request = %v, want OK", resp.OverallCode)
+	}
+	st := resp.Statuses[0]
+	if st.LimitRemaining != 1 {
+		t.Errorf("LimitRemaining = %d, want 1", st.LimitRemaining)
+	}
+	if got := st.CurrentLimit; got == nil || got.RequestsPerUnit != 2 || got.Unit != rlsv3.RateLimitResponse_RateLimit_MINUTE {
+		t.Errorf("CurrentLimit = %v, want 2 per minute", got)
+	}
+	if reset := st.DurationUntilReset.AsDuration(); reset <= 0 || reset > time.Minute {
+		t.Errorf("DurationUntilReset = %s, want within the window", reset)
+	}
+	if st := resp.Statuses[1]; st.Code != rlsv3.RateLimitResponse_OK || st.CurrentLimit != nil {
+		t.Errorf("status of a descriptor no rule matches = %v, want OK without a limit", st)
+	}
+
+	// A hits_addend of 2 goes over the one request left
+	resp, err = srv.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{login},
+		HitsAddend:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.OverallCode != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Fatalf("second request = %v, want OVER_LIMIT", resp.OverallCode)
+	}
+	st = resp.Statuses[0]
+	if st.Code != rlsv3.RateLimitResponse_OVER_LIMIT || st.LimitRemaining != 0 {
+		t.Errorf("status = %v, want OVER_LIMIT with none remaining", st)
+	}
+	if st.CurrentLimit == nil || st.DurationUntilReset == nil {
+		t.Errorf("OVER_LIMIT status without the limit or reset: %v", st)
+	}
+
+	// Another domain doesn't match the rule
+	resp, err = srv.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Domain:      "internal",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{login},
+		HitsAddend:  5,
+	})
+	if err != nil || resp.OverallCode != rlsv3.RateLimitResponse_OK {
+		t.Errorf("request of another domain = %v, %v, want OK", resp.GetOverallCode(), err)
+	}
+}
+
+func TestShouldRateLimitUnavailable(t *testing.T) {
+	srv := NewServer([]Rule{{Domain: "edge", Entries: []Entry{{Key: "remote_address"}}, Limiter: unavailable{}}})
+	_, err := srv.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor("remote_address", "203.0.113.7")},
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("error = %v, want Unavailable", err)
+	}
+}
@@ -0,0 +1,115 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+)
+
+var (
+	// ErrLimitExceeded is returned when a request is denied by policy,
+	// e.g. by Wait when the cost can never fit in the limit.
+	ErrLimitExceeded = errors.New("ratelimiter: limit exceeded")
+	// ErrStoreUnavailable is returned when the backing store could not be
+	// reached, so no decision was made.
+	ErrStoreUnavailable = errors.New("ratelimiter: store unavailable")
+	// ErrWouldExceedDeadline is returned by Wait when the request could not be
+	// allowed before the context deadline or the configured MaxDelay.
+	ErrWouldExceedDeadline = errors.New("ratelimiter: wait would exceed deadline")
+	// ErrLeaseLost is returned by Heartbeat when a Concurrency slot was
+	// reclaimed because its lease expired.
+	ErrLeaseLost = errors.New("ratelimiter: lease lost")
+	// ErrInvalidCost is returned by AllowN, WaitN, ReserveN and Refund for a
+	// cost of zero or less, which would otherwise give quota back or take it.
+	ErrInvalidCost = errors.New("ratelimiter: cost must be positive")
+	// ErrInvalidKey is returned for keys the configured key layout can't
+	// hold, e.g. keys containing braces with WithHashTags.
+	ErrInvalidKey = errors.New("ratelimiter: invalid key")
+)
+
+// Algorithm names reported in Error.
+const (
+	AlgorithmFixedWindow         = "fixed_window"
+	AlgorithmSlidingLog          = "sliding_log"
+	AlgorithmSlidingCounter      = "sliding_counter"
+	AlgorithmTokenBucket         = "token_bucket"
+	AlgorithmMulti               = "multi"
+	AlgorithmLeakyBucket         = "leaky_bucket"
+	AlgorithmGCRA                = "gcra"
+	AlgorithmSlidingBuckets      = "sliding_buckets"
+	AlgorithmConcurrency         = "concurrency"
+	AlgorithmAdaptive            = "adaptive"
+	AlgorithmHierarchical        = "hierarchical"
+	AlgorithmPriority            = "priority"
+	AlgorithmQuota               = "quota"
+	AlgorithmBandwidth           = "bandwidth"
+	AlgorithmEWMA                = "ewma"
+	AlgorithmPenalty             = "penalty"
+	AlgorithmAdaptiveConcurrency = "adaptive_concurrency"
+	AlgorithmIdempotent          = "idempotent"
+	AlgorithmTwoTier             = "two_tier"
+	AlgorithmChain               = "chain"
+	AlgorithmHotKeys             = "hot_keys"
+	AlgorithmFallback            = "fallback"
+	AlgorithmPrefetch            = "prefetch"
+)
+
+// Error is returned by limiters and records the algorithm and key involved.
+// Use errors.Is with ErrLimitExceeded or ErrStoreUnavailable to tell them apart.
+type Error struct {
+	Algorithm string
+	Key       string
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %q: %v", e.Algorithm, e.Key, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// storeError wraps an error returned by the store.
+func storeError(algorithm, key string, err error) error {
+	return &Error{Algorithm: algorithm, Key: key, Err: fmt.Errorf("%w: %w", ErrStoreUnavailable, err)}
+}
+
+// costError reports a request cost n that is not positive and finite, or returns nil.
+func costError[N int64 | float64](algorithm, key string, n N) error {
+	if n > 0 && !math.IsInf(float64(n), 1) {
+		return nil
+	}
+	return &Error{Algorithm: algorithm, Key: key, Err: fmt.Errorf("%w, got %v", ErrInvalidCost, n)}
+}
+
+// replyError reports a reply from the store that could not be parsed.
+func replyError(algorithm, key string, reply any) error {
+	return &Error{Algorithm: algorithm, Key: key, Err: fmt.Errorf("ratelimiter: unexpected reply from Redis: %v", reply)}
+}
+
+// stateHealed reports that a script found state for key that no version of
+// it could have written, e.g. edited by hand or by a buggy client, and reset it.
+func stateHealed(ctx context.Context, algorithm, key string) {
+	slog.WarnContext(ctx, "ratelimiter: reset corrupted state", "algorithm", algorithm, "key", key)
+}
+
+// StoreErrorPolicy decides the outcome of a request when the limiter failed
+// with err, see WithOnStoreError.
+type StoreErrorPolicy func(ctx context.Context, key string, err error) (Result, error)
+
+// FailOpen allows requests while the store is failing, favoring availability.
+// The error is logged with slog.
+func FailOpen(ctx context.Context, key string, err error) (Result, error) {
+	slog.WarnContext(ctx, "ratelimiter: allowing request, store failed", "key", key, "error", err)
+	return Result{Allowed: true}, nil
+}
+
+// FailClosed denies requests while the store is failing, favoring strictness.
+// The error is logged with slog.
+func FailClosed(ctx context.Context, key string, err error) (Result, error) {
+	slog.WarnContext(ctx, "ratelimiter: denying request, store failed", "key", key, "error", err)
+	return Result{Allowed: false}, nil
+}
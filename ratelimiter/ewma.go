@@ -0,0 +1,311 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keeps an exponentially decaying request count per key: every second the
+// count shrinks by a factor of e^(-1/window), and each request adds its cost.
+// A steady rate of r requests per second settles at r*window, so allowing
+// counts up to limit allows limit requests per window on average.
+var ewmaScript = newScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+	local max_idle = tonumber(ARGV[5])
+
+	local count = tonumber(redis.call('HGET', key, 'count') or 0)
+	local last = tonumber(redis.call('HGET', key, 'last') or now)
+	count = count * math.exp(-math.max(0, now - last) / window)
+
+	-- Floats are truncated when converted to Redis replies, so return the count as a string
+	if count + cost > limit then
+		return {0, tostring(count)}
+	end
+
+	count = count + cost
+	redis.call('HSET', key, 'count', count, 'last', now)
+	-- Expire once the count has decayed below a single request
+	local decay = window * math.log(math.max(count, 1))
+	redis.call('EXPIRE', key, math.max(math.ceil(decay) + 1, max_idle))
+
+	return {1, tostring(count)}
+`)
+
+// EWMA algorithm
+// Tracks an exponentially weighted moving average of the request rate per key
+// and rejects requests once the smoothed rate exceeds WithLimit per WithWindow.
+// Recent requests weigh the most and older ones fade out gradually, so short
+// bursts are tolerated better than with a fixed window, while using two
+// numbers per key instead of a log entry per request.
+type EWMA struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewEWMA returns a limiter allowing WithLimit requests per WithWindow on average,
+// smoothed over WithWindow.
+// It panics if the options are invalid.
+func NewEWMA(client redis.UniversalClient, opts ...Option) *EWMA {
+	cfg := newConfig(opts)
+	cfg.mustValidateWindow()
+	cfg.preloadScripts(client)
+	return &EWMA{client: client, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *EWMA) forKey(ctx context.Context, key string) (*EWMA, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmEWMA, Key: key, Err: err}
+	}
+	return &EWMA{client: l.client, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *EWMA) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *EWMA) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *EWMA) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+func (l *EWMA) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmEWMA, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmEWMA, key, res), nil
+}
+
+func (l *EWMA) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("ewma", key)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		return l.allowStore(ctx, redisKey, key, n, now)
+	}
+	result, err := ewmaScript.Run(ctx, l.client, []string{redisKey},
+		l.limit, l.window.Seconds(), seconds(now), n, int64(l.maxIdle.Seconds())).Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmEWMA, key, err)
+	}
+	// Redis Lua returns {allowed, count} with allowed as int64 and count as a string
+	if len(result) != 2 {
+		return Result{}, replyError(AlgorithmEWMA, key, result)
+	}
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return Result{}, replyError(AlgorithmEWMA, key, result)
+	}
+	count, err := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+	if err != nil {
+		return Result{}, replyError(AlgorithmEWMA, key, result)
+	}
+	return l.result(allowed == 1, count, n, now), nil
+}
+
+// allowStore is allowN for WithStore: the same decision as ewmaScript,
+// made in Go on the count kept in the Store.
+func (l *EWMA) allowStore(ctx context.Context, storeKey, key string, n int64, now time.Time) (Result, error) {
+	var allowed bool
+	var count float64
+	err := updateState(ctx, l.store, storeKey, func(st *ewmaState, _ time.Duration) (bool, time.Duration) {
+		count = l.decayed(st.Count, st.Last, now)
+		if allowed = count+float64(n) <= float64(l.limit); !allowed {
+			return false, 0
+		}
+		count += float64(n)
+		st.Count, st.Last = count, seconds(now)
+		// Expire once the count has decayed below a single request
+		decay := l.window.Seconds() * math.Log(max(count, 1))
+		return true, l.idleTTL(time.Duration(math.Ceil(decay)+1) * time.Second)
+	})
+	if err != nil {
+		return Result{}, storeError(AlgorithmEWMA, key, err)
+	}
+	return l.result(allowed, count, n, now), nil
+}
+
+// ewmaState is the decaying count of a key kept in a Store, as of Last in
+// unix seconds.
+type ewmaState struct {
+	Count float64 `json:"count"`
+	Last  float64 `json:"last"`
+}
+
+// storedState reads the count kept in the Store for key and how long until
+// it expires.
+func (l *EWMA) storedState(ctx context.Context, storeKey string) (ewmaState, time.Duration, error) {
+	var st ewmaState
+	var ttl time.Duration
+	err := updateState(ctx, l.store, storeKey, func(stored *ewmaState, expiresIn time.Duration) (bool, time.Duration) {
+		st, ttl = *stored, expiresIn
+		return false, 0
+	})
+	return st, ttl, err
+}
+
+// result builds the Result of a request costing n with the decayed count at count.
+func (l *EWMA) result(allowed bool, count float64, n int64, now time.Time) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     l.limit,
+		Remaining: max(0, int64(float64(l.limit)-count)),
+		// Time for the count to decay below a single request
+		ResetAt: now.Add(l.decayTime(count, 1)),
+	}
+	if !res.Allowed && n <= l.limit {
+		res.RetryAfter = l.decayTime(count, float64(l.limit-n))
+	}
+	return res
+}
+
+// decayTime returns how long it takes for count to decay to target.
+func (l *EWMA) decayTime(count, target float64) time.Duration {
+	if count <= target {
+		return 0
+	}
+	if target <= 0 {
+		// Never reaches zero; use the time to decay below a tiny fraction
+		target = 1e-3
+	}
+	return durationOf(l.window.Seconds() * math.Log(count/target))
+}
+
+// Check reports the state for key without counting a request.
+func (l *EWMA) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("ewma", key)
+	if err != nil {
+		return Result{}, err
+	}
+	var count float64
+	if l.store != nil {
+		st, _, err := l.storedState(ctx, redisKey)
+		if err != nil {
+			return Result{}, storeError(AlgorithmEWMA, key, err)
+		}
+		count = l.decayed(st.Count, st.Last, now)
+	} else {
+		state, err := l.client.HMGet(ctx, redisKey, "count", "last").Result()
+		if err != nil {
+			return Result{}, storeError(AlgorithmEWMA, key, err)
+		}
+		count = l.decay(state, now)
+	}
+	return l.result(count+1 <= float64(l.limit), count, 1, now), nil
+}
+
+// Reset clears the average for key.
+func (l *EWMA) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("ewma", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmEWMA, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmEWMA, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+// Estimate is the decayed request count, and Rate the smoothed rate in
+// requests per second.
+func (l *EWMA) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	redisKey, err := l.key("ewma", key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	var count float64
+	var ttl time.Duration
+	if l.store != nil {
+		st, expiresIn, err := l.storedState(ctx, redisKey)
+		if err != nil {
+			return State{}, storeError(AlgorithmEWMA, key, err)
+		}
+		count, ttl = l.decayed(st.Count, st.Last, now), expiresIn
+	} else {
+		pipe := l.client.Pipeline()
+		hmget := pipe.HMGet(ctx, redisKey, "count", "last")
+		pttl := pipe.PTTL(ctx, redisKey)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return State{}, storeError(AlgorithmEWMA, key, err)
+		}
+		count, ttl = l.decay(hmget.Val(), now), pttl.Val()
+	}
+
+	return State{
+		Algorithm: AlgorithmEWMA,
+		Key:       key,
+		Limit:     l.limit,
+		Window:    l.window,
+		Estimate:  count,
+		Rate:      count / l.window.Seconds(),
+		TTL:       max(0, ttl),
+	}, nil
+}
+
+// decay computes the count at now from the stored [count, last] state,
+// the same way the Lua script does.
+func (l *EWMA) decay(state []any, now time.Time) float64 {
+	count, err := strconv.ParseFloat(fmt.Sprint(state[0]), 64)
+	if err != nil {
+		// Nothing stored, so no recent requests
+		return 0
+	}
+	last, err := strconv.ParseFloat(fmt.Sprint(state[1]), 64)
+	if err != nil {
+		return count
+	}
+	return l.decayed(count, last, now)
+}
+
+// decayed returns the count at now of a count last updated at last, in
+// unix seconds.
+func (l *EWMA) decayed(count, last float64, now time.Time) float64 {
+	return count * math.Exp(-max(0, seconds(now)-last)/l.window.Seconds())
+}
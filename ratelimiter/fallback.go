@@ -0,0 +1,286 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// FallbackPolicy decides when a Fallback switches to its local limiter
+// and back.
+type FallbackPolicy struct {
+	// Errors consecutive store errors switch to the local limiter.
+	Errors int
+	// Probe is how often a request is sent to the primary limiter while on
+	// the local one; the first that succeeds switches back.
+	Probe time.Duration
+	// OnTransition, if set, is called on every switch, with local reporting
+	// whether requests are now decided locally and err the error that caused
+	// the switch, nil when switching back.
+	OnTransition func(ctx context.Context, local bool, err error)
+
+	// Latency, if positive, is the latency objective of the primary
+	// limiter. Once its smoothed latency has been above Latency for
+	// LatencyFor, Shift of the requests are decided locally, and Shift more
+	// after every further LatencyFor it stays above, up to all of them.
+	// Once it has been back under Latency for LatencyFor, Shift fewer are,
+	// step by step until none. While shifted, a request still goes to the
+	// primary limiter at least once per Probe to measure its latency.
+	Latency    time.Duration
+	LatencyFor time.Duration
+	Shift      float64
+	// OnShift, if set, is called whenever the share of requests decided
+	// locally for latency changes, with the new share and the smoothed
+	// latency that caused the change.
+	OnShift func(ctx context.Context, share float64, latency time.Duration)
+}
+
+func (p FallbackPolicy) validate() error {
+	if p.Errors <= 0 || p.Probe <= 0 || p.Latency < 0 {
+		return fmt.Errorf("ratelimiter: invalid fallback policy %+v", p)
+	}
+	if p.Latency > 0 && (p.LatencyFor <= 0 || !(p.Shift > 0 && p.Shift <= 1)) {
+		return fmt.Errorf("ratelimiter: invalid fallback latency objective %+v", p)
+	}
+	return nil
+}
+
+// latencySmoothing is the weight of each new sample in the smoothed
+// latency of a Fallback's primary limiter, so a single slow request
+// doesn't shift requests on its own.
+const latencySmoothing = 0.2
+
+// Fallback wraps a Redis backed Limiter with an in-process token bucket
+// that takes over during Redis outages. Each instance enforces its share of
+// the limit, so the fleet as a whole stays close to it while Redis is down
+// rather than failing open or closed. Once Redis answers again, requests
+// go back to the primary limiter, whose state is whatever Redis kept.
+type Fallback struct {
+	primary Limiter
+	local   *TokenBucket
+	policy  FallbackPolicy
+	config
+
+	mu        sync.Mutex
+	errors    int
+	degraded  bool
+	nextProbe time.Duration // monotonic, see monotonic
+
+	// Latency objective state, see FallbackPolicy.Latency
+	latency time.Duration // smoothed latency of the primary limiter
+	slow    bool          // latency is above the objective
+	since   time.Duration // monotonic start of the current slow or fast run
+	steps   int           // Shifts of the requests decided locally
+}
+
+// NewFallback returns primary falling back to a local limiter enforcing
+// limit divided among instances, the number of processes sharing primary.
+// primary must return store errors, i.e. not be configured with
+// WithOnStoreError. Only the decision and clock options are used.
+// It panics if limit or the policy is invalid, or instances is less than 1.
+func NewFallback(primary Limiter, limit Limit, instances int, policy FallbackPolicy, opts ...Option) *Fallback {
+	if err := limit.validate(); err != nil {
+		panic(err)
+	}
+	if err := policy.validate(); err != nil {
+		panic(err)
+	}
+	if instances < 1 {
+		panic(fmt.Sprintf("ratelimiter: instances must be at least 1, got %d", instances))
+	}
+	cfg := newConfig(opts)
+	local := NewTokenBucket(nil,
+		WithStore(NewMemoryStore()),
+		WithBurst(max(1, limit.burst()/int64(instances))),
+		WithRate(limit.rate()/float64(instances)),
+		WithClock(cfg.clock),
+	)
+	return &Fallback{primary: primary, local: local, policy: policy, config: cfg}
+}
+
+// Allow implements Limiter.
+func (l *Fallback) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN implements Limiter.
+func (l *Fallback) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmFallback, key, n); err != nil {
+		return Result{}, err
+	}
+	if !l.usePrimary() {
+		res, err := l.local.AllowN(ctx, key, n)
+		if err != nil {
+			return res, err
+		}
+		return l.decide(ctx, AlgorithmFallback, key, res), nil
+	}
+
+	start := monotonic(l.clock)
+	res, err := l.primary.AllowN(ctx, key, n)
+	if err == nil && l.policy.Latency > 0 {
+		l.measured(ctx, monotonic(l.clock)-start)
+	}
+	if errors.Is(err, ErrStoreUnavailable) {
+		l.failed(ctx, err)
+		res, err = l.local.AllowN(ctx, key, n)
+		if err != nil {
+			return res, err
+		}
+		return l.decide(ctx, AlgorithmFallback, key, res), nil
+	}
+	if err == nil {
+		l.succeeded(ctx)
+	}
+	return res, err
+}
+
+// Degraded reports whether requests are currently decided locally.
+func (l *Fallback) Degraded() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.degraded
+}
+
+// Shifted reports the share of requests currently decided locally because
+// the primary limiter is slow, from 0 to 1, see FallbackPolicy.Latency.
+func (l *Fallback) Shifted() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.share()
+}
+
+// Latency reports the smoothed latency of the primary limiter, measured
+// when FallbackPolicy.Latency is set.
+func (l *Fallback) Latency() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.latency
+}
+
+// share returns the share of requests decided locally for latency.
+func (l *Fallback) share() float64 {
+	return min(1, float64(l.steps)*l.policy.Shift)
+}
+
+// usePrimary reports whether a request should go to the primary limiter:
+// always while it is healthy and fast, all but the shifted share while it
+// is slow, and one request per probe interval otherwise.
+func (l *Fallback) usePrimary() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.degraded && (l.steps == 0 || rand.Float64() >= l.share()) {
+		return true
+	}
+	now := monotonic(l.clock)
+	if now < l.nextProbe {
+		return false
+	}
+	l.nextProbe = now + l.policy.Probe
+	return true
+}
+
+// measured records the latency d of a decision of the primary limiter,
+// shifting requests to or from the local limiter once the smoothed latency
+// was on the same side of the objective for long enough.
+func (l *Fallback) measured(ctx context.Context, d time.Duration) {
+	now := monotonic(l.clock)
+	l.mu.Lock()
+	if l.latency == 0 {
+		l.latency = d
+	} else {
+		l.latency += time.Duration(latencySmoothing * float64(d-l.latency))
+	}
+	if slow := l.latency > l.policy.Latency; slow != l.slow {
+		l.slow = slow
+		l.since = now
+	}
+	maxSteps := int(math.Ceil(1 / l.policy.Shift))
+	changed := false
+	if now-l.since >= l.policy.LatencyFor {
+		switch {
+		case l.slow && l.steps < maxSteps:
+			l.steps++
+			changed = true
+		case !l.slow && l.steps > 0:
+			l.steps--
+			changed = true
+		}
+		// The next step needs another full run
+		l.since = now
+	}
+	share, latency, slow := l.share(), l.latency, l.slow
+	l.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	if slow {
+		slog.WarnContext(ctx, "ratelimiter: store slow, shifting requests to local limiter",
+			"share", share, "latency", latency, "objective", l.policy.Latency)
+	} else {
+		slog.InfoContext(ctx, "ratelimiter: store fast again, shifting requests back from local limiter",
+			"share", share, "latency", latency, "objective", l.policy.Latency)
+	}
+	if l.policy.OnShift != nil {
+		l.policy.OnShift(ctx, share, latency)
+	}
+}
+
+// failed records a store error, switching to the local limiter once there
+// were enough in a row.
+func (l *Fallback) failed(ctx context.Context, err error) {
+	l.mu.Lock()
+	l.errors++
+	if l.degraded || l.errors < l.policy.Errors {
+		l.mu.Unlock()
+		return
+	}
+	l.degraded = true
+	l.nextProbe = monotonic(l.clock) + l.policy.Probe
+	l.mu.Unlock()
+
+	slog.WarnContext(ctx, "ratelimiter: store failing, switching to local limiter", "error", err)
+	if l.policy.OnTransition != nil {
+		l.policy.OnTransition(ctx, true, err)
+	}
+}
+
+// succeeded records a store success, switching back to the primary limiter.
+func (l *Fallback) succeeded(ctx context.Context) {
+	l.mu.Lock()
+	l.errors = 0
+	if !l.degraded {
+		l.mu.Unlock()
+		return
+	}
+	l.degraded = false
+	l.mu.Unlock()
+
+	slog.InfoContext(ctx, "ratelimiter: store recovered, switching back from local limiter")
+	if l.policy.OnTransition != nil {
+		l.policy.OnTransition(ctx, false, nil)
+	}
+}
+
+// Check reports the state for key without counting a request, from the
+// local limiter while degraded.
+func (l *Fallback) Check(ctx context.Context, key string) (Result, error) {
+	if l.Degraded() {
+		return l.local.Check(ctx, key)
+	}
+	return l.primary.Check(ctx, key)
+}
+
+// Reset clears key in both limiters.
+func (l *Fallback) Reset(ctx context.Context, key string) error {
+	if err := l.local.Reset(ctx, key); err != nil {
+		return err
+	}
+	return l.primary.Reset(ctx, key)
+}
@@ -0,0 +1,118 @@
+// Package fasthttplimiter adapts limiters to fasthttp, so high-throughput
+// servers and proxies built on it don't convert requests to net/http types:
+//
+//	fasthttp.ListenAndServe(":8080", fasthttplimiter.New(limiter, handler))
+//
+// Responses carry the same headers as the net/http middleware.
+package fasthttplimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/moonorange/go_rate_limiter/ratelimiter/middleware"
+	"github.com/valyala/fasthttp"
+)
+
+// KeyFunc extracts the key a request is limited by. An error fails the
+// request, see WithOnError.
+type KeyFunc func(ctx *fasthttp.RequestCtx) (string, error)
+
+// New returns next limited by l. Each request costs one unit of the key
+// returned by the KeyFunc, the remote IP by default. Allowed requests go on
+// to next with the rate limit headers set; denied ones are answered with
+// 429 Too Many Requests.
+func New(l ratelimiter.Limiter, next fasthttp.RequestHandler, opts ...Option) fasthttp.RequestHandler {
+	cfg := newConfig(opts)
+	return func(ctx *fasthttp.RequestCtx) {
+		key, err := cfg.keyFunc(ctx)
+		if err != nil {
+			cfg.onError(ctx, err)
+			return
+		}
+		// RequestCtx is a context.Context, but one that is never canceled
+		res, err := l.Allow(context.Background(), key)
+		if err != nil {
+			cfg.onError(ctx, err)
+			return
+		}
+		SetHeaders(&ctx.Response.Header, cfg.headers, res)
+		if !res.Allowed {
+			reply(ctx, fasthttp.StatusTooManyRequests)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// RemoteIP is the default KeyFunc: the IP address the request came from.
+// Behind a proxy that is the proxy's address.
+func RemoteIP(ctx *fasthttp.RequestCtx) (string, error) {
+	return ctx.RemoteIP().String(), nil
+}
+
+// SetHeaders sets the rate limit headers of style on a fasthttp response,
+// the same as on a net/http one.
+func SetHeaders(h *fasthttp.ResponseHeader, style middleware.HeaderStyle, res ratelimiter.Result) {
+	std := make(http.Header)
+	style.Set(std, res)
+	for name, values := range std {
+		for _, v := range values {
+			h.Add(name, v)
+		}
+	}
+}
+
+type config struct {
+	keyFunc KeyFunc
+	headers middleware.HeaderStyle
+	onError func(ctx *fasthttp.RequestCtx, err error)
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{keyFunc: RemoteIP, onError: onError}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Option configures the handler.
+type Option func(*config)
+
+// WithKeyFunc sets how the key of a request is extracted.
+// Defaults to RemoteIP.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(cfg *config) { cfg.keyFunc = fn }
+}
+
+// WithHeaderStyle selects the rate limit headers of responses.
+// Defaults to middleware.HeadersBoth.
+func WithHeaderStyle(style middleware.HeaderStyle) Option {
+	return func(cfg *config) { cfg.headers = style }
+}
+
+// WithOnError sets how a request is answered when no decision could be
+// made. The default answers 503 Service Unavailable when the store failed
+// and 500 Internal Server Error otherwise.
+func WithOnError(fn func(ctx *fasthttp.RequestCtx, err error)) Option {
+	return func(cfg *config) { cfg.onError = fn }
+}
+
+func onError(ctx *fasthttp.RequestCtx, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ratelimiter.ErrStoreUnavailable) {
+		status = http.StatusServiceUnavailable
+	}
+	reply(ctx, status)
+}
+
+// reply answers ctx with status and its text. Unlike ctx.Error it keeps the
+// headers already set, such as the rate limit headers of a denied request.
+func reply(ctx *fasthttp.RequestCtx, status int) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString(http.StatusText(status))
+}
@@ -0,0 +1,64 @@
+package fasthttplimiter
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/valyala/fasthttp"
+)
+
+// serve runs h on a GET request from addr and returns its response.
+func serve(h fasthttp.RequestHandler, addr string) *fasthttp.Response {
+	var req fasthttp.Request
+	req.SetRequestURI("/")
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(addr)}, nil)
+	h(&ctx)
+	return &ctx.Response
+}
+
+func TestNew(t *testing.T) {
+	l := ratelimiter.NewFixedWindow(nil, ratelimiter.WithStore(ratelimiter.NewMemoryStore()),
+		ratelimiter.WithLimit(1), ratelimiter.WithWindow(time.Minute))
+	h := New(l, func(ctx *fasthttp.RequestCtx) { ctx.SetBodyString("ok") })
+
+	resp := serve(h, "10.0.0.1")
+	if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "ok" {
+		t.Fatalf("first request = %d %q, want 200 from next", resp.StatusCode(), resp.Body())
+	}
+	if got := string(resp.Header.Peek("RateLimit-Remaining")); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+
+	// The headers set before answering must survive on the 429
+	resp = serve(h, "10.0.0.1")
+	if resp.StatusCode() != fasthttp.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", resp.StatusCode())
+	}
+	for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "X-RateLimit-Limit", "Retry-After"} {
+		if len(resp.Header.Peek(name)) == 0 {
+			t.Errorf("429 without %s", name)
+		}
+	}
+
+	if resp := serve(h, "10.0.0.2"); resp.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("request of another IP = %d, want 200", resp.StatusCode())
+	}
+}
+
+func TestNewError(t *testing.T) {
+	l := ratelimiter.NewFixedWindow(nil, ratelimiter.WithStore(ratelimiter.NewMemoryStore()))
+	keyErr := func(*fasthttp.RequestCtx) (string, error) { return "", errors.New("no key") }
+	h := New(l, func(*fasthttp.RequestCtx) { t.Error("next called on error") }, WithKeyFunc(keyErr))
+
+	resp := serve(h, "10.0.0.1")
+	if resp.StatusCode() != fasthttp.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", resp.StatusCode())
+	}
+	if got := string(resp.Body()); got != "Internal Server Error" {
+		t.Errorf("body = %q, want the status text", got)
+	}
+}
@@ -0,0 +1,105 @@
+// Package fiberlimiter adapts limiters to Fiber, mounted like any Fiber
+// middleware, on the app, a group or a route:
+//
+//	app.Post("/login", fiberlimiter.New(strict), login)
+//
+// Responses carry the same headers as the net/http middleware.
+package fiberlimiter
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/moonorange/go_rate_limiter/ratelimiter/fasthttplimiter"
+	"github.com/moonorange/go_rate_limiter/ratelimiter/middleware"
+)
+
+// KeyFunc extracts the key a request is limited by, e.g. from c.Locals set
+// by an authentication middleware. An error fails the request, see
+// WithOnError.
+type KeyFunc func(c *fiber.Ctx) (string, error)
+
+// New returns Fiber middleware limiting requests with l. Each request costs
+// one unit of the key returned by the KeyFunc, the client IP by default.
+// Allowed requests continue with the rate limit headers set; denied ones
+// are answered by the WithOnDenied handler, 429 Too Many Requests by
+// default.
+func New(l ratelimiter.Limiter, opts ...Option) fiber.Handler {
+	cfg := newConfig(opts)
+	return func(c *fiber.Ctx) error {
+		key, err := cfg.keyFunc(c)
+		if err != nil {
+			return cfg.onError(c, err)
+		}
+		res, err := l.Allow(c.UserContext(), key)
+		if err != nil {
+			return cfg.onError(c, err)
+		}
+		fasthttplimiter.SetHeaders(&c.Context().Response.Header, cfg.headers, res)
+		if !res.Allowed {
+			return cfg.onDenied(c, res)
+		}
+		return c.Next()
+	}
+}
+
+// IP is the default KeyFunc: Fiber's c.IP, which reads the client IP from
+// the ProxyHeader of the app config when one is set.
+func IP(c *fiber.Ctx) (string, error) {
+	return c.IP(), nil
+}
+
+type config struct {
+	keyFunc  KeyFunc
+	headers  middleware.HeaderStyle
+	onDenied func(c *fiber.Ctx, res ratelimiter.Result) error
+	onError  func(c *fiber.Ctx, err error) error
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{keyFunc: IP, onDenied: onDenied, onError: onError}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Option configures the middleware.
+type Option func(*config)
+
+// WithKeyFunc sets how the key of a request is extracted. Defaults to IP.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(cfg *config) { cfg.keyFunc = fn }
+}
+
+// WithHeaderStyle selects the rate limit headers of responses.
+// Defaults to middleware.HeadersBoth.
+func WithHeaderStyle(style middleware.HeaderStyle) Option {
+	return func(cfg *config) { cfg.headers = style }
+}
+
+// WithOnDenied sets how a denied request is answered, after the rate limit
+// headers are set. The default answers 429 Too Many Requests.
+func WithOnDenied(fn func(c *fiber.Ctx, res ratelimiter.Result) error) Option {
+	return func(cfg *config) { cfg.onDenied = fn }
+}
+
+// WithOnError sets how a request is answered when no decision could be
+// made. The default returns a 503 Service Unavailable fiber.Error when the
+// store failed and a 500 Internal Server Error one otherwise, for the
+// app's ErrorHandler.
+func WithOnError(fn func(c *fiber.Ctx, err error) error) Option {
+	return func(cfg *config) { cfg.onError = fn }
+}
+
+func onDenied(c *fiber.Ctx, _ ratelimiter.Result) error {
+	return c.SendStatus(fiber.StatusTooManyRequests)
+}
+
+func onError(_ *fiber.Ctx, err error) error {
+	if errors.Is(err, ratelimiter.ErrStoreUnavailable) {
+		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+	}
+	return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,41 @@
+package fiberlimiter
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+func TestNew(t *testing.T) {
+	l := ratelimiter.NewFixedWindow(nil, ratelimiter.WithStore(ratelimiter.NewMemoryStore()),
+		ratelimiter.WithLimit(1), ratelimiter.WithWindow(time.Minute))
+	app := fiber.New()
+	app.Get("/", New(l), func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("first request = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", resp.StatusCode)
+	}
+	for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "X-RateLimit-Limit", "Retry-After"} {
+		if resp.Header.Get(name) == "" {
+			t.Errorf("429 without %s", name)
+		}
+	}
+}
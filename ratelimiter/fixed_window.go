@@ -0,0 +1,356 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Counting and starting the window happen in one script: with INCR and
+// EXPIRE sent separately, a process dying in between would leave a counter
+// that never expires and limit the key forever. A counter left without an
+// expiry by an older version is given one on its next request.
+// INCRBY fails on a value that is not an integer or would overflow, which
+// would also lock the key forever, so such a counter starts over instead.
+var fixedWindowScript = newScript(`
+	local key = KEYS[1]
+	local cost = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+
+	local healed = 0
+	local stored = redis.call('GET', key)
+	if stored then
+		local n = tonumber(stored)
+		if not n or n ~= math.floor(n) or n < 0 or n > 2^53 - cost then
+			redis.call('DEL', key)
+			healed = 1
+		end
+	end
+
+	local count = redis.call('INCRBY', key, cost)
+	local ttl = redis.call('PTTL', key)
+	if ttl < 0 then
+		redis.call('PEXPIRE', key, window)
+		ttl = window
+	end
+	return {count, ttl, healed}
+`)
+
+// FixedWindow algorithm
+// Restrict a number of requests from a client to a fixed number within the time window.
+// It is simple and easy to implement
+// but it might lead more traffic than expected
+// if spikes happen during the border of the time window.
+type FixedWindow struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewFixedWindow returns a limiter allowing a fixed number of requests per window.
+// It panics if the options are invalid.
+func NewFixedWindow(client redis.UniversalClient, opts ...Option) *FixedWindow {
+	cfg := newConfig(opts)
+	cfg.mustValidateWindow()
+	cfg.preloadScripts(client)
+	return &FixedWindow{client: client, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *FixedWindow) forKey(ctx context.Context, key string) (*FixedWindow, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmFixedWindow, Key: key, Err: err}
+	}
+	return &FixedWindow{client: l.client, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *FixedWindow) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *FixedWindow) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *FixedWindow) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+func (l *FixedWindow) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmFixedWindow, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmFixedWindow, key, res), nil
+}
+
+func (l *FixedWindow) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	redisKey, err := l.key("fixed", key)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		count, ttl, err := l.store.Increment(ctx, redisKey, n, l.windowTTL(key, now))
+		if err != nil {
+			return Result{}, storeError(AlgorithmFixedWindow, key, err)
+		}
+		return l.result(count, ttl, now), nil
+	}
+	if l.noScripts {
+		return l.allowTx(ctx, key, n, now)
+	}
+
+	result, err := fixedWindowScript.Run(ctx, l.client, []string{redisKey},
+		n, l.windowTTL(key, now).Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmFixedWindow, key, err)
+	}
+	return l.parseReply(ctx, key, result, now)
+}
+
+// allowTx is allowN for WithoutScripts. Creating the counter with its expiry
+// (SET NX PX) in the same MULTI/EXEC as the increment keeps the guarantee of
+// the script that no counter is ever left without an expiry.
+func (l *FixedWindow) allowTx(ctx context.Context, key string, n int64, now time.Time) (Result, error) {
+	redisKey, err := l.key("fixed", key)
+	if err != nil {
+		return Result{}, err
+	}
+	var incr *redis.IntCmd
+	var pttl *redis.DurationCmd
+	_, err = l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetArgs(ctx, redisKey, 0, redis.SetArgs{Mode: "NX", TTL: l.windowTTL(key, now)})
+		incr = pipe.IncrBy(ctx, redisKey, n)
+		pttl = pipe.PTTL(ctx, redisKey)
+		return nil
+	})
+	// SET NX answers nil when the window already exists
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return Result{}, storeError(AlgorithmFixedWindow, key, err)
+	}
+	// That nil is the only error reported when the increment failed too
+	for _, cmd := range []redis.Cmder{incr, pttl} {
+		if err := cmd.Err(); err != nil {
+			return Result{}, storeError(AlgorithmFixedWindow, key, err)
+		}
+	}
+	return l.result(incr.Val(), max(0, pttl.Val()), now), nil
+}
+
+// parseReply builds the Result from a fixedWindowScript reply.
+func (l *FixedWindow) parseReply(ctx context.Context, key string, result []int64, now time.Time) (Result, error) {
+	// Redis Lua returns {count, ttl in ms, healed}
+	if len(result) != 3 {
+		return Result{}, replyError(AlgorithmFixedWindow, key, result)
+	}
+	if result[2] == 1 {
+		stateHealed(ctx, AlgorithmFixedWindow, key)
+	}
+	return l.result(result[0], time.Duration(result[1])*time.Millisecond, now), nil
+}
+
+// result builds the Result for a window holding count requests and expiring in ttl.
+func (l *FixedWindow) result(count int64, ttl time.Duration, now time.Time) Result {
+	res := Result{
+		Allowed:   count <= l.limit,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-count),
+		ResetAt:   now.Add(ttl),
+	}
+	if !res.Allowed {
+		res.RetryAfter = ttl
+	}
+	return res
+}
+
+// windowTTL returns how long a window for key starting at now lasts.
+// With WithJitter, windows end on a grid offset by a hash of the key, so
+// keys that start together (e.g. after a deploy) still reset at different times.
+func (l *FixedWindow) windowTTL(key string, now time.Time) time.Duration {
+	if !l.jitter {
+		return l.window
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	offset := int64(h.Sum64() % uint64(l.window))
+
+	elapsed := (now.UnixNano() - offset) % int64(l.window)
+	if elapsed < 0 {
+		elapsed += int64(l.window)
+	}
+	// PEXPIRE has millisecond precision
+	return max(time.Millisecond, l.window-time.Duration(elapsed))
+}
+
+// AllowMany checks one request for each key, counting all of them in a
+// single pipelined round trip. Results are in the order of keys.
+func (l *FixedWindow) AllowMany(ctx context.Context, keys []string) ([]Result, error) {
+	windows := make([]*FixedWindow, len(keys))
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		w, err := l.forKey(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if redisKeys[i], err = w.key("fixed", key); err != nil {
+			return nil, err
+		}
+		windows[i] = w
+	}
+	now := l.clock.Now()
+	if l.noScripts || l.store != nil {
+		return allowEach(ctx, l, keys)
+	}
+
+	run := func() ([]*redis.Cmd, error) {
+		pipe := l.client.Pipeline()
+		cmds := make([]*redis.Cmd, len(keys))
+		for i, key := range keys {
+			w := windows[i]
+			cmds[i] = fixedWindowScript.EvalSha(ctx, pipe, []string{redisKeys[i]},
+				1, w.windowTTL(key, now).Milliseconds())
+		}
+		_, err := pipe.Exec(ctx)
+		return cmds, err
+	}
+	cmds, err := run()
+	// Load the script once and retry rather than sending its source per key
+	if redis.HasErrorPrefix(err, "NOSCRIPT") {
+		if err := fixedWindowScript.Load(ctx, l.client).Err(); err != nil {
+			return nil, storeError(AlgorithmFixedWindow, keys[0], err)
+		}
+		cmds, _ = run()
+	}
+
+	results := make([]Result, len(keys))
+	for i, key := range keys {
+		res, err := windows[i].batchResult(ctx, key, cmds[i], now)
+		if err != nil {
+			if results[i], err = l.storeFailed(ctx, key, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		results[i] = l.decide(ctx, AlgorithmFixedWindow, key, res)
+	}
+	return results, nil
+}
+
+// batchResult parses the reply of a pipelined fixedWindowScript call.
+func (l *FixedWindow) batchResult(ctx context.Context, key string, cmd *redis.Cmd, now time.Time) (Result, error) {
+	result, err := cmd.Int64Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmFixedWindow, key, err)
+	}
+	return l.parseReply(ctx, key, result, now)
+}
+
+// Check reports the state for key without counting a request.
+func (l *FixedWindow) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	count, ttl, err := l.counter(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{
+		Allowed:   count < l.limit,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-count),
+		ResetAt:   now.Add(ttl),
+	}
+	if !res.Allowed {
+		res.RetryAfter = ttl
+	}
+	return res, nil
+}
+
+// counter reads the count of the current window for key and how long
+// until it ends.
+func (l *FixedWindow) counter(ctx context.Context, key string) (int64, time.Duration, error) {
+	redisKey, err := l.key("fixed", key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if l.store != nil {
+		count, ttl, err := l.store.Counter(ctx, redisKey)
+		if err != nil {
+			return 0, 0, storeError(AlgorithmFixedWindow, key, err)
+		}
+		return count, ttl, nil
+	}
+
+	pipe := l.client.Pipeline()
+	get := pipe.Get(ctx, redisKey)
+	pttl := pipe.PTTL(ctx, redisKey)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, storeError(AlgorithmFixedWindow, key, err)
+	}
+	count, _ := get.Int64()
+	// No expiry means no window has started yet
+	return count, max(0, pttl.Val()), nil
+}
+
+// Reset clears the counter for key.
+func (l *FixedWindow) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("fixed", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmFixedWindow, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmFixedWindow, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *FixedWindow) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	count, ttl, err := l.counter(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+
+	st := State{
+		Algorithm: AlgorithmFixedWindow,
+		Key:       key,
+		Limit:     l.limit,
+		Window:    l.window,
+		Count:     count,
+		TTL:       ttl,
+	}
+	if ttl > 0 {
+		st.WindowStart = now.Add(ttl - l.window)
+	}
+	return st, nil
+}
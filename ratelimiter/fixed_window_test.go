@@ -0,0 +1,119 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A counter left without an expiry, e.g. by a process that died between
+// INCR and EXPIRE before the script, must not limit its key forever.
+func TestFixedWindowOrphanedKey(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	l := NewFixedWindow(client, WithLimit(3), WithWindow(time.Minute))
+
+	redisKey, err := l.key("fixed", "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mr.Set(redisKey, "5"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := l.Allow(ctx, "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Fatalf("Allow over the orphaned count = %+v, want denied", res)
+	}
+	if ttl := mr.TTL(redisKey); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("TTL of the orphaned counter = %s, want it expiring within the window", ttl)
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Minute {
+		t.Errorf("RetryAfter = %s, want within the window", res.RetryAfter)
+	}
+
+	mr.FastForward(time.Minute)
+	if res, err := l.Allow(ctx, "user:1"); err != nil || !res.Allowed {
+		t.Fatalf("Allow once the window expired = %+v, %v, want allowed", res, err)
+	}
+}
+
+// A counter that can't be incremented starts over rather than failing
+// every request for the key.
+func TestFixedWindowCorruptedKey(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	l := NewFixedWindow(client, WithLimit(3), WithWindow(time.Minute))
+
+	redisKey, err := l.key("fixed", "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stored := range []string{"not a number", "1.5", "-4"} {
+		if err := mr.Set(redisKey, stored); err != nil {
+			t.Fatal(err)
+		}
+		res, err := l.Allow(ctx, "user:1")
+		if err != nil || !res.Allowed || res.Remaining != 2 {
+			t.Errorf("Allow on %q = %+v, %v, want allowed as the first request", stored, res, err)
+		}
+		if ttl := mr.TTL(redisKey); ttl <= 0 {
+			t.Errorf("TTL after healing %q = %s, want an expiry", stored, ttl)
+		}
+	}
+}
+
+// Every counter gets its expiry with its first request, with or without
+// scripts, so none is left behind whatever happens to the process.
+func TestFixedWindowExpires(t *testing.T) {
+	ctx := context.Background()
+	for name, opts := range map[string][]Option{
+		"script":      nil,
+		"transaction": {WithoutScripts()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mr, client := newRedis(t)
+			l := NewFixedWindow(client, append(opts, WithLimit(3), WithWindow(time.Minute))...)
+			redisKey, err := l.key("fixed", "user:1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				if _, err := l.Allow(ctx, "user:1"); err != nil {
+					t.Fatal(err)
+				}
+				if ttl := mr.TTL(redisKey); ttl <= 0 || ttl > time.Minute {
+					t.Fatalf("TTL = %s, want it expiring within the window", ttl)
+				}
+			}
+			mr.FastForward(time.Minute)
+			if mr.Exists(redisKey) {
+				t.Error("counter still exists after the window")
+			}
+		})
+	}
+}
+
+// Without scripts nothing heals a corrupted counter, and a failed increment
+// must not pass for an allowed request.
+func TestFixedWindowTxIncrementError(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	l := NewFixedWindow(client, WithLimit(3), WithWindow(time.Minute), WithoutScripts())
+
+	redisKey, err := l.key("fixed", "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mr.Set(redisKey, "not a number"); err != nil {
+		t.Fatal(err)
+	}
+	mr.SetTTL(redisKey, time.Minute)
+	if res, err := l.Allow(ctx, "user:1"); !errors.Is(err, ErrStoreUnavailable) {
+		t.Errorf("Allow on a corrupted counter = %+v, %v, want ErrStoreUnavailable", res, err)
+	}
+}
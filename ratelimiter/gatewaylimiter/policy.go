@@ -0,0 +1,158 @@
+// Package gatewaylimiter enforces one limit per client on services
+// serving both HTTP and gRPC, e.g. with grpc-gateway, rather than a quota
+// per surface. A Policy is defined once and mounted on both:
+//
+//	policy := &gatewaylimiter.Policy{
+//		Key:     middleware.APIKey("X-API-Key"),
+//		Limiter: perClient,
+//		Methods: map[string]ratelimiter.Limiter{"/orders.v1.Orders/Create": orderCreation},
+//	}
+//	srv := grpc.NewServer(grpc.UnaryInterceptor(policy.UnaryServerInterceptor()))
+//	http.ListenAndServe(":8080", policy.HTTP(gatewaylimiter.Methods(routes))(mux))
+//
+// Behind grpc-gateway, mount the policy on the gRPC server only: each HTTP
+// request the gateway relays is a gRPC call, which would otherwise be
+// counted twice. The gateway forwards the Authorization and
+// X-Forwarded-For headers as metadata, so keys such as
+// middleware.BearerToken, or middleware.ClientIP trusting the gateway's
+// address, find the same client there; other headers must be sent with
+// the gateway's Grpc-Metadata- prefix or passed by its header matcher.
+package gatewaylimiter
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/moonorange/go_rate_limiter/ratelimiter/grpclimiter"
+	"github.com/moonorange/go_rate_limiter/ratelimiter/middleware"
+	"google.golang.org/grpc"
+)
+
+// Policy is the limits of a service, shared by its HTTP and gRPC surfaces.
+// Operations are named by their gRPC full method on both.
+type Policy struct {
+	// Key extracts the client of a request. gRPC calls are passed to it as
+	// the HTTP/2 requests they were made with, see grpclimiter.Request.
+	// Defaults to middleware.RemoteAddr.
+	Key middleware.KeyFunc
+	// Limiter decides the requests for methods not in Methods, sharing
+	// each client's limit across them. Nil leaves them unlimited.
+	Limiter ratelimiter.Limiter
+	// Methods decides the requests for the methods it names, e.g.
+	// "/orders.v1.Orders/Create", keyed by the method and the client.
+	Methods map[string]ratelimiter.Limiter
+}
+
+// UnaryServerInterceptor returns an interceptor enforcing p on unary calls,
+// see grpclimiter.UnaryServerInterceptor.
+func (p *Policy) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	interceptors := make(map[string]grpc.UnaryServerInterceptor, len(p.Methods))
+	for method, l := range p.Methods {
+		interceptors[method] = grpclimiter.UnaryServerInterceptor(l, grpclimiter.HTTPKey(p.key(method)))
+	}
+	fallback := grpc.UnaryServerInterceptor(passUnary)
+	if p.Limiter != nil {
+		fallback = grpclimiter.UnaryServerInterceptor(p.Limiter, grpclimiter.HTTPKey(p.key("")))
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if i, ok := interceptors[info.FullMethod]; ok {
+			return i(ctx, req, info, handler)
+		}
+		return fallback(ctx, req, info, handler)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor enforcing p on streams,
+// see grpclimiter.StreamServerInterceptor.
+func (p *Policy) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	interceptors := make(map[string]grpc.StreamServerInterceptor, len(p.Methods))
+	for method, l := range p.Methods {
+		interceptors[method] = grpclimiter.StreamServerInterceptor(l, grpclimiter.HTTPKey(p.key(method)))
+	}
+	fallback := grpc.StreamServerInterceptor(passStream)
+	if p.Limiter != nil {
+		fallback = grpclimiter.StreamServerInterceptor(p.Limiter, grpclimiter.HTTPKey(p.key("")))
+	}
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if i, ok := interceptors[info.FullMethod]; ok {
+			return i(srv, ss, info, handler)
+		}
+		return fallback(srv, ss, info, handler)
+	}
+}
+
+// HTTP returns middleware enforcing p on HTTP requests, see
+// middleware.HTTP. method maps a request to the gRPC method it stands for,
+// or "" if none; opts apply as there, except WithKeyFunc.
+func (p *Policy) HTTP(method func(r *http.Request) string, opts ...middleware.Option) func(http.Handler) http.Handler {
+	// Each limiter's options are appended to opts
+	opts = slices.Clip(opts)
+	return func(next http.Handler) http.Handler {
+		handlers := make(map[string]http.Handler, len(p.Methods))
+		for m, l := range p.Methods {
+			handlers[m] = middleware.HTTP(l, append(opts, middleware.WithKeyFunc(p.key(m)))...)(next)
+		}
+		fallback := next
+		if p.Limiter != nil {
+			fallback = middleware.HTTP(p.Limiter, append(opts, middleware.WithKeyFunc(p.key("")))...)(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h, ok := handlers[method(r)]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			fallback.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Methods returns a method function for HTTP mapping http.ServeMux
+// patterns to gRPC methods, e.g.
+//
+//	gatewaylimiter.Methods(map[string]string{
+//		"POST /v1/orders":     "/orders.v1.Orders/Create",
+//		"GET /v1/orders/{id}": "/orders.v1.Orders/Get",
+//	})
+//
+// It panics if a pattern is invalid or conflicts with another, like
+// http.ServeMux.Handle.
+func Methods(routes map[string]string) func(r *http.Request) string {
+	mux := http.NewServeMux()
+	for pattern := range routes {
+		// Only matched, never served
+		mux.Handle(pattern, http.NotFoundHandler())
+	}
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return routes[pattern]
+	}
+}
+
+// key returns the KeyFunc of requests for method, prefixing the client's
+// key with the method unless it is "".
+func (p *Policy) key(method string) middleware.KeyFunc {
+	key := p.Key
+	if key == nil {
+		key = middleware.RemoteAddr
+	}
+	if method == "" {
+		return key
+	}
+	return func(r *http.Request) (string, error) {
+		k, err := key(r)
+		if err != nil {
+			return "", err
+		}
+		return method + ":" + k, nil
+	}
+}
+
+func passUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(ctx, req)
+}
+
+func passStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, ss)
+}
@@ -0,0 +1,281 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Generic cell rate algorithm. Instead of counting tokens it stores a single
+// number per key, the theoretical arrival time (TAT) of the next request.
+// Each request pushes the TAT forward by one emission interval (1/rate) per
+// unit of cost, and is allowed as long as the TAT stays within the burst
+// tolerance of now.
+var gcraScript = newScript(`
+	local key = KEYS[1]
+	local interval = tonumber(ARGV[1])
+	local tolerance = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+	local max_idle = tonumber(ARGV[5])
+
+	local tat = tonumber(redis.call('GET', key) or now)
+	-- Start over if the stored TAT is unusable
+	local healed = 0
+	if tat == nil or tat ~= tat or tat == math.huge or tat == -math.huge then
+		redis.call('DEL', key)
+		tat, healed = now, 1
+	end
+	tat = math.max(tat, now)
+
+	local new_tat = tat + interval * cost
+	local allow_at = new_tat - tolerance
+	-- Floats are truncated when converted to Redis replies, so return the TAT as a string
+	if now < allow_at then
+		return {0, tostring(tat), healed}
+	end
+
+	local ttl = math.ceil((new_tat - now) * 1000)
+	redis.call('SET', key, tostring(new_tat), 'PX', math.max(ttl, max_idle))
+	return {1, tostring(new_tat), healed}
+`)
+
+// GCRA algorithm
+// Generic cell rate algorithm, a token bucket equivalent that stores one
+// timestamp per key instead of a hash. Allows bursts of up to WithBurst
+// requests and a sustained WithRate requests per second, with exact
+// retry-after values and the lowest memory use of all algorithms.
+type GCRA struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewGCRA returns a limiter allowing WithRate requests per second
+// with bursts of up to WithBurst requests.
+// It panics if the options are invalid.
+func NewGCRA(client redis.UniversalClient, opts ...Option) *GCRA {
+	cfg := newConfig(opts)
+	cfg.mustValidateBucket()
+	cfg.preloadScripts(client)
+	return &GCRA{client: client, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *GCRA) forKey(ctx context.Context, key string) (*GCRA, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmGCRA, Key: key, Err: err}
+	}
+	return &GCRA{client: l.client, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *GCRA) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *GCRA) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *GCRA) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+func (l *GCRA) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmGCRA, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmGCRA, key, res), nil
+}
+
+func (l *GCRA) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	redisKey, err := l.key("gcra", key)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		allowed, tokens, err := l.store.TakeTokens(ctx, redisKey, l.bucket(), float64(n), now)
+		if err != nil {
+			return Result{}, storeError(AlgorithmGCRA, key, err)
+		}
+		return l.result(allowed, l.tatOf(tokens, now), n, now), nil
+	}
+
+	result, err := gcraScript.Run(ctx, l.client, []string{redisKey},
+		l.interval(), l.tolerance(), seconds(now), n, l.maxIdle.Milliseconds()).Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmGCRA, key, err)
+	}
+	// Redis Lua returns {allowed, tat, healed} with allowed and healed as int64 and tat as a string
+	if len(result) != 3 {
+		return Result{}, replyError(AlgorithmGCRA, key, result)
+	}
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return Result{}, replyError(AlgorithmGCRA, key, result)
+	}
+	tat, err := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+	if err != nil {
+		return Result{}, replyError(AlgorithmGCRA, key, result)
+	}
+	if result[2] == int64(1) {
+		stateHealed(ctx, AlgorithmGCRA, key)
+	}
+	return l.result(allowed == 1, tat, n, now), nil
+}
+
+// result builds the Result of a request costing n given the TAT after it.
+func (l *GCRA) result(allowed bool, tat float64, n int64, now time.Time) Result {
+	nowSeconds := seconds(now)
+	res := Result{
+		Allowed: allowed,
+		Limit:   int64(l.capacity),
+		// Requests that still fit before the TAT leaves the tolerance
+		Remaining: max(0, int64(math.Floor((nowSeconds-(tat-l.tolerance()))/l.interval()))),
+		ResetAt:   now.Add(durationOf(max(0, tat-nowSeconds))),
+	}
+	if !res.Allowed {
+		allowAt := max(tat, nowSeconds) + l.interval()*float64(n) - l.tolerance()
+		res.RetryAfter = durationOf(max(0, allowAt-nowSeconds))
+	}
+	return res
+}
+
+// Check reports the state for key without counting a request.
+func (l *GCRA) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	tat, err := l.tat(ctx, key, now)
+	if err != nil {
+		return Result{}, err
+	}
+	allowed := seconds(now) >= tat+l.interval()-l.tolerance()
+	return l.result(allowed, tat, 1, now), nil
+}
+
+// Reset clears the TAT for key.
+func (l *GCRA) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("gcra", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmGCRA, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmGCRA, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *GCRA) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	tat, err := l.tat(ctx, key, now)
+	if err != nil {
+		return State{}, err
+	}
+	var ttl time.Duration
+	if l.store == nil {
+		redisKey, err := l.key("gcra", key)
+		if err != nil {
+			return State{}, err
+		}
+		if ttl, err = l.client.PTTL(ctx, redisKey).Result(); err != nil {
+			return State{}, storeError(AlgorithmGCRA, key, err)
+		}
+	}
+	return State{
+		Algorithm: AlgorithmGCRA,
+		Key:       key,
+		Limit:     int64(l.capacity),
+		TAT:       time.Unix(0, int64(tat*1e9)),
+		TTL:       max(0, ttl),
+	}, nil
+}
+
+// tat reads the theoretical arrival time for key, never earlier than now.
+func (l *GCRA) tat(ctx context.Context, key string, now time.Time) (float64, error) {
+	redisKey, err := l.key("gcra", key)
+	if err != nil {
+		return 0, err
+	}
+	if l.store != nil {
+		tokens, err := l.store.Tokens(ctx, redisKey, l.bucket(), now)
+		if err != nil {
+			return 0, storeError(AlgorithmGCRA, key, err)
+		}
+		return l.tatOf(tokens, now), nil
+	}
+	v, err := l.client.Get(ctx, redisKey).Float64()
+	if errors.Is(err, redis.Nil) {
+		return seconds(now), nil
+	}
+	if err != nil {
+		return 0, storeError(AlgorithmGCRA, key, err)
+	}
+	return max(v, seconds(now)), nil
+}
+
+// bucket describes the token bucket equivalent to l for a Store, which
+// holds the tokens that fit before the TAT leaves the tolerance.
+func (l *GCRA) bucket() Bucket {
+	return Bucket{Capacity: l.capacity, Rate: l.rate, MaxIdle: l.maxIdle}
+}
+
+// tatOf returns the TAT of a bucket from l.bucket holding tokens at now.
+func (l *GCRA) tatOf(tokens float64, now time.Time) float64 {
+	return seconds(now) + (l.capacity-tokens)*l.interval()
+}
+
+// interval returns the emission interval in seconds, the time one request
+// adds to the TAT.
+func (l *GCRA) interval() float64 {
+	return 1 / l.rate
+}
+
+// tolerance returns how far in seconds the TAT may run ahead of now, which
+// allows bursts of l.capacity requests.
+func (l *GCRA) tolerance() float64 {
+	return l.interval() * l.capacity
+}
+
+// seconds converts t to float64 seconds as used by the Lua scripts.
+func seconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// durationOf converts float64 seconds to a Duration.
+func durationOf(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,134 @@
+// Package ginlimiter adapts limiters to Gin. Each middleware returned by New
+// carries its own configuration, so routes and groups mount the limiter
+// they need:
+//
+//	r.POST("/login", ginlimiter.New(strict), login)
+//	api := r.Group("/api", ginlimiter.New(lenient, ginlimiter.WithKeyFunc(byAPIKey)))
+package ginlimiter
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/moonorange/go_rate_limiter/ratelimiter/middleware"
+)
+
+// KeyFunc extracts the key a request is limited by from its gin.Context,
+// e.g. the user set by an authentication middleware. An error aborts the
+// request, see WithOnError.
+type KeyFunc func(c *gin.Context) (string, error)
+
+// New returns Gin middleware limiting requests with l. Each request costs
+// one unit of the key returned by the KeyFunc, the client IP by default.
+// Allowed requests continue with the rate limit headers set; denied ones
+// are aborted with 429 Too Many Requests.
+func New(l ratelimiter.Limiter, opts ...Option) gin.HandlerFunc {
+	cfg := newConfig(opts)
+	return func(c *gin.Context) {
+		key, err := cfg.keyFunc(c)
+		if err != nil {
+			cfg.onError(c, err)
+			return
+		}
+		if cfg.perRoute {
+			// FullPath is the route pattern, e.g. "/users/:id", so all users share a count
+			key = c.Request.Method + " " + c.FullPath() + ":" + key
+		}
+		cost := int64(1)
+		if cfg.cost != nil {
+			cost = cfg.cost(c)
+		}
+		res, err := l.AllowN(c.Request.Context(), key, cost)
+		if err != nil {
+			cfg.onError(c, err)
+			return
+		}
+		cfg.headers.Set(c.Writer.Header(), res)
+		if !res.Allowed {
+			cfg.onDenied(c, res)
+			return
+		}
+		c.Next()
+	}
+}
+
+// ClientIP is the default KeyFunc: gin's ClientIP, which only reads
+// forwarding headers from the proxies trusted with Engine.SetTrustedProxies.
+func ClientIP(c *gin.Context) (string, error) {
+	return c.ClientIP(), nil
+}
+
+type config struct {
+	keyFunc  KeyFunc
+	perRoute bool
+	cost     func(c *gin.Context) int64
+	headers  middleware.HeaderStyle
+	onDenied func(c *gin.Context, res ratelimiter.Result)
+	onError  func(c *gin.Context, err error)
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{keyFunc: ClientIP, onDenied: onDenied, onError: onError}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Option configures the middleware.
+type Option func(*config)
+
+// WithKeyFunc sets how the key of a request is extracted.
+// Defaults to ClientIP.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(cfg *config) { cfg.keyFunc = fn }
+}
+
+// WithPerRoute counts each route separately, by prefixing keys with the
+// method and route pattern, e.g. "GET /users/:id:203.0.113.7". Without it
+// one middleware mounted on a group shares each key's limit across routes.
+func WithPerRoute() Option {
+	return func(cfg *config) { cfg.perRoute = true }
+}
+
+// WithCost sets what a request costs, e.g. more for an export than for a
+// lookup. Defaults to 1.
+func WithCost(fn func(c *gin.Context) int64) Option {
+	return func(cfg *config) { cfg.cost = fn }
+}
+
+// WithHeaderStyle selects the rate limit headers of responses.
+// Defaults to middleware.HeadersBoth.
+func WithHeaderStyle(style middleware.HeaderStyle) Option {
+	return func(cfg *config) { cfg.headers = style }
+}
+
+// WithOnDenied sets how a denied request is answered, after the rate limit
+// headers are set; it must abort the request. The default aborts with 429
+// Too Many Requests.
+func WithOnDenied(fn func(c *gin.Context, res ratelimiter.Result)) Option {
+	return func(cfg *config) { cfg.onDenied = fn }
+}
+
+// WithOnError sets how a request is answered when no decision could be
+// made; it must abort the request. The default aborts with 503 Service
+// Unavailable when the store failed and 500 Internal Server Error
+// otherwise, recording err in c.Errors.
+func WithOnError(fn func(c *gin.Context, err error)) Option {
+	return func(cfg *config) { cfg.onError = fn }
+}
+
+func onDenied(c *gin.Context, _ ratelimiter.Result) {
+	c.String(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+	c.Abort()
+}
+
+func onError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ratelimiter.ErrStoreUnavailable) {
+		status = http.StatusServiceUnavailable
+	}
+	c.AbortWithError(status, err)
+}
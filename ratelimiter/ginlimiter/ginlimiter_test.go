@@ -0,0 +1,74 @@
+package ginlimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// newRouter returns a router with the routes /a and /b/:id, limited to one
+// request a minute per client with opts.
+func newRouter(opts ...Option) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	l := ratelimiter.NewFixedWindow(nil, ratelimiter.WithStore(ratelimiter.NewMemoryStore()),
+		ratelimiter.WithLimit(1), ratelimiter.WithWindow(time.Minute))
+	r := gin.New()
+	api := r.Group("/", New(l, opts...))
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	api.GET("/a", ok)
+	api.GET("/b/:id", ok)
+	return r
+}
+
+// get serves a GET request of path from addr with h.
+func get(h http.Handler, path, addr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.RemoteAddr = addr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestNew(t *testing.T) {
+	r := newRouter()
+
+	w := get(r, "/a", "203.0.113.7:1234")
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("first request = %d %q, want 200 from the handler", w.Code, w.Body)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+
+	// Without WithPerRoute the routes of the group share the limit
+	w = get(r, "/b/1", "203.0.113.7:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", w.Code)
+	}
+	for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "X-RateLimit-Limit", "Retry-After"} {
+		if w.Header().Get(name) == "" {
+			t.Errorf("429 without %s", name)
+		}
+	}
+
+	if w := get(r, "/a", "203.0.113.8:1234"); w.Code != http.StatusOK {
+		t.Errorf("request of another client = %d, want 200", w.Code)
+	}
+}
+
+func TestNewPerRoute(t *testing.T) {
+	r := newRouter(WithPerRoute())
+
+	for _, path := range []string{"/a", "/b/1"} {
+		if w := get(r, path, "203.0.113.7:1234"); w.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200 as routes are counted separately", path, w.Code)
+		}
+	}
+	if w := get(r, "/b/2", "203.0.113.7:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("GET /b/2 = %d, want 429 as it shares the route of /b/1", w.Code)
+	}
+}
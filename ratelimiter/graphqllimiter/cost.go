@@ -0,0 +1,218 @@
+// Package graphqllimiter limits GraphQL requests by their cost, so one
+// query fetching thousands of nested objects consumes more of a client's
+// budget than a trivial one:
+//
+//	limiter := ratelimiter.NewTokenBucket(rdb, ratelimiter.WithBurst(1000), ratelimiter.WithRate(50))
+//	http.Handle("/graphql", graphqllimiter.HTTP(limiter, graphqllimiter.Complexity{MaxDepth: 10})(gql))
+//
+// Servers that parse requests themselves, e.g. gqlgen in an
+// AroundOperations extension, call Allow with the request instead.
+package graphqllimiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+var (
+	// ErrInvalidQuery is returned for requests whose cost can't be computed
+	// because their query doesn't parse or names no operation it has.
+	ErrInvalidQuery = errors.New("graphqllimiter: invalid query")
+	// ErrTooDeep is returned for queries nesting deeper than the MaxDepth
+	// of their Complexity.
+	ErrTooDeep = errors.New("graphqllimiter: query too deep")
+)
+
+// Request is a GraphQL request, as posted over HTTP.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Complexity computes the cost of requests. Each field costs 1, or its
+// cost in Fields, plus the cost of its selections multiplied by the number
+// of items it returns, read from its ListArguments. For
+//
+//	{ repositories(first: 10) { issues(first: 20) { title } } }
+//
+// that is 1 + 10 * (1 + 20 * 1) = 211. Fragments cost what their
+// selections cost where they are spread. The zero Complexity is ready to
+// use.
+type Complexity struct {
+	// Fields sets the cost of fields by name, e.g. {"search": 10}, for
+	// fields that are expensive to resolve.
+	Fields map[string]int64
+	// ListArguments name the arguments setting how many items a field
+	// returns. Defaults to "first", "last" and "limit".
+	ListArguments []string
+	// MaxDepth, if positive, rejects queries whose fields nest deeper with
+	// ErrTooDeep.
+	MaxDepth int
+}
+
+var defaultListArguments = []string{"first", "last", "limit"}
+
+// Cost returns the cost of req, at least 1 so that fields costing nothing
+// still count as a request.
+func (c Complexity) Cost(req Request) (int64, error) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: req.Query})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+	op := doc.Operations.ForName(req.OperationName)
+	if op == nil {
+		return 0, fmt.Errorf("%w: no operation %q", ErrInvalidQuery, req.OperationName)
+	}
+	w := walker{c: c, doc: doc, op: op, variables: req.Variables}
+	cost, err := w.selections(op.SelectionSet, 1)
+	if err != nil {
+		return 0, err
+	}
+	return max(cost, 1), nil
+}
+
+// Allow decides req for key of l, costing its Cost, for servers calling
+// the limiter themselves. Allow fails without consuming anything if the
+// cost can't be computed.
+func Allow(ctx context.Context, l ratelimiter.Limiter, c Complexity, key string, req Request) (ratelimiter.Result, error) {
+	cost, err := c.Cost(req)
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+	return l.AllowN(ctx, key, cost)
+}
+
+// walker computes the cost of an operation.
+type walker struct {
+	c         Complexity
+	doc       *ast.QueryDocument
+	op        *ast.OperationDefinition
+	variables map[string]any
+	spreading []string // fragments being spread, to stop cycles
+}
+
+// selections returns the cost of set, whose fields are at depth.
+func (w *walker) selections(set ast.SelectionSet, depth int) (int64, error) {
+	var total int64
+	for _, sel := range set {
+		var cost int64
+		var err error
+		switch sel := sel.(type) {
+		case *ast.Field:
+			cost, err = w.field(sel, depth)
+		case *ast.InlineFragment:
+			cost, err = w.selections(sel.SelectionSet, depth)
+		case *ast.FragmentSpread:
+			cost, err = w.spread(sel, depth)
+		}
+		if err != nil {
+			return 0, err
+		}
+		total = add(total, cost)
+	}
+	return total, nil
+}
+
+func (w *walker) field(f *ast.Field, depth int) (int64, error) {
+	if w.c.MaxDepth > 0 && depth > w.c.MaxDepth {
+		return 0, fmt.Errorf("%w: %s nests deeper than %d", ErrTooDeep, f.Name, w.c.MaxDepth)
+	}
+	cost, ok := w.c.Fields[f.Name]
+	if !ok {
+		cost = 1
+	}
+	if len(f.SelectionSet) == 0 {
+		return cost, nil
+	}
+	children, err := w.selections(f.SelectionSet, depth+1)
+	if err != nil {
+		return 0, err
+	}
+	return add(cost, mul(w.items(f), children)), nil
+}
+
+func (w *walker) spread(s *ast.FragmentSpread, depth int) (int64, error) {
+	frag := w.doc.Fragments.ForName(s.Name)
+	if frag == nil {
+		return 0, fmt.Errorf("%w: no fragment %q", ErrInvalidQuery, s.Name)
+	}
+	if slices.Contains(w.spreading, s.Name) {
+		return 0, fmt.Errorf("%w: fragment %q spreads itself", ErrInvalidQuery, s.Name)
+	}
+	w.spreading = append(w.spreading, s.Name)
+	defer func() { w.spreading = w.spreading[:len(w.spreading)-1] }()
+	return w.selections(frag.SelectionSet, depth)
+}
+
+// items returns how many items f returns, at least 1.
+func (w *walker) items(f *ast.Field) int64 {
+	names := w.c.ListArguments
+	if names == nil {
+		names = defaultListArguments
+	}
+	for _, name := range names {
+		if arg := f.Arguments.ForName(name); arg != nil {
+			if n, ok := w.int(arg.Value); ok {
+				return max(n, 1)
+			}
+		}
+	}
+	return 1
+}
+
+// int returns the value of an integer literal or variable.
+func (w *walker) int(v *ast.Value) (int64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	switch v.Kind {
+	case ast.IntValue:
+		n, err := strconv.ParseInt(v.Raw, 10, 64)
+		return n, err == nil
+	case ast.Variable:
+		if value, ok := w.variables[v.Raw]; ok {
+			switch n := value.(type) {
+			case float64:
+				return int64(min(n, math.MaxInt64)), true
+			case int:
+				return int64(n), true
+			case int64:
+				return n, true
+			case json.Number:
+				i, err := n.Int64()
+				return i, err == nil
+			}
+			return 0, false
+		}
+		if def := w.op.VariableDefinitions.ForName(v.Raw); def != nil {
+			return w.int(def.DefaultValue)
+		}
+	}
+	return 0, false
+}
+
+// add and mul saturate rather than overflow, so absurd queries cost the
+// most rather than a negative amount.
+func add(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+func mul(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
@@ -0,0 +1,97 @@
+package graphqllimiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"github.com/moonorange/go_rate_limiter/ratelimiter/middleware"
+)
+
+// maxBody bounds the request bodies read to compute costs.
+const maxBody = 1 << 20
+
+// HTTP returns middleware limiting the GraphQL requests posted to the next
+// handler by their cost, computed by c. It is middleware.HTTP with the cost
+// of WithCost, and opts apply as there. Denied requests and requests whose
+// cost can't be computed are answered in the GraphQL response format,
+// with 429 Too Many Requests and 400 Bad Request; WithOnDenied and
+// WithOnError in opts replace that. GET requests, whose query is in the
+// URL, are costed too.
+func HTTP(l ratelimiter.Limiter, c Complexity, opts ...middleware.Option) func(http.Handler) http.Handler {
+	opts = append([]middleware.Option{
+		middleware.WithCost(func(r *http.Request) (int64, error) { return requestCost(r, c) }),
+		middleware.WithOnDenied(onDenied),
+		middleware.WithOnError(onError),
+	}, opts...)
+	return middleware.HTTP(l, opts...)
+}
+
+// requestCost returns the cost of the GraphQL request r, leaving its body
+// for the next handler to read.
+func requestCost(r *http.Request, c Complexity) (int64, error) {
+	var req Request
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				return 0, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+			}
+		}
+		return c.Cost(req)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	r.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+	if len(body) > maxBody {
+		return 0, fmt.Errorf("%w: body over %d bytes", ErrInvalidQuery, maxBody)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+	return c.Cost(req)
+}
+
+// gqlError writes a GraphQL response carrying only an error.
+func gqlError(w http.ResponseWriter, status int, message string, extensions map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]any{{"message": message, "extensions": extensions}},
+	})
+}
+
+func onDenied(w http.ResponseWriter, _ *http.Request, res ratelimiter.Result) {
+	gqlError(w, http.StatusTooManyRequests, "rate limit exceeded", map[string]any{
+		"code":       "RATE_LIMITED",
+		"retryAfter": res.RetryAfterSeconds(),
+	})
+}
+
+func onError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidQuery), errors.Is(err, ErrTooDeep):
+		gqlError(w, http.StatusBadRequest, err.Error(), map[string]any{"code": "QUERY_REJECTED"})
+		return
+	case errors.Is(err, middleware.ErrNoKey):
+		gqlError(w, http.StatusUnauthorized, err.Error(), map[string]any{"code": "UNAUTHENTICATED"})
+		return
+	}
+	slog.ErrorContext(r.Context(), "ratelimiter: no decision for request", "path", r.URL.Path, "error", err)
+	if errors.Is(err, ratelimiter.ErrStoreUnavailable) {
+		gqlError(w, http.StatusServiceUnavailable, "rate limiter unavailable", map[string]any{"code": "UNAVAILABLE"})
+		return
+	}
+	gqlError(w, http.StatusInternalServerError, "internal error", map[string]any{"code": "INTERNAL"})
+}
@@ -0,0 +1,119 @@
+package grpclimiter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ClientKeyFunc extracts the key an outbound call to method on cc is
+// throttled by. An error fails the call.
+type ClientKeyFunc func(ctx context.Context, cc *grpc.ClientConn, method string) (string, error)
+
+// UnaryClientInterceptor returns an interceptor throttling the calls of a
+// client with l, each costing one unit of the key returned by keyFunc, so
+// services fanning out to a rate limited API stay within its limit:
+//
+//	conn, err := grpc.NewClient(target,
+//		grpc.WithUnaryInterceptor(grpclimiter.UnaryClientInterceptor(limiter, grpclimiter.Target, grpclimiter.WithWait())),
+//	)
+//
+// A nil keyFunc keys calls by Method. A call over the limit fails with
+// codes.ResourceExhausted without being sent, unless WithWait is given.
+func UnaryClientInterceptor(l ratelimiter.Limiter, keyFunc ClientKeyFunc, opts ...ClientOption) grpc.UnaryClientInterceptor {
+	cfg := newClientConfig(l, keyFunc, opts)
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		if err := cfg.throttle(ctx, cc, method); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, callOpts...)
+	}
+}
+
+// StreamClientInterceptor returns an interceptor throttling the streams a
+// client opens like UnaryClientInterceptor. Opening a stream costs one
+// unit; the messages sent on it are not throttled.
+func StreamClientInterceptor(l ratelimiter.Limiter, keyFunc ClientKeyFunc, opts ...ClientOption) grpc.StreamClientInterceptor {
+	cfg := newClientConfig(l, keyFunc, opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if err := cfg.throttle(ctx, cc, method); err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, callOpts...)
+	}
+}
+
+// Method is the default ClientKeyFunc: the full method called, e.g.
+// "/pkg.Service/Method", so each method has its own limit.
+func Method(_ context.Context, _ *grpc.ClientConn, method string) (string, error) {
+	return method, nil
+}
+
+// Target keys calls by the target of their connection, e.g.
+// "dns:///api.internal:443", so all methods of a server share its limit.
+func Target(_ context.Context, cc *grpc.ClientConn, _ string) (string, error) {
+	return cc.Target(), nil
+}
+
+type clientConfig struct {
+	limiter ratelimiter.Limiter
+	keyFunc ClientKeyFunc
+	wait    bool
+}
+
+func newClientConfig(l ratelimiter.Limiter, keyFunc ClientKeyFunc, opts []ClientOption) clientConfig {
+	cfg := clientConfig{limiter: l, keyFunc: keyFunc}
+	if cfg.keyFunc == nil {
+		cfg.keyFunc = Method
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if _, ok := l.(ratelimiter.Waiter); cfg.wait && !ok {
+		panic("grpclimiter: WithWait needs a limiter implementing ratelimiter.Waiter")
+	}
+	return cfg
+}
+
+// ClientOption configures a client interceptor.
+type ClientOption func(*clientConfig)
+
+// WithWait blocks calls over the limit until they are allowed rather than
+// failing them, up to the deadline of the call and the max delay of the
+// limiter. The limiter must implement ratelimiter.Waiter.
+func WithWait() ClientOption {
+	return func(cfg *clientConfig) { cfg.wait = true }
+}
+
+// throttle decides an outbound call, returning the status error it fails
+// with if it was denied or no decision could be made.
+func (cfg clientConfig) throttle(ctx context.Context, cc *grpc.ClientConn, method string) error {
+	key, err := cfg.keyFunc(ctx, cc, method)
+	if err != nil {
+		return statusError(err)
+	}
+	if !cfg.wait {
+		res, err := cfg.limiter.Allow(ctx, key)
+		if err != nil {
+			return statusError(err)
+		}
+		if !res.Allowed {
+			return exhausted(res)
+		}
+		return nil
+	}
+	err = cfg.limiter.(ratelimiter.Waiter).Wait(ctx, key)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, ratelimiter.ErrWouldExceedDeadline), errors.Is(err, ratelimiter.ErrLimitExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return statusError(err)
+}
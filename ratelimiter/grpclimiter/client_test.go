@@ -0,0 +1,32 @@
+package grpclimiter
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	intercept := UnaryClientInterceptor(newLimiter(), nil)
+	sent := 0
+	invoker := func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+		sent++
+		return nil
+	}
+
+	if err := intercept(context.Background(), "/pkg.Service/Method", nil, nil, nil, invoker); err != nil {
+		t.Fatalf("first call = %v, want sent", err)
+	}
+	if err := intercept(context.Background(), "/pkg.Service/Method", nil, nil, nil, invoker); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second call = %v, want ResourceExhausted", err)
+	}
+	if err := intercept(context.Background(), "/pkg.Service/Other", nil, nil, nil, invoker); err != nil {
+		t.Errorf("call of another method = %v, want sent", err)
+	}
+	if sent != 2 {
+		t.Errorf("%d calls sent, want 2", sent)
+	}
+}
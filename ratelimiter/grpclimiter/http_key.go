@@ -0,0 +1,54 @@
+package grpclimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"net/url"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPKey adapts a KeyFunc of the HTTP middleware to gRPC, so a service
+// serving both extracts keys once, e.g. with middleware.ClientIP or
+// middleware.APIKey, and a client gets the same key on both. The function
+// sees the call as the HTTP/2 request it was made with, see Request.
+// middleware.ErrNoKey fails the call with codes.Unauthenticated.
+func HTTPKey(fn middleware.KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		key, err := fn(Request(ctx, fullMethod))
+		if errors.Is(err, middleware.ErrNoKey) {
+			return "", status.Error(codes.Unauthenticated, err.Error())
+		}
+		return key, err
+	}
+}
+
+// Request returns the HTTP/2 request a call to fullMethod was made with,
+// as far as ctx tells: a POST to the full method, with the incoming
+// metadata as headers and the peer's address as RemoteAddr. Calls relayed
+// by grpc-gateway carry the Authorization and X-Forwarded-For headers of
+// the original HTTP request.
+func Request(ctx context.Context, fullMethod string) *http.Request {
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: fullMethod},
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(http.Header),
+		RequestURI: fullMethod,
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for name, values := range md {
+		r.Header[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r.WithContext(ctx)
+}
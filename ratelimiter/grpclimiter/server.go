@@ -0,0 +1,135 @@
+// Package grpclimiter adapts limiters to gRPC servers with interceptors
+// that decide every call with a ratelimiter.Limiter. Denied calls fail with
+// codes.ResourceExhausted and a RetryInfo detail telling well-behaved
+// clients when to retry:
+//
+//	srv := grpc.NewServer(
+//		grpc.UnaryInterceptor(grpclimiter.UnaryServerInterceptor(limiter, nil)),
+//		grpc.StreamInterceptor(grpclimiter.StreamServerInterceptor(limiter, nil)),
+//	)
+//
+// UnaryClientInterceptor and StreamClientInterceptor throttle the calls of
+// clients instead.
+package grpclimiter
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// KeyFunc extracts the key a call to fullMethod is limited by, e.g. the
+// caller's address or API key. An error fails the call; return a status
+// error to choose its code.
+type KeyFunc func(ctx context.Context, fullMethod string) (string, error)
+
+// UnaryServerInterceptor returns an interceptor limiting unary calls with l,
+// each costing one unit of the key returned by keyFunc. A nil keyFunc keys
+// calls by PeerAddr.
+func UnaryServerInterceptor(l ratelimiter.Limiter, keyFunc KeyFunc) grpc.UnaryServerInterceptor {
+	if keyFunc == nil {
+		keyFunc = PeerAddr
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := allow(ctx, l, keyFunc, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor limiting streams with l
+// like UnaryServerInterceptor. Opening a stream costs one unit; the
+// messages sent on it are not limited.
+func StreamServerInterceptor(l ratelimiter.Limiter, keyFunc KeyFunc) grpc.StreamServerInterceptor {
+	if keyFunc == nil {
+		keyFunc = PeerAddr
+	}
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := allow(ss.Context(), l, keyFunc, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// PeerAddr is the default KeyFunc: the IP address of the caller, without
+// its port. Behind a proxy that is the proxy's address.
+func PeerAddr(ctx context.Context, _ string) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", status.Error(codes.Internal, "ratelimiter: no peer address")
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		// Not host:port, e.g. a Unix socket
+		return p.Addr.String(), nil
+	}
+	return host, nil
+}
+
+// Metadata returns a KeyFunc keying calls by the first value of the
+// metadata entry name, e.g. "x-api-key". Calls without it fail with
+// codes.Unauthenticated.
+func Metadata(name string) KeyFunc {
+	return func(ctx context.Context, _ string) (string, error) {
+		values := metadata.ValueFromIncomingContext(ctx, name)
+		if len(values) == 0 || values[0] == "" {
+			return "", status.Errorf(codes.Unauthenticated, "ratelimiter: missing %s metadata", name)
+		}
+		return values[0], nil
+	}
+}
+
+// allow decides a call, returning the status error it fails with if it
+// was denied or no decision could be made.
+func allow(ctx context.Context, l ratelimiter.Limiter, keyFunc KeyFunc, fullMethod string) error {
+	key, err := keyFunc(ctx, fullMethod)
+	if err != nil {
+		return statusError(err)
+	}
+	res, err := l.Allow(ctx, key)
+	if err != nil {
+		return statusError(err)
+	}
+	if !res.Allowed {
+		return exhausted(res)
+	}
+	return nil
+}
+
+// exhausted returns the error of a denied call, with a RetryInfo detail
+// when the limiter knows when to retry.
+func exhausted(res ratelimiter.Result) error {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	if res.RetryAfter <= 0 {
+		return st.Err()
+	}
+	withRetry, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(res.RetryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+	return withRetry.Err()
+}
+
+// statusError converts an error that prevented a decision to a status
+// error: Unavailable when the store failed, the code of a status error, and
+// Internal otherwise.
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, ratelimiter.ErrStoreUnavailable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
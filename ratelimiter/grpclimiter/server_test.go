@@ -0,0 +1,80 @@
+package grpclimiter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// newLimiter returns a limiter allowing one call a minute per key, in
+// memory.
+func newLimiter() ratelimiter.Limiter {
+	return ratelimiter.NewFixedWindow(nil, ratelimiter.WithStore(ratelimiter.NewMemoryStore()),
+		ratelimiter.WithLimit(1), ratelimiter.WithWindow(time.Minute))
+}
+
+// fromPeer returns a context of a call from addr.
+func fromPeer(addr string) context.Context {
+	tcp, _ := net.ResolveTCPAddr("tcp", addr)
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: tcp})
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	intercept := UnaryServerInterceptor(newLimiter(), nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/pkg.Service/Method"}
+	handler := func(context.Context, any) (any, error) { return "ok", nil }
+
+	resp, err := intercept(fromPeer("203.0.113.7:1234"), nil, info, handler)
+	if err != nil || resp != "ok" {
+		t.Fatalf("first call = %v, %v, want the handler's response", resp, err)
+	}
+
+	// Another port of the same caller shares its limit
+	_, err = intercept(fromPeer("203.0.113.7:5678"), nil, info, handler)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("second call = %v, want ResourceExhausted", err)
+	}
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	if retry == nil {
+		t.Fatal("ResourceExhausted without RetryInfo")
+	}
+	if delay := retry.RetryDelay.AsDuration(); delay <= 0 || delay > time.Minute {
+		t.Errorf("RetryDelay = %s, want within the window", delay)
+	}
+
+	if _, err := intercept(fromPeer("203.0.113.8:1234"), nil, info, handler); err != nil {
+		t.Errorf("call of another caller = %v, want allowed", err)
+	}
+}
+
+func TestMetadataKey(t *testing.T) {
+	intercept := UnaryServerInterceptor(newLimiter(), Metadata("x-api-key"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/pkg.Service/Method"}
+	handler := func(context.Context, any) (any, error) { return "ok", nil }
+
+	if _, err := intercept(context.Background(), nil, info, handler); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("call without the key = %v, want Unauthenticated", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "k1"))
+	if _, err := intercept(ctx, nil, info, handler); err != nil {
+		t.Fatalf("first call = %v, want allowed", err)
+	}
+	if _, err := intercept(ctx, nil, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second call = %v, want ResourceExhausted", err)
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedis starts an in-process Redis for the test and returns a client
+// connected to it.
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+// newDeadRedis returns a client of a Redis that is no longer running, which
+// fails every command at once.
+func newDeadRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1, DialerRetries: 1, DialerRetryTimeout: time.Millisecond})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// newFakeClock returns a clock stopped at a Monday midnight in UTC, so
+// calendar periods start with it.
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// errStoreDown is returned by every operation of failingStore.
+var errStoreDown = errors.New("store down")
+
+// failingStore is a Store whose every operation fails.
+type failingStore struct{}
+
+func (failingStore) Increment(context.Context, string, int64, time.Duration) (int64, time.Duration, error) {
+	return 0, 0, errStoreDown
+}
+
+func (failingStore) Counter(context.Context, string) (int64, time.Duration, error) {
+	return 0, 0, errStoreDown
+}
+
+func (failingStore) TakeTokens(context.Context, string, Bucket, float64, time.Time) (bool, float64, error) {
+	return false, 0, errStoreDown
+}
+
+func (failingStore) Tokens(context.Context, string, Bucket, time.Time) (float64, error) {
+	return 0, errStoreDown
+}
+
+func (failingStore) Update(context.Context, string, func([]byte, time.Duration) ([]byte, time.Duration)) error {
+	return errStoreDown
+}
+
+func (failingStore) Delete(context.Context, ...string) error {
+	return errStoreDown
+}
@@ -0,0 +1,162 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Hierarchical limiter
+// Nested token buckets: every child key (e.g. an endpoint) has its own
+// bucket and draws from the bucket of its parent key (e.g. the customer)
+// at the same time. A request is allowed only if both have enough tokens,
+// and then takes from both in one atomic step. This models org-level quotas
+// with per-API sublimits.
+type Hierarchical struct {
+	client redis.UniversalClient
+	parent Limit
+	child  Limit
+	config
+}
+
+// NewHierarchical returns a limiter enforcing parent per parent key and
+// child per child key.
+// It panics if one of the limits is invalid, or on Redis Cluster without
+// WithHashTags.
+func NewHierarchical(client redis.UniversalClient, parent, child Limit, opts ...Option) *Hierarchical {
+	if err := parent.validate(); err != nil {
+		panic(err)
+	}
+	if err := child.validate(); err != nil {
+		panic(err)
+	}
+	cfg := newConfig(opts)
+	cfg.mustBeSlotSafe(client, "Hierarchical")
+	cfg.preloadScripts(client)
+	return &Hierarchical{client: client, parent: parent, child: child, config: cfg}
+}
+
+// Allow reports whether one request for child under parent is allowed right now.
+// The most restrictive of the two buckets decides the Result.
+func (l *Hierarchical) Allow(ctx context.Context, parent, child string) (Result, error) {
+	return l.AllowN(ctx, parent, child, 1)
+}
+
+// AllowN is like Allow for a request costing n.
+func (l *Hierarchical) AllowN(ctx context.Context, parent, child string, n int64) (Result, error) {
+	key := parent + ":" + child
+	if err := costError(AlgorithmHierarchical, key, n); err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	limits := []Limit{l.parent, l.child}
+	redisKeys, err := l.bucketKeys(parent, child)
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, tokens, err := takeAll(ctx, l.client, l.store, AlgorithmHierarchical, key, redisKeys,
+		limits, []int64{n, n}, now, l.maxIdle)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmHierarchical, key, bucketsResult(allowed, tokens, limits, n, now)), nil
+}
+
+// Check reports the state for child under parent without taking tokens.
+func (l *Hierarchical) Check(ctx context.Context, parent, child string) (Result, error) {
+	redisKeys, err := l.bucketKeys(parent, child)
+	if err != nil {
+		return Result{}, err
+	}
+	return checkAll(ctx, l.client, l.store, AlgorithmHierarchical, parent+":"+child, redisKeys,
+		[]Limit{l.parent, l.child}, l.clock.Now())
+}
+
+// Reset refills the bucket of child under parent.
+// The parent bucket is shared with its other children and left alone.
+func (l *Hierarchical) Reset(ctx context.Context, parent, child string) error {
+	redisKeys, err := l.bucketKeys(parent, child)
+	if err != nil {
+		return err
+	}
+	if err := deleteBuckets(ctx, l.client, l.store, redisKeys[1]); err != nil {
+		return storeError(AlgorithmHierarchical, parent+":"+child, err)
+	}
+	return nil
+}
+
+// ResetParent refills the bucket of parent.
+func (l *Hierarchical) ResetParent(ctx context.Context, parent string) error {
+	redisKey, err := l.key("hier", parent)
+	if err != nil {
+		return err
+	}
+	if err := deleteBuckets(ctx, l.client, l.store, redisKey); err != nil {
+		return storeError(AlgorithmHierarchical, parent, err)
+	}
+	return nil
+}
+
+// Inspect returns the state of child under parent, with the parent bucket
+// first and the child bucket second in State.Limits.
+func (l *Hierarchical) Inspect(ctx context.Context, parent, child string) (State, error) {
+	key := parent + ":" + child
+	st := State{Algorithm: AlgorithmHierarchical, Key: key}
+	keys, err := l.bucketKeys(parent, child)
+	if err != nil {
+		return State{}, err
+	}
+	names := []string{parent, key}
+	for i, limit := range []Limit{l.parent, l.child} {
+		ls, err := inspectLimit(ctx, l.client, l.store, keys[i], limit, l.clock.Now())
+		if err != nil {
+			return State{}, storeError(AlgorithmHierarchical, key, err)
+		}
+		ls.Algorithm = AlgorithmHierarchical
+		ls.Key = names[i]
+		ls.Window = limit.Window
+		st.Limits = append(st.Limits, ls)
+	}
+	return st, nil
+}
+
+// Child returns a Limiter for the children of parent, e.g. to hand the
+// per-customer limiter to code that only knows about endpoints.
+func (l *Hierarchical) Child(parent string) Limiter {
+	return &childLimiter{l: l, parent: parent}
+}
+
+// bucketKeys returns the Redis keys of the parent and child buckets.
+func (l *Hierarchical) bucketKeys(parent, child string) ([]string, error) {
+	parentKey, err := l.key("hier", parent)
+	if err != nil {
+		return nil, err
+	}
+	childKey, err := l.subKey("hier", parent, child)
+	if err != nil {
+		return nil, err
+	}
+	return []string{parentKey, childKey}, nil
+}
+
+// childLimiter adapts a Hierarchical limiter with a fixed parent to Limiter.
+type childLimiter struct {
+	l      *Hierarchical
+	parent string
+}
+
+func (c *childLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return c.l.Allow(ctx, c.parent, key)
+}
+
+func (c *childLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	return c.l.AllowN(ctx, c.parent, key, n)
+}
+
+func (c *childLimiter) Check(ctx context.Context, key string) (Result, error) {
+	return c.l.Check(ctx, c.parent, key)
+}
+
+func (c *childLimiter) Reset(ctx context.Context, key string) error {
+	return c.l.Reset(ctx, c.parent, key)
+}
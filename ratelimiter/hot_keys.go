@@ -0,0 +1,140 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HotKeyPolicy decides when a key is hot, i.e. so far over its limit that
+// its denials are better cached locally than asked of Redis again.
+type HotKeyPolicy struct {
+	// Denials denials of a key within Within make it hot.
+	Denials int64
+	Within  time.Duration
+	// MaxKeys bounds how many keys are tracked; the least recently seen
+	// ones are forgotten first. Zero means unbounded.
+	MaxKeys int
+}
+
+func (p HotKeyPolicy) validate() error {
+	if p.Denials <= 0 || p.Within <= 0 || p.MaxKeys < 0 {
+		return fmt.Errorf("ratelimiter: invalid hot key policy %+v", p)
+	}
+	return nil
+}
+
+// HotKeys wraps a Limiter to shield Redis from a single key under attack.
+// It counts the denials of each key in process, and once a key is hot it
+// caches the denial until the wrapped limiter's RetryAfter: further
+// requests for the key are denied locally without a round trip.
+// Keys that are merely at their limit keep asking the wrapped limiter.
+type HotKeys struct {
+	limiter Limiter
+	policy  HotKeyPolicy
+	config
+
+	mu   sync.Mutex
+	keys *Manager[*hotKey]
+}
+
+// hotKey is the local state of one key. Times are monotonic, see monotonic.
+type hotKey struct {
+	denials int64
+	first   time.Duration // start of the current run of denials
+	until   time.Duration // end of the cached denial
+	denied  Result        // the denial cached until then
+}
+
+// NewHotKeys returns l denying hot keys locally. Only the decision and
+// clock options are used.
+// It panics if the policy is invalid.
+func NewHotKeys(l Limiter, policy HotKeyPolicy, opts ...Option) *HotKeys {
+	if err := policy.validate(); err != nil {
+		panic(err)
+	}
+	h := &HotKeys{limiter: l, policy: policy, config: newConfig(opts)}
+	// A key idle for Within has no denials left to count; if it was hot it
+	// just asks the wrapped limiter again
+	h.keys = NewManager(func(string) *hotKey { return &hotKey{} }, policy.MaxKeys, policy.Within)
+	h.keys.SetClock(h.clock)
+	return h
+}
+
+// Allow implements Limiter.
+func (l *HotKeys) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN implements Limiter.
+func (l *HotKeys) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmHotKeys, key, n); err != nil {
+		return Result{}, err
+	}
+	if res, ok := l.cached(key); ok {
+		return l.decide(ctx, AlgorithmHotKeys, key, res), nil
+	}
+
+	res, err := l.limiter.AllowN(ctx, key, n)
+	if err != nil {
+		return res, err
+	}
+
+	now := monotonic(l.clock)
+	k := l.keys.Get(key)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if res.Allowed {
+		k.denials = 0
+		return res, nil
+	}
+	if k.denials == 0 || now-k.first > l.policy.Within {
+		k.denials = 0
+		k.first = now
+	}
+	k.denials++
+	if k.denials >= l.policy.Denials && res.RetryAfter > 0 {
+		k.until = now + res.RetryAfter
+		k.denied = res
+	}
+	return res, nil
+}
+
+// cached returns the cached denial of key, if it is hot.
+func (l *HotKeys) cached(key string) (Result, bool) {
+	now := monotonic(l.clock)
+	k := l.keys.Get(key)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now >= k.until {
+		return Result{}, false
+	}
+	res := k.denied
+	res.Remaining = 0
+	res.RetryAfter = k.until - now
+	return res, true
+}
+
+// Hot reports whether requests for key are currently denied locally.
+func (l *HotKeys) Hot(key string) bool {
+	_, ok := l.cached(key)
+	return ok
+}
+
+// Check reports the cached denial of a hot key, and asks the wrapped
+// limiter for other keys.
+func (l *HotKeys) Check(ctx context.Context, key string) (Result, error) {
+	if res, ok := l.cached(key); ok {
+		return res, nil
+	}
+	return l.limiter.Check(ctx, key)
+}
+
+// Reset forgets the local state of key in this process and resets the
+// wrapped limiter. Other processes keep denying it until their cached
+// denial ends.
+func (l *HotKeys) Reset(ctx context.Context, key string) error {
+	l.keys.Delete(key)
+	return l.limiter.Reset(ctx, key)
+}
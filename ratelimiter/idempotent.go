@@ -0,0 +1,214 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// pendingRetry is the RetryAfter reported for a duplicate of a request
+// whose decision has not been recorded yet.
+const pendingRetry = 100 * time.Millisecond
+
+// Returns the decision recorded for a request ID, or claims the ID so
+// concurrent duplicates see it as pending while the original is decided.
+var dedupClaimScript = newScript(`
+	local key = KEYS[1]
+	local ttl = tonumber(ARGV[1])
+
+	local decision = redis.call('GET', key)
+	if decision then
+		return decision
+	end
+	redis.call('SET', key, 'pending', 'PX', ttl)
+	return false
+`)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id, the identifier of a
+// request that may be delivered more than once, e.g. a webhook delivery ID.
+// Idempotent limiters return the decision made for the first delivery
+// to every retry of it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Idempotent wraps a Limiter so retried deliveries of a request, e.g. by
+// webhook redelivery or an at-least-once queue, don't consume capacity
+// twice. Requests carry their ID in the context (WithRequestID); the first
+// decision for an ID is recorded for the dedup TTL and returned to every
+// duplicate of it. Requests without an ID are passed through.
+type Idempotent struct {
+	limiter Limiter
+	client  redis.UniversalClient
+	ttl     time.Duration
+	config
+}
+
+// NewIdempotent returns l deduplicating request IDs seen within ttl.
+// Only the key, clock and store options are used.
+// It panics if ttl is shorter than a millisecond.
+func NewIdempotent(client redis.UniversalClient, l Limiter, ttl time.Duration, opts ...Option) *Idempotent {
+	if ttl < time.Millisecond {
+		panic(fmt.Sprintf("ratelimiter: dedup ttl must be at least 1ms, got %s", ttl))
+	}
+	cfg := newConfig(opts)
+	cfg.preloadScripts(client)
+	return &Idempotent{limiter: l, client: client, ttl: ttl, config: cfg}
+}
+
+// Allow implements Limiter.
+func (l *Idempotent) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN implements Limiter.
+// A duplicate whose original is still being decided is denied with a short
+// RetryAfter, so it can be retried once the original decision is recorded.
+func (l *Idempotent) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmIdempotent, key, n); err != nil {
+		return Result{}, err
+	}
+	id := RequestID(ctx)
+	if id == "" {
+		return l.limiter.AllowN(ctx, key, n)
+	}
+	redisKey, err := l.subKey("idem", key, id)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	prior, claimed, err := l.claim(ctx, redisKey)
+	switch {
+	case err != nil:
+		return l.storeFailed(ctx, key, storeError(AlgorithmIdempotent, key, err))
+	case claimed:
+		// First delivery: decide and record below
+	case prior == "pending":
+		return Result{ResetAt: now.Add(pendingRetry), RetryAfter: pendingRetry}, nil
+	default:
+		res, err := decodeResult(prior, now)
+		if err != nil {
+			return Result{}, replyError(AlgorithmIdempotent, key, prior)
+		}
+		return res, nil
+	}
+
+	res, err := l.limiter.AllowN(ctx, key, n)
+	if err != nil {
+		// Nothing was decided, so a retry must not find the ID claimed
+		l.unclaim(ctx, redisKey)
+		return res, err
+	}
+	// Failing to record only means a duplicate is decided again
+	l.record(ctx, redisKey, encodeResult(res, now))
+	return res, nil
+}
+
+// claim returns the decision recorded at redisKey, or claims it for this
+// delivery and reports true if none was, like dedupClaimScript.
+func (l *Idempotent) claim(ctx context.Context, redisKey string) (string, bool, error) {
+	if l.store == nil {
+		prior, err := dedupClaimScript.Run(ctx, l.client, []string{redisKey}, l.ttl.Milliseconds()).Text()
+		if errors.Is(err, redis.Nil) {
+			return "", true, nil
+		}
+		return prior, false, err
+	}
+	var prior string
+	var claimed bool
+	err := l.store.Update(ctx, redisKey, func(state []byte, _ time.Duration) ([]byte, time.Duration) {
+		prior, claimed = string(state), state == nil
+		if !claimed {
+			return nil, 0
+		}
+		return []byte("pending"), l.ttl
+	})
+	return prior, claimed, err
+}
+
+// unclaim releases the claim at redisKey.
+func (l *Idempotent) unclaim(ctx context.Context, redisKey string) {
+	if l.store != nil {
+		l.store.Delete(ctx, redisKey)
+		return
+	}
+	l.client.Del(ctx, redisKey)
+}
+
+// record stores the decision made for the claim at redisKey.
+func (l *Idempotent) record(ctx context.Context, redisKey, decision string) {
+	if l.store != nil {
+		l.store.Update(ctx, redisKey, func([]byte, time.Duration) ([]byte, time.Duration) {
+			return []byte(decision), l.ttl
+		})
+		return
+	}
+	l.client.Set(ctx, redisKey, decision, l.ttl)
+}
+
+// Check implements Limiter. Checks don't consume capacity and are not deduplicated.
+func (l *Idempotent) Check(ctx context.Context, key string) (Result, error) {
+	return l.limiter.Check(ctx, key)
+}
+
+// Reset resets the wrapped limiter. Recorded decisions expire on their own.
+func (l *Idempotent) Reset(ctx context.Context, key string) error {
+	return l.limiter.Reset(ctx, key)
+}
+
+// encodeResult encodes res, decided at now, as
+// "<allowed>|<limit>|<remaining>|<reset at ms>|<retry after ms>".
+func encodeResult(res Result, now time.Time) string {
+	allowed := "0"
+	if res.Allowed {
+		allowed = "1"
+	}
+	return strings.Join([]string{
+		allowed,
+		strconv.FormatInt(res.Limit, 10),
+		strconv.FormatInt(res.Remaining, 10),
+		strconv.FormatInt(res.ResetAt.UnixMilli(), 10),
+		strconv.FormatInt(now.Add(res.RetryAfter).UnixMilli(), 10),
+	}, "|")
+}
+
+// decodeResult decodes a Result written by encodeResult, with RetryAfter
+// shortened by the time elapsed until now.
+func decodeResult(s string, now time.Time) (Result, error) {
+	parts := strings.Split(s, "|")
+	if len(parts) != 5 {
+		return Result{}, fmt.Errorf("ratelimiter: malformed decision %q", s)
+	}
+	var fields [4]int64
+	for i, p := range parts[1:] {
+		v, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return Result{}, fmt.Errorf("ratelimiter: malformed decision %q", s)
+		}
+		fields[i] = v
+	}
+	res := Result{
+		Allowed:   parts[0] == "1",
+		Limit:     fields[0],
+		Remaining: fields[1],
+		ResetAt:   time.UnixMilli(fields[2]),
+	}
+	if !res.Allowed {
+		res.RetryAfter = max(0, time.UnixMilli(fields[3]).Sub(now))
+	}
+	return res, nil
+}
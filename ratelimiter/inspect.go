@@ -0,0 +1,80 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+)
+
+// Inspector is implemented by limiters that can expose their raw state,
+// to answer "why is this key being throttled" without redis-cli.
+type Inspector interface {
+	Inspect(ctx context.Context, key string) (State, error)
+}
+
+// State is the internal state a limiter stores for a key.
+// Only the fields relevant to the algorithm are set.
+type State struct {
+	Algorithm string
+	Key       string
+	// Limit is the configured limit (requests, or capacity for buckets).
+	Limit int64
+	// Window is the configured window length, if any.
+	Window time.Duration
+
+	// Count is the number of requests in the current window.
+	Count int64
+	// PreviousCount is the count of the previous window (SlidingCounter).
+	PreviousCount int64
+	// Estimate is the weighted request estimate (SlidingCounter, EWMA).
+	Estimate float64
+	// WindowStart is the start of the current window.
+	WindowStart time.Time
+	// SubWindows holds the count of every live sub-window, oldest first
+	// (SlidingBuckets).
+	SubWindows []int64
+
+	// Tokens is the number of tokens stored at LastRefill (TokenBucket).
+	Tokens float64
+	// Available is the number of tokens available now, including the refill
+	// since LastRefill (TokenBucket).
+	Available float64
+	// LastRefill is when the bucket was last updated (TokenBucket, LeakyBucket).
+	LastRefill time.Time
+	// Rate is the current refill rate in requests per second (Adaptive),
+	// or the smoothed request rate (EWMA).
+	Rate float64
+	// Level is how full the bucket is now (LeakyBucket).
+	Level float64
+	// TAT is the theoretical arrival time of the next request (GCRA).
+	TAT time.Time
+
+	// Version is the schema version of the stored state, zero for state
+	// written before versioning (TokenBucket, LeakyBucket).
+	Version int
+
+	// TTL is the time until the stored state expires; zero if nothing is stored.
+	TTL time.Duration
+
+	// Limits holds the state of every limit of a MultiLimiter, of the parent
+	// and child buckets of a Hierarchical limiter, of every class of a
+	// Priority limiter, or of the request and byte buckets of a Bandwidth limiter.
+	Limits []State
+}
+
+var (
+	_ Inspector = (*FixedWindow)(nil)
+	_ Inspector = (*SlidingLog)(nil)
+	_ Inspector = (*SlidingCounter)(nil)
+	_ Inspector = (*TokenBucket)(nil)
+	_ Inspector = (*MultiLimiter)(nil)
+	_ Inspector = (*LeakyBucket)(nil)
+	_ Inspector = (*GCRA)(nil)
+	_ Inspector = (*SlidingBuckets)(nil)
+	_ Inspector = (*Concurrency)(nil)
+	_ Inspector = (*Adaptive)(nil)
+	_ Inspector = (*Priority)(nil)
+	_ Inspector = (*Quota)(nil)
+	_ Inspector = (*Bandwidth)(nil)
+	_ Inspector = (*EWMA)(nil)
+	_ Inspector = (*AdaptiveConcurrency)(nil)
+)
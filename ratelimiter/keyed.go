@@ -0,0 +1,66 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+)
+
+// Keyer is implemented by key types that know their own rate limit key,
+// e.g. a struct combining a user ID and an endpoint.
+type Keyer interface {
+	RateLimitKey() string
+}
+
+// KeyOf converts k to a string key.
+// It uses RateLimitKey for a Keyer, String for a fmt.Stringer (e.g. netip.Addr)
+// and fmt.Sprint for anything else (e.g. integers).
+func KeyOf[K comparable](k K) string {
+	switch v := any(k).(type) {
+	case Keyer:
+		return v.RateLimitKey()
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Keyed adapts a Limiter to keys of type K so callers don't have to
+// format keys by hand at every call site.
+type Keyed[K comparable] struct {
+	limiter Limiter
+	keyFunc func(K) string
+}
+
+// NewKeyed wraps l so it can be called with keys of type K.
+// Keys are converted with KeyOf.
+func NewKeyed[K comparable](l Limiter) *Keyed[K] {
+	return &Keyed[K]{limiter: l, keyFunc: KeyOf[K]}
+}
+
+// NewKeyedFunc is like NewKeyed but converts keys with fn.
+func NewKeyedFunc[K comparable](l Limiter, fn func(K) string) *Keyed[K] {
+	return &Keyed[K]{limiter: l, keyFunc: fn}
+}
+
+// Allow reports whether one request for k is allowed right now.
+func (l *Keyed[K]) Allow(ctx context.Context, k K) (Result, error) {
+	return l.limiter.Allow(ctx, l.keyFunc(k))
+}
+
+// AllowN is like Allow for a request costing n.
+func (l *Keyed[K]) AllowN(ctx context.Context, k K, n int64) (Result, error) {
+	return l.limiter.AllowN(ctx, l.keyFunc(k), n)
+}
+
+// Check reports the current state for k without counting a request.
+func (l *Keyed[K]) Check(ctx context.Context, k K) (Result, error) {
+	return l.limiter.Check(ctx, l.keyFunc(k))
+}
+
+// Reset clears all state stored for k.
+func (l *Keyed[K]) Reset(ctx context.Context, k K) error {
+	return l.limiter.Reset(ctx, l.keyFunc(k))
+}
@@ -0,0 +1,451 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Version of the leaky bucket state layout, stored in the "v" field.
+// It follows the rules of tokenBucketSchema.
+//
+// Versions: 0 stored level and last; 1 added the marker.
+const leakyBucketSchema = 1
+
+// Every request pours its cost into the bucket, which leaks at a constant rate.
+// A request is accepted while it fits; otherwise the reply tells how much
+// has to leak out before it would.
+var leakyBucketScript = newScript(`
+	local key = KEYS[1]
+	local capacity = tonumber(ARGV[1])
+	local rate = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+	local max_idle = tonumber(ARGV[5])
+	local schema = tonumber(ARGV[6] or 0)
+
+	local version = tonumber(redis.call('HGET', key, 'v') or 0)
+	local level = tonumber(redis.call('HGET', key, 'level') or 0)
+	local last = tonumber(redis.call('HGET', key, 'last') or now)
+
+	-- Start over from an empty bucket if the state is unusable
+	local function finite(x)
+		return x ~= nil and x == x and x ~= math.huge and x ~= -math.huge
+	end
+	local healed = 0
+	if not (finite(level) and finite(last)) or level < 0 then
+		redis.call('DEL', key)
+		level, last, healed = 0, now, 1
+	end
+
+	local elapsed = now - last
+	level = math.max(0, level - elapsed * rate)
+
+	-- Floats are truncated when converted to Redis replies, so return the level as a string
+	if level + cost > capacity then
+		return {0, tostring(level), healed}
+	end
+
+	level = level + cost
+	redis.call('HSET', key, 'level', level, 'last', now)
+	if version == nil or version < schema then
+		redis.call('HSET', key, 'v', schema)
+	end
+	-- Expire once the bucket has drained completely
+	redis.call('EXPIRE', key, math.max(math.ceil(level / rate), max_idle))
+
+	return {1, tostring(level), healed}
+`)
+
+// Takes a cancelled request back out of the bucket.
+var leakyBucketCancelScript = newScript(`
+	local key = KEYS[1]
+	local rate = tonumber(ARGV[1])
+	local now = tonumber(ARGV[2])
+	local cost = tonumber(ARGV[3])
+
+	local level = tonumber(redis.call('HGET', key, 'level'))
+	if level == nil then
+		return 0
+	end
+	local last = tonumber(redis.call('HGET', key, 'last') or now)
+
+	level = math.max(0, level - (now - last) * rate - cost)
+	redis.call('HSET', key, 'level', level, 'last', now)
+	return 0
+`)
+
+// LeakyBucket algorithm
+// Requests fill a bucket that drains at a constant rate, and requests that
+// would overflow it are rejected. Unlike the token bucket, which allows a
+// whole burst at once after an idle period, it smooths traffic towards the
+// drain rate. The classic algorithm for shaping traffic.
+type LeakyBucket struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewLeakyBucket returns a bucket holding up to WithCapacity (or WithBurst)
+// requests and draining WithRate requests per second.
+// It panics if the options are invalid.
+func NewLeakyBucket(client redis.UniversalClient, opts ...Option) *LeakyBucket {
+	cfg := newConfig(opts)
+	cfg.mustValidateBucket()
+	cfg.preloadScripts(client)
+	return &LeakyBucket{client: client, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *LeakyBucket) forKey(ctx context.Context, key string) (*LeakyBucket, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmLeakyBucket, Key: key, Err: err}
+	}
+	return &LeakyBucket{client: l.client, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *LeakyBucket) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+// With WithShaping, the request is queued right away and Wait returns when
+// its turn comes, so requests proceed evenly spaced at the leak rate.
+func (l *LeakyBucket) Wait(ctx context.Context, key string) error {
+	return l.WaitN(ctx, key, 1)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *LeakyBucket) WaitN(ctx context.Context, key string, n int64) error {
+	if l.shaping {
+		return l.shapeN(ctx, key, n)
+	}
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+// The request pours n units into the bucket.
+func (l *LeakyBucket) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmLeakyBucket, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmLeakyBucket, key, res), nil
+}
+
+func (l *LeakyBucket) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	allowed, level, err := l.pour(ctx, key, n, now)
+	if err != nil {
+		return Result{}, err
+	}
+	return l.result(allowed, level, n, now), nil
+}
+
+// pour runs the bucket script for a request costing n.
+// It returns whether the request fit and the level of the bucket after it.
+func (l *LeakyBucket) pour(ctx context.Context, key string, n int64, now time.Time) (bool, float64, error) {
+	// Convert the current time to a float64 in seconds
+	nowSeconds := float64(now.UnixNano()) / 1e9
+
+	redisKey, err := l.key("leaky", key)
+	if err != nil {
+		return false, 0, err
+	}
+	if l.store != nil {
+		// The room left in the bucket is a token bucket
+		allowed, tokens, err := l.store.TakeTokens(ctx, redisKey, l.bucket(), float64(n), now)
+		if err != nil {
+			return false, 0, storeError(AlgorithmLeakyBucket, key, err)
+		}
+		return allowed, l.capacity - tokens, nil
+	}
+	result, err := leakyBucketScript.Run(ctx, l.client, []string{redisKey},
+		l.capacity, l.rate, nowSeconds, n, int64(l.maxIdle.Seconds()), leakyBucketSchema).Slice()
+	if err != nil {
+		return false, 0, storeError(AlgorithmLeakyBucket, key, err)
+	}
+	// Redis Lua returns {allowed, level, healed} with allowed and healed as int64 and level as a string
+	if len(result) != 3 {
+		return false, 0, replyError(AlgorithmLeakyBucket, key, result)
+	}
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return false, 0, replyError(AlgorithmLeakyBucket, key, result)
+	}
+	level, err := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+	if err != nil {
+		return false, 0, replyError(AlgorithmLeakyBucket, key, result)
+	}
+	if result[2] == int64(1) {
+		stateHealed(ctx, AlgorithmLeakyBucket, key)
+	}
+	return allowed == 1, level, nil
+}
+
+// Reserve queues a request for key and reports when it may proceed, shaping
+// traffic to the leak rate instead of rejecting bursts: the bucket is a queue
+// and the request leaves it once everything queued before it has leaked out.
+// The Reservation is not OK if the queue (the bucket) is full.
+func (l *LeakyBucket) Reserve(ctx context.Context, key string) (*Reservation, error) {
+	return l.ReserveN(ctx, key, 1)
+}
+
+// ReserveN is like Reserve for a request costing n.
+func (l *LeakyBucket) ReserveN(ctx context.Context, key string, n int64) (*Reservation, error) {
+	r, _, err := l.reserveN(ctx, key, n)
+	return r, err
+}
+
+// reserveN is ReserveN that also reports how long until a full queue has
+// room for the request.
+func (l *LeakyBucket) reserveN(ctx context.Context, key string, n int64) (*Reservation, time.Duration, error) {
+	if err := costError(AlgorithmLeakyBucket, key, n); err != nil {
+		return nil, 0, err
+	}
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	now := l.clock.Now()
+	ok, level, err := l.pour(ctx, key, n, now)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		return &Reservation{}, l.drainTime(level + float64(n) - l.capacity), nil
+	}
+
+	return &Reservation{
+		ok:    true,
+		clock: l.clock,
+		// Virtual scheduling: wait for the requests queued before this one
+		timeToAct: now.Add(l.drainTime(level - float64(n))),
+		cancel: func(ctx context.Context) error {
+			return l.unpour(ctx, key, n)
+		},
+	}, 0, nil
+}
+
+// unpour takes a cancelled request costing n back out of the bucket for key.
+func (l *LeakyBucket) unpour(ctx context.Context, key string, n int64) error {
+	nowSeconds := float64(l.clock.Now().UnixNano()) / 1e9
+	redisKey, err := l.key("leaky", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		// A negative cost gives the room back
+		if _, _, err := l.store.TakeTokens(ctx, redisKey, l.bucket(), -float64(n), l.clock.Now()); err != nil {
+			return storeError(AlgorithmLeakyBucket, key, err)
+		}
+		return nil
+	}
+	err = leakyBucketCancelScript.Run(ctx, l.client, []string{redisKey}, l.rate, nowSeconds, n).Err()
+	if err != nil {
+		return storeError(AlgorithmLeakyBucket, key, err)
+	}
+	return nil
+}
+
+// shapeN blocks until a request costing n for key has been queued and its
+// turn has come, or ctx is done. A request abandoned while queued leaves
+// the queue again.
+func (l *LeakyBucket) shapeN(ctx context.Context, key string, n int64) error {
+	if float64(n) > l.capacity {
+		return fmt.Errorf("%w: cost %d exceeds capacity %v", ErrLimitExceeded, n, l.capacity)
+	}
+	start := l.clock.Now()
+	for {
+		r, untilRoom, err := l.reserveN(ctx, key, n)
+		if err != nil {
+			return err
+		}
+		delay := r.Delay()
+		if !r.OK() {
+			// The queue is full, wait for room
+			delay = max(untilRoom, minWaitDelay)
+		}
+
+		if l.maxDelay > 0 && l.clock.Since(start)+delay > l.maxDelay {
+			r.Cancel(context.WithoutCancel(ctx))
+			return fmt.Errorf("%w: wait of %s exceeds max delay %s", ErrWouldExceedDeadline, delay, l.maxDelay)
+		}
+		// Context deadlines are set on the system clock
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			r.Cancel(context.WithoutCancel(ctx))
+			return fmt.Errorf("%w: wait of %s exceeds context deadline", ErrWouldExceedDeadline, delay)
+		}
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.Cancel(context.WithoutCancel(ctx))
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if r.OK() {
+			return nil
+		}
+	}
+}
+
+// result builds the Result of a request costing n with the bucket at level.
+func (l *LeakyBucket) result(allowed bool, level float64, n int64, now time.Time) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int64(l.capacity),
+		Remaining: max(0, int64(l.capacity-level)),
+		// Time for the bucket to drain completely
+		ResetAt: now.Add(l.drainTime(level)),
+	}
+	if !res.Allowed {
+		// Delay until enough has leaked out for the request to fit
+		res.RetryAfter = l.drainTime(level + float64(n) - l.capacity)
+	}
+	return res
+}
+
+// Check reports the state for key without pouring a request into the bucket.
+func (l *LeakyBucket) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("leaky", key)
+	if err != nil {
+		return Result{}, err
+	}
+	level, err := l.level(ctx, redisKey, now)
+	if err != nil {
+		return Result{}, storeError(AlgorithmLeakyBucket, key, err)
+	}
+	return l.result(level+1 <= l.capacity, level, 1, now), nil
+}
+
+// Reset empties the bucket for key.
+func (l *LeakyBucket) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("leaky", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmLeakyBucket, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmLeakyBucket, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *LeakyBucket) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	redisKey, err := l.key("leaky", key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	if l.store != nil {
+		// A Store only exposes the level now
+		level, err := l.level(ctx, redisKey, now)
+		if err != nil {
+			return State{}, storeError(AlgorithmLeakyBucket, key, err)
+		}
+		return State{
+			Algorithm:  AlgorithmLeakyBucket,
+			Key:        key,
+			Limit:      int64(l.capacity),
+			Level:      level,
+			LastRefill: now,
+		}, nil
+	}
+
+	pipe := l.client.Pipeline()
+	hmget := pipe.HMGet(ctx, redisKey, "level", "last", "v")
+	pttl := pipe.PTTL(ctx, redisKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return State{}, storeError(AlgorithmLeakyBucket, key, err)
+	}
+	state := hmget.Val()
+
+	st := State{
+		Algorithm: AlgorithmLeakyBucket,
+		Key:       key,
+		Limit:     int64(l.capacity),
+		Level:     leak(state, l.rate, now),
+		TTL:       max(0, pttl.Val()),
+	}
+	if last, err := strconv.ParseFloat(fmt.Sprint(state[1]), 64); err == nil {
+		st.LastRefill = time.Unix(0, int64(last*1e9))
+	}
+	if v, err := strconv.Atoi(fmt.Sprint(state[2])); err == nil {
+		st.Version = v
+	}
+	return st, nil
+}
+
+// level returns the level at now of the bucket stored at redisKey.
+func (l *LeakyBucket) level(ctx context.Context, redisKey string, now time.Time) (float64, error) {
+	if l.store != nil {
+		tokens, err := l.store.Tokens(ctx, redisKey, l.bucket(), now)
+		return l.capacity - tokens, err
+	}
+	state, err := l.client.HMGet(ctx, redisKey, "level", "last").Result()
+	if err != nil {
+		return 0, err
+	}
+	return leak(state, l.rate, now), nil
+}
+
+// bucket returns the token bucket a Store keeps for the room left in the
+// leaky bucket: it holds capacity minus the level, and refills as the
+// bucket leaks.
+func (l *LeakyBucket) bucket() Bucket {
+	return Bucket{Capacity: l.capacity, Rate: l.rate, MaxIdle: l.maxIdle}
+}
+
+// leak computes the level of a bucket at now from its stored
+// [level, last] state, the same way the Lua script does.
+func leak(state []any, rate float64, now time.Time) float64 {
+	level, err := strconv.ParseFloat(fmt.Sprint(state[0]), 64)
+	if err != nil {
+		// Nothing stored, so the bucket is empty
+		return 0
+	}
+	last, err := strconv.ParseFloat(fmt.Sprint(state[1]), 64)
+	if err != nil {
+		return level
+	}
+	elapsed := float64(now.UnixNano())/1e9 - last
+	return max(0, level-max(0, elapsed)*rate)
+}
+
+// drainTime returns how long it takes for the given amount to leak out.
+func (l *LeakyBucket) drainTime(amount float64) time.Duration {
+	return time.Duration(max(0, amount) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,61 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Reservations of no room or less would drain the queue.
+func TestLeakyBucketReserveInvalidCost(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	l := NewLeakyBucket(client, WithRate(1), WithCapacity(2), WithShaping())
+
+	for _, n := range []int64{0, -5} {
+		if _, err := l.ReserveN(ctx, "k", n); !errors.Is(err, ErrInvalidCost) {
+			t.Errorf("ReserveN(%d) error = %v, want ErrInvalidCost", n, err)
+		}
+		if err := l.WaitN(ctx, "k", n); !errors.Is(err, ErrInvalidCost) {
+			t.Errorf("WaitN(%d) with shaping error = %v, want ErrInvalidCost", n, err)
+		}
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("invalid reservations wrote %v", keys)
+	}
+}
+
+// Shaping measures its wait on the limiter's clock, and leaves the queue
+// when the wait would exceed MaxDelay.
+func TestLeakyBucketShapingMaxDelay(t *testing.T) {
+	ctx := context.Background()
+	_, client := newRedis(t)
+	clock := newFakeClock()
+	l := NewLeakyBucket(client, WithRate(1), WithCapacity(5), WithShaping(),
+		WithMaxDelay(2*time.Second), WithClock(clock))
+
+	if err := l.Wait(ctx, "k"); err != nil {
+		t.Fatalf("Wait on an empty queue = %v, want nil", err)
+	}
+	if _, err := l.AllowN(ctx, "k", 2); err != nil {
+		t.Fatal(err)
+	}
+	// Three requests are queued ahead, 3s at the leak rate
+	if err := l.Wait(ctx, "k"); !errors.Is(err, ErrWouldExceedDeadline) {
+		t.Fatalf("Wait behind 3s of queue = %v, want ErrWouldExceedDeadline", err)
+	}
+	st, err := l.Inspect(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Level != 3 {
+		t.Errorf("Level after giving up = %v, want 3", st.Level)
+	}
+
+	// Once the queue drained on the clock, the request goes through at once
+	clock.Advance(3 * time.Second)
+	if err := l.Wait(ctx, "k"); err != nil {
+		t.Errorf("Wait after the queue drained = %v, want nil", err)
+	}
+}
@@ -0,0 +1,58 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+)
+
+// Lease is a slot taken by Concurrency.Acquire or AdaptiveConcurrency.Acquire.
+type Lease struct {
+	limiter slots
+	key     string
+	id      string
+	res     Result
+	once    sync.Once
+}
+
+// slots is implemented by the limiters handing out Leases.
+type slots interface {
+	Heartbeat(ctx context.Context, key, id string) error
+	Release(ctx context.Context, key, id string) error
+}
+
+// OK reports whether the slot was acquired.
+func (l *Lease) OK() bool {
+	return l.res.Allowed
+}
+
+// Result returns the outcome of Acquire.
+func (l *Lease) Result() Result {
+	return l.res
+}
+
+// ID identifies the holder, so another process can Heartbeat or Release
+// the slot through the limiter.
+func (l *Lease) ID() string {
+	return l.id
+}
+
+// Heartbeat keeps the slot from being reclaimed.
+// Call it more often than the lease timeout while the work runs.
+// It returns ErrLeaseLost if the slot has already been reclaimed.
+func (l *Lease) Heartbeat(ctx context.Context) error {
+	if !l.OK() {
+		return nil
+	}
+	return l.limiter.Heartbeat(ctx, l.key, l.id)
+}
+
+// Release frees the slot. It is a no-op if the slot was not acquired
+// or was already released.
+func (l *Lease) Release(ctx context.Context) error {
+	if !l.OK() {
+		return nil
+	}
+	var err error
+	l.once.Do(func() { err = l.limiter.Release(ctx, l.key, l.id) })
+	return err
+}
@@ -0,0 +1,76 @@
+// Package ratelimiter implements Redis backed rate limiters.
+//
+// Seven algorithms are available, all behind the same Limiter interface:
+// Fixed Window, Sliding Window Log, Sliding Window Counter, Sliding Window
+// with sub-window buckets, Token Bucket, Leaky Bucket and GCRA.
+// Concurrency caps the number of requests in flight instead of their rate,
+// and AdaptiveConcurrency adapts that cap to the latency of the backend.
+// See the README for a comparison of their trade-offs.
+package ratelimiter
+
+import "context"
+
+// Limiter decides whether a request identified by key may proceed.
+type Limiter interface {
+	// Allow reports whether one request for key is allowed right now.
+	// A denied request is not an error; err is only set when the
+	// decision could not be made (e.g. Redis is unreachable).
+	Allow(ctx context.Context, key string) (Result, error)
+	// AllowN is like Allow but for a request costing n units (requests or
+	// tokens), so a single expensive call can consume more of the limit.
+	AllowN(ctx context.Context, key string, n int64) (Result, error)
+	// Check reports the current state for key, as Allow would for one
+	// request, without counting the request or consuming tokens.
+	Check(ctx context.Context, key string) (Result, error)
+	// Reset clears all state stored for key, as if it had never been seen.
+	Reset(ctx context.Context, key string) error
+}
+
+// Refunder is implemented by limiters that can give back capacity
+// taken by a request that ended up doing no work.
+type Refunder interface {
+	Refund(ctx context.Context, key string, n int64) error
+}
+
+// Waiter is implemented by limiters that can block until a request is
+// allowed, sleeping for the RetryAfter of each denial instead of polling.
+type Waiter interface {
+	// Wait blocks until a request for key is allowed or ctx is done.
+	Wait(ctx context.Context, key string) error
+	// WaitN is like Wait for a request costing n.
+	WaitN(ctx context.Context, key string, n int64) error
+}
+
+var (
+	_ Refunder = (*TokenBucket)(nil)
+
+	_ Waiter = (*FixedWindow)(nil)
+	_ Waiter = (*SlidingLog)(nil)
+	_ Waiter = (*SlidingCounter)(nil)
+	_ Waiter = (*TokenBucket)(nil)
+	_ Waiter = (*MultiLimiter)(nil)
+	_ Waiter = (*LeakyBucket)(nil)
+	_ Waiter = (*GCRA)(nil)
+	_ Waiter = (*SlidingBuckets)(nil)
+	_ Waiter = (*Adaptive)(nil)
+	_ Waiter = (*EWMA)(nil)
+
+	_ Limiter = (*FixedWindow)(nil)
+	_ Limiter = (*SlidingLog)(nil)
+	_ Limiter = (*SlidingCounter)(nil)
+	_ Limiter = (*TokenBucket)(nil)
+	_ Limiter = (*MultiLimiter)(nil)
+	_ Limiter = (*LeakyBucket)(nil)
+	_ Limiter = (*GCRA)(nil)
+	_ Limiter = (*SlidingBuckets)(nil)
+	_ Limiter = (*Adaptive)(nil)
+	_ Limiter = (*Quota)(nil)
+	_ Limiter = (*Bandwidth)(nil)
+	_ Limiter = (*EWMA)(nil)
+	_ Limiter = (*Penalized)(nil)
+	_ Limiter = (*Idempotent)(nil)
+	_ Limiter = (*TwoTier)(nil)
+	_ Limiter = (*HotKeys)(nil)
+	_ Limiter = (*Fallback)(nil)
+	_ Limiter = (*Prefetcher)(nil)
+)
@@ -0,0 +1,64 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisLimiters returns every limiter keeping its state in client.
+func newRedisLimiters(client redis.UniversalClient) map[string]Limiter {
+	window := []Option{WithLimit(3), WithWindow(time.Minute)}
+	bucket := []Option{WithRate(1), WithBurst(3)}
+	perMinute := Limit{Requests: 3, Window: time.Minute}
+	noScripts := []Option{WithoutScripts()}
+	return map[string]Limiter{
+		"FixedWindow":    NewFixedWindow(client, window...),
+		"SlidingLog":     NewSlidingLog(client, window...),
+		"SlidingCounter": NewSlidingCounter(client, window...),
+		"SlidingBuckets": NewSlidingBuckets(client, window...),
+		"TokenBucket":    NewTokenBucket(client, bucket...),
+		"GCRA":           NewGCRA(client, bucket...),
+		"LeakyBucket":    NewLeakyBucket(client, bucket...),
+		"Quota":          NewQuota(client, Daily, WithLimit(3)),
+		"MultiLimiter":   NewMultiLimiter(client, []Limit{perMinute, {Requests: 100, Window: time.Hour}}),
+		"Bandwidth":      NewBandwidth(client, perMinute, Limit{Requests: 1 << 20, Window: time.Minute}),
+		"Adaptive":       NewAdaptive(client, AIMD{MinRate: 1, MaxRate: 10, Increase: 1, Decrease: 0.5}),
+		"EWMA":           NewEWMA(client, window...),
+		"Penalized": NewPenalized(client, NewFixedWindow(client, window...),
+			PenaltyPolicy{Denials: 3, Within: time.Minute, Cooldown: time.Minute, MaxCooldown: time.Hour}),
+		"Idempotent": NewIdempotent(client, NewFixedWindow(client, window...), time.Minute),
+
+		"FixedWindow/transaction":    NewFixedWindow(client, append(noScripts, window...)...),
+		"SlidingLog/transaction":     NewSlidingLog(client, append(noScripts, window...)...),
+		"SlidingCounter/transaction": NewSlidingCounter(client, append(noScripts, window...)...),
+		"TokenBucket/transaction":    NewTokenBucket(client, append(noScripts, bucket...)...),
+	}
+}
+
+// An unreachable Redis fails every call with ErrStoreUnavailable, without
+// panicking on the missing reply.
+func TestLimitersUnreachable(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "request-1")
+	for name, l := range newRedisLimiters(newDeadRedis(t)) {
+		t.Run(name, func(t *testing.T) {
+			if res, err := l.Allow(ctx, "k"); !errors.Is(err, ErrStoreUnavailable) {
+				t.Errorf("Allow = %+v, %v, want ErrStoreUnavailable", res, err)
+			}
+			if res, err := l.Check(ctx, "k"); !errors.Is(err, ErrStoreUnavailable) {
+				t.Errorf("Check = %+v, %v, want ErrStoreUnavailable", res, err)
+			}
+			if err := l.Reset(ctx, "k"); !errors.Is(err, ErrStoreUnavailable) {
+				t.Errorf("Reset = %v, want ErrStoreUnavailable", err)
+			}
+			if in, ok := l.(Inspector); ok {
+				if st, err := in.Inspect(ctx, "k"); !errors.Is(err, ErrStoreUnavailable) {
+					t.Errorf("Inspect = %+v, %v, want ErrStoreUnavailable", st, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LimitProvider returns the limit that applies to key.
+// It is consulted on every request, so e.g. premium users can get higher
+// limits than free users from a single limiter.
+type LimitProvider interface {
+	Limits(ctx context.Context, key string) (Limit, error)
+}
+
+// LimitProviderFunc adapts a function to LimitProvider.
+type LimitProviderFunc func(ctx context.Context, key string) (Limit, error)
+
+// Limits implements LimitProvider.
+func (f LimitProviderFunc) Limits(ctx context.Context, key string) (Limit, error) {
+	return f(ctx, key)
+}
+
+// RedisLimitProvider reads per-key limits from Redis hashes and caches them in-process.
+// A key's limit is stored as
+//
+//	HSET limits:<key> requests 1000 window_ms 3600000 burst 50
+//
+// Keys without a hash get the default limit, and fields missing from a hash
+// their default value.
+type RedisLimitProvider struct {
+	client     redis.UniversalClient
+	cfg        config
+	defaults   Limit
+	ttl        time.Duration
+	maxEntries int
+
+	mu    sync.Mutex
+	cache map[string]cachedLimit
+}
+
+type cachedLimit struct {
+	limit   Limit
+	expires time.Time
+}
+
+// NewRedisLimitProvider returns a provider that falls back to defaults
+// and caches limits read from Redis for ttl.
+// The hashes are named like limiter keys of kind "limits", so WithKeyPrefix,
+// WithNamespace, WithHashTags and WithKeyFunc apply; WithClock sets the clock
+// the cache expires on. Other options are ignored.
+func NewRedisLimitProvider(client redis.UniversalClient, defaults Limit, ttl time.Duration, opts ...Option) *RedisLimitProvider {
+	return &RedisLimitProvider{
+		client:     client,
+		cfg:        newConfig(opts),
+		defaults:   defaults,
+		ttl:        ttl,
+		maxEntries: 10000,
+		cache:      make(map[string]cachedLimit),
+	}
+}
+
+// Limits implements LimitProvider.
+func (p *RedisLimitProvider) Limits(ctx context.Context, key string) (Limit, error) {
+	now := p.cfg.clock.Now()
+
+	p.mu.Lock()
+	cached, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.limit, nil
+	}
+
+	redisKey, err := p.cfg.key("limits", key)
+	if err != nil {
+		return Limit{}, err
+	}
+	fields, err := p.client.HGetAll(ctx, redisKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return Limit{}, fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+	limit := p.defaults
+	if v, err := strconv.ParseInt(fields["requests"], 10, 64); err == nil {
+		limit.Requests = v
+	}
+	if v, err := strconv.ParseInt(fields["window_ms"], 10, 64); err == nil {
+		limit.Window = time.Duration(v) * time.Millisecond
+	}
+	if v, err := strconv.ParseInt(fields["burst"], 10, 64); err == nil {
+		limit.Burst = v
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Keep the cache bounded; expired entries go first, then everything
+	if len(p.cache) >= p.maxEntries {
+		for k, c := range p.cache {
+			if now.After(c.expires) {
+				delete(p.cache, k)
+			}
+		}
+		if len(p.cache) >= p.maxEntries {
+			clear(p.cache)
+		}
+	}
+	p.cache[key] = cachedLimit{limit: limit, expires: now.Add(p.ttl)}
+
+	return limit, nil
+}
+
+// SetLimits stores the limit for key in Redis and updates the local cache.
+func (p *RedisLimitProvider) SetLimits(ctx context.Context, key string, limit Limit) error {
+	redisKey, err := p.cfg.key("limits", key)
+	if err != nil {
+		return err
+	}
+	err = p.client.HSet(ctx, redisKey,
+		"requests", limit.Requests,
+		"window_ms", limit.Window.Milliseconds(),
+		"burst", limit.Burst,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+
+	p.mu.Lock()
+	p.cache[key] = cachedLimit{limit: limit, expires: p.cfg.clock.Now().Add(p.ttl)}
+	p.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,105 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Manager lazily creates and caches one value per key, typically a local
+// (in-process) limiter per client. It is bounded: when it holds maxEntries
+// keys the least recently used one is evicted, and keys unused for longer
+// than idleTTL are dropped, so millions of distinct client IPs can't leak memory.
+type Manager[T any] struct {
+	newFunc    func(key string) T
+	maxEntries int
+	idleTTL    time.Duration
+	clock      Clock
+
+	mu      sync.Mutex
+	lru     *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type managerEntry[T any] struct {
+	key      string
+	value    T
+	lastUsed time.Duration // monotonic reading of clock
+}
+
+// NewManager returns a Manager creating values with newFunc.
+// A maxEntries or idleTTL of zero disables that bound.
+func NewManager[T any](newFunc func(key string) T, maxEntries int, idleTTL time.Duration) *Manager[T] {
+	return &Manager[T]{
+		newFunc:    newFunc,
+		maxEntries: maxEntries,
+		idleTTL:    idleTTL,
+		clock:      SystemClock,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// SetClock replaces the clock used for idle expiry.
+// Idle time is measured with its monotonic time if it is a MonotonicClock.
+func (m *Manager[T]) SetClock(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = clock
+}
+
+// Get returns the value for key, creating it if needed.
+func (m *Manager[T]) Get(key string) T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := monotonic(m.clock)
+	if el, ok := m.entries[key]; ok {
+		e := el.Value.(*managerEntry[T])
+		if m.idleTTL <= 0 || now-e.lastUsed < m.idleTTL {
+			e.lastUsed = now
+			m.lru.MoveToFront(el)
+			return e.value
+		}
+		m.remove(el)
+	}
+
+	m.evict(now)
+	e := &managerEntry[T]{key: key, value: m.newFunc(key), lastUsed: now}
+	m.entries[key] = m.lru.PushFront(e)
+	return e.value
+}
+
+// Delete drops the value for key.
+func (m *Manager[T]) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+}
+
+// Len returns the number of cached values.
+func (m *Manager[T]) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// evict drops idle entries from the back of the list and makes room for one more.
+func (m *Manager[T]) evict(now time.Duration) {
+	for el := m.lru.Back(); el != nil; el = m.lru.Back() {
+		e := el.Value.(*managerEntry[T])
+		idle := m.idleTTL > 0 && now-e.lastUsed >= m.idleTTL
+		full := m.maxEntries > 0 && m.lru.Len() >= m.maxEntries
+		if !idle && !full {
+			return
+		}
+		m.remove(el)
+	}
+}
+
+func (m *Manager[T]) remove(el *list.Element) {
+	m.lru.Remove(el)
+	delete(m.entries, el.Value.(*managerEntry[T]).key)
+}
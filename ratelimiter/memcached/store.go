@@ -0,0 +1,253 @@
+// Package memcached implements a ratelimiter.Store on Memcached, for
+// deployments that run Memcached but not Redis.
+//
+// Every operation is a compare-and-swap loop (gets, then cas or add), so
+// concurrent processes never lose each other's updates. It backs every
+// limiter accepting ratelimiter.WithStore.
+//
+// Like the Lua scripts, the store starts over from empty state when it
+// finds a value it can't parse, rather than failing every request for the key.
+//
+// Memcached keys are limited to 250 bytes without spaces or control
+// characters, so keys that may contain them should be hashed with
+// ratelimiter.WithKeyFunc. Memcached may also evict state under memory
+// pressure before it expires, which forgets the requests counted so far.
+package memcached
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// casRetries bounds how often an update is retried when another client
+// changed the item first.
+const casRetries = 16
+
+// errConflict is returned when an update kept conflicting.
+var errConflict = errors.New("memcached: too many conflicting updates")
+
+// Store is a ratelimiter.Store backed by a Memcached client.
+type Store struct {
+	client *memcache.Client
+	clock  ratelimiter.Clock
+}
+
+var _ ratelimiter.Store = (*Store)(nil)
+
+// New returns a Store keeping limiter state in client.
+func New(client *memcache.Client) *Store {
+	return &Store{client: client, clock: ratelimiter.SystemClock}
+}
+
+// SetClock replaces the clock counters expire by. Pass the clock given to
+// the limiters with WithClock, so their windows end at the same time.
+func (s *Store) SetClock(clock ratelimiter.Clock) {
+	s.clock = clock
+}
+
+// Increment implements ratelimiter.Store.
+// Counters are stored as "<count>:<expiry in unix ms>", as Memcached can't
+// tell how long an item has left to live.
+func (s *Store) Increment(ctx context.Context, key string, n int64, ttl time.Duration) (int64, time.Duration, error) {
+	var count int64
+	var expires time.Time
+	err := s.update(ctx, key, func(value []byte, now time.Time) ([]byte, time.Time) {
+		count, expires = 0, now.Add(ttl)
+		// Memcached expires items in whole seconds, so an item may outlive its window slightly
+		if c, e, ok := parseCounter(value); ok && now.Before(e) {
+			count, expires = c, e
+		}
+		count += n
+		return []byte(strconv.FormatInt(count, 10) + ":" + strconv.FormatInt(expires.UnixMilli(), 10)), expires
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, expires.Sub(s.clock.Now()), nil
+}
+
+// Counter implements ratelimiter.Store.
+func (s *Store) Counter(ctx context.Context, key string) (int64, time.Duration, error) {
+	item, err := s.client.Get(key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	count, expires, ok := parseCounter(item.Value)
+	left := expires.Sub(s.clock.Now())
+	if !ok || left <= 0 {
+		return 0, 0, nil
+	}
+	return count, left, nil
+}
+
+// TakeTokens implements ratelimiter.Store.
+// Buckets are stored as "<tokens>:<last refill in unix ns>".
+func (s *Store) TakeTokens(ctx context.Context, key string, b ratelimiter.Bucket, cost float64, now time.Time) (bool, float64, error) {
+	var allowed bool
+	var tokens float64
+	err := s.update(ctx, key, func(value []byte, _ time.Time) ([]byte, time.Time) {
+		t, last, ok := parseBucket(value)
+		if !ok {
+			// A bucket that doesn't exist is full
+			t, last = b.Capacity, now
+		}
+		allowed, tokens, last = b.Take(t, last, cost, now)
+		if !allowed {
+			return nil, time.Time{}
+		}
+		value = []byte(strconv.FormatFloat(tokens, 'g', -1, 64) + ":" + strconv.FormatInt(last.UnixNano(), 10))
+		return value, now.Add(b.TTL(tokens))
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed, tokens, nil
+}
+
+// Tokens implements ratelimiter.Store.
+func (s *Store) Tokens(ctx context.Context, key string, b ratelimiter.Bucket, now time.Time) (float64, error) {
+	item, err := s.client.Get(key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return b.Capacity, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	tokens, last, ok := parseBucket(item.Value)
+	if !ok {
+		return b.Capacity, nil
+	}
+	return b.Tokens(tokens, last, now), nil
+}
+
+// Update implements ratelimiter.Store.
+// State is stored as "<expiry in unix ms>:<state>".
+func (s *Store) Update(ctx context.Context, key string, fn func(state []byte, expiresIn time.Duration) ([]byte, time.Duration)) error {
+	return s.update(ctx, key, func(value []byte, now time.Time) ([]byte, time.Time) {
+		state, expires, ok := parseState(value)
+		if !ok || !now.Before(expires) {
+			state, expires = nil, now
+		}
+		next, ttl := fn(state, expires.Sub(now))
+		if next == nil {
+			return nil, time.Time{}
+		}
+		expires = now.Add(ttl)
+		return append([]byte(strconv.FormatInt(expires.UnixMilli(), 10)+":"), next...), expires
+	})
+}
+
+// Delete implements ratelimiter.Store.
+func (s *Store) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := s.client.Delete(key); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return err
+		}
+	}
+	return nil
+}
+
+// update applies fn to the item at key with compare-and-swap, retrying
+// while other clients change it first. fn gets the current value, nil if
+// there is none, and returns the new value and when it expires; a nil value
+// leaves the item unchanged.
+func (s *Store) update(ctx context.Context, key string, fn func(value []byte, now time.Time) ([]byte, time.Time)) error {
+	for range casRetries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item, err := s.client.Get(key)
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			item, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var current []byte
+		if item != nil {
+			current = item.Value
+		}
+		now := s.clock.Now()
+		value, expires := fn(current, now)
+		if value == nil {
+			return nil
+		}
+
+		next := &memcache.Item{Key: key, Value: value, Expiration: expiration(expires.Sub(now))}
+		if item == nil {
+			err = s.client.Add(next)
+		} else {
+			next.CasID = item.CasID
+			err = s.client.CompareAndSwap(next)
+		}
+		// Another client created, changed or deleted the item in between
+		if errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrCacheMiss) {
+			continue
+		}
+		return err
+	}
+	return errConflict
+}
+
+// expiration converts a TTL to Memcached's relative expiry in seconds,
+// rounded up so items never expire early. Memcached reads values beyond 30
+// days as unix timestamps, so longer TTLs are capped to 30 days.
+func expiration(ttl time.Duration) int32 {
+	const maxRelative = 30 * 24 * 60 * 60
+	return int32(min(maxRelative, max(1, math.Ceil(ttl.Seconds()))))
+}
+
+// parseCounter parses a counter written by Increment.
+// It reports false for a missing or malformed value.
+func parseCounter(value []byte) (int64, time.Time, bool) {
+	countField, expiresField, ok := strings.Cut(string(value), ":")
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	count, err1 := strconv.ParseInt(countField, 10, 64)
+	expires, err2 := strconv.ParseInt(expiresField, 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, time.Time{}, false
+	}
+	return count, time.UnixMilli(expires), true
+}
+
+// parseBucket parses a bucket written by TakeTokens.
+// It reports false for a missing or malformed value.
+func parseBucket(value []byte) (float64, time.Time, bool) {
+	tokensField, lastField, ok := strings.Cut(string(value), ":")
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	tokens, err1 := strconv.ParseFloat(tokensField, 64)
+	last, err2 := strconv.ParseInt(lastField, 10, 64)
+	if err1 != nil || err2 != nil || math.IsNaN(tokens) || math.IsInf(tokens, 0) {
+		return 0, time.Time{}, false
+	}
+	return tokens, time.Unix(0, last), true
+}
+
+// parseState parses state written by Update.
+// It reports false for a missing or malformed value.
+func parseState(value []byte) ([]byte, time.Time, bool) {
+	expiresField, state, ok := strings.Cut(string(value), ":")
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	expires, err := strconv.ParseInt(expiresField, 10, 64)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	return []byte(state), time.UnixMilli(expires), true
+}
@@ -0,0 +1,173 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often a MemoryStore drops expired state.
+const memorySweepInterval = time.Minute
+
+// MemoryStore is a Store keeping limiter state in process memory, for
+// single-instance services and tests that should not need Redis. Each key
+// has its own state, and operations on it are serialized by a mutex.
+// Expired state is dropped as it is read and by a periodic sweep, so keys
+// that are never seen again don't leak memory.
+//
+// Expiry is measured with the monotonic time of its clock (see
+// MonotonicClock), so a wall clock step doesn't expire or revive state.
+type MemoryStore struct {
+	mu        sync.Mutex
+	clock     Clock
+	entries   map[string]*memoryEntry
+	lastSweep time.Duration
+}
+
+// memoryEntry is the state of one key: a counter, a bucket or the state
+// stored with Update.
+type memoryEntry struct {
+	count   int64
+	tokens  float64
+	last    time.Time
+	state   []byte
+	expires time.Duration // monotonic reading of clock
+}
+
+// NewMemoryStore returns an empty MemoryStore using SystemClock.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{clock: SystemClock, entries: make(map[string]*memoryEntry)}
+}
+
+// SetClock replaces the clock used for expiry. Pass the clock given to
+// the limiters with WithClock, so their state expires in the same time.
+func (s *MemoryStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Increment implements Store.
+func (s *MemoryStore) Increment(ctx context.Context, key string, n int64, ttl time.Duration) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	e := s.get(key, now)
+	if e == nil {
+		e = &memoryEntry{expires: now + ttl}
+		s.entries[key] = e
+	}
+	e.count += n
+	return e.count, e.expires - now, nil
+}
+
+// Counter implements Store.
+func (s *MemoryStore) Counter(ctx context.Context, key string) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	e := s.get(key, now)
+	if e == nil {
+		return 0, 0, nil
+	}
+	return e.count, e.expires - now, nil
+}
+
+// TakeTokens implements Store.
+func (s *MemoryStore) TakeTokens(ctx context.Context, key string, b Bucket, cost float64, now time.Time) (bool, float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mono := s.now()
+	e := s.get(key, mono)
+	if e == nil {
+		// A bucket that doesn't exist is full
+		e = &memoryEntry{tokens: b.Capacity, last: now}
+	}
+	allowed, tokens, last := b.Take(e.tokens, e.last, cost, now)
+	if !allowed {
+		return false, tokens, nil
+	}
+	e.tokens, e.last, e.expires = tokens, last, mono+b.TTL(tokens)
+	s.entries[key] = e
+	return true, tokens, nil
+}
+
+// Tokens implements Store.
+func (s *MemoryStore) Tokens(ctx context.Context, key string, b Bucket, now time.Time) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.get(key, s.now())
+	if e == nil {
+		return b.Capacity, nil
+	}
+	return b.Tokens(e.tokens, e.last, now), nil
+}
+
+// Update implements Store.
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(state []byte, expiresIn time.Duration) ([]byte, time.Duration)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var state []byte
+	var left time.Duration
+	if e := s.get(key, now); e != nil {
+		state, left = e.state, e.expires-now
+	}
+	next, ttl := fn(state, left)
+	if next == nil {
+		return nil
+	}
+	// The caller may still own the slice fn returned, so store a copy
+	s.entries[key] = &memoryEntry{state: append([]byte(nil), next...), expires: now + ttl}
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// Len returns the number of keys holding state, including expired ones
+// not dropped yet.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// now returns the monotonic time and drops expired state when a sweep is due.
+func (s *MemoryStore) now() time.Duration {
+	now := monotonic(s.clock)
+	if now-s.lastSweep >= memorySweepInterval {
+		for key, e := range s.entries {
+			if now >= e.expires {
+				delete(s.entries, key)
+			}
+		}
+		s.lastSweep = now
+	}
+	return now
+}
+
+// get returns the live state of key, or nil if it has none.
+func (s *MemoryStore) get(key string, now time.Duration) *memoryEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if now >= e.expires {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIP returns a KeyFunc keying requests by the client IP address,
+// reading the forwarding headers only when they were set by a trusted
+// proxy, e.g.
+//
+//	middleware.ClientIP(netip.MustParsePrefix("10.0.0.0/8"))
+//
+// for a load balancer in 10.0.0.0/8. A request from any other peer is keyed
+// by the peer's address, so clients can't pick their key by sending the
+// headers themselves. From a trusted peer, the Forwarded header (RFC 7239),
+// X-Forwarded-For or X-Real-IP is read, in that order, and the hops it
+// lists are walked from the nearest: the client is the first hop that is not
+// a trusted proxy. Should a header hold a value that isn't an IP address,
+// e.g. an obfuscated Forwarded identifier, the hop that reported it is used.
+func ClientIP(trustedProxies ...netip.Prefix) KeyFunc {
+	trusted := func(ip netip.Addr) bool {
+		for _, p := range trustedProxies {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(r *http.Request) (string, error) {
+		peer, ok := parseIP(r.RemoteAddr)
+		if !ok {
+			return RemoteAddr(r)
+		}
+		if !trusted(peer) {
+			return peer.String(), nil
+		}
+
+		hops := forwardedFor(r.Header)
+		if len(hops) == 0 {
+			if ip, ok := parseIP(r.Header.Get("X-Real-IP")); ok {
+				return ip.String(), nil
+			}
+			return peer.String(), nil
+		}
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip, ok := parseIP(hops[i])
+			if !ok {
+				break
+			}
+			client = ip
+			if !trusted(ip) {
+				break
+			}
+		}
+		return client.String(), nil
+	}
+}
+
+// forwardedFor returns the hops listed by the Forwarded header, or by
+// X-Forwarded-For without one, from the farthest to the nearest.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, line := range h.Values("Forwarded") {
+		for _, element := range strings.Split(line, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	for _, line := range h.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(line, ",")...)
+	}
+	return hops
+}
+
+// parseIP parses an IP address with an optional port, as found in
+// RemoteAddr and the forwarding headers, e.g. "[2001:db8::1]:4711".
+// IPv4-mapped IPv6 addresses are unmapped, so both forms share one key.
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// HeaderStyle selects which rate limit headers responses carry.
+// Retry-After is set on denied responses whatever the style.
+type HeaderStyle int
+
+const (
+	// HeadersBoth sets the draft and the legacy headers, the default, so
+	// clients migrate at their own pace.
+	HeadersBoth HeaderStyle = iota
+	// HeadersDraft sets the IETF draft headers RateLimit-Limit,
+	// RateLimit-Remaining and RateLimit-Reset, in seconds from now, and
+	// RateLimit-Policy when configured.
+	HeadersDraft
+	// HeadersLegacy sets X-RateLimit-Limit, X-RateLimit-Remaining and
+	// X-RateLimit-Reset, a Unix time, which many client SDKs still parse.
+	HeadersLegacy
+	// HeadersNone sets no rate limit headers, e.g. to not reveal limits to
+	// abusive clients.
+	HeadersNone
+)
+
+// SetHeaders sets the rate limit headers of a response from res in both
+// styles, see HeadersBoth. It is exported for adapters to other frameworks.
+func SetHeaders(h http.Header, res ratelimiter.Result) {
+	HeadersBoth.Set(h, res)
+}
+
+// Set sets the rate limit headers of style from res, and Retry-After when
+// the request was denied.
+// Limit and Remaining are unknown for some decisions, e.g. a cooldown of
+// Penalized, and are left out then.
+func (s HeaderStyle) Set(h http.Header, res ratelimiter.Result) {
+	draft := s == HeadersBoth || s == HeadersDraft
+	legacy := s == HeadersBoth || s == HeadersLegacy
+	if res.Limit > 0 {
+		limit := strconv.FormatInt(res.Limit, 10)
+		remaining := strconv.FormatInt(res.Remaining, 10)
+		if draft {
+			h.Set("RateLimit-Limit", limit)
+			h.Set("RateLimit-Remaining", remaining)
+		}
+		if legacy {
+			h.Set("X-RateLimit-Limit", limit)
+			h.Set("X-RateLimit-Remaining", remaining)
+		}
+	}
+	if !res.ResetAt.IsZero() {
+		if draft {
+			h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(time.Until(res.ResetAt)), 10))
+		}
+		if legacy {
+			h.Set("X-RateLimit-Reset", strconv.FormatInt(res.ResetAt.Unix(), 10))
+		}
+	}
+	if retryAfter := RetryAfter(res, false); retryAfter != "" {
+		h.Set("Retry-After", retryAfter)
+	}
+}
+
+// RetryAfter returns the Retry-After header of a denied request, from the
+// RetryAfter the limiter computed for it: until the window resets, enough
+// old requests slide out or enough tokens refill, depending on the
+// algorithm. It is in delay-seconds, or an HTTP-date if date is set, both
+// rounded up so clients never retry early. It is empty when the request
+// was allowed or the limiter can't tell when to retry, e.g. Concurrency.
+func RetryAfter(res ratelimiter.Result, date bool) string {
+	if res.Allowed || res.RetryAfter <= 0 {
+		return ""
+	}
+	if !date {
+		return strconv.FormatInt(res.RetryAfterSeconds(), 10)
+	}
+	at := time.Now().Add(res.RetryAfter)
+	if rounded := at.Truncate(time.Second); rounded.Before(at) {
+		at = rounded.Add(time.Second)
+	}
+	return at.UTC().Format(http.TimeFormat)
+}
+
+// Policy formats limits for a RateLimit-Policy header, e.g. "10;w=1" for
+// 10 requests per second, with a burst parameter for limits that set one.
+func Policy(limits ...ratelimiter.Limit) string {
+	policies := make([]string, len(limits))
+	for i, l := range limits {
+		policies[i] = strconv.FormatInt(l.Requests, 10) + ";w=" + strconv.FormatInt(ceilSeconds(l.Window), 10)
+		if l.Burst > 0 {
+			policies[i] += ";burst=" + strconv.FormatInt(l.Burst, 10)
+		}
+	}
+	return strings.Join(policies, ", ")
+}
+
+// ceilSeconds returns d in whole seconds, rounded up so clients never act
+// early, and never negative.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(max(0, (d+time.Second-1)/time.Second))
+}
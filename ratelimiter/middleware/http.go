@@ -0,0 +1,81 @@
+// Package middleware adapts limiters to servers: net/http middleware that
+// decides every request with a ratelimiter.Limiter and answers denied ones
+// with 429 Too Many Requests.
+//
+//	limiter := ratelimiter.NewTokenBucket(rdb, ratelimiter.WithBurst(20), ratelimiter.WithRate(10))
+//	http.ListenAndServe(":8080", middleware.HTTP(limiter)(mux))
+//
+// For clients, Transport paces outbound requests instead.
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// KeyFunc extracts the key a request is limited by, e.g. its client IP or
+// API key. An error fails the request, see WithOnError.
+type KeyFunc func(r *http.Request) (string, error)
+
+// HTTP returns middleware limiting requests with l. Each request costs one
+// unit, unless WithCost says otherwise, of the key returned by the KeyFunc,
+// the remote address by default.
+// Allowed requests go on to the next handler with the rate limit headers
+// set; denied ones are answered by the WithOnDenied handler, 429 Too Many
+// Requests by default.
+func HTTP(l ratelimiter.Limiter, opts ...Option) func(http.Handler) http.Handler {
+	c := newConfig(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, err := c.keyFunc(r)
+			if err != nil {
+				c.onError(w, r, err)
+				return
+			}
+			if c.route != nil {
+				key = r.Method + " " + c.route(r) + ":" + key
+			}
+			if c.prefix != "" {
+				key = c.prefix + ":" + key
+			}
+			cost := int64(1)
+			if c.cost != nil {
+				if cost, err = c.cost(r); err != nil {
+					c.onError(w, r, err)
+					return
+				}
+			}
+			res, err := l.AllowN(r.Context(), key, cost)
+			if err != nil {
+				c.onError(w, r, err)
+				return
+			}
+			c.headers.Set(w.Header(), res)
+			if date := RetryAfter(res, true); c.retryDate && date != "" {
+				// Replaces the delay-seconds form set with the other headers
+				w.Header().Set("Retry-After", date)
+			}
+			if c.policy != "" && (c.headers == HeadersBoth || c.headers == HeadersDraft) {
+				w.Header().Set("RateLimit-Policy", c.policy)
+			}
+			if !res.Allowed {
+				c.onDenied(w, r, res)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RemoteAddr is the default KeyFunc: the IP address the request came from,
+// without its port. Behind a proxy that is the proxy's address.
+func RemoteAddr(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// Not host:port, e.g. set by a test or a Unix socket listener
+		return r.RemoteAddr, nil
+	}
+	return host, nil
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// newLimiter returns a limiter allowing one request a minute per key, in
+// memory.
+func newLimiter() ratelimiter.Limiter {
+	return ratelimiter.NewFixedWindow(nil, ratelimiter.WithStore(ratelimiter.NewMemoryStore()),
+		ratelimiter.WithLimit(1), ratelimiter.WithWindow(time.Minute))
+}
+
+// unavailable is a Limiter whose store can't be reached.
+type unavailable struct{ ratelimiter.Limiter }
+
+func (unavailable) AllowN(context.Context, string, int64) (ratelimiter.Result, error) {
+	return ratelimiter.Result{}, ratelimiter.ErrStoreUnavailable
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("ok")) })
+
+// get serves a GET request of path from addr with h.
+func get(h http.Handler, path, addr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.RemoteAddr = addr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTP(t *testing.T) {
+	h := HTTP(newLimiter(), WithPolicy(ratelimiter.Limit{Requests: 1, Window: time.Minute}))(ok)
+
+	w := get(h, "/", "203.0.113.7:1234")
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("first request = %d %q, want 200 from next", w.Code, w.Body)
+	}
+	for name, want := range map[string]string{
+		"RateLimit-Limit":       "1",
+		"RateLimit-Remaining":   "0",
+		"RateLimit-Reset":       "60",
+		"X-RateLimit-Limit":     "1",
+		"X-RateLimit-Remaining": "0",
+		"RateLimit-Policy":      "1;w=60",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if got := w.Header().Get("Retry-After"); got != "" {
+		t.Errorf("Retry-After on an allowed request = %q", got)
+	}
+
+	// Another port of the same client shares its limit
+	w = get(h, "/", "203.0.113.7:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", w.Code)
+	}
+	for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"} {
+		if w.Header().Get(name) == "" {
+			t.Errorf("429 without %s", name)
+		}
+	}
+
+	if w := get(h, "/", "203.0.113.8:1234"); w.Code != http.StatusOK {
+		t.Errorf("request of another client = %d, want 200", w.Code)
+	}
+}
+
+func TestHTTPRouteKey(t *testing.T) {
+	mw := HTTP(newLimiter(), WithRouteKey(Pattern))
+	mux := http.NewServeMux()
+	mux.Handle("GET /a", mw(ok))
+	mux.Handle("GET /b/{id}", mw(ok))
+
+	for _, path := range []string{"/a", "/b/1"} {
+		if w := get(mux, path, "203.0.113.7:1234"); w.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200 as routes are counted separately", path, w.Code)
+		}
+	}
+	if w := get(mux, "/b/2", "203.0.113.7:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("GET /b/2 = %d, want 429 as it shares the route of /b/1", w.Code)
+	}
+}
+
+func TestHTTPStoreUnavailable(t *testing.T) {
+	h := HTTP(unavailable{})(ok)
+	if w := get(h, "/", "203.0.113.7:1234"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", w.Code)
+	}
+}
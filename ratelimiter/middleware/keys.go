@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrNoKey is returned by the KeyFuncs of this package for requests that
+// lack what they key on, e.g. an API key. The default error handler
+// answers it with 401 Unauthorized.
+var ErrNoKey = errors.New("middleware: request has no key")
+
+// Header returns a KeyFunc keying requests by the value of the header
+// name, e.g. "X-Tenant-ID". The value is used as is; use APIKey for
+// headers carrying secrets.
+func Header(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		value := r.Header.Get(name)
+		if value == "" {
+			return "", ErrNoKey
+		}
+		return value, nil
+	}
+}
+
+// APIKey returns a KeyFunc keying requests by the API key in the header
+// name, e.g. "X-API-Key". The key is hashed with HashKey, so raw
+// credentials never become Redis key names, where anyone with read access
+// to Redis, its backups or its slow log would find them.
+func APIKey(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		value := r.Header.Get(name)
+		if value == "" {
+			return "", ErrNoKey
+		}
+		return HashKey(value), nil
+	}
+}
+
+// BearerToken keys requests by the bearer token of their Authorization
+// header, hashed like APIKey.
+func BearerToken(r *http.Request) (string, error) {
+	token, ok := bearer(r)
+	if !ok {
+		return "", ErrNoKey
+	}
+	return HashKey(token), nil
+}
+
+// HashKey returns the SHA-256 of secret in hex, for keys made of
+// credentials. API keys and tokens have far too much entropy to be
+// recovered from their hash.
+func HashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearer returns the token of a "Bearer" Authorization header.
+func bearer(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
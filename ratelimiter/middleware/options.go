@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+type config struct {
+	keyFunc   KeyFunc
+	route     func(r *http.Request) string
+	prefix    string
+	cost      func(r *http.Request) (int64, error)
+	policy    string
+	headers   HeaderStyle
+	retryDate bool
+	onDenied  func(w http.ResponseWriter, r *http.Request, res ratelimiter.Result)
+	onError   func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+func newConfig(opts []Option) config {
+	c := config{keyFunc: RemoteAddr, onDenied: onDenied, onError: onError}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Option configures the middleware.
+type Option func(*config)
+
+// WithKeyFunc sets how the key of a request is extracted.
+// Defaults to RemoteAddr.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(c *config) { c.keyFunc = fn }
+}
+
+// WithRouteKey counts each route separately, by prefixing keys with the
+// method and the route of the request returned by route, e.g.
+// "GET /users/{id}:203.0.113.7". Without it one middleware wrapping several
+// routes shares each key's limit across them. Pass Pattern for routes of
+// an http.ServeMux; routers such as chi have their own.
+func WithRouteKey(route func(r *http.Request) string) Option {
+	return func(c *config) { c.route = route }
+}
+
+// Pattern returns the http.ServeMux pattern that matched r, without the
+// method and host it may include. It is only known to middleware wrapping
+// the handlers registered on the mux, not the mux itself.
+func Pattern(r *http.Request) string {
+	pattern := r.Pattern
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = pattern[i+1:]
+	}
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern
+}
+
+// WithCost sets what a request costs, e.g. more for an export than for a
+// lookup. An error fails the request, see WithOnError. Defaults to 1.
+func WithCost(fn func(r *http.Request) (int64, error)) Option {
+	return func(c *config) { c.cost = fn }
+}
+
+// WithPolicy announces the limits l enforces in a RateLimit-Policy header,
+// e.g. "10;w=1, 1000;w=3600" for a MultiLimiter allowing 10 requests per
+// second and 1000 per hour. Limiters don't know their windows in a common
+// form, so the header is only sent with this option.
+func WithPolicy(limits ...ratelimiter.Limit) Option {
+	return func(c *config) { c.policy = Policy(limits...) }
+}
+
+// WithHeaderStyle selects the rate limit headers of responses.
+// Defaults to HeadersBoth.
+func WithHeaderStyle(style HeaderStyle) Option {
+	return func(c *config) { c.headers = style }
+}
+
+// WithRetryAfterDate sends Retry-After as an HTTP-date rather than in
+// delay-seconds, for clients and caches that expect one.
+func WithRetryAfterDate() Option {
+	return func(c *config) { c.retryDate = true }
+}
+
+// WithOnDenied sets how a denied request is answered, e.g. with an
+// application/problem+json body or a challenge page. The rate limit headers
+// are already set when fn is called; fn writes the status and body. The
+// default answers 429 Too Many Requests in plain text.
+func WithOnDenied(fn func(w http.ResponseWriter, r *http.Request, res ratelimiter.Result)) Option {
+	return func(c *config) { c.onDenied = fn }
+}
+
+// onDenied is the default denial handler, see WithOnDenied.
+func onDenied(w http.ResponseWriter, _ *http.Request, _ ratelimiter.Result) {
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// WithOnError sets how a request is answered when no decision could be
+// made, because the KeyFunc or the limiter failed. The default answers 401
+// Unauthorized for ErrNoKey, 503 Service Unavailable when the store failed
+// and 500 Internal Server Error otherwise. To let requests through while
+// Redis is down, configure the limiter with
+// ratelimiter.WithOnStoreError(ratelimiter.FailOpen) instead.
+func WithOnError(fn func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(c *config) { c.onError = fn }
+}
+
+// onError is the default error handler, see WithOnError.
+func onError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrNoKey) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	slog.ErrorContext(r.Context(), "ratelimiter: no decision for request", "path", r.URL.Path, "error", err)
+	status := http.StatusInternalServerError
+	if errors.Is(err, ratelimiter.ErrStoreUnavailable) {
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, http.StatusText(status), status)
+}
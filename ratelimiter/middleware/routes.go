@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// Route is an entry of the table passed to Routes.
+type Route struct {
+	// Pattern is an http.ServeMux pattern, with an optional method, e.g.
+	// "POST /login" or "GET /users/{id}".
+	Pattern string
+	// Limiter decides the requests matching Pattern.
+	Limiter ratelimiter.Limiter
+	// Policy, if set, is announced in the RateLimit-Policy header, see
+	// WithPolicy.
+	Policy []ratelimiter.Limit
+}
+
+// Routes returns middleware limiting each request with the Limiter of the
+// route of table it matches, so one middleware in front of a whole server
+// enforces e.g. 5 logins and 100 searches a minute:
+//
+//	middleware.Routes([]middleware.Route{
+//		{Pattern: "POST /login", Limiter: logins},
+//		{Pattern: "GET /search", Limiter: searches},
+//	}, middleware.WithKeyFunc(middleware.ClientIP(proxies)))
+//
+// Patterns are matched the way http.ServeMux matches them, the most
+// specific one winning. Keys are prefixed with the pattern, e.g.
+// "POST /login:203.0.113.7", so routes sharing a limiter are still counted
+// separately. Requests matching no route are not limited; add a "/" route
+// to limit them too. opts apply to every route.
+//
+// Routes panics if a pattern is invalid or conflicts with another, like
+// http.ServeMux.Handle.
+func Routes(table []Route, opts ...Option) func(http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range table {
+		// Only matched, the handlers are per next
+		mux.Handle(rt.Pattern, http.NotFoundHandler())
+	}
+	return func(next http.Handler) http.Handler {
+		limited := make(map[string]http.Handler, len(table))
+		for _, rt := range table {
+			routeOpts := append([]Option{}, opts...)
+			routeOpts = append(routeOpts, func(c *config) { c.prefix = rt.Pattern })
+			if len(rt.Policy) > 0 {
+				routeOpts = append(routeOpts, WithPolicy(rt.Policy...))
+			}
+			limited[rt.Pattern] = HTTP(rt.Limiter, routeOpts...)(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The pattern is empty when nothing matched
+			if _, pattern := mux.Handler(r); limited[pattern] != nil {
+				limited[pattern].ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
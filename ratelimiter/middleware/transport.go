@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// Transport is an http.RoundTripper that waits for Limiter before every
+// request, so clients of third-party APIs stay within their published
+// quotas:
+//
+//	client := &http.Client{Transport: &middleware.Transport{
+//		Limiter: ratelimiter.NewGCRA(rdb, ratelimiter.WithRate(10)),
+//	}}
+//
+// With a Redis backed limiter the quota is shared by every process calling
+// the API. A request whose wait would outlast its context, or the
+// limiter's max delay, fails with the limiter's error instead of being
+// sent.
+type Transport struct {
+	// Limiter paces the requests.
+	Limiter ratelimiter.Waiter
+	// Key extracts the key a request is paced by. Defaults to Host, so each
+	// API has its own quota.
+	Key KeyFunc
+	// Base sends the requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := t.key(req)
+	if err == nil {
+		err = t.Limiter.Wait(req.Context(), key)
+	}
+	if err != nil {
+		// RoundTrip must close the body, even when the request isn't sent
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+func (t *Transport) key(req *http.Request) (string, error) {
+	if t.Key == nil {
+		return Host(req)
+	}
+	return t.Key(req)
+}
+
+// Host is the default key of Transport: the host and port of the request
+// URL, e.g. "api.example.com".
+func Host(r *http.Request) (string, error) {
+	return r.URL.Host, nil
+}
@@ -0,0 +1,386 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit allows Requests requests per Window.
+// For token buckets Requests/Window is the sustained rate and Burst the
+// bucket capacity, which defaults to Requests.
+type Limit struct {
+	Requests int64
+	Window   time.Duration
+	Burst    int64
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Window)
+}
+
+// Evaluates every limit of a MultiLimiter, the parent and child buckets
+// of a Hierarchical limiter, or the request and byte buckets of a Bandwidth
+// limiter, in one atomic step.
+// Each limit is a token bucket holding Burst tokens and refilling Requests
+// of them evenly over Window, and the request costs its own number of tokens
+// in each. Tokens are only taken when every bucket has enough,
+// so a request denied by one limit doesn't use up the others.
+var multiLimitScript = newScript(`
+	local now = tonumber(ARGV[1])
+	local max_idle = tonumber(ARGV[2])
+
+	local allowed = 1
+	local tokens = {}
+	for i, key in ipairs(KEYS) do
+		local capacity = tonumber(ARGV[i * 3])
+		local rate = tonumber(ARGV[i * 3 + 1])
+		local cost = tonumber(ARGV[i * 3 + 2])
+		local t = tonumber(redis.call('HGET', key, 'tokens') or capacity)
+		local last = tonumber(redis.call('HGET', key, 'last') or now)
+		t = math.min(capacity, t + (now - last) * rate)
+		if t < cost then
+			allowed = 0
+		end
+		tokens[i] = t
+	end
+
+	local reply = {allowed}
+	for i, key in ipairs(KEYS) do
+		local capacity = tonumber(ARGV[i * 3])
+		local rate = tonumber(ARGV[i * 3 + 1])
+		local cost = tonumber(ARGV[i * 3 + 2])
+		if allowed == 1 then
+			tokens[i] = tokens[i] - cost
+			redis.call('HSET', key, 'tokens', tokens[i], 'last', now)
+			-- Expire once the bucket would have refilled completely
+			local refill = math.ceil((capacity - tokens[i]) / rate * 1000)
+			redis.call('PEXPIRE', key, math.max(refill, max_idle))
+		end
+		-- Floats are truncated when converted to Redis replies, so return tokens as strings
+		reply[i + 1] = tostring(tokens[i])
+	end
+	return reply
+`)
+
+// MultiLimiter combines several limits on the same key, e.g.
+// 10 requests per second AND 1000 requests per hour.
+// All limits are evaluated atomically in a single Lua script and the
+// most restrictive one decides the Result. With WithStore they are taken
+// from in turn, and given back when one of them denies the request.
+type MultiLimiter struct {
+	client redis.UniversalClient
+	limits []Limit
+	config
+}
+
+// NewMultiLimiter returns a limiter enforcing all of limits at once.
+// It panics if limits is empty or one of them is invalid, or on Redis
+// Cluster without WithHashTags.
+func NewMultiLimiter(client redis.UniversalClient, limits []Limit, opts ...Option) *MultiLimiter {
+	if len(limits) == 0 {
+		panic("ratelimiter: MultiLimiter needs at least one limit")
+	}
+	for _, limit := range limits {
+		if err := limit.validate(); err != nil {
+			panic(err)
+		}
+	}
+	cfg := newConfig(opts)
+	cfg.mustBeSlotSafe(client, "MultiLimiter")
+	cfg.preloadScripts(client)
+	return &MultiLimiter{client: client, limits: limits, config: cfg}
+}
+
+// Allow implements Limiter.
+func (l *MultiLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *MultiLimiter) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *MultiLimiter) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+func (l *MultiLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmMulti, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmMulti, key, res), nil
+}
+
+func (l *MultiLimiter) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	now := l.clock.Now()
+	costs := make([]int64, len(l.limits))
+	for i := range costs {
+		costs[i] = n
+	}
+	redisKeys, err := l.bucketKeys(key)
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, tokens, err := takeAll(ctx, l.client, l.store, AlgorithmMulti, key, redisKeys, l.limits, costs, now, l.maxIdle)
+	if err != nil {
+		return Result{}, err
+	}
+	return bucketsResult(allowed, tokens, l.limits, n, now), nil
+}
+
+// bucketKeys returns the Redis keys of the buckets for key, one per limit.
+// They are named after the position of the limit, as limits may share a Window.
+func (l *MultiLimiter) bucketKeys(key string) ([]string, error) {
+	keys := make([]string, len(l.limits))
+	for i := range l.limits {
+		redisKey, err := l.subKey("multi", key, strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = redisKey
+	}
+	return keys, nil
+}
+
+// takeAll takes costs[i] tokens from the bucket at keys[i], which enforces
+// limits[i], if and only if all of them have enough. The buckets are kept in
+// store if it isn't nil.
+// It returns whether the tokens were taken and the tokens left in each bucket.
+func takeAll(ctx context.Context, client redis.UniversalClient, store Store, algorithm, key string, keys []string, limits []Limit, costs []int64, now time.Time, maxIdle time.Duration) (bool, []float64, error) {
+	if store != nil {
+		return takeAllStore(ctx, store, algorithm, key, keys, limits, costs, now, maxIdle)
+	}
+	nowSeconds := float64(now.UnixNano()) / 1e9
+
+	args := []any{nowSeconds, maxIdle.Milliseconds()}
+	for i, limit := range limits {
+		args = append(args, limit.burst(), limit.rate(), costs[i])
+	}
+
+	result, err := multiLimitScript.Run(ctx, client, keys, args...).Slice()
+	if err != nil {
+		return false, nil, storeError(algorithm, key, err)
+	}
+	if len(result) != len(limits)+1 {
+		return false, nil, replyError(algorithm, key, result)
+	}
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return false, nil, replyError(algorithm, key, result)
+	}
+
+	tokens := make([]float64, len(limits))
+	for i := range limits {
+		if tokens[i], err = strconv.ParseFloat(fmt.Sprint(result[i+1]), 64); err != nil {
+			return false, nil, replyError(algorithm, key, result)
+		}
+	}
+	return allowed == 1, tokens, nil
+}
+
+// takeAllStore is takeAll with a Store, which only takes from one bucket
+// atomically: the buckets are taken from in turn, and those taken from are
+// given back when one doesn't have enough. Concurrent requests may then both
+// be denied, but never both be allowed past a limit.
+func takeAllStore(ctx context.Context, store Store, algorithm, key string, keys []string, limits []Limit, costs []int64, now time.Time, maxIdle time.Duration) (bool, []float64, error) {
+	tokens := make([]float64, len(limits))
+	for i, limit := range limits {
+		allowed, left, err := store.TakeTokens(ctx, keys[i], limit.bucket(maxIdle), float64(costs[i]), now)
+		if err != nil {
+			return false, nil, storeError(algorithm, key, err)
+		}
+		if allowed {
+			tokens[i] = left
+			continue
+		}
+
+		// Give back what was taken, and report the tokens before this request
+		tokens[i] = left
+		for j := range i {
+			if _, _, err := store.TakeTokens(ctx, keys[j], limits[j].bucket(maxIdle), -float64(costs[j]), now); err != nil {
+				return false, nil, storeError(algorithm, key, err)
+			}
+			tokens[j] += float64(costs[j])
+		}
+		for j := i + 1; j < len(limits); j++ {
+			if tokens[j], err = store.Tokens(ctx, keys[j], limits[j].bucket(maxIdle), now); err != nil {
+				return false, nil, storeError(algorithm, key, err)
+			}
+		}
+		return false, tokens, nil
+	}
+	return true, tokens, nil
+}
+
+// bucketsResult builds the Result of a request costing n in every bucket.
+// The most restrictive limit decides it.
+func bucketsResult(allowed bool, tokens []float64, limits []Limit, n int64, now time.Time) Result {
+	res := Result{Allowed: allowed}
+	for i, limit := range limits {
+		// The limit with the fewest remaining requests is the most restrictive
+		remaining := max(0, int64(tokens[i]))
+		if i == 0 || remaining < res.Remaining {
+			res.Limit = limit.burst()
+			res.Remaining = remaining
+			res.ResetAt = now.Add(limit.refillTime(float64(limit.burst()) - tokens[i]))
+		}
+		if !res.Allowed && tokens[i] < float64(n) {
+			res.RetryAfter = max(res.RetryAfter, limit.refillTime(float64(n)-tokens[i]))
+		}
+	}
+	return res
+}
+
+// Check reports the state for key without counting a request.
+func (l *MultiLimiter) Check(ctx context.Context, key string) (Result, error) {
+	redisKeys, err := l.bucketKeys(key)
+	if err != nil {
+		return Result{}, err
+	}
+	return checkAll(ctx, l.client, l.store, AlgorithmMulti, key, redisKeys, l.limits, l.clock.Now())
+}
+
+// checkAll reports the state of the buckets at keys like takeAll,
+// without taking tokens.
+func checkAll(ctx context.Context, client redis.UniversalClient, store Store, algorithm, key string, keys []string, limits []Limit, now time.Time) (Result, error) {
+	available := make([]float64, len(keys))
+	if store != nil {
+		for i, limit := range limits {
+			tokens, err := store.Tokens(ctx, keys[i], limit.bucket(0), now)
+			if err != nil {
+				return Result{}, storeError(algorithm, key, err)
+			}
+			available[i] = tokens
+		}
+	} else {
+		pipe := client.Pipeline()
+		states := make([]*redis.SliceCmd, len(keys))
+		for i, bucketKey := range keys {
+			states[i] = pipe.HMGet(ctx, bucketKey, "tokens", "last")
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return Result{}, storeError(algorithm, key, err)
+		}
+		for i, limit := range limits {
+			available[i] = refill(states[i].Val(), float64(limit.burst()), limit.rate(), 0, now)
+		}
+	}
+
+	res := Result{Allowed: true}
+	for i, limit := range limits {
+		tokens := available[i]
+
+		remaining := max(0, int64(tokens))
+		if i == 0 || remaining < res.Remaining {
+			res.Limit = limit.burst()
+			res.Remaining = remaining
+			res.ResetAt = now.Add(limit.refillTime(float64(limit.burst()) - tokens))
+		}
+		if tokens < 1 {
+			res.Allowed = false
+			res.RetryAfter = max(res.RetryAfter, limit.refillTime(1-tokens))
+		}
+	}
+	return res, nil
+}
+
+// Reset clears the buckets of every limit for key.
+func (l *MultiLimiter) Reset(ctx context.Context, key string) error {
+	redisKeys, err := l.bucketKeys(key)
+	if err != nil {
+		return err
+	}
+	if err := deleteBuckets(ctx, l.client, l.store, redisKeys...); err != nil {
+		return storeError(AlgorithmMulti, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+// The state of each limit is reported in State.Limits.
+func (l *MultiLimiter) Inspect(ctx context.Context, key string) (State, error) {
+	st := State{Algorithm: AlgorithmMulti, Key: key}
+	keys, err := l.bucketKeys(key)
+	if err != nil {
+		return State{}, err
+	}
+	for i, limit := range l.limits {
+		ls, err := inspectLimit(ctx, l.client, l.store, keys[i], limit, l.clock.Now())
+		if err != nil {
+			return State{}, storeError(AlgorithmMulti, key, err)
+		}
+		ls.Algorithm = AlgorithmMulti
+		ls.Key = key
+		ls.Window = limit.Window
+		st.Limits = append(st.Limits, ls)
+	}
+	return st, nil
+}
+
+// deleteBuckets deletes the buckets at keys, from store if it isn't nil.
+func deleteBuckets(ctx context.Context, client redis.UniversalClient, store Store, keys ...string) error {
+	if store != nil {
+		return store.Delete(ctx, keys...)
+	}
+	return client.Del(ctx, keys...).Err()
+}
+
+// inspectLimit reads the state of the bucket at redisKey enforcing limit,
+// from store if it isn't nil, which only exposes the tokens available now.
+func inspectLimit(ctx context.Context, client redis.UniversalClient, store Store, redisKey string, limit Limit, now time.Time) (State, error) {
+	if store == nil {
+		return inspectBucket(ctx, client, redisKey, float64(limit.burst()), limit.rate(), 0, now)
+	}
+	tokens, err := store.Tokens(ctx, redisKey, limit.bucket(0), now)
+	if err != nil {
+		return State{}, err
+	}
+	return State{
+		Limit:      limit.burst(),
+		Tokens:     tokens,
+		Available:  tokens,
+		LastRefill: now,
+	}, nil
+}
+
+// bucket returns the token bucket a Store keeps for the limit.
+func (l Limit) bucket(maxIdle time.Duration) Bucket {
+	return Bucket{Capacity: float64(l.burst()), Rate: l.rate(), MaxIdle: maxIdle}
+}
+
+// burst returns the bucket capacity.
+func (l Limit) burst() int64 {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// validate reports whether the limit can be enforced.
+func (l Limit) validate() error {
+	if l.Requests <= 0 || l.Window <= 0 || l.Burst < 0 {
+		return fmt.Errorf("ratelimiter: invalid limit %s", l)
+	}
+	return nil
+}
+
+// rate returns how many requests are refilled per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Window.Seconds()
+}
+
+// refillTime returns how long it takes to refill the given number of requests.
+func (l Limit) refillTime(requests float64) time.Duration {
+	return time.Duration(requests / l.rate() * float64(time.Second))
+}
@@ -0,0 +1,33 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Limits sharing a Window keep a bucket each.
+func TestMultiLimiterSameWindow(t *testing.T) {
+	ctx := context.Background()
+	_, client := newRedis(t)
+	clock := newFakeClock()
+	l := NewMultiLimiter(client, []Limit{
+		{Requests: 10, Window: time.Minute},
+		{Requests: 10, Window: time.Minute, Burst: 2},
+	}, WithClock(clock))
+
+	for i, want := range []bool{true, true, false} {
+		if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed != want {
+			t.Fatalf("Allow %d = %+v, %v, want allowed %v", i, res, err, want)
+		}
+	}
+	st, err := l.Inspect(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{8, 0} {
+		if got := st.Limits[i].Tokens; got != want {
+			t.Errorf("limit %d tokens = %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package netlimiter limits the connections a server accepts per source IP
+// address or subnet, protecting servers below the HTTP layer, e.g. from
+// clients opening connections faster than TLS handshakes can be afforded:
+//
+//	ln, err := net.Listen("tcp", ":443")
+//	...
+//	limiter := ratelimiter.NewTokenBucket(rdb, ratelimiter.WithBurst(20), ratelimiter.WithRate(5))
+//	srv.ServeTLS(netlimiter.New(ln, limiter, netlimiter.WithSubnet(24, 64)), cert, key)
+package netlimiter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// DefaultTimeout bounds each decision of the limiter, see WithTimeout.
+const DefaultTimeout = time.Second
+
+// Listener is a net.Listener accepting connections only as fast as its
+// limiter allows for their source.
+type Listener struct {
+	net.Listener
+	limiter ratelimiter.Limiter
+	cfg     config
+
+	ready     chan net.Conn
+	errs      chan error
+	ctx       context.Context // done once the Listener is closed
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	pending   atomic.Int64 // only counted with WithWait
+}
+
+// New returns ln limited by l, each connection costing one unit of the key
+// of its source, its IP address by default. Connections over the limit are
+// closed as soon as they are accepted, unless WithWait is given. Each
+// connection is decided in its own goroutine, so a slow limiter doesn't
+// hold up the others. A connection no decision could be made for is
+// decided by the policy of WithOnStoreError, accepted by default.
+func New(ln net.Listener, l ratelimiter.Limiter, opts ...Option) *Listener {
+	cfg := config{v4Bits: 32, v6Bits: 128, timeout: DefaultTimeout, onError: ratelimiter.FailOpen}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if _, ok := l.(ratelimiter.Waiter); cfg.wait && !ok {
+		panic("netlimiter: WithWait needs a limiter implementing ratelimiter.Waiter")
+	}
+	nl := &Listener{
+		Listener: ln,
+		limiter:  l,
+		cfg:      cfg,
+		ready:    make(chan net.Conn),
+		errs:     make(chan error),
+	}
+	nl.ctx, nl.cancel = context.WithCancel(context.Background())
+	go nl.acceptLoop()
+	return nl
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.ready:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener. Connections still being decided are
+// closed.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(l.cancel)
+	return l.Listener.Close()
+}
+
+// acceptLoop accepts connections for Accept, each decided in its own
+// goroutine so sources over their limit don't hold up the others.
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.ctx.Done():
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.admit(conn)
+	}
+}
+
+// admit hands conn to Accept once its source is allowed, or rejects it.
+func (l *Listener) admit(conn net.Conn) {
+	var allowed bool
+	if l.cfg.wait {
+		allowed = l.wait(conn)
+	} else {
+		allowed = l.allow(conn)
+	}
+	if !allowed {
+		return
+	}
+	select {
+	case l.ready <- conn:
+	case <-l.ctx.Done():
+		conn.Close()
+	}
+}
+
+// allow reports whether conn is allowed right now, rejecting it if not.
+func (l *Listener) allow(conn net.Conn) bool {
+	key := l.key(conn)
+	ctx, cancel := context.WithTimeout(l.ctx, l.cfg.timeout)
+	defer cancel()
+	res, err := l.limiter.Allow(ctx, key)
+	if err != nil {
+		return l.storeFailed(conn, key, err)
+	}
+	if !res.Allowed {
+		l.reject(conn, res)
+	}
+	return res.Allowed
+}
+
+// wait reports whether conn was allowed after waiting for its turn,
+// rejecting it if not.
+func (l *Listener) wait(conn net.Conn) bool {
+	if l.cfg.maxPending > 0 {
+		defer l.pending.Add(-1)
+		if l.pending.Add(1) > l.cfg.maxPending {
+			l.reject(conn, ratelimiter.Result{})
+			return false
+		}
+	}
+
+	key := l.key(conn)
+	err := l.limiter.(ratelimiter.Waiter).Wait(l.ctx, key)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ratelimiter.ErrStoreUnavailable):
+		return l.storeFailed(conn, key, err)
+	default:
+		// Closed, the wait would exceed the limiter's max delay or the
+		// cost can never fit
+		l.reject(conn, ratelimiter.Result{})
+		return false
+	}
+}
+
+// storeFailed decides conn, whose limiter failed with err, by the
+// WithOnStoreError policy, rejecting it if not allowed.
+func (l *Listener) storeFailed(conn net.Conn, key string, err error) bool {
+	res, err := l.cfg.onError(l.ctx, key, err)
+	if err != nil {
+		l.logFailure(conn, err)
+		res.Allowed = false
+	}
+	if !res.Allowed {
+		l.reject(conn, res)
+	}
+	return res.Allowed
+}
+
+// key returns the key of the source of conn.
+func (l *Listener) key(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		// Not an IP address, e.g. a Unix socket
+		return addr.String()
+	}
+	ip := ap.Addr().Unmap()
+	bits := l.cfg.v6Bits
+	if ip.Is4() {
+		bits = l.cfg.v4Bits
+	}
+	if bits >= ip.BitLen() {
+		return ip.String()
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ip.String()
+	}
+	return prefix.String()
+}
+
+func (l *Listener) reject(conn net.Conn, res ratelimiter.Result) {
+	if l.cfg.onReject != nil {
+		l.cfg.onReject(conn, res)
+	}
+	conn.Close()
+}
+
+// logFailure logs the error no decision could be made for conn with.
+func (l *Listener) logFailure(conn net.Conn, err error) {
+	slog.Error("ratelimiter: no decision for connection", "remote", conn.RemoteAddr().String(), "error", err)
+}
+
+type config struct {
+	v4Bits, v6Bits int
+	wait           bool
+	maxPending     int64
+	timeout        time.Duration
+	onError        ratelimiter.StoreErrorPolicy
+	onReject       func(conn net.Conn, res ratelimiter.Result)
+}
+
+// Option configures a Listener.
+type Option func(*config)
+
+// WithSubnet keys connections by the subnet of their source, the first
+// v4Bits of IPv4 and v6Bits of IPv6 addresses, e.g. 24 and 64, so a client
+// can't escape its limit by hopping addresses of its allocation.
+func WithSubnet(v4Bits, v6Bits int) Option {
+	return func(cfg *config) {
+		cfg.v4Bits = v4Bits
+		cfg.v6Bits = v6Bits
+	}
+}
+
+// WithWait delays connections over the limit until they are allowed
+// rather than closing them, up to the limiter's max delay, which must be
+// set for clients not to wait forever. At most maxPending connections wait
+// at once, if positive; more are closed. The limiter must implement
+// ratelimiter.Waiter.
+func WithWait(maxPending int) Option {
+	return func(cfg *config) {
+		cfg.wait = true
+		cfg.maxPending = int64(maxPending)
+	}
+}
+
+// WithTimeout bounds each decision of the limiter by d, DefaultTimeout by
+// default. A decision timing out is decided by the WithOnStoreError
+// policy. It does not bound the waits of WithWait, which the limiter's max
+// delay does.
+func WithTimeout(d time.Duration) Option {
+	return func(cfg *config) { cfg.timeout = d }
+}
+
+// WithOnStoreError decides connections the limiter failed to decide, e.g.
+// because Redis is unreachable or the decision timed out, with policy
+// rather than ratelimiter.FailOpen. Connections the policy doesn't allow,
+// or fails for, are rejected.
+func WithOnStoreError(policy ratelimiter.StoreErrorPolicy) Option {
+	return func(cfg *config) { cfg.onError = policy }
+}
+
+// WithOnReject calls fn before a connection over the limit is closed, e.g.
+// to write a protocol level error. res is the zero Result when the
+// connection was rejected by WithWait.
+func WithOnReject(fn func(conn net.Conn, res ratelimiter.Result)) Option {
+	return func(cfg *config) { cfg.onReject = fn }
+}
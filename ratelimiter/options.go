@@ -0,0 +1,488 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Default settings used when the corresponding option is not given.
+const (
+	DefaultLimit      = 10
+	DefaultWindow     = time.Minute
+	DefaultCapacity   = 10
+	DefaultRefillRate = 1
+)
+
+// config holds the settings shared by all limiters.
+// Each limiter only reads the fields relevant to its algorithm.
+type config struct {
+	limit     int64
+	window    time.Duration
+	capacity  float64
+	rate      float64
+	keyPrefix string
+	keyFunc   KeyFunc
+	ttl       TTLPolicy
+	maxIdle   time.Duration
+	clock     Clock
+	limits    LimitProvider
+	dryRun    bool
+	onDecide  DecisionHook
+	onError   StoreErrorPolicy
+	maxDelay  time.Duration
+	precision int
+	lease     time.Duration
+	jitter    bool
+	maxLog    int64
+	debt      float64
+	loc       *time.Location
+	shaping   bool
+	interval  time.Duration
+	soft      float64
+	curve     func(x float64) float64
+	noScripts bool
+	hashTags  bool
+	store     Store
+	preload   bool
+}
+
+// DecisionHook is called with every decision a limiter makes, before dry-run
+// mode is applied, e.g. to record metrics or logs.
+type DecisionHook func(ctx context.Context, algorithm, key string, res Result)
+
+// KeyFunc builds the Redis key for a limiter.
+// kind identifies the algorithm's data ("fixed", "log", "counter", "bucket",
+// "multi", "leaky", "gcra", "sliding", "concurrency", "adaptive", "hier", "priority", "quota", "bw", "ewma", "penalty", "aconc", "idem", "chain", "limits" or "warmup")
+// and key is the caller supplied key, e.g. a user ID.
+type KeyFunc func(kind, key string) string
+
+func newConfig(opts []Option) config {
+	c := config{
+		limit:     DefaultLimit,
+		window:    DefaultWindow,
+		capacity:  DefaultCapacity,
+		rate:      DefaultRefillRate,
+		precision: DefaultPrecision,
+		lease:     DefaultLeaseTimeout,
+		clock:     SystemClock,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// key builds the Redis key for an algorithm, e.g. "api:bucket:user:123".
+// With WithHashTags it fails for keys containing braces, which would end
+// the hash tag early or leave it empty.
+func (c *config) key(kind, key string) (string, error) {
+	if c.keyFunc != nil {
+		return c.keyFunc(kind, key), nil
+	}
+	if c.hashTags {
+		if strings.ContainsAny(key, "{}") {
+			return "", fmt.Errorf("%w: %q contains braces, which WithHashTags does not allow", ErrInvalidKey, key)
+		}
+		key = "{" + key + "}"
+	}
+	if c.keyPrefix == "" {
+		return kind + ":" + key, nil
+	}
+	return c.keyPrefix + ":" + kind + ":" + key, nil
+}
+
+// subKey builds the Redis key of one of several pieces of data an algorithm
+// stores for key, e.g. "api:multi:user:123:0". With WithHashTags only key
+// is hashed, "api:multi:{user:123}:0", so all pieces share a cluster slot.
+func (c *config) subKey(kind, key, suffix string) (string, error) {
+	if c.keyFunc != nil || !c.hashTags {
+		return c.key(kind, key+":"+suffix)
+	}
+	redisKey, err := c.key(kind, key)
+	if err != nil {
+		return "", err
+	}
+	return redisKey + ":" + suffix, nil
+}
+
+// forKey returns the config to use for key.
+// With a LimitProvider, the limit it returns replaces the configured one:
+// Requests per Window for window based limiters, and a bucket of Requests
+// tokens refilling over Window for TokenBucket.
+func (c config) forKey(ctx context.Context, key string) (config, error) {
+	if c.limits == nil {
+		return c, nil
+	}
+	limit, err := c.limits.Limits(ctx, key)
+	if err != nil {
+		return c, err
+	}
+	if err := limit.validate(); err != nil {
+		return c, err
+	}
+	c.limit = limit.Requests
+	c.window = limit.Window
+	c.capacity = float64(limit.burst())
+	c.rate = limit.rate()
+	return c, nil
+}
+
+// mustValidateWindow panics if the window based settings are unusable.
+// Like time.NewTicker, constructors treat invalid settings as programming errors.
+func (c config) mustValidateWindow() {
+	if c.limit <= 0 {
+		panic(fmt.Sprintf("ratelimiter: limit must be positive, got %d", c.limit))
+	}
+	if c.window <= 0 {
+		panic(fmt.Sprintf("ratelimiter: window must be positive, got %s", c.window))
+	}
+	if c.noScripts && c.maxLog > 0 {
+		panic("ratelimiter: WithMaxEntries requires Lua scripts and cannot be used with WithoutScripts")
+	}
+}
+
+// mustBeSlotSafe panics if client spreads keys over shards (Redis Cluster
+// or Ring) and the keys a limiter updates together in one script could
+// land on different shards, which Redis would reject on every request.
+func (c config) mustBeSlotSafe(client redis.UniversalClient, limiter string) {
+	if sharded(client) && !c.hashTags && c.keyFunc == nil && c.store == nil {
+		panic("ratelimiter: " + limiter + " updates several keys at once and needs WithHashTags on Redis Cluster")
+	}
+}
+
+// sharded reports whether client spreads keys over shards (Redis Cluster or Ring).
+func sharded(client redis.UniversalClient) bool {
+	switch client.(type) {
+	case *redis.ClusterClient, *redis.Ring:
+		return true
+	}
+	return false
+}
+
+// mustValidateBucket panics if the token bucket settings are unusable.
+func (c config) mustValidateBucket() {
+	if !(c.rate > 0) || math.IsInf(c.rate, 0) {
+		panic(fmt.Sprintf("ratelimiter: rate must be positive and finite, got %v", c.rate))
+	}
+	if !(c.capacity >= 1) || math.IsInf(c.capacity, 0) {
+		panic(fmt.Sprintf("ratelimiter: burst must be at least 1, got %v", c.capacity))
+	}
+	if !(c.debt >= 0) || math.IsInf(c.debt, 0) {
+		panic(fmt.Sprintf("ratelimiter: debt must be non-negative and finite, got %v", c.debt))
+	}
+}
+
+// decide applies soft limiting, reports res to the decision hook and
+// applies dry-run mode.
+func (c *config) decide(ctx context.Context, algorithm, key string, res Result) Result {
+	if c.soft > 0 && res.Allowed && res.Limit > 0 {
+		res = c.shed(res)
+	}
+	if c.onDecide != nil {
+		c.onDecide(ctx, algorithm, key, res)
+	}
+	if c.dryRun && !res.Allowed {
+		slog.InfoContext(ctx, "ratelimiter: dry run, request would be denied",
+			"algorithm", algorithm, "key", key, "limit", res.Limit, "retry_after", res.RetryAfter)
+		res.Allowed = true
+		res.RetryAfter = 0
+	}
+	return res
+}
+
+// shed rejects an allowed request with a probability given by the soft limit
+// curve, based on how far the usage is between the soft and the hard limit.
+func (c *config) shed(res Result) Result {
+	usage := float64(res.Limit-res.Remaining) / float64(res.Limit)
+	if usage <= c.soft {
+		return res
+	}
+	x := min(1, (usage-c.soft)/(1-c.soft))
+	p := x
+	if c.curve != nil {
+		p = c.curve(x)
+	}
+	if rand.Float64() < p {
+		res.Allowed = false
+		// Usage recovers towards zero by ResetAt; estimate when it is back
+		// at the soft limit, assuming it recovers evenly
+		if now := c.clock.Now(); res.ResetAt.After(now) {
+			res.RetryAfter = time.Duration(float64(res.ResetAt.Sub(now)) * (usage - c.soft) / usage)
+		}
+	}
+	return res
+}
+
+// storeFailed applies the StoreErrorPolicy to an error from Allow.
+func (c *config) storeFailed(ctx context.Context, key string, err error) (Result, error) {
+	if c.onError == nil || errors.Is(err, ErrInvalidKey) {
+		return Result{}, err
+	}
+	return c.onError(ctx, key, err)
+}
+
+// Option configures a limiter.
+type Option func(*config)
+
+// WithLimit sets the number of requests allowed per window.
+// Used by FixedWindow, SlidingLog, SlidingCounter, SlidingBuckets and EWMA,
+// by Concurrency as the number of requests in flight, and by
+// AdaptiveConcurrency as the initial number.
+func WithLimit(limit int64) Option {
+	return func(c *config) { c.limit = limit }
+}
+
+// WithWindow sets the length of the time window.
+// Used by FixedWindow, SlidingLog, SlidingCounter and SlidingBuckets,
+// and by EWMA as the smoothing time constant.
+func WithWindow(window time.Duration) Option {
+	return func(c *config) { c.window = window }
+}
+
+// WithPrecision sets the number of sub-windows the window is split into.
+// Higher precision is more accurate but stores more fields per key.
+// Used by SlidingBuckets.
+func WithPrecision(subWindows int) Option {
+	return func(c *config) { c.precision = subWindows }
+}
+
+// WithLeaseTimeout sets how long a slot stays held without a heartbeat
+// before it is reclaimed from a crashed holder. Used by Concurrency and
+// AdaptiveConcurrency.
+func WithLeaseTimeout(d time.Duration) Option {
+	return func(c *config) { c.lease = d }
+}
+
+// WithJitter offsets each key's window boundaries by a deterministic,
+// hash-based amount, so keys don't all reset at the same moment and cause
+// a synchronized burst. Used by FixedWindow.
+func WithJitter() Option {
+	return func(c *config) { c.jitter = true }
+}
+
+// WithMaxEntries bounds the sorted set of a sliding log to n entries per key.
+// Beyond that the oldest entries are collapsed into one weighted entry, so
+// high-rate keys use bounded memory; those requests may count slightly
+// longer than the window. Used by SlidingLog.
+func WithMaxEntries(n int64) Option {
+	return func(c *config) { c.maxLog = n }
+}
+
+// WithScriptPreload makes the constructor load every Lua script into the
+// script cache of the client, see LoadScripts, so that not even the first
+// request pays for a NOSCRIPT reply. The constructor then blocks for up to
+// 5s, once per client. A failure to load is logged rather than fatal, since
+// limiters send a missing script's source anyway.
+func WithScriptPreload() Option {
+	return func(c *config) { c.preload = true }
+}
+
+// WithoutScripts makes a limiter use WATCH/MULTI/EXEC transactions with
+// optimistic retries instead of Lua scripts, for managed Redis offerings
+// that block EVAL. Decisions stay exact but cost more round trips, and
+// under heavy contention on one key a request may fail after too many
+// conflicting retries. Used by FixedWindow, SlidingLog (without
+// WithMaxEntries), SlidingCounter and TokenBucket; AllowMany falls back to
+// one transaction per key.
+func WithoutScripts() Option {
+	return func(c *config) { c.noScripts = true }
+}
+
+// WithServer adapts a limiter to the server found by DetectServer: on one
+// that doesn't run Lua scripts it is WithoutScripts, and otherwise it
+// changes nothing, as the scripts run alike on Redis, Valkey, Dragonfly and
+// KeyDB.
+func WithServer(srv Server) Option {
+	return func(c *config) {
+		if !srv.Scripting {
+			c.noScripts = true
+		}
+	}
+}
+
+// WithStore keeps the limiter state in s instead of Redis, e.g. to run
+// without a Redis server or against another backend; the Redis client passed
+// to the constructor is not used and may be nil. Used by every limiter;
+// AllowMany falls back to one Store operation per key.
+// TokenBucket ignores TTLPolicy with a Store, which keeps idle buckets for
+// WithMaxIdle once they have refilled.
+func WithStore(s Store) Option {
+	return func(c *config) { c.store = s }
+}
+
+// WithLocation sets the time zone calendar periods are aligned to.
+// Defaults to UTC. Used by Quota.
+func WithLocation(loc *time.Location) Option {
+	return func(c *config) { c.loc = loc }
+}
+
+// WithShaping makes Wait queue requests and delay each one until its turn,
+// smoothing traffic to the leak rate instead of waiting for room and then
+// letting a burst through. Used by LeakyBucket.
+func WithShaping() Option {
+	return func(c *config) { c.shaping = true }
+}
+
+// WithRate sets the sustained rate in requests (tokens) per second.
+// Used by TokenBucket.
+func WithRate(perSecond float64) Option {
+	return func(c *config) { c.rate = perSecond }
+}
+
+// WithBurst sets how many requests may be made at once after the bucket has
+// been idle, i.e. the bucket capacity. "2 req/s sustained, bursts of 20" is
+// WithRate(2), WithBurst(20). Used by TokenBucket.
+func WithBurst(burst int64) Option {
+	return func(c *config) { c.capacity = float64(burst) }
+}
+
+// WithDebt lets requests borrow up to debt tokens beyond an empty bucket.
+// Occasional bursts slightly above capacity are allowed and paid back by a
+// longer refill, for interactive workloads where a hard rejection is worse
+// than brief over-admission. Used by TokenBucket.
+func WithDebt(debt float64) Option {
+	return func(c *config) { c.debt = debt }
+}
+
+// WithRefillInterval refills the bucket in discrete steps, adding
+// rate*interval tokens at the end of every interval instead of continuously,
+// e.g. WithRate(1), WithRefillInterval(10*time.Second) adds 10 tokens every
+// 10 seconds, as some quota contracts are expressed. Used by TokenBucket.
+func WithRefillInterval(interval time.Duration) Option {
+	return func(c *config) { c.interval = interval }
+}
+
+// WithCapacity sets the maximum number of tokens in the bucket.
+// It is the same as WithBurst but accepts fractional capacities.
+// Used by TokenBucket.
+func WithCapacity(capacity float64) Option {
+	return func(c *config) { c.capacity = capacity }
+}
+
+// WithRefillRate sets how many tokens are added to the bucket per second.
+// It is the same as WithRate. Used by TokenBucket.
+func WithRefillRate(rate float64) Option {
+	return func(c *config) { c.rate = rate }
+}
+
+// Every converts a minimum time between requests to a rate for WithRate,
+// e.g. Every(100*time.Millisecond) is 10 requests per second.
+func Every(interval time.Duration) float64 {
+	if interval <= 0 {
+		return math.Inf(1)
+	}
+	return 1 / interval.Seconds()
+}
+
+// WithKeyPrefix prepends prefix to every Redis key the limiter uses.
+func WithKeyPrefix(prefix string) Option {
+	return func(c *config) { c.keyPrefix = prefix }
+}
+
+// WithNamespace prefixes every Redis key with the given parts joined by ":",
+// e.g. WithNamespace("billing", "prod", tenantID), so several applications
+// or tenants can share a Redis instance without key collisions.
+func WithNamespace(parts ...string) Option {
+	return func(c *config) { c.keyPrefix = strings.Join(parts, ":") }
+}
+
+// WithHashTags wraps the caller supplied key in a Redis Cluster hash tag,
+// e.g. "api:bucket:{user:123}", so every Redis key a limiter uses for it
+// lands in the same slot. Limiters that update several keys in one Lua
+// script (SlidingCounter, MultiLimiter, Hierarchical, Bandwidth and
+// AdaptiveConcurrency) need it on Redis Cluster and Ring, and their
+// constructors panic without it.
+// Keys must not contain braces themselves; requests for such keys fail
+// with ErrInvalidKey. It changes the key layout, so existing state is not
+// found after enabling it.
+func WithHashTags() Option {
+	return func(c *config) { c.hashTags = true }
+}
+
+// WithKeyFunc replaces the default key layout entirely.
+// It takes precedence over WithKeyPrefix, WithNamespace and WithHashTags,
+// and must keep the keys of one caller key in the same cluster slot itself.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(c *config) { c.keyFunc = fn }
+}
+
+// WithLimitProvider looks up the limit for each key with p instead of
+// using the static limit options. Not used by MultiLimiter.
+func WithLimitProvider(p LimitProvider) Option {
+	return func(c *config) { c.limits = p }
+}
+
+// WithDryRun evaluates and records every decision as usual, counters included,
+// but always allows the request. Would-be denials are logged with slog and
+// passed to the DecisionHook, so a new limit can be observed before enforcing it.
+func WithDryRun() Option {
+	return func(c *config) { c.dryRun = true }
+}
+
+// WithDecisionHook calls hook with every decision.
+func WithDecisionHook(hook DecisionHook) Option {
+	return func(c *config) { c.onDecide = hook }
+}
+
+// WithMaxIdle keeps a key's state in Redis for at least d after its last request.
+// By default keys are reclaimed as soon as their state no longer matters:
+// after the window for window based limiters and once the bucket has refilled
+// (capacity/rate) for token buckets. Shorter values are raised to that minimum.
+// FixedWindow ignores it because its key expiry marks the end of the window.
+func WithMaxIdle(d time.Duration) Option {
+	return func(c *config) { c.maxIdle = d }
+}
+
+// idleTTL returns the TTL for a key that must live at least min.
+func (c *config) idleTTL(min time.Duration) time.Duration {
+	return max(min, c.maxIdle)
+}
+
+// WithSoftLimit rejects requests with increasing probability once usage
+// exceeds the fraction soft of the limit (e.g. 0.8), instead of allowing
+// everything up to the hard limit and then nothing, to degrade gracefully
+// under load. curve maps the position between the soft and the hard limit
+// (0 to 1) to a rejection probability (0 to 1); nil means linear.
+// Requests shed this way are still counted, and their RetryAfter estimates
+// when usage is back at the soft limit.
+func WithSoftLimit(soft float64, curve func(x float64) float64) Option {
+	return func(c *config) {
+		c.soft = soft
+		c.curve = curve
+	}
+}
+
+// WithOnStoreError sets what Allow returns when no decision could be made,
+// e.g. because Redis is unreachable. By default the error is returned.
+// Use FailOpen for availability, FailClosed for strictness, or a custom policy.
+// The policy also covers the few seconds a Sentinel or Cluster failover takes,
+// once the client's own retries are exhausted.
+func WithOnStoreError(policy StoreErrorPolicy) Option {
+	return func(c *config) { c.onError = policy }
+}
+
+// WithMaxDelay makes Wait return ErrWouldExceedDeadline immediately instead
+// of blocking when the total wait would exceed d.
+func WithMaxDelay(d time.Duration) Option {
+	return func(c *config) { c.maxDelay = d }
+}
+
+// WithClock makes the limiter read time from clock instead of the system clock,
+// so tests and simulations can control time.
+func WithClock(clock Clock) Option {
+	return func(c *config) { c.clock = clock }
+}
+
+// WithTTLPolicy scales token bucket TTLs with traffic instead of WithMaxIdle.
+// Used by TokenBucket.
+func WithTTLPolicy(policy TTLPolicy) Option {
+	return func(c *config) { c.ttl = policy }
+}
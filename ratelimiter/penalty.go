@@ -0,0 +1,272 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PenaltyPolicy puts keys that keep hammering after being throttled into a
+// cooldown in which all their requests are rejected. Each cooldown lasts
+// Multiplier times longer than the previous one, up to MaxCooldown.
+type PenaltyPolicy struct {
+	// Denials consecutive denials within Within trigger a cooldown.
+	Denials int64
+	Within  time.Duration
+	// Cooldown is the length of the first cooldown.
+	Cooldown time.Duration
+	// MaxCooldown caps the escalation. A key that stays out of trouble for
+	// MaxCooldown after its last cooldown starts over at Cooldown.
+	MaxCooldown time.Duration
+	// Multiplier scales each further cooldown, e.g. 2 doubles it. Defaults to 2.
+	Multiplier float64
+}
+
+func (p PenaltyPolicy) validate() error {
+	if p.Denials <= 0 || p.Within <= 0 || p.Cooldown <= 0 || p.MaxCooldown < p.Cooldown {
+		return fmt.Errorf("ratelimiter: invalid penalty policy %+v", p)
+	}
+	if p.Multiplier != 0 && !(p.Multiplier >= 1) {
+		return fmt.Errorf("ratelimiter: penalty multiplier must be at least 1, got %v", p.Multiplier)
+	}
+	return nil
+}
+
+// Records a denial and starts an escalating cooldown once there were
+// enough of them in a row. Returns the end of the cooldown in ms, or 0.
+var penaltyScript = newScript(`
+	local key = KEYS[1]
+	local now = tonumber(ARGV[1])
+	local threshold = tonumber(ARGV[2])
+	local within = tonumber(ARGV[3])
+	local cooldown = tonumber(ARGV[4])
+	local max_cooldown = tonumber(ARGV[5])
+	local multiplier = tonumber(ARGV[6])
+
+	local denials = tonumber(redis.call('HGET', key, 'denials') or 0)
+	local first = tonumber(redis.call('HGET', key, 'first') or now)
+	local level = tonumber(redis.call('HGET', key, 'level') or 0)
+
+	-- Denials spread out over more than within don't count as hammering
+	if now - first > within then
+		denials = 0
+		first = now
+	end
+	denials = denials + 1
+
+	local cooldown_until = 0
+	if denials >= threshold then
+		level = level + 1
+		local length = math.min(max_cooldown, cooldown * multiplier ^ (level - 1))
+		cooldown_until = now + length
+		denials = 0
+		first = now
+		redis.call('HSET', key, 'until', cooldown_until)
+	end
+
+	redis.call('HSET', key, 'denials', denials, 'first', first, 'level', level)
+	-- Forget the escalation level after a quiet period of max_cooldown
+	redis.call('PEXPIRE', key, math.max(within, cooldown_until - now) + max_cooldown)
+	return cooldown_until
+`)
+
+// Penalized wraps a Limiter with a PenaltyPolicy, to discourage clients
+// that keep sending requests after being throttled. Requests during a
+// cooldown are rejected without consulting the wrapped limiter, with
+// RetryAfter set to the end of the cooldown.
+type Penalized struct {
+	limiter Limiter
+	client  redis.UniversalClient
+	policy  PenaltyPolicy
+	config
+}
+
+// NewPenalized returns l with policy applied. Only the key, clock and store
+// options are used.
+// It panics if the policy is invalid.
+func NewPenalized(client redis.UniversalClient, l Limiter, policy PenaltyPolicy, opts ...Option) *Penalized {
+	if err := policy.validate(); err != nil {
+		panic(err)
+	}
+	if policy.Multiplier == 0 {
+		policy.Multiplier = 2
+	}
+	cfg := newConfig(opts)
+	cfg.preloadScripts(client)
+	return &Penalized{limiter: l, client: client, policy: policy, config: cfg}
+}
+
+// Allow implements Limiter.
+func (l *Penalized) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN implements Limiter.
+func (l *Penalized) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmPenalty, key, n); err != nil {
+		return Result{}, err
+	}
+	redisKey, err := l.key("penalty", key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	state, err := l.state(ctx, redisKey)
+	if err != nil {
+		return l.storeFailed(ctx, key, storeError(AlgorithmPenalty, key, err))
+	}
+	if res, ok := l.cooldown(state, now); ok {
+		return l.decide(ctx, AlgorithmPenalty, key, res), nil
+	}
+
+	res, err := l.limiter.AllowN(ctx, key, n)
+	if err != nil {
+		return res, err
+	}
+	if res.Allowed {
+		// A successful request ends a streak of denials
+		if state.Denials > 0 {
+			l.clearDenials(ctx, redisKey)
+		}
+		return res, nil
+	}
+
+	until, err := l.penalize(ctx, redisKey, now)
+	if err != nil {
+		// The wrapped limiter already decided; failing to record the denial
+		// only delays the penalty
+		return res, nil
+	}
+	if until > 0 {
+		res.RetryAfter = max(res.RetryAfter, time.UnixMilli(until).Sub(now))
+	}
+	return res, nil
+}
+
+// penaltyState is the penalty record of a key, in unix ms: the end of its
+// last cooldown, the denials of the current streak and when it started,
+// and the number of cooldowns so far. Redis keeps it in a hash.
+type penaltyState struct {
+	Until   int64 `json:"until"`
+	Denials int64 `json:"denials"`
+	First   int64 `json:"first"`
+	Level   int64 `json:"level"`
+}
+
+// state reads the end of the last cooldown and the current denials of the
+// key at redisKey. Missing or malformed fields are zero.
+func (l *Penalized) state(ctx context.Context, redisKey string) (penaltyState, error) {
+	var st penaltyState
+	if l.store != nil {
+		err := updateState(ctx, l.store, redisKey, func(stored *penaltyState, _ time.Duration) (bool, time.Duration) {
+			st = *stored
+			return false, 0
+		})
+		return st, err
+	}
+	fields, err := l.client.HMGet(ctx, redisKey, "until", "denials").Result()
+	if err != nil {
+		return st, err
+	}
+	st.Until, _ = strconv.ParseInt(fmt.Sprint(fields[0]), 10, 64)
+	st.Denials, _ = strconv.ParseInt(fmt.Sprint(fields[1]), 10, 64)
+	return st, nil
+}
+
+// clearDenials ends the streak of denials of the key at redisKey. Failing
+// to clear it only makes a cooldown come sooner, so errors are ignored.
+func (l *Penalized) clearDenials(ctx context.Context, redisKey string) {
+	if l.store == nil {
+		l.client.HSet(ctx, redisKey, "denials", 0)
+		return
+	}
+	updateState(ctx, l.store, redisKey, func(st *penaltyState, expiresIn time.Duration) (bool, time.Duration) {
+		if st.Denials == 0 || expiresIn <= 0 {
+			return false, 0
+		}
+		st.Denials = 0
+		return true, expiresIn
+	})
+}
+
+// penalize records a denial at now for the key at redisKey and returns the
+// end of the cooldown it started, or 0 if it started none.
+func (l *Penalized) penalize(ctx context.Context, redisKey string, now time.Time) (int64, error) {
+	p := l.policy
+	if l.store == nil {
+		return penaltyScript.Run(ctx, l.client, []string{redisKey}, now.UnixMilli(),
+			p.Denials, p.Within.Milliseconds(), p.Cooldown.Milliseconds(),
+			p.MaxCooldown.Milliseconds(), p.Multiplier).Int64()
+	}
+	// The same steps as penaltyScript
+	ms := now.UnixMilli()
+	var until int64
+	err := updateState(ctx, l.store, redisKey, func(st *penaltyState, _ time.Duration) (bool, time.Duration) {
+		until = 0
+		if st.First == 0 || ms-st.First > p.Within.Milliseconds() {
+			st.Denials, st.First = 0, ms
+		}
+		st.Denials++
+		if st.Denials >= p.Denials {
+			st.Level++
+			length := min(float64(p.MaxCooldown.Milliseconds()),
+				float64(p.Cooldown.Milliseconds())*math.Pow(p.Multiplier, float64(st.Level-1)))
+			until = ms + int64(length)
+			st.Until, st.Denials, st.First = until, 0, ms
+		}
+		return true, max(p.Within, time.Duration(until-ms)*time.Millisecond) + p.MaxCooldown
+	})
+	return until, err
+}
+
+// cooldown returns the Result for a key in cooldown at now, if it is in one.
+func (l *Penalized) cooldown(state penaltyState, now time.Time) (Result, bool) {
+	if state.Until == 0 {
+		return Result{}, false
+	}
+	until := time.UnixMilli(state.Until)
+	if !now.Before(until) {
+		return Result{}, false
+	}
+	// The wrapped limiter isn't consulted, so Limit and Remaining are unknown
+	return Result{ResetAt: until, RetryAfter: until.Sub(now)}, true
+}
+
+// Check reports the state for key without counting a request.
+// A key in cooldown is reported as denied until the cooldown ends.
+func (l *Penalized) Check(ctx context.Context, key string) (Result, error) {
+	redisKey, err := l.key("penalty", key)
+	if err != nil {
+		return Result{}, err
+	}
+	state, err := l.state(ctx, redisKey)
+	if err != nil {
+		return Result{}, storeError(AlgorithmPenalty, key, err)
+	}
+	if res, ok := l.cooldown(state, l.clock.Now()); ok {
+		return res, nil
+	}
+	return l.limiter.Check(ctx, key)
+}
+
+// Reset lifts any cooldown and escalation for key and resets the wrapped limiter.
+func (l *Penalized) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("penalty", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		err = l.store.Delete(ctx, redisKey)
+	} else {
+		err = l.client.Del(ctx, redisKey).Err()
+	}
+	if err != nil {
+		return storeError(AlgorithmPenalty, key, err)
+	}
+	return l.limiter.Reset(ctx, key)
+}
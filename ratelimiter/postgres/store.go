@@ -0,0 +1,266 @@
+// Package postgres implements a ratelimiter.Store on PostgreSQL, for teams
+// whose only shared datastore is their relational database.
+//
+// State lives in one small table (see CreateTable). Counters are updated
+// with a single INSERT ... ON CONFLICT DO UPDATE, and token buckets and the
+// state of Update are read and written in a transaction holding a per-key
+// advisory lock, so concurrent processes never lose each other's updates.
+// Every limiter that accepts ratelimiter.WithStore runs on it.
+//
+// The store uses database/sql, so any PostgreSQL driver works, e.g.
+// github.com/jackc/pgx/v5/stdlib or github.com/lib/pq.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// lockClass is the first key of the advisory locks taken on keys, so they
+// don't collide with advisory locks the application takes itself.
+const lockClass = 0x726c // "rl"
+
+// Store is a ratelimiter.Store keeping limiter state in a PostgreSQL table.
+type Store struct {
+	db    *sql.DB
+	table string
+	clock ratelimiter.Clock
+}
+
+var _ ratelimiter.Store = (*Store)(nil)
+
+// New returns a Store keeping limiter state in table, e.g. "ratelimiter"
+// or "app.ratelimiter". The table is created by CreateTable.
+func New(db *sql.DB, table string) *Store {
+	return &Store{db: db, table: quoteIdent(table), clock: ratelimiter.SystemClock}
+}
+
+// SetClock replaces the clock counters expire by. Pass the clock given to
+// the limiters with WithClock, so their windows end at the same time.
+func (s *Store) SetClock(clock ratelimiter.Clock) {
+	s.clock = clock
+}
+
+// CreateTable creates the table of the store if it doesn't exist, and adds
+// the columns of later versions to a table created by an earlier one.
+// Times are stored as integers read from the limiters' clock rather than the
+// database's, so both agree on when windows end.
+func (s *Store) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		key     text PRIMARY KEY,
+		count   bigint NOT NULL DEFAULT 0,
+		tokens  double precision NOT NULL DEFAULT 0,
+		last    bigint NOT NULL DEFAULT 0, -- unix ns, when the bucket was last refilled
+		state   bytea,                     -- stored with Update
+		expires bigint NOT NULL            -- unix ms
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `ALTER TABLE `+s.table+` ADD COLUMN IF NOT EXISTS state bytea`)
+	return err
+}
+
+// Cleanup deletes expired state and returns how many keys it deleted.
+// Expired rows are ignored anyway; call it periodically, e.g. from a cron
+// job, so keys that are never seen again don't accumulate.
+func (s *Store) Cleanup(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires <= $1`, s.clock.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Increment implements ratelimiter.Store.
+func (s *Store) Increment(ctx context.Context, key string, n int64, ttl time.Duration) (int64, time.Duration, error) {
+	now := s.clock.Now()
+	var count, expires int64
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO `+s.table+` AS t (key, count, expires) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			count   = CASE WHEN t.expires > $4 THEN t.count + EXCLUDED.count ELSE EXCLUDED.count END,
+			expires = CASE WHEN t.expires > $4 THEN t.expires ELSE EXCLUDED.expires END
+		RETURNING count, expires`,
+		key, n, now.Add(ttl).UnixMilli(), now.UnixMilli(),
+	).Scan(&count, &expires)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, time.UnixMilli(expires).Sub(now), nil
+}
+
+// Counter implements ratelimiter.Store.
+func (s *Store) Counter(ctx context.Context, key string) (int64, time.Duration, error) {
+	now := s.clock.Now()
+	var count, expires int64
+	err := s.db.QueryRowContext(ctx, `SELECT count, expires FROM `+s.table+` WHERE key = $1 AND expires > $2`,
+		key, now.UnixMilli()).Scan(&count, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, time.UnixMilli(expires).Sub(now), nil
+}
+
+// TakeTokens implements ratelimiter.Store.
+func (s *Store) TakeTokens(ctx context.Context, key string, b ratelimiter.Bucket, cost float64, now time.Time) (allowed bool, tokens float64, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = lock(ctx, tx, key); err != nil {
+		return false, 0, err
+	}
+	stored, last, ok, err := s.bucket(ctx, tx, key)
+	if err != nil {
+		return false, 0, err
+	}
+	if !ok {
+		// A bucket that doesn't exist is full
+		stored, last = b.Capacity, now
+	}
+	allowed, tokens, last = b.Take(stored, last, cost, now)
+	if !allowed {
+		return false, tokens, tx.Commit()
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO `+s.table+` (key, tokens, last, expires) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = EXCLUDED.tokens, last = EXCLUDED.last, expires = EXCLUDED.expires`,
+		key, tokens, last.UnixNano(), now.Add(b.TTL(tokens)).UnixMilli())
+	if err != nil {
+		return false, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, 0, err
+	}
+	return true, tokens, nil
+}
+
+// Tokens implements ratelimiter.Store.
+func (s *Store) Tokens(ctx context.Context, key string, b ratelimiter.Bucket, now time.Time) (float64, error) {
+	tokens, last, ok, err := s.bucket(ctx, s.db, key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return b.Capacity, nil
+	}
+	return b.Tokens(tokens, last, now), nil
+}
+
+// Update implements ratelimiter.Store.
+func (s *Store) Update(ctx context.Context, key string, fn func(state []byte, expiresIn time.Duration) ([]byte, time.Duration)) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = lock(ctx, tx, key); err != nil {
+		return err
+	}
+	now := s.clock.Now()
+	var state []byte
+	var expires int64
+	err = tx.QueryRowContext(ctx, `SELECT state, expires FROM `+s.table+` WHERE key = $1 AND expires > $2`,
+		key, now.UnixMilli()).Scan(&state, &expires)
+	left := time.UnixMilli(expires).Sub(now)
+	if errors.Is(err, sql.ErrNoRows) {
+		state, left, err = nil, 0, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	next, ttl := fn(state, left)
+	if next == nil {
+		return tx.Commit()
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO `+s.table+` (key, state, expires) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET state = EXCLUDED.state, expires = EXCLUDED.expires`,
+		key, next, now.Add(ttl).UnixMilli())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete implements ratelimiter.Store.
+func (s *Store) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(keys))
+	args := make([]any, len(keys))
+	for i, key := range keys {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = key
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE key IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	return err
+}
+
+// lock takes the advisory lock of key for the rest of tx. It serializes
+// updates of the state at key, including its creation, which a row lock
+// can't cover.
+func lock(ctx context.Context, tx *sql.Tx, key string) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`, lockClass, key)
+	return err
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// bucket reads the stored tokens and last refill of the bucket at key.
+// It reports false for a missing, expired or unusable bucket, which starts
+// over full like in the Lua scripts.
+func (s *Store) bucket(ctx context.Context, q querier, key string) (float64, time.Time, bool, error) {
+	var tokens float64
+	var last int64
+	err := q.QueryRowContext(ctx, `SELECT tokens, last FROM `+s.table+` WHERE key = $1 AND expires > $2`,
+		key, s.clock.Now().UnixMilli()).Scan(&tokens, &last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, false, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+	if math.IsNaN(tokens) || math.IsInf(tokens, 0) {
+		return 0, time.Time{}, false, nil
+	}
+	return tokens, time.Unix(0, last), true, nil
+}
+
+// quoteIdent quotes a possibly schema qualified table name for SQL.
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
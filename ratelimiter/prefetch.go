@@ -0,0 +1,178 @@
+package ratelimiter
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Prefetcher wraps a Limiter to serve Check for its most checked keys from
+// memory, e.g. to fill rate limit headers on every response of a busy API.
+// It counts the Checks of each key in process, and Refresh reads the state
+// of the top keys from the wrapped limiter, so their Checks don't make a
+// round trip to Redis. Their state is then up to one refresh interval old,
+// apart from the requests this process made since, which Allow records.
+type Prefetcher struct {
+	limiter  Limiter
+	topK     int
+	interval time.Duration
+	config
+
+	mu     sync.Mutex
+	counts map[string]int64         // Checks of each key, halved every refresh
+	hot    map[string]prefetchEntry // state of the top keys at the last refresh
+}
+
+// prefetchEntry is the state of a top key. at is monotonic, see monotonic.
+type prefetchEntry struct {
+	res Result
+	at  time.Duration
+}
+
+// NewPrefetcher returns l serving Check for its topK most checked keys
+// locally, refreshed every interval by Run. Only the clock option is used.
+// At most 16 * topK keys are counted at once; others are counted once the
+// next refresh has made room.
+// It panics if topK is less than 1 or interval is not positive.
+func NewPrefetcher(l Limiter, topK int, interval time.Duration, opts ...Option) *Prefetcher {
+	if topK < 1 {
+		panic(fmt.Sprintf("ratelimiter: prefetcher top keys must be at least 1, got %d", topK))
+	}
+	if interval <= 0 {
+		panic(fmt.Sprintf("ratelimiter: prefetch interval must be positive, got %s", interval))
+	}
+	return &Prefetcher{
+		limiter:  l,
+		topK:     topK,
+		interval: interval,
+		config:   newConfig(opts),
+		counts:   make(map[string]int64),
+		hot:      make(map[string]prefetchEntry),
+	}
+}
+
+// Allow implements Limiter.
+func (l *Prefetcher) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN implements Limiter. The result replaces the prefetched state of
+// key, if it is a top key, as the freshest one this process knows.
+func (l *Prefetcher) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmPrefetch, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.limiter.AllowN(ctx, key, n)
+	if err != nil {
+		return res, err
+	}
+	now := monotonic(l.clock)
+	l.mu.Lock()
+	if _, ok := l.hot[key]; ok {
+		// As Check would report it for the next request
+		next := res
+		next.Allowed = res.Remaining > 0
+		if next.Allowed {
+			next.RetryAfter = 0
+		}
+		l.hot[key] = prefetchEntry{res: next, at: now}
+	}
+	l.mu.Unlock()
+	return res, nil
+}
+
+// Check reports the prefetched state of a top key, and asks the wrapped
+// limiter for other keys.
+func (l *Prefetcher) Check(ctx context.Context, key string) (Result, error) {
+	now := monotonic(l.clock)
+	l.mu.Lock()
+	if _, ok := l.counts[key]; ok || len(l.counts) < 16*l.topK {
+		l.counts[key]++
+	}
+	e, ok := l.hot[key]
+	l.mu.Unlock()
+	if !ok {
+		return l.limiter.Check(ctx, key)
+	}
+	res := e.res
+	if !res.Allowed {
+		res.RetryAfter = max(0, res.RetryAfter-(now-e.at))
+	}
+	return res, nil
+}
+
+// Prefetched reports whether Check for key is served locally.
+func (l *Prefetcher) Prefetched(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.hot[key]
+	return ok
+}
+
+// Reset forgets the prefetched state of key in this process and resets
+// the wrapped limiter. Other processes serve their prefetched state until
+// their next refresh.
+func (l *Prefetcher) Reset(ctx context.Context, key string) error {
+	l.mu.Lock()
+	delete(l.hot, key)
+	l.mu.Unlock()
+	return l.limiter.Reset(ctx, key)
+}
+
+// Run refreshes the top keys every interval until ctx is done, e.g.
+// go prefetcher.Run(ctx). Refresh errors are retried on the next tick.
+// It returns ctx.Err().
+func (l *Prefetcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			_ = l.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh reads the state of the topK most checked keys from the wrapped
+// limiter and stops serving the others locally. Counts are halved, so keys
+// checked less often than they used to fall out of the top in a few
+// refreshes. A key whose state can't be read is asked of the wrapped
+// limiter until the next refresh.
+func (l *Prefetcher) Refresh(ctx context.Context) error {
+	l.mu.Lock()
+	keys := slices.SortedFunc(maps.Keys(l.counts), func(a, b string) int {
+		return cmp.Compare(l.counts[b], l.counts[a])
+	})
+	keys = keys[:min(len(keys), l.topK)]
+	for key, count := range l.counts {
+		if count /= 2; count == 0 {
+			delete(l.counts, key)
+		} else {
+			l.counts[key] = count
+		}
+	}
+	l.mu.Unlock()
+
+	hot := make(map[string]prefetchEntry, len(keys))
+	var errs []error
+	for _, key := range keys {
+		res, err := l.limiter.Check(ctx, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		hot[key] = prefetchEntry{res: res, at: monotonic(l.clock)}
+	}
+
+	l.mu.Lock()
+	l.hot = hot
+	l.mu.Unlock()
+	return errors.Join(errs...)
+}
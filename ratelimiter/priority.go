@@ -0,0 +1,363 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Counts the requests of every priority class in one hash per window.
+// Higher classes (lower indexes) may borrow the unused capacity of lower
+// classes, but the unused reservations of higher classes are off limits to
+// lower ones. So a request of class p fits when
+//
+//	sum over higher classes of max(count, reserve) + sum over the rest of count + cost <= limit
+var priorityScript = newScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local priority = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+
+	local used = 0
+	for i = 0, #ARGV - 5 do
+		local count = tonumber(redis.call('HGET', key, i) or 0)
+		if i < priority then
+			used = used + math.max(count, tonumber(ARGV[5 + i]))
+		else
+			used = used + count
+		end
+	end
+
+	if used + cost > limit then
+		return {0, used, redis.call('PTTL', key)}
+	end
+
+	redis.call('HINCRBY', key, priority, cost)
+	local ttl = redis.call('PTTL', key)
+	if ttl < 0 then
+		redis.call('PEXPIRE', key, window)
+		ttl = window
+	end
+	return {1, used + cost, ttl}
+`)
+
+// Priority limiter
+// Fixed window whose capacity is partitioned across priority classes,
+// e.g. 80% reserved for paid traffic and 20% for free traffic. A class may
+// use its own share and borrow whatever lower classes leave unused, while
+// the reserved share of higher classes stays available to them.
+// Class 0 is the highest priority.
+type Priority struct {
+	client redis.UniversalClient
+	shares []float64
+	config
+}
+
+// NewPriority returns a limiter allowing WithLimit requests per WithWindow,
+// reserving shares[i] of them (a fraction between 0 and 1) for class i.
+// It panics if shares is empty, a share is negative or they add up to more
+// than 1.
+func NewPriority(client redis.UniversalClient, shares []float64, opts ...Option) *Priority {
+	if len(shares) == 0 {
+		panic("ratelimiter: Priority needs at least one class")
+	}
+	var total float64
+	for _, share := range shares {
+		if !(share >= 0) {
+			panic(fmt.Sprintf("ratelimiter: priority shares must not be negative, got %v", share))
+		}
+		total += share
+	}
+	if total > 1+1e-9 {
+		panic(fmt.Sprintf("ratelimiter: priority shares add up to %v, more than 1", total))
+	}
+	cfg := newConfig(opts)
+	cfg.mustValidateWindow()
+	cfg.preloadScripts(client)
+	return &Priority{client: client, shares: shares, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *Priority) forKey(ctx context.Context, key string) (*Priority, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmPriority, Key: key, Err: err}
+	}
+	return &Priority{client: l.client, shares: l.shares, config: cfg}, nil
+}
+
+// Allow reports whether one request of the given priority class for key
+// is allowed right now.
+func (l *Priority) Allow(ctx context.Context, key string, priority int) (Result, error) {
+	return l.AllowN(ctx, key, priority, 1)
+}
+
+// AllowN is like Allow for a request costing n.
+func (l *Priority) AllowN(ctx context.Context, key string, priority int, n int64) (Result, error) {
+	if err := costError(AlgorithmPriority, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, priority, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmPriority, key, res), nil
+}
+
+func (l *Priority) allowN(ctx context.Context, key string, priority int, n int64) (Result, error) {
+	if priority < 0 || priority >= len(l.shares) {
+		return Result{}, &Error{Algorithm: AlgorithmPriority, Key: key,
+			Err: fmt.Errorf("ratelimiter: priority %d out of range [0, %d)", priority, len(l.shares))}
+	}
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	if l.store != nil {
+		return l.allowStore(ctx, key, priority, n, now)
+	}
+
+	args := []any{l.limit, l.window.Milliseconds(), priority, n}
+	for _, reserve := range l.reserves() {
+		args = append(args, reserve)
+	}
+	redisKey, err := l.key("priority", key)
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := priorityScript.Run(ctx, l.client, []string{redisKey}, args...).Int64Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmPriority, key, err)
+	}
+	// Redis Lua returns {allowed, used, ttl in ms}
+	if len(result) != 3 {
+		return Result{}, replyError(AlgorithmPriority, key, result)
+	}
+	return l.result(result[0] == 1, result[1], time.Duration(result[2])*time.Millisecond, now), nil
+}
+
+// allowStore is allowN for WithStore: the same decision as priorityScript,
+// made in Go on the counters kept in the Store.
+func (l *Priority) allowStore(ctx context.Context, key string, priority int, n int64, now time.Time) (Result, error) {
+	storeKey, err := l.key("priority", key)
+	if err != nil {
+		return Result{}, err
+	}
+	var allowed bool
+	var used int64
+	var ttl time.Duration
+	err = updateState(ctx, l.store, storeKey, func(st *priorityState, expiresIn time.Duration) (bool, time.Duration) {
+		counts := l.classCounts(*st)
+		used, ttl = l.used(counts, priority), expiresIn
+		if allowed = used+n <= l.limit; !allowed {
+			return false, 0
+		}
+		counts[priority] += n
+		used += n
+		if ttl <= 0 {
+			ttl = l.window
+		}
+		st.Counts = counts
+		return true, ttl
+	})
+	if err != nil {
+		return Result{}, storeError(AlgorithmPriority, key, err)
+	}
+	return l.result(allowed, used, ttl, now), nil
+}
+
+// priorityState is the request count of every class of a key kept in a
+// Store, for the window it expires with.
+type priorityState struct {
+	Counts []int64 `json:"counts"`
+}
+
+// classCounts returns the count of every class in st, with classes added
+// since it was stored counted as zero.
+func (l *Priority) classCounts(st priorityState) []int64 {
+	counts := make([]int64, len(l.shares))
+	copy(counts, st.Counts)
+	return counts
+}
+
+// result builds the Result for a class that sees used requests of the limit
+// in a window expiring in ttl.
+func (l *Priority) result(allowed bool, used int64, ttl time.Duration, now time.Time) Result {
+	// No expiry means no window has started yet
+	ttl = max(0, ttl)
+	res := Result{
+		Allowed:   allowed,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-used),
+		ResetAt:   now.Add(ttl),
+	}
+	if !res.Allowed {
+		res.RetryAfter = ttl
+	}
+	return res
+}
+
+// Check reports the state for key as seen by the given priority class
+// without counting a request.
+func (l *Priority) Check(ctx context.Context, key string, priority int) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	counts, ttl, err := l.counts(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	used := l.used(counts, priority)
+	return l.result(used < l.limit, used, ttl, now), nil
+}
+
+// Reset clears the counters of all classes for key.
+func (l *Priority) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("priority", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmPriority, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmPriority, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+// The count and reserved share of each class are reported in State.Limits.
+func (l *Priority) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	counts, ttl, err := l.counts(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	st := State{
+		Algorithm: AlgorithmPriority,
+		Key:       key,
+		Limit:     l.limit,
+		Window:    l.window,
+		TTL:       max(0, ttl),
+	}
+	if ttl > 0 {
+		st.WindowStart = now.Add(ttl - l.window)
+	}
+	for i, reserve := range l.reserves() {
+		st.Count += counts[i]
+		st.Limits = append(st.Limits, State{
+			Algorithm: AlgorithmPriority,
+			Key:       key + ":" + strconv.Itoa(i),
+			Limit:     reserve,
+			Window:    l.window,
+			Count:     counts[i],
+		})
+	}
+	return st, nil
+}
+
+// Class returns a Limiter for the requests of one priority class, e.g. to
+// hand to middleware that only knows about keys.
+func (l *Priority) Class(priority int) Limiter {
+	return &classLimiter{l: l, priority: priority}
+}
+
+// counts reads the request count of every class for key and the remaining window.
+func (l *Priority) counts(ctx context.Context, key string) ([]int64, time.Duration, error) {
+	redisKey, err := l.key("priority", key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if l.store != nil {
+		var counts []int64
+		var ttl time.Duration
+		err := updateState(ctx, l.store, redisKey, func(st *priorityState, expiresIn time.Duration) (bool, time.Duration) {
+			counts, ttl = l.classCounts(*st), expiresIn
+			return false, 0
+		})
+		if err != nil {
+			return nil, 0, storeError(AlgorithmPriority, key, err)
+		}
+		return counts, ttl, nil
+	}
+	fields := make([]string, len(l.shares))
+	for i := range fields {
+		fields[i] = strconv.Itoa(i)
+	}
+
+	pipe := l.client.Pipeline()
+	hmget := pipe.HMGet(ctx, redisKey, fields...)
+	pttl := pipe.PTTL(ctx, redisKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, storeError(AlgorithmPriority, key, err)
+	}
+
+	counts := make([]int64, len(l.shares))
+	for i, v := range hmget.Val() {
+		counts[i], _ = strconv.ParseInt(fmt.Sprint(v), 10, 64)
+	}
+	return counts, pttl.Val(), nil
+}
+
+// used returns the capacity unavailable to the given class, the same way
+// the Lua script computes it.
+func (l *Priority) used(counts []int64, priority int) int64 {
+	var used int64
+	for i, reserve := range l.reserves() {
+		if i < priority {
+			used += max(counts[i], reserve)
+		} else {
+			used += counts[i]
+		}
+	}
+	return used
+}
+
+// reserves returns the number of requests reserved for each class.
+func (l *Priority) reserves() []int64 {
+	reserves := make([]int64, len(l.shares))
+	for i, share := range l.shares {
+		reserves[i] = int64(math.Floor(float64(l.limit) * share))
+	}
+	return reserves
+}
+
+// classLimiter adapts a Priority limiter with a fixed class to Limiter.
+type classLimiter struct {
+	l        *Priority
+	priority int
+}
+
+func (c *classLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return c.l.Allow(ctx, key, c.priority)
+}
+
+func (c *classLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	return c.l.AllowN(ctx, key, c.priority, n)
+}
+
+func (c *classLimiter) Check(ctx context.Context, key string) (Result, error) {
+	return c.l.Check(ctx, key, c.priority)
+}
+
+func (c *classLimiter) Reset(ctx context.Context, key string) error {
+	return c.l.Reset(ctx, key)
+}
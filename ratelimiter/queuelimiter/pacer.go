@@ -0,0 +1,198 @@
+// Package queuelimiter paces queue consumers, e.g. Kafka consumer group
+// members, so workers draining a backlog don't overwhelm the databases or
+// APIs their messages are written to:
+//
+//	pacer := queuelimiter.New(ratelimiter.NewTokenBucket(rdb, ratelimiter.WithBurst(50), ratelimiter.WithRate(200)), "orders-db")
+//	for {
+//		msg, err := reader.FetchMessage(ctx)
+//		...
+//		if err := pacer.Wait(ctx); err != nil {
+//			return err
+//		}
+//		process(msg)
+//	}
+//
+// With a Redis backed limiter the rate is shared by every consumer, however
+// many instances the group is scaled to.
+package queuelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/moonorange/go_rate_limiter/ratelimiter"
+)
+
+// minDelay bounds how often a Pacer retries when the limiter reports no
+// RetryAfter for a denied message.
+const minDelay = 10 * time.Millisecond
+
+// Pacer paces the messages of a consumer. It is safe for concurrent use by
+// the workers of the consumer.
+type Pacer struct {
+	limiter ratelimiter.Limiter
+	key     string
+	cfg     config
+
+	mu      sync.Mutex
+	paused  bool
+	waiting int // workers waiting for the pause threshold or longer
+}
+
+// New returns a Pacer taking the capacity for messages from key of l. A
+// token bucket lets a burst through after idle periods. Limiters that
+// implement ratelimiter.Waiter do the waiting, honoring their options, so
+// e.g. a leaky bucket with WithShaping spaces messages evenly and
+// WithMaxDelay bounds the wait; other limiters are retried after the
+// RetryAfter of each denial.
+func New(l ratelimiter.Limiter, key string, opts ...Option) *Pacer {
+	cfg := config{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Pacer{limiter: l, key: key, cfg: cfg}
+}
+
+// Wait blocks until one message may be processed or ctx is done.
+func (p *Pacer) Wait(ctx context.Context) error {
+	return p.WaitN(ctx, 1)
+}
+
+// WaitN blocks until a batch of n messages may be processed or ctx is done,
+// pausing the consumer while the wait is longer than the pause threshold,
+// see WithPause. It fails with ratelimiter.ErrLimitExceeded if n can never
+// fit in the limit.
+func (p *Pacer) WaitN(ctx context.Context, n int64) error {
+	if w, ok := p.limiter.(ratelimiter.Waiter); ok {
+		return p.wait(ctx, w, n)
+	}
+	return p.poll(ctx, n)
+}
+
+// wait leaves the wait to w. The state of the limiter tells beforehand
+// whether it will be long enough to pause.
+func (p *Pacer) wait(ctx context.Context, w ratelimiter.Waiter, n int64) error {
+	if p.cfg.pause != nil {
+		res, err := p.limiter.Check(ctx, p.key)
+		if err == nil && !res.Allowed && res.RetryAfter >= p.cfg.threshold {
+			p.pause(ctx, res.RetryAfter)
+			defer p.resume(ctx)
+		}
+	}
+	return w.WaitN(ctx, p.key, n)
+}
+
+// poll retries a batch of n messages after the RetryAfter of each denial.
+func (p *Pacer) poll(ctx context.Context, n int64) error {
+	paused := false
+	for {
+		res, err := p.limiter.AllowN(ctx, p.key, n)
+		if err != nil {
+			return err
+		}
+		if res.Allowed {
+			return nil
+		}
+		// Limit is zero when no limit applies to the denial, e.g. a
+		// FailClosed store error, so waiting may help
+		if res.Limit > 0 && n > res.Limit {
+			return fmt.Errorf("%w: cost %d exceeds limit %d", ratelimiter.ErrLimitExceeded, n, res.Limit)
+		}
+
+		delay := max(res.RetryAfter, minDelay)
+		if !paused && p.cfg.pause != nil && delay >= p.cfg.threshold {
+			p.pause(ctx, delay)
+			paused = true
+			defer p.resume(ctx)
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep sleeps for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Paused reports whether the consumer is paused, i.e. the pause hook ran
+// and the resume hook hasn't yet.
+func (p *Pacer) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+func (p *Pacer) pause(ctx context.Context, wait time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.waiting++
+	if !p.paused {
+		p.paused = true
+		p.cfg.pause(ctx, wait)
+	}
+}
+
+// resume ends the long wait of a worker that called pause, whether its
+// messages were allowed or the wait failed.
+func (p *Pacer) resume(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.waiting--
+	// Other workers still waiting long keep the consumer paused
+	if p.paused && p.waiting == 0 {
+		p.paused = false
+		if p.cfg.resume != nil {
+			// The consumer must resume even when ctx ended the wait
+			p.cfg.resume(context.WithoutCancel(ctx))
+		}
+	}
+}
+
+// Handler returns handle paced by p, each message costing one unit, for
+// consumers that call a handler per message. The message is not handled,
+// and the error returned, if the wait fails.
+func Handler[M any](p *Pacer, handle func(ctx context.Context, msg M) error) func(ctx context.Context, msg M) error {
+	return func(ctx context.Context, msg M) error {
+		if err := p.Wait(ctx); err != nil {
+			return err
+		}
+		return handle(ctx, msg)
+	}
+}
+
+type config struct {
+	threshold time.Duration
+	pause     func(ctx context.Context, wait time.Duration)
+	resume    func(ctx context.Context)
+}
+
+// Option configures a Pacer.
+type Option func(*config)
+
+// WithPause calls pause when a message has to wait threshold or longer,
+// and resume once no worker is waiting that long anymore, whether their
+// messages were allowed or their waits failed; each is called once per
+// pause, however many workers wait. Consumers that stop polling their
+// broker for too long are removed from their group, e.g. after Kafka's
+// max.poll.interval.ms, so pause should pause fetching and keep polling,
+// e.g. with franz-go's PauseFetchPartitions, and resume undo it. wait is
+// the expected delay. The hooks run with the Pacer locked, so they must
+// not call it.
+func WithPause(threshold time.Duration, pause func(ctx context.Context, wait time.Duration), resume func(ctx context.Context)) Option {
+	return func(cfg *config) {
+		cfg.threshold = threshold
+		cfg.pause = pause
+		cfg.resume = resume
+	}
+}
@@ -0,0 +1,286 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Period is a calendar period a Quota resets on.
+type Period int
+
+const (
+	// Daily quotas reset at midnight.
+	Daily Period = iota
+	// Weekly quotas reset at midnight on Monday.
+	Weekly
+	// Monthly quotas reset at midnight on the 1st.
+	Monthly
+)
+
+func (p Period) String() string {
+	switch p {
+	case Daily:
+		return "daily"
+	case Weekly:
+		return "weekly"
+	case Monthly:
+		return "monthly"
+	default:
+		return fmt.Sprintf("Period(%d)", int(p))
+	}
+}
+
+// bounds returns the start and end of the period containing t, in t's location.
+func (p Period) bounds(t time.Time) (start, end time.Time) {
+	y, m, d := t.Date()
+	switch p {
+	case Weekly:
+		// Days since Monday
+		offset := (int(t.Weekday()) + 6) % 7
+		start = time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 7)
+	case Monthly:
+		start = time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// Counts a quota without consuming it for denied requests, since a client
+// that hits its monthly quota should not push the count ever higher.
+var quotaScript = newScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local cost = tonumber(ARGV[2])
+	local expire_at = tonumber(ARGV[3])
+
+	local count = tonumber(redis.call('GET', key) or 0)
+	if count + cost > limit then
+		return {0, count}
+	end
+
+	count = redis.call('INCRBY', key, cost)
+	redis.call('PEXPIREAT', key, expire_at)
+	return {1, count}
+`)
+
+// Quota limiter
+// Allows WithLimit requests per calendar day, week or month in the location
+// given by WithLocation, e.g. "10,000 calls per calendar month resetting on
+// the 1st". Unlike FixedWindow, whose windows start with the first request,
+// periods follow the calendar, including months of different lengths and
+// daylight saving changes.
+type Quota struct {
+	client redis.UniversalClient
+	period Period
+	config
+}
+
+// NewQuota returns a limiter allowing WithLimit requests per period.
+// It panics if the options are invalid.
+func NewQuota(client redis.UniversalClient, period Period, opts ...Option) *Quota {
+	cfg := newConfig(opts)
+	if cfg.limit <= 0 {
+		panic(fmt.Sprintf("ratelimiter: limit must be positive, got %d", cfg.limit))
+	}
+	if period < Daily || period > Monthly {
+		panic(fmt.Sprintf("ratelimiter: unknown quota period %s", period))
+	}
+	cfg.preloadScripts(client)
+	return &Quota{client: client, period: period, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+// Only the number of requests of a LimitProvider limit is used; the period is fixed.
+func (l *Quota) forKey(ctx context.Context, key string) (*Quota, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmQuota, Key: key, Err: err}
+	}
+	return &Quota{client: l.client, period: l.period, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *Quota) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN implements Limiter.
+// A denied request does not use up any quota.
+func (l *Quota) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmQuota, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmQuota, key, res), nil
+}
+
+func (l *Quota) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	start, end := l.period.bounds(now.In(l.location()))
+
+	redisKey, err := l.periodKey(key, start)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		return l.allowStore(ctx, key, redisKey, n, end, now)
+	}
+	result, err := quotaScript.Run(ctx, l.client, []string{redisKey},
+		l.limit, n, end.UnixMilli()).Int64Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmQuota, key, err)
+	}
+	// Redis Lua returns {allowed, count}
+	if len(result) != 2 {
+		return Result{}, replyError(AlgorithmQuota, key, result)
+	}
+	return l.result(result[0] == 1, result[1], end, now), nil
+}
+
+// allowStore is allowN with WithStore. Stores only increment atomically, so
+// the request is counted first and taken back if it went over the quota;
+// concurrent requests may both be denied, but never both be allowed past it.
+func (l *Quota) allowStore(ctx context.Context, key, redisKey string, n int64, end, now time.Time) (Result, error) {
+	// The counter lives until the period ends
+	ttl := end.Sub(now)
+	count, _, err := l.store.Increment(ctx, redisKey, n, ttl)
+	if err != nil {
+		return Result{}, storeError(AlgorithmQuota, key, err)
+	}
+	if count <= l.limit {
+		return l.result(true, count, end, now), nil
+	}
+	if count, _, err = l.store.Increment(ctx, redisKey, -n, ttl); err != nil {
+		return Result{}, storeError(AlgorithmQuota, key, err)
+	}
+	return l.result(false, count, end, now), nil
+}
+
+// result builds the Result for a period ending at end in which count requests were made.
+func (l *Quota) result(allowed bool, count int64, end, now time.Time) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-count),
+		ResetAt:   end,
+	}
+	if !res.Allowed {
+		res.RetryAfter = end.Sub(now)
+	}
+	return res
+}
+
+// Check reports the remaining quota for key without counting a request.
+func (l *Quota) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	start, end := l.period.bounds(now.In(l.location()))
+
+	redisKey, err := l.periodKey(key, start)
+	if err != nil {
+		return Result{}, err
+	}
+	var count int64
+	if l.store != nil {
+		count, _, err = l.store.Counter(ctx, redisKey)
+	} else {
+		count, err = l.client.Get(ctx, redisKey).Int64()
+		if errors.Is(err, redis.Nil) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return Result{}, storeError(AlgorithmQuota, key, err)
+	}
+	return l.result(count < l.limit, count, end, now), nil
+}
+
+// Reset clears the quota of the current period for key.
+func (l *Quota) Reset(ctx context.Context, key string) error {
+	start, _ := l.period.bounds(l.clock.Now().In(l.location()))
+	redisKey, err := l.periodKey(key, start)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmQuota, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmQuota, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *Quota) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	start, end := l.period.bounds(l.clock.Now().In(l.location()))
+	redisKey, err := l.periodKey(key, start)
+	if err != nil {
+		return State{}, err
+	}
+
+	var count int64
+	var ttl time.Duration
+	if l.store != nil {
+		if count, ttl, err = l.store.Counter(ctx, redisKey); err != nil {
+			return State{}, storeError(AlgorithmQuota, key, err)
+		}
+	} else {
+		pipe := l.client.Pipeline()
+		get := pipe.Get(ctx, redisKey)
+		pttl := pipe.PTTL(ctx, redisKey)
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return State{}, storeError(AlgorithmQuota, key, err)
+		}
+		count, _ = get.Int64()
+		ttl = max(0, pttl.Val())
+	}
+
+	return State{
+		Algorithm:   AlgorithmQuota,
+		Key:         key,
+		Limit:       l.limit,
+		Window:      end.Sub(start),
+		Count:       count,
+		WindowStart: start,
+		TTL:         ttl,
+	}, nil
+}
+
+// periodKey returns the Redis key of the period starting at start,
+// e.g. "quota:user:123:2024-01-01".
+func (l *Quota) periodKey(key string, start time.Time) (string, error) {
+	return l.subKey("quota", key, start.Format(time.DateOnly))
+}
+
+// location returns the time zone periods are aligned to.
+func (l *Quota) location() *time.Location {
+	if l.loc == nil {
+		return time.UTC
+	}
+	return l.loc
+}
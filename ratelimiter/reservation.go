@@ -0,0 +1,50 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Reservation holds tokens taken by TokenBucket.Reserve, or a place in the
+// queue taken by LeakyBucket.Reserve.
+// It mirrors rate.Reservation from golang.org/x/time/rate, backed by Redis.
+type Reservation struct {
+	ok        bool
+	clock     Clock
+	timeToAct time.Time
+	cancel    func(ctx context.Context) error
+	once      sync.Once
+}
+
+// OK reports whether the reservation can ever be honored.
+// It is false when the requested cost exceeds the bucket capacity.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long the caller must wait before acting on the reservation.
+// Zero means it may act immediately.
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.clock.Now())
+}
+
+// DelayFrom returns the delay relative to t.
+func (r *Reservation) DelayFrom(t time.Time) time.Duration {
+	if !r.ok {
+		return 0
+	}
+	return max(0, r.timeToAct.Sub(t))
+}
+
+// Cancel gives the reserved tokens back to the bucket.
+// It is a no-op if the reservation was not OK, was already cancelled,
+// or its time to act has passed (the tokens are considered used).
+func (r *Reservation) Cancel(ctx context.Context) error {
+	if !r.ok || !r.clock.Now().Before(r.timeToAct) {
+		return nil
+	}
+	var err error
+	r.once.Do(func() { err = r.cancel(ctx) })
+	return err
+}
@@ -0,0 +1,40 @@
+package ratelimiter
+
+import "time"
+
+// Result describes the outcome of a rate limit decision.
+// It carries everything needed to populate HTTP 429 responses
+// and rate limit headers without further Redis queries.
+type Result struct {
+	// Allowed reports whether the request may proceed.
+	Allowed bool
+	// Limit is the maximum number of requests allowed (the bucket capacity
+	// for TokenBucket).
+	Limit int64
+	// Remaining is the number of requests that can still be made right now.
+	Remaining int64
+	// ResetAt is when the limiter will be back to its full capacity.
+	ResetAt time.Time
+	// RetryAfter is how long to wait before the next request can be allowed:
+	// until the window resets for FixedWindow and Quota, until enough old
+	// requests stop counting for the sliding windows and EWMA, and until
+	// enough capacity refills or drains for the buckets and GCRA.
+	// It is zero when Allowed is true, when the cost can never fit, and for
+	// Concurrency, whose slots free up whenever holders finish.
+	RetryAfter time.Duration
+}
+
+// RetryAfterSeconds returns RetryAfter rounded up to whole seconds, as
+// needed for a Retry-After header: rounding down would tell clients to
+// retry before the request can be allowed.
+func (r Result) RetryAfterSeconds() int64 {
+	return int64((r.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// Err returns ErrLimitExceeded if the request was denied and nil otherwise.
+func (r Result) Err() error {
+	if r.Allowed {
+		return nil
+	}
+	return ErrLimitExceeded
+}
@@ -0,0 +1,205 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScriptV0 is the token bucket script of schema version 0, run by
+// processes not upgraded yet during a rolling deploy. It stores tokens and
+// last only, and knows nothing of the marker.
+var tokenBucketScriptV0 = redis.NewScript(`
+	local key = KEYS[1]
+	local capacity = tonumber(ARGV[1])
+	local rate = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+
+	local tokens = tonumber(redis.call('HGET', key, 'tokens') or capacity)
+	local last = tonumber(redis.call('HGET', key, 'last') or now)
+	tokens = math.min(capacity, tokens + (now - last) * rate)
+	if tokens < 1 then
+		return 0
+	end
+	redis.call('HMSET', key, 'tokens', tokens - 1, 'last', now)
+	redis.call('EXPIRE', key, math.ceil(capacity / rate))
+	return 1
+`)
+
+// bucketWriters leave a bucket of capacity 3 with 1 token at now, the way
+// each schema version stores it. Version 2 stands for a future version
+// adding a field this one doesn't know.
+var bucketWriters = map[int]func(t *testing.T, mr *miniredis.Miniredis, l *TokenBucket, redisKey string){
+	0: func(t *testing.T, mr *miniredis.Miniredis, l *TokenBucket, redisKey string) {
+		mr.HSet(redisKey, "tokens", "1", "last", strconv.FormatFloat(seconds(l.clock.Now()), 'f', -1, 64))
+	},
+	1: func(t *testing.T, mr *miniredis.Miniredis, l *TokenBucket, redisKey string) {
+		for range 2 {
+			if _, err := l.Allow(context.Background(), "k"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	},
+	2: func(t *testing.T, mr *miniredis.Miniredis, l *TokenBucket, redisKey string) {
+		mr.HSet(redisKey, "tokens", "1", "last", strconv.FormatFloat(seconds(l.clock.Now()), 'f', -1, 64),
+			"hits", "2", "v", "2", "future", "kept")
+	},
+}
+
+// Every schema version must decide requests on state written by every
+// other one, and never lower the marker or drop fields it doesn't know.
+func TestTokenBucketSchemaCompatibility(t *testing.T) {
+	ctx := context.Background()
+	readers := map[string]struct {
+		version int
+		allow   func(l *TokenBucket, client redis.UniversalClient, redisKey string) (bool, error)
+	}{
+		"v0 script": {0, func(l *TokenBucket, client redis.UniversalClient, redisKey string) (bool, error) {
+			allowed, err := tokenBucketScriptV0.Run(ctx, client, []string{redisKey},
+				l.capacity, l.rate, seconds(l.clock.Now())).Int64()
+			return allowed == 1, err
+		}},
+		"v1 script": {1, func(l *TokenBucket, client redis.UniversalClient, redisKey string) (bool, error) {
+			res, err := l.Allow(ctx, "k")
+			return res.Allowed, err
+		}},
+		"v1 transaction": {1, func(l *TokenBucket, client redis.UniversalClient, redisKey string) (bool, error) {
+			l.noScripts = true
+			res, err := l.Allow(ctx, "k")
+			return res.Allowed, err
+		}},
+	}
+	for written, write := range bucketWriters {
+		for name, reader := range readers {
+			t.Run(fmt.Sprintf("v%d state/%s", written, name), func(t *testing.T) {
+				mr, client := newRedis(t)
+				clock := newFakeClock()
+				l := NewTokenBucket(client, WithRate(0.001), WithBurst(3), WithClock(clock))
+				redisKey, err := l.key("bucket", "k")
+				if err != nil {
+					t.Fatal(err)
+				}
+				write(t, mr, l, redisKey)
+
+				st, err := l.Inspect(ctx, "k")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if st.Version != written {
+					t.Errorf("Inspect version = %d, want %d", st.Version, written)
+				}
+				if st.Available < 0.99 || st.Available > 1.01 {
+					t.Errorf("Inspect available = %v, want the 1 token written", st.Available)
+				}
+
+				clock.Advance(time.Millisecond)
+				for i, want := range []bool{true, false} {
+					allowed, err := reader.allow(l, client, redisKey)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if allowed != want {
+						t.Fatalf("request %d allowed = %v, want %v", i, allowed, want)
+					}
+				}
+
+				// The marker only ever goes up
+				want := ""
+				if v := max(written, reader.version); v > 0 {
+					want = strconv.Itoa(v)
+				}
+				if got := mr.HGet(redisKey, "v"); got != want {
+					t.Errorf("marker = %q, want %q", got, want)
+				}
+				if written == 2 && mr.HGet(redisKey, "future") != "kept" {
+					t.Error("field of a newer version dropped")
+				}
+				if ttl := mr.TTL(redisKey); ttl <= 0 {
+					t.Errorf("TTL = %s, want an expiry", ttl)
+				}
+			})
+		}
+	}
+}
+
+// Version 0 state counted no hits: it is migrated as established traffic
+// so the adaptive TTL doesn't shrink it back to the minimum.
+func TestTokenBucketSchemaMigratesHits(t *testing.T) {
+	ctx := context.Background()
+	for name, noScripts := range map[string]bool{"script": false, "transaction": true} {
+		t.Run(name, func(t *testing.T) {
+			mr, client := newRedis(t)
+			clock := newFakeClock()
+			l := NewTokenBucket(client, WithRate(0.001), WithBurst(3), WithClock(clock), WithTTLPolicy(AdaptiveTTLPolicy))
+			l.noScripts = noScripts
+			redisKey, err := l.key("bucket", "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			bucketWriters[0](t, mr, l, redisKey)
+
+			if _, err := l.Allow(ctx, "k"); err != nil {
+				t.Fatal(err)
+			}
+			minTTL, maxTTL := l.ttlBounds()
+			want := int64(maxTTL/minTTL) + 1
+			if got, _ := strconv.ParseInt(mr.HGet(redisKey, "hits"), 10, 64); got != want {
+				t.Errorf("hits after migration = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestLeakyBucketSchemaCompatibility(t *testing.T) {
+	ctx := context.Background()
+	now := func(l *LeakyBucket) string { return strconv.FormatFloat(seconds(l.clock.Now()), 'f', -1, 64) }
+	writers := map[int]func(t *testing.T, mr *miniredis.Miniredis, l *LeakyBucket, redisKey string){
+		0: func(t *testing.T, mr *miniredis.Miniredis, l *LeakyBucket, redisKey string) {
+			mr.HSet(redisKey, "level", "2", "last", now(l))
+		},
+		1: func(t *testing.T, mr *miniredis.Miniredis, l *LeakyBucket, redisKey string) {
+			if _, err := l.AllowN(ctx, "k", 2); err != nil {
+				t.Fatal(err)
+			}
+		},
+		2: func(t *testing.T, mr *miniredis.Miniredis, l *LeakyBucket, redisKey string) {
+			mr.HSet(redisKey, "level", "2", "last", now(l), "v", "2", "future", "kept")
+		},
+	}
+	for written, write := range writers {
+		t.Run(fmt.Sprintf("v%d state", written), func(t *testing.T) {
+			mr, client := newRedis(t)
+			clock := newFakeClock()
+			l := NewLeakyBucket(client, WithRate(0.001), WithCapacity(3), WithClock(clock))
+			redisKey, err := l.key("leaky", "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(t, mr, l, redisKey)
+
+			if res, err := l.Check(ctx, "k"); err != nil || res.Remaining != 1 {
+				t.Fatalf("Check = %+v, %v, want 1 remaining", res, err)
+			}
+			for i, want := range []bool{true, false} {
+				res, err := l.Allow(ctx, "k")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Allowed != want {
+					t.Fatalf("request %d allowed = %v, want %v", i, res.Allowed, want)
+				}
+			}
+			if got, want := mr.HGet(redisKey, "v"), strconv.Itoa(max(written, leakyBucketSchema)); got != want {
+				t.Errorf("marker = %q, want %s", got, want)
+			}
+			if written == 2 && mr.HGet(redisKey, "future") != "kept" {
+				t.Error("field of a newer version dropped")
+			}
+		})
+	}
+}
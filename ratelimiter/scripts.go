@@ -0,0 +1,95 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// scripts holds every Lua script of the package, for LoadScripts.
+var scripts []*redis.Script
+
+// newScript returns a script for src and registers it for LoadScripts.
+func newScript(src string) *redis.Script {
+	s := redis.NewScript(src)
+	scripts = append(scripts, s)
+	return s
+}
+
+// LoadScripts loads every Lua script used by the limiters into the script
+// cache of client (SCRIPT LOAD, on every shard of a cluster or ring), so the first
+// requests after startup already run them by SHA with EVALSHA instead of
+// sending their source.
+//
+// Calling it is optional: limiters always try EVALSHA first, and when Redis
+// answers NOSCRIPT because its cache was flushed, e.g. after a restart or a
+// failover to a replica, they send the source once with EVAL, which caches
+// it again. Call it next to the limiter constructors, which do not talk to
+// Redis unless given WithScriptPreload.
+func LoadScripts(ctx context.Context, client redis.UniversalClient) error {
+	// A ring sends keyless commands such as SCRIPT LOAD to a random shard
+	if ring, ok := client.(*redis.Ring); ok {
+		return ring.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+			return LoadScripts(ctx, shard)
+		})
+	}
+	for _, s := range scripts {
+		if err := s.Load(ctx, client).Err(); err != nil {
+			return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+		}
+	}
+	return nil
+}
+
+// LoadScriptsOnConnect loads every Lua script on a new connection, in one
+// pipelined round trip. Set it as the OnConnect hook of the client so that
+// after a Sentinel failover the promoted replica, whose script cache is
+// empty, has the scripts before the first request runs on it:
+//
+//	rdb := redis.NewFailoverClient(&redis.FailoverOptions{
+//		MasterName:    "mymaster",
+//		SentinelAddrs: []string{"sentinel:26379"},
+//		OnConnect:     ratelimiter.LoadScriptsOnConnect,
+//	})
+//
+// Without it limiters still recover, by sending each script's source once.
+func LoadScriptsOnConnect(ctx context.Context, cn *redis.Conn) error {
+	_, err := cn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, s := range scripts {
+			s.Load(ctx, pipe)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+// preloadTimeout bounds the script loading of WithScriptPreload.
+const preloadTimeout = 5 * time.Second
+
+// preloaded holds the clients WithScriptPreload loaded the scripts on.
+var preloaded sync.Map
+
+// preloadScripts loads every script on client for WithScriptPreload, once
+// per client.
+func (c config) preloadScripts(client redis.UniversalClient) {
+	if !c.preload || client == nil || c.store != nil {
+		return
+	}
+	if _, ok := preloaded.Load(client); ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preloadTimeout)
+	defer cancel()
+	if err := LoadScripts(ctx, client); err != nil {
+		slog.Warn("ratelimiter: preloading scripts", "error", err)
+		return
+	}
+	preloaded.Store(client, struct{}{})
+}
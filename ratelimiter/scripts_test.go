@@ -0,0 +1,154 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLoadScripts(t *testing.T) {
+	ctx := context.Background()
+	_, client := newRedis(t)
+
+	if err := LoadScripts(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, len(scripts))
+	for i, s := range scripts {
+		hashes[i] = s.Hash()
+	}
+	exists, err := client.ScriptExists(ctx, hashes...).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range exists {
+		if !ok {
+			t.Errorf("script %s not loaded", hashes[i])
+		}
+	}
+}
+
+func TestLoadScriptsOnConnect(t *testing.T) {
+	ctx := context.Background()
+	mr, _ := newRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), OnConnect: LoadScriptsOnConnect})
+	defer client.Close()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	exists, err := client.ScriptExists(ctx, scripts[0].Hash(), scripts[len(scripts)-1].Hash()).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists[0] || !exists[1] {
+		t.Errorf("scripts loaded on connect = %v, want all", exists)
+	}
+}
+
+// With WithScriptPreload the constructor loads the scripts, and a client that
+// can't load them still gets a limiter.
+func TestScriptPreload(t *testing.T) {
+	ctx := context.Background()
+	_, client := newRedis(t)
+
+	NewTokenBucket(client, WithRate(1), WithScriptPreload())
+	exists, err := client.ScriptExists(ctx, tokenBucketScript.Hash(), scripts[len(scripts)-1].Hash()).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists[0] || !exists[1] {
+		t.Errorf("scripts loaded by the constructor = %v, want all", exists)
+	}
+
+	down := newDeadRedis(t)
+	l := NewFixedWindow(down, WithScriptPreload())
+	if _, ok := preloaded.Load(redis.UniversalClient(down)); ok {
+		t.Error("client marked as preloaded after a failed load")
+	}
+	if _, err := l.Allow(ctx, "k"); err == nil {
+		t.Error("Allow on a closed Redis succeeded")
+	}
+}
+
+// Flushing the script cache mid-run, as a restart or a failover to a
+// replica does, must neither fail requests nor lose the limiter state.
+func TestScriptFlushMidRun(t *testing.T) {
+	ctx := context.Background()
+	_, client := newRedis(t)
+	if err := LoadScripts(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+
+	window := []Option{WithLimit(2), WithWindow(time.Minute)}
+	bucket := []Option{WithRate(0.01), WithBurst(2)}
+	perMinute := Limit{Requests: 2, Window: time.Minute}
+	limiters := map[string]Limiter{
+		"FixedWindow":    NewFixedWindow(client, window...),
+		"SlidingLog":     NewSlidingLog(client, window...),
+		"SlidingCounter": NewSlidingCounter(client, window...),
+		"SlidingBuckets": NewSlidingBuckets(client, window...),
+		"TokenBucket":    NewTokenBucket(client, bucket...),
+		"GCRA":           NewGCRA(client, bucket...),
+		"LeakyBucket":    NewLeakyBucket(client, bucket...),
+		"Quota":          NewQuota(client, Daily, WithLimit(2)),
+		"MultiLimiter":   NewMultiLimiter(client, []Limit{perMinute}),
+	}
+	for name, l := range limiters {
+		t.Run(name, func(t *testing.T) {
+			if res, err := l.Allow(ctx, "k"); err != nil || !res.Allowed {
+				t.Fatalf("Allow = %+v, %v, want allowed", res, err)
+			}
+			if err := client.ScriptFlush(ctx).Err(); err != nil {
+				t.Fatal(err)
+			}
+			if exists, _ := client.ScriptExists(ctx, scripts[0].Hash()).Result(); len(exists) != 1 || exists[0] {
+				t.Fatal("script cache not flushed")
+			}
+			if res, err := l.Allow(ctx, "k"); err != nil || !res.Allowed {
+				t.Fatalf("Allow after SCRIPT FLUSH = %+v, %v, want allowed", res, err)
+			}
+			// The first request still counts after the flush
+			if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed {
+				t.Fatalf("Allow over the limit = %+v, %v, want denied", res, err)
+			}
+		})
+	}
+}
+
+// Pipelined requests run their script by SHA only, and reload it once when
+// the cache was flushed.
+func TestScriptFlushPipelined(t *testing.T) {
+	ctx := context.Background()
+	_, client := newRedis(t)
+	keys := []string{"a", "b", "a"}
+
+	t.Run("FixedWindow", func(t *testing.T) {
+		l := NewFixedWindow(client, WithLimit(1), WithWindow(time.Minute))
+		if err := client.ScriptFlush(ctx).Err(); err != nil {
+			t.Fatal(err)
+		}
+		results, err := l.AllowMany(ctx, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !results[0].Allowed || !results[1].Allowed || results[2].Allowed {
+			t.Errorf("AllowMany after SCRIPT FLUSH = %+v, want a and b allowed once", results)
+		}
+	})
+	t.Run("TokenBucket", func(t *testing.T) {
+		l := NewTokenBucket(client, WithRate(0.01), WithBurst(1))
+		if err := client.ScriptFlush(ctx).Err(); err != nil {
+			t.Fatal(err)
+		}
+		results, err := l.AllowMany(ctx, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !results[0].Allowed || !results[1].Allowed || results[2].Allowed {
+			t.Errorf("AllowMany after SCRIPT FLUSH = %+v, want a and b allowed once", results)
+		}
+	})
+}
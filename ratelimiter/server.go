@@ -0,0 +1,70 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis compatible servers told apart by DetectServer.
+const (
+	ServerRedis     = "redis"
+	ServerValkey    = "valkey"
+	ServerDragonfly = "dragonfly"
+	ServerKeyDB     = "keydb"
+)
+
+// Server identifies the Redis compatible server behind a client.
+type Server struct {
+	// Name is one of ServerRedis, ServerValkey, ServerDragonfly or ServerKeyDB.
+	Name    string
+	Version string
+	// Scripting reports whether the server runs Lua scripts. Without it the
+	// limiters that support WithoutScripts must use it, and the others can't run.
+	Scripting bool
+}
+
+// DetectServer reports which server client talks to, from INFO server, and
+// whether it runs Lua scripts. On a cluster or ring it asks one of the shards.
+//
+// The limiters only rely on what these servers implement alike: their
+// scripts touch nothing but the keys they are passed, take the time from
+// their arguments rather than TIME, so verbatim and effects replication
+// agree, and never use Redis 7 functions (FUNCTION, FCALL) or field expiry
+// (HEXPIRE). Servers that reject scripts, e.g. some managed offerings, are
+// reported by Scripting; pass the Server to WithServer to adapt to them.
+func DetectServer(ctx context.Context, client redis.UniversalClient) (Server, error) {
+	info, err := client.Info(ctx, "server").Result()
+	if err != nil {
+		return Server{}, fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+	srv := parseServer(info)
+	srv.Scripting = client.Eval(ctx, "return 1", nil).Err() == nil
+	return srv, nil
+}
+
+// parseServer reads the server name and version from INFO server.
+// Compatible servers also report a redis_version, so their own fields win.
+func parseServer(info string) Server {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		if k, v, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":"); ok {
+			fields[k] = v
+		}
+	}
+	switch {
+	case fields["dragonfly_version"] != "":
+		return Server{Name: ServerDragonfly, Version: fields["dragonfly_version"]}
+	case fields["valkey_version"] != "" || fields["server_name"] == ServerValkey:
+		return Server{Name: ServerValkey, Version: cmp.Or(fields["valkey_version"], fields["redis_version"])}
+	// KeyDB has no field of its own, only its executable gives it away
+	case strings.Contains(fields["executable"], "keydb"):
+		return Server{Name: ServerKeyDB, Version: fields["redis_version"]}
+	}
+	return Server{Name: ServerRedis, Version: fields["redis_version"]}
+}
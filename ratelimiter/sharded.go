@@ -0,0 +1,26 @@
+package ratelimiter
+
+import "github.com/redis/go-redis/v9"
+
+// NewShardedClient returns a client spreading limiter keys over independent
+// Redis servers, for fleets where a single Redis is the throughput ceiling.
+// It is a go-redis Ring: each key is placed on one server by rendezvous
+// hashing of its hash tag, if any, so all the keys a limiter uses for one
+// caller key stay together with WithHashTags, which the multi-key limiters
+// require on it. Every other Ring setting is taken from opt, which may be nil.
+//
+// Servers are named by their address. Adding or removing one only moves the
+// keys that hash to it, whose limits start over on their new server. Unlike
+// Redis Cluster, servers are not replicated: while one is down the keys on
+// it are moved to the others.
+func NewShardedClient(addrs []string, opt *redis.RingOptions) *redis.Ring {
+	var ringOpt redis.RingOptions
+	if opt != nil {
+		ringOpt = *opt
+	}
+	ringOpt.Addrs = make(map[string]string, len(addrs))
+	for _, addr := range addrs {
+		ringOpt.Addrs[addr] = addr
+	}
+	return redis.NewRing(&ringOpt)
+}
@@ -0,0 +1,385 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultPrecision is the number of sub-windows used by SlidingBuckets
+// when WithPrecision is not given.
+const DefaultPrecision = 10
+
+// The window is split into sub-windows stored as fields of one hash, keyed by
+// their index since the epoch. Sub-windows that slid out of the window are
+// deleted, the rest are summed and returned so the caller can work out when
+// enough of them expire.
+var slidingBucketsScript = newScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local size = tonumber(ARGV[2])
+	local precision = tonumber(ARGV[3])
+	local now = tonumber(ARGV[4])
+	local cost = tonumber(ARGV[5])
+	local max_idle = tonumber(ARGV[6])
+
+	local current = math.floor(now / size)
+	local oldest = current - precision + 1
+
+	local fields = redis.call('HGETALL', key)
+	local count = 0
+	local live = {}
+	for i = 1, #fields, 2 do
+		local index = tonumber(fields[i])
+		if index < oldest then
+			redis.call('HDEL', key, fields[i])
+		else
+			count = count + tonumber(fields[i + 1])
+			live[fields[i]] = tonumber(fields[i + 1])
+		end
+	end
+
+	local allowed = 0
+	if count + cost <= limit then
+		allowed = 1
+		live[tostring(current)] = redis.call('HINCRBY', key, current, cost)
+		redis.call('PEXPIRE', key, math.max(size * precision, max_idle))
+	end
+
+	local reply = {allowed}
+	for index, n in pairs(live) do
+		table.insert(reply, index)
+		table.insert(reply, n)
+	end
+	return reply
+`)
+
+// SlidingBuckets algorithm
+// Sliding window split into WithPrecision sub-windows kept in a Redis hash.
+// Requests are counted in the sub-window they fall into and the whole
+// sub-window drops out once it is older than the window. More precision
+// means a more accurate window at the cost of more hash fields per key:
+// a precision of 1 is a fixed window, a precision equal to the window in
+// milliseconds is a sliding log.
+type SlidingBuckets struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewSlidingBuckets returns a limiter allowing a fixed number of requests in
+// any window, tracked with WithPrecision sub-windows.
+// It panics if the options are invalid.
+func NewSlidingBuckets(client redis.UniversalClient, opts ...Option) *SlidingBuckets {
+	cfg := newConfig(opts)
+	cfg.mustValidateWindow()
+	if cfg.precision < 1 {
+		panic(fmt.Sprintf("ratelimiter: precision must be positive, got %d", cfg.precision))
+	}
+	if cfg.window < time.Duration(cfg.precision)*time.Millisecond {
+		panic(fmt.Sprintf("ratelimiter: sub-windows must be at least 1ms, got window %s with precision %d", cfg.window, cfg.precision))
+	}
+	cfg.preloadScripts(client)
+	return &SlidingBuckets{client: client, config: cfg}
+}
+
+// subWindow is the request count of one sub-window, identified by its index
+// since the Unix epoch in sub-window sizes.
+type subWindow struct {
+	index int64
+	count int64
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *SlidingBuckets) forKey(ctx context.Context, key string) (*SlidingBuckets, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmSlidingBuckets, Key: key, Err: err}
+	}
+	return &SlidingBuckets{client: l.client, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *SlidingBuckets) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *SlidingBuckets) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *SlidingBuckets) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+func (l *SlidingBuckets) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmSlidingBuckets, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmSlidingBuckets, key, res), nil
+}
+
+func (l *SlidingBuckets) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("sliding", key)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		return l.allowStore(ctx, key, redisKey, n, now)
+	}
+	result, err := slidingBucketsScript.Run(ctx, l.client, []string{redisKey},
+		l.limit, l.size().Milliseconds(), l.precision, now.UnixMilli(), n, l.maxIdle.Milliseconds()).Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingBuckets, key, err)
+	}
+	// Redis Lua returns {allowed, index, count, index, count, ...}
+	if len(result)%2 != 1 {
+		return Result{}, replyError(AlgorithmSlidingBuckets, key, result)
+	}
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return Result{}, replyError(AlgorithmSlidingBuckets, key, result)
+	}
+	windows := make(map[string]string, len(result)/2)
+	for i := 1; i < len(result); i += 2 {
+		windows[fmt.Sprint(result[i])] = fmt.Sprint(result[i+1])
+	}
+	return l.result(allowed == 1, l.live(windows, now), n, now), nil
+}
+
+// allowStore is allowN with WithStore, which keeps each sub-window in a
+// counter of its own. Stores only increment atomically, so the request is
+// counted first and taken back if it didn't fit, as SlidingCounter does;
+// concurrent requests may both be denied, but never both be allowed past
+// the limit. It reads one counter per sub-window.
+func (l *SlidingBuckets) allowStore(ctx context.Context, key, redisKey string, n int64, now time.Time) (Result, error) {
+	windows, _, err := l.storeWindows(ctx, redisKey, now, false)
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingBuckets, key, err)
+	}
+	current := subWindow{index: now.UnixMilli() / l.size().Milliseconds()}
+	currentKey := l.subWindowKey(redisKey, current.index)
+	ttl := max(l.size()*time.Duration(l.precision), l.maxIdle)
+	if current.count, _, err = l.store.Increment(ctx, currentKey, n, ttl); err != nil {
+		return Result{}, storeError(AlgorithmSlidingBuckets, key, err)
+	}
+
+	count := current.count
+	for _, w := range windows {
+		count += w.count
+	}
+	// The count includes this request, which fits if it stays within the limit
+	allowed := count <= l.limit
+	if !allowed {
+		if _, _, err := l.store.Increment(ctx, currentKey, -n, ttl); err != nil {
+			return Result{}, storeError(AlgorithmSlidingBuckets, key, err)
+		}
+		current.count -= n
+	}
+	if current.count > 0 {
+		windows = append(windows, current)
+	}
+	return l.result(allowed, windows, n, now), nil
+}
+
+// storeWindows reads the sub-windows inside the window at now from the
+// Store, oldest first, the current one only if current is set. It also
+// returns how long until the last of them expires.
+func (l *SlidingBuckets) storeWindows(ctx context.Context, redisKey string, now time.Time, current bool) ([]subWindow, time.Duration, error) {
+	newest := now.UnixMilli() / l.size().Milliseconds()
+	if !current {
+		newest--
+	}
+	var windows []subWindow
+	var ttl time.Duration
+	for index := now.UnixMilli()/l.size().Milliseconds() - int64(l.precision) + 1; index <= newest; index++ {
+		count, expiresIn, err := l.store.Counter(ctx, l.subWindowKey(redisKey, index))
+		if err != nil {
+			return nil, 0, err
+		}
+		if count > 0 {
+			windows = append(windows, subWindow{index: index, count: count})
+			ttl = max(ttl, expiresIn)
+		}
+	}
+	return windows, ttl, nil
+}
+
+// subWindowKey returns the Store key of the sub-window at index.
+func (l *SlidingBuckets) subWindowKey(redisKey string, index int64) string {
+	return redisKey + ":" + strconv.FormatInt(index, 10)
+}
+
+// result builds the Result of a request costing n given the live sub-windows.
+func (l *SlidingBuckets) result(allowed bool, windows []subWindow, n int64, now time.Time) Result {
+	var count int64
+	for _, w := range windows {
+		count += w.count
+	}
+	res := Result{
+		Allowed:   allowed,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-count),
+		ResetAt:   now,
+	}
+	if len(windows) > 0 {
+		// The newest sub-window is the last to slide out
+		res.ResetAt = l.expiry(windows[len(windows)-1])
+	}
+	// A cost above the limit can never be allowed
+	if res.Allowed || n > l.limit {
+		return res
+	}
+
+	// Wait for the oldest sub-windows to slide out until the request fits
+	excess := count + n - l.limit
+	for _, w := range windows {
+		excess -= w.count
+		if excess <= 0 {
+			res.RetryAfter = max(0, l.expiry(w).Sub(now))
+			break
+		}
+	}
+	return res
+}
+
+// Check reports the state for key without counting a request.
+func (l *SlidingBuckets) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+
+	redisKey, err := l.key("sliding", key)
+	if err != nil {
+		return Result{}, err
+	}
+	var windows []subWindow
+	if l.store != nil {
+		windows, _, err = l.storeWindows(ctx, redisKey, now, true)
+	} else {
+		var fields map[string]string
+		fields, err = l.client.HGetAll(ctx, redisKey).Result()
+		windows = l.live(fields, now)
+	}
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingBuckets, key, err)
+	}
+	var count int64
+	for _, w := range windows {
+		count += w.count
+	}
+	return l.result(count < l.limit, windows, 1, now), nil
+}
+
+// Reset clears all sub-windows for key.
+func (l *SlidingBuckets) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("sliding", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		// Only the sub-windows inside the window still count
+		current := l.clock.Now().UnixMilli() / l.size().Milliseconds()
+		keys := make([]string, 0, l.precision)
+		for index := current - int64(l.precision) + 1; index <= current; index++ {
+			keys = append(keys, l.subWindowKey(redisKey, index))
+		}
+		if err := l.store.Delete(ctx, keys...); err != nil {
+			return storeError(AlgorithmSlidingBuckets, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmSlidingBuckets, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *SlidingBuckets) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	redisKey, err := l.key("sliding", key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+
+	var windows []subWindow
+	var ttl time.Duration
+	if l.store != nil {
+		if windows, ttl, err = l.storeWindows(ctx, redisKey, now, true); err != nil {
+			return State{}, storeError(AlgorithmSlidingBuckets, key, err)
+		}
+	} else {
+		pipe := l.client.Pipeline()
+		hgetall := pipe.HGetAll(ctx, redisKey)
+		pttl := pipe.PTTL(ctx, redisKey)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return State{}, storeError(AlgorithmSlidingBuckets, key, err)
+		}
+		windows, ttl = l.live(hgetall.Val(), now), max(0, pttl.Val())
+	}
+
+	st := State{
+		Algorithm: AlgorithmSlidingBuckets,
+		Key:       key,
+		Limit:     l.limit,
+		Window:    l.window,
+		// The window covers the current sub-window and the precision-1 before it
+		WindowStart: now.Truncate(l.size()).Add(-l.size() * time.Duration(l.precision-1)),
+		TTL:         ttl,
+	}
+	for _, w := range windows {
+		st.Count += w.count
+		st.SubWindows = append(st.SubWindows, w.count)
+	}
+	return st, nil
+}
+
+// live parses the stored sub-windows and returns those still inside the
+// window at now, oldest first.
+func (l *SlidingBuckets) live(fields map[string]string, now time.Time) []subWindow {
+	oldest := now.UnixMilli()/l.size().Milliseconds() - int64(l.precision) + 1
+	windows := make([]subWindow, 0, len(fields))
+	for field, value := range fields {
+		index, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || index < oldest {
+			continue
+		}
+		count, _ := strconv.ParseInt(value, 10, 64)
+		windows = append(windows, subWindow{index: index, count: count})
+	}
+	slices.SortFunc(windows, func(a, b subWindow) int { return int(a.index - b.index) })
+	return windows
+}
+
+// expiry returns when w slides out of the window.
+func (l *SlidingBuckets) expiry(w subWindow) time.Time {
+	return time.UnixMilli((w.index + int64(l.precision)) * l.size().Milliseconds())
+}
+
+// size returns the length of one sub-window.
+func (l *SlidingBuckets) size() time.Duration {
+	return (l.window / time.Duration(l.precision)).Truncate(time.Millisecond)
+}
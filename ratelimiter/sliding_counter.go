@@ -0,0 +1,432 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Reads both window counters, weighs them and counts the request only if the
+// estimate is below the limit, in one round trip and without letting
+// concurrent requests slip in between the check and the increment.
+var slidingCounterScript = newScript(`
+	local current_key = KEYS[1]
+	local previous_key = KEYS[2]
+	local limit = tonumber(ARGV[1])
+	local weight = tonumber(ARGV[2])
+	local cost = tonumber(ARGV[3])
+	local ttl = tonumber(ARGV[4])
+
+	-- A counter that INCRBY could not have written (not an integer, negative,
+	-- or about to overflow) starts over
+	local healed = 0
+	local function count(key)
+		local n = tonumber(redis.call('GET', key) or 0)
+		if not n or n ~= math.floor(n) or n < 0 or n > 2^53 - cost then
+			redis.call('DEL', key)
+			healed = 1
+			return 0
+		end
+		return n
+	end
+	local current = count(current_key)
+	local previous = count(previous_key)
+
+	if previous * weight + current >= limit then
+		return {0, previous, current, healed}
+	end
+
+	redis.call('INCRBY', current_key, cost)
+	-- Keep data for 2x window to ensure previous window data is available
+	redis.call('PEXPIRE', current_key, ttl)
+	return {1, previous, current, healed}
+`)
+
+// SlidingCounter algorithm
+// Hybrid approach that approximates a sliding window using fixed window counters.
+// More accurate than Fixed Window, more memory efficient than Sliding Log.
+type SlidingCounter struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewSlidingCounter returns a limiter allowing roughly a fixed number of requests in any window.
+// It panics if the options are invalid.
+func NewSlidingCounter(client redis.UniversalClient, opts ...Option) *SlidingCounter {
+	cfg := newConfig(opts)
+	cfg.mustValidateWindow()
+	cfg.mustBeSlotSafe(client, "SlidingCounter")
+	if cfg.window < time.Millisecond {
+		panic(fmt.Sprintf("ratelimiter: window must be at least 1ms, got %s", cfg.window))
+	}
+	cfg.preloadScripts(client)
+	return &SlidingCounter{client: client, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *SlidingCounter) forKey(ctx context.Context, key string) (*SlidingCounter, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmSlidingCounter, Key: key, Err: err}
+	}
+	return &SlidingCounter{client: l.client, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *SlidingCounter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *SlidingCounter) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *SlidingCounter) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+func (l *SlidingCounter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmSlidingCounter, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmSlidingCounter, key, res), nil
+}
+
+func (l *SlidingCounter) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	currentKey, previousKey, err := l.windowKeys(key, now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Only the first unit may push the estimate up to the limit,
+	// so the remaining n-1 units lower the effective limit
+	limit := float64(l.limit - (n - 1))
+
+	if l.store != nil {
+		return l.allowStore(ctx, key, n, limit, now)
+	}
+	if l.noScripts {
+		return l.allowTx(ctx, key, n, limit, now)
+	}
+	result, err := slidingCounterScript.Run(ctx, l.client, []string{currentKey, previousKey},
+		limit, 1-l.progress(now), n, l.idleTTL(l.window*2).Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingCounter, key, err)
+	}
+	// Redis Lua returns {allowed, previous count, current count before this request, healed}
+	if len(result) != 4 {
+		return Result{}, replyError(AlgorithmSlidingCounter, key, result)
+	}
+	if result[3] == 1 {
+		stateHealed(ctx, AlgorithmSlidingCounter, key)
+	}
+	return l.result(result[0] == 1, result[1], result[2], n, limit, now), nil
+}
+
+// allowTx is allowN for WithoutScripts: the same decision as
+// slidingCounterScript, made in Go between WATCH and MULTI/EXEC.
+func (l *SlidingCounter) allowTx(ctx context.Context, key string, n int64, limit float64, now time.Time) (Result, error) {
+	currentKey, previousKey, err := l.windowKeys(key, now)
+	if err != nil {
+		return Result{}, err
+	}
+	var allowed bool
+	var previousCount, currentCount int64
+	err = watch(ctx, l.client, func(tx *redis.Tx) error {
+		var err error
+		if currentCount, err = countOf(tx.Get(ctx, currentKey)); err != nil {
+			return err
+		}
+		if previousCount, err = countOf(tx.Get(ctx, previousKey)); err != nil {
+			return err
+		}
+		allowed = l.estimate(previousCount, currentCount, now) < limit
+		if !allowed {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.IncrBy(ctx, currentKey, n)
+			pipe.PExpire(ctx, currentKey, l.idleTTL(l.window*2))
+			return nil
+		})
+		return err
+	}, currentKey, previousKey)
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingCounter, key, err)
+	}
+	return l.result(allowed, previousCount, currentCount, n, limit, now), nil
+}
+
+// allowStore is allowN with WithStore. Stores only increment atomically, so
+// the request is counted first and taken back if it didn't fit; concurrent
+// requests may briefly see each other's counts and both be denied, but
+// never both be allowed past the limit.
+func (l *SlidingCounter) allowStore(ctx context.Context, key string, n int64, limit float64, now time.Time) (Result, error) {
+	currentKey, previousKey, err := l.windowKeys(key, now)
+	if err != nil {
+		return Result{}, err
+	}
+	previousCount, _, err := l.store.Counter(ctx, previousKey)
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingCounter, key, err)
+	}
+	ttl := l.idleTTL(l.window * 2)
+	currentCount, _, err := l.store.Increment(ctx, currentKey, n, ttl)
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingCounter, key, err)
+	}
+	// The count before this request, as the script compares it
+	currentCount -= n
+	allowed := l.estimate(previousCount, currentCount, now) < limit
+	if !allowed {
+		if _, _, err := l.store.Increment(ctx, currentKey, -n, ttl); err != nil {
+			return Result{}, storeError(AlgorithmSlidingCounter, key, err)
+		}
+	}
+	return l.result(allowed, previousCount, currentCount, n, limit, now), nil
+}
+
+// result builds the Result of a request costing n, checked against limit,
+// given the window counts before it.
+func (l *SlidingCounter) result(allowed bool, previousCount, currentCount, n int64, limit float64, now time.Time) Result {
+	estimatedCount := l.estimate(previousCount, currentCount, now)
+
+	windowStart := l.windowStart(now)
+	if !allowed {
+		return Result{
+			Limit:      l.limit,
+			ResetAt:    resetAt(windowStart, l.window, previousCount, currentCount, now),
+			RetryAfter: retryAfter(now, windowStart, l.window, limit, previousCount, currentCount),
+		}
+	}
+
+	return Result{
+		Allowed:   true,
+		Limit:     l.limit,
+		Remaining: max(0, int64(float64(l.limit)-estimatedCount-float64(n))),
+		// The request just counted stops weighing once the next window is over
+		ResetAt: windowStart.Add(2 * l.window),
+	}
+}
+
+// Check reports the state for key without counting a request.
+func (l *SlidingCounter) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	_, previousCount, currentCount, estimatedCount, err := l.counts(ctx, key, now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	windowStart := l.windowStart(now)
+	res := Result{
+		Allowed:   estimatedCount < float64(l.limit),
+		Limit:     l.limit,
+		Remaining: max(0, int64(float64(l.limit)-estimatedCount)),
+		ResetAt:   resetAt(windowStart, l.window, previousCount, currentCount, now),
+	}
+	if !res.Allowed {
+		res.RetryAfter = retryAfter(now, windowStart, l.window, float64(l.limit), previousCount, currentCount)
+	}
+	return res, nil
+}
+
+// Reset clears both window counters for key.
+// Older windows no longer affect the estimate and expire on their own.
+func (l *SlidingCounter) Reset(ctx context.Context, key string) error {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	currentKey, previousKey, err := l.windowKeys(key, l.clock.Now())
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, currentKey, previousKey); err != nil {
+			return storeError(AlgorithmSlidingCounter, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, currentKey, previousKey).Err(); err != nil {
+		return storeError(AlgorithmSlidingCounter, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *SlidingCounter) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+	ttl, previousCount, currentCount, estimatedCount, err := l.counts(ctx, key, now)
+	if err != nil {
+		return State{}, err
+	}
+
+	return State{
+		Algorithm:     AlgorithmSlidingCounter,
+		Key:           key,
+		Limit:         l.limit,
+		Window:        l.window,
+		Count:         currentCount,
+		PreviousCount: previousCount,
+		Estimate:      estimatedCount,
+		WindowStart:   l.windowStart(now),
+		TTL:           ttl,
+	}, nil
+}
+
+// counts reads both window counters for key and estimates the number of
+// requests in the sliding window ending at now. It also returns how long
+// until the current counter expires.
+func (l *SlidingCounter) counts(ctx context.Context, key string, now time.Time) (ttl time.Duration, previousCount, currentCount int64, estimatedCount float64, err error) {
+	currentKey, previousKey, err := l.windowKeys(key, now)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if l.store != nil {
+		if currentCount, ttl, err = l.store.Counter(ctx, currentKey); err != nil {
+			return 0, 0, 0, 0, storeError(AlgorithmSlidingCounter, key, err)
+		}
+		if previousCount, _, err = l.store.Counter(ctx, previousKey); err != nil {
+			return 0, 0, 0, 0, storeError(AlgorithmSlidingCounter, key, err)
+		}
+		return ttl, previousCount, currentCount, l.estimate(previousCount, currentCount, now), nil
+	}
+
+	// Get counts from both windows in one round trip; a missing window is
+	// zero, but any other error must not read as zero traffic
+	pipe := l.client.Pipeline()
+	current := pipe.Get(ctx, currentKey)
+	previous := pipe.Get(ctx, previousKey)
+	pttl := pipe.PTTL(ctx, currentKey)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, 0, 0, storeError(AlgorithmSlidingCounter, key, err)
+	}
+	if currentCount, err = countOf(current); err != nil {
+		return 0, 0, 0, 0, replyError(AlgorithmSlidingCounter, key, current.Val())
+	}
+	if previousCount, err = countOf(previous); err != nil {
+		return 0, 0, 0, 0, replyError(AlgorithmSlidingCounter, key, previous.Val())
+	}
+	return max(0, pttl.Val()), previousCount, currentCount, l.estimate(previousCount, currentCount, now), nil
+}
+
+// countOf returns the value of a window counter read with GET, zero if it does not exist.
+func countOf(cmd *redis.StringCmd) (int64, error) {
+	count, err := cmd.Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
+
+// estimate returns the weighted number of requests in the sliding window
+// ending at now, from the counts of the previous and current windows.
+func (l *SlidingCounter) estimate(previousCount, currentCount int64, now time.Time) float64 {
+	// Estimate total requests using weighted average
+	// Example: previousCount=4, currentCount=2, progress=0.4
+	// 4 * (1-0.4) + 2 = 4 * 0.6 + 2 = 2.4 + 2 = 4.4 requests
+	return float64(previousCount)*(1-l.progress(now)) + float64(currentCount)
+}
+
+// progress returns how far into the current window now is (0.0 to 1.0).
+func (l *SlidingCounter) progress(now time.Time) float64 {
+	// Example: 1705329824500 ms with 10s window
+	// 1705329824500 % 10000 = 4500 ms into window
+	// 4500 / 10000 = 0.45 (45% through the window)
+	return float64(now.UnixMilli()%l.window.Milliseconds()) / float64(l.window.Milliseconds())
+}
+
+// resetAt returns when neither window counter weighs on the estimate anymore.
+func resetAt(windowStart time.Time, window time.Duration, previous, current int64, now time.Time) time.Time {
+	switch {
+	case current > 0:
+		return windowStart.Add(2 * window)
+	case previous > 0:
+		return windowStart.Add(window)
+	default:
+		return now
+	}
+}
+
+// retryAfter computes how long until the weighted estimate drops below the limit.
+// Within the current window only the previous window's weight decreases:
+//
+//	previous * (1-p) + current < limit  =>  p > 1 - (limit-current)/previous
+//
+// If the current window alone has reached the limit, we have to wait until it
+// becomes the previous window and its weight decreases enough:
+//
+//	current * (1-p) < limit  =>  p > 1 - limit/current
+func retryAfter(now, windowStart time.Time, window time.Duration, limit float64, previous, current int64) time.Duration {
+	// A cost above the limit can never be allowed
+	if limit <= 0 {
+		return 0
+	}
+
+	var at time.Time
+	if float64(current) < limit {
+		p := 1 - (limit-float64(current))/float64(previous)
+		at = windowStart.Add(time.Duration(p * float64(window)))
+	} else {
+		p := 1 - limit/float64(current)
+		at = windowStart.Add(window).Add(time.Duration(p * float64(window)))
+	}
+	// The estimate must drop below the limit, not to it, and progress moves
+	// in whole milliseconds
+	return max(time.Millisecond, at.Sub(now))
+}
+
+// windowKeys returns the Redis keys of the current and previous windows at now.
+// Keys are named after the start of their window in Unix seconds, or in
+// Unix milliseconds for windows that are not whole seconds.
+func (l *SlidingCounter) windowKeys(key string, now time.Time) (currentKey, previousKey string, err error) {
+	// e.g. 1705329824 with 10s window -> current 1705329820, previous 1705329810
+	current := l.windowStart(now)
+	previous := current.Add(-l.window)
+
+	name := func(start time.Time) string {
+		if l.window%time.Second == 0 {
+			return strconv.FormatInt(start.Unix(), 10)
+		}
+		return strconv.FormatInt(start.UnixMilli(), 10) + "ms"
+	}
+	if currentKey, err = l.subKey("counter", key, name(current)); err != nil {
+		return "", "", err
+	}
+	if previousKey, err = l.subKey("counter", key, name(previous)); err != nil {
+		return "", "", err
+	}
+	return currentKey, previousKey, nil
+}
+
+// windowStart returns the start of the window containing now.
+// Windows are aligned on the Unix epoch in whole milliseconds, so any
+// window length, e.g. 250ms or 90s, splits time the same way everywhere.
+func (l *SlidingCounter) windowStart(now time.Time) time.Time {
+	ms, window := now.UnixMilli(), l.window.Milliseconds()
+	return time.UnixMilli(ms - ms%window)
+}
@@ -0,0 +1,186 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Windows that are not whole seconds, or longer than a minute, must be
+// aligned on the epoch in milliseconds like any other.
+func TestSlidingCounterWindows(t *testing.T) {
+	for _, window := range []time.Duration{250 * time.Millisecond, 90 * time.Second, time.Hour} {
+		t.Run(window.String(), func(t *testing.T) {
+			clock := newFakeClock()
+			l := NewSlidingCounter(nil, WithLimit(10), WithWindow(window), WithClock(clock))
+			start := clock.Now()
+
+			// 40% into the window, and into the one after it
+			for _, at := range []time.Duration{window * 4 / 10, window * 14 / 10} {
+				now := start.Add(at)
+				want := start.Add(at.Truncate(window))
+				if got := l.windowStart(now); !got.Equal(want) {
+					t.Errorf("windowStart at +%s = %s, want %s", at, got, want)
+				}
+				if got := l.progress(now); got < 0.399 || got > 0.401 {
+					t.Errorf("progress at +%s = %v, want 0.4", at, got)
+				}
+			}
+
+			currentKey, previousKey, err := l.windowKeys("k", start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if currentKey == previousKey {
+				t.Fatalf("current and previous window share the key %q", currentKey)
+			}
+			if subSecond := window%time.Second != 0; strings.HasSuffix(currentKey, "ms") != subSecond {
+				t.Errorf("window key %q, want milliseconds only for windows that aren't whole seconds", currentKey)
+			}
+		})
+	}
+}
+
+func TestSlidingCounterAllow(t *testing.T) {
+	ctx := context.Background()
+	backends := map[string]func(t *testing.T, clock Clock) (redis.UniversalClient, []Option){
+		"script": func(t *testing.T, clock Clock) (redis.UniversalClient, []Option) {
+			_, client := newRedis(t)
+			return client, nil
+		},
+		"transaction": func(t *testing.T, clock Clock) (redis.UniversalClient, []Option) {
+			_, client := newRedis(t)
+			return client, []Option{WithoutScripts()}
+		},
+		"store": func(t *testing.T, clock Clock) (redis.UniversalClient, []Option) {
+			store := NewMemoryStore()
+			store.SetClock(clock)
+			return nil, []Option{WithStore(store)}
+		},
+	}
+	for _, window := range []time.Duration{250 * time.Millisecond, 90 * time.Second, time.Hour} {
+		for backend, setup := range backends {
+			t.Run(window.String()+"/"+backend, func(t *testing.T) {
+				clock := newFakeClock()
+				start := clock.Now()
+				client, opts := setup(t, clock)
+				l := NewSlidingCounter(client, append(opts, WithLimit(10), WithWindow(window), WithClock(clock))...)
+
+				allow := func(want int) {
+					t.Helper()
+					for i := range want + 1 {
+						res, err := l.Allow(ctx, "k")
+						if err != nil {
+							t.Fatal(err)
+						}
+						if res.Allowed != (i < want) {
+							t.Fatalf("request %d at +%s allowed = %v, want %d allowed", i, clock.Now().Sub(start), res.Allowed, want)
+						}
+						if !res.Allowed && (res.RetryAfter <= 0 || res.RetryAfter > window) {
+							t.Fatalf("RetryAfter = %s, want within the %s window", res.RetryAfter, window)
+						}
+					}
+				}
+
+				allow(10)
+				// The full previous window weighs on the start of the next one
+				clock.Advance(window)
+				allow(0)
+				// Half of it remains halfway through
+				clock.Advance(window / 2)
+				allow(5)
+				// Two windows later nothing is left
+				clock.Advance(2 * window)
+				allow(10)
+			})
+		}
+	}
+}
+
+// A failing Redis must not read as zero traffic: the error reaches the
+// caller, or the store error policy decides.
+func TestSlidingCounterStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	backends := map[string]func(t *testing.T) (redis.UniversalClient, []Option){
+		"script": func(t *testing.T) (redis.UniversalClient, []Option) {
+			mr, client := newRedis(t)
+			mr.SetError("ERR injected failure")
+			return client, nil
+		},
+		"transaction": func(t *testing.T) (redis.UniversalClient, []Option) {
+			mr, client := newRedis(t)
+			mr.SetError("ERR injected failure")
+			return client, []Option{WithoutScripts()}
+		},
+		"unreachable": func(t *testing.T) (redis.UniversalClient, []Option) {
+			return newDeadRedis(t), nil
+		},
+		"store": func(t *testing.T) (redis.UniversalClient, []Option) {
+			return nil, []Option{WithStore(failingStore{})}
+		},
+	}
+	policies := []struct {
+		name    string
+		policy  StoreErrorPolicy
+		allowed bool
+	}{
+		{"none", nil, false},
+		{"FailOpen", FailOpen, true},
+		{"FailClosed", FailClosed, false},
+	}
+	for backend, setup := range backends {
+		for _, p := range policies {
+			t.Run(backend+"/"+p.name, func(t *testing.T) {
+				client, opts := setup(t)
+				opts = append(opts, WithLimit(10), WithWindow(time.Minute))
+				if p.policy != nil {
+					opts = append(opts, WithOnStoreError(p.policy))
+				}
+				l := NewSlidingCounter(client, opts...)
+
+				res, err := l.Allow(ctx, "k")
+				if p.policy == nil {
+					if !errors.Is(err, ErrStoreUnavailable) {
+						t.Fatalf("Allow error = %v, want ErrStoreUnavailable", err)
+					}
+				} else if err != nil {
+					t.Fatalf("Allow error = %v, want the policy to decide", err)
+				}
+				if res.Allowed != p.allowed {
+					t.Errorf("Allow = %+v, want allowed %v", res, p.allowed)
+				}
+
+				// Check and Inspect report the error whatever the policy
+				if _, err := l.Check(ctx, "k"); !errors.Is(err, ErrStoreUnavailable) {
+					t.Errorf("Check error = %v, want ErrStoreUnavailable", err)
+				}
+				if _, err := l.Inspect(ctx, "k"); !errors.Is(err, ErrStoreUnavailable) {
+					t.Errorf("Inspect error = %v, want ErrStoreUnavailable", err)
+				}
+			})
+		}
+	}
+}
+
+// A counter holding something other than a count is an error, not zero.
+func TestSlidingCounterCorruptedCount(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	clock := newFakeClock()
+	l := NewSlidingCounter(client, WithLimit(10), WithWindow(time.Minute), WithClock(clock))
+
+	_, previousKey, err := l.windowKeys("k", clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mr.Set(previousKey, "lots"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := l.Check(ctx, "k"); err == nil {
+		t.Fatalf("Check = %+v, want an error for the unparsable count", res)
+	}
+}
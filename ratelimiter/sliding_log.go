@@ -0,0 +1,602 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Trimming, counting and logging happen in one script, so two concurrent
+// requests cannot both pass the count check and exceed the limit.
+// Each unit of cost is logged as its own member, made unique by the request
+// id so requests within the same millisecond do not overwrite each other.
+var slidingLogScript = newScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+	local ttl = tonumber(ARGV[5])
+	local id = ARGV[6]
+
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+	local count = redis.call('ZCARD', key)
+
+	if count + cost > limit then
+		-- cost slots are free once the entry at index count+cost-limit-1 has expired
+		local retry = 0
+		if count > 0 then
+			local i = math.min(count + cost - limit - 1, count - 1)
+			local oldest = redis.call('ZRANGE', key, i, i, 'WITHSCORES')
+			retry = tonumber(oldest[2]) + window - now
+		end
+		local reset = now
+		local newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+		if #newest > 0 then
+			reset = tonumber(newest[2]) + window
+		end
+		return {0, count, retry, reset}
+	end
+
+	-- Add the members in chunks, as unpack fails on a few thousand values
+	for first = 1, cost, 1000 do
+		local args = {}
+		for i = first, math.min(first + 999, cost) do
+			args[#args + 1] = now
+			args[#args + 1] = now .. ':' .. id .. ':' .. i
+		end
+		redis.call('ZADD', key, unpack(args))
+	end
+	-- Reset TTL for cleanup of inactive users
+	redis.call('PEXPIRE', key, math.max(window, ttl))
+
+	return {1, count + cost, 0, now + window}
+`)
+
+// Sliding log bounded to max_entries members per key. When the log grows
+// beyond that, its oldest entries are collapsed into one entry carrying their
+// combined weight, encoded as "<weight>x<timestamp>:<id>". The collapsed entry
+// takes the newest timestamp of the group, so it expires no earlier than the
+// requests it stands for and the limit is never exceeded, only enforced a
+// little longer for them.
+var boundedLogScript = newScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+	local max_entries = tonumber(ARGV[5])
+	local ttl = tonumber(ARGV[6])
+	local id = ARGV[7]
+
+	local function weight(member)
+		return tonumber(string.match(member, '^(%d+)x')) or 1
+	end
+
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+	local entries = redis.call('ZRANGE', key, 0, -1, 'WITHSCORES')
+	local count = 0
+	for i = 1, #entries, 2 do
+		count = count + weight(entries[i])
+	end
+
+	if count + cost > limit then
+		-- Free the oldest entries until the request fits
+		local retry = 0
+		local excess = count + cost - limit
+		for i = 1, #entries, 2 do
+			excess = excess - weight(entries[i])
+			if excess <= 0 then
+				retry = tonumber(entries[i + 1]) + window - now
+				break
+			end
+		end
+		local reset = now
+		if #entries > 0 then
+			reset = tonumber(entries[#entries]) + window
+		end
+		return {0, count, retry, reset}
+	end
+
+	local member = now .. ':' .. id
+	if cost > 1 then
+		member = cost .. 'x' .. member
+	end
+	redis.call('ZADD', key, now, member)
+
+	local excess = redis.call('ZCARD', key) - max_entries
+	if excess > 0 then
+		-- Collapse the oldest excess+1 entries into one
+		local oldest = redis.call('ZRANGE', key, 0, excess, 'WITHSCORES')
+		local total = 0
+		for i = 1, #oldest, 2 do
+			total = total + weight(oldest[i])
+		end
+		local score = oldest[#oldest]
+		redis.call('ZREMRANGEBYRANK', key, 0, excess)
+		redis.call('ZADD', key, score, total .. 'x' .. score .. ':' .. id)
+	end
+	redis.call('PEXPIRE', key, math.max(window, ttl))
+
+	return {1, count + cost, 0, now + window}
+`)
+
+// SlidingLog algorithm
+// Stores timestamp of each request in a sorted set.
+// Provides accurate rate limiting but uses more memory (one entry per request).
+// WithMaxEntries bounds the memory per key by collapsing old entries.
+// With WithStore the log is kept as a list of entries (see logState).
+type SlidingLog struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewSlidingLog returns a limiter allowing a fixed number of requests in any window.
+// It panics if the options are invalid.
+func NewSlidingLog(client redis.UniversalClient, opts ...Option) *SlidingLog {
+	cfg := newConfig(opts)
+	cfg.mustValidateWindow()
+	cfg.preloadScripts(client)
+	return &SlidingLog{client: client, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *SlidingLog) forKey(ctx context.Context, key string) (*SlidingLog, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmSlidingLog, Key: key, Err: err}
+	}
+	return &SlidingLog{client: l.client, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *SlidingLog) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *SlidingLog) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *SlidingLog) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+// A request costing n is logged as n entries.
+func (l *SlidingLog) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmSlidingLog, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmSlidingLog, key, res), nil
+}
+
+func (l *SlidingLog) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	id, err := randomID()
+	if err != nil {
+		return Result{}, &Error{Algorithm: AlgorithmSlidingLog, Key: key, Err: err}
+	}
+	now := l.clock.Now().UnixMilli()
+	if l.store != nil {
+		return l.allowStore(ctx, key, n, now)
+	}
+	if l.noScripts {
+		return l.allowTx(ctx, key, n, now, id)
+	}
+
+	redisKey, err := l.key("log", key)
+	if err != nil {
+		return Result{}, err
+	}
+	var cmd *redis.Cmd
+	if l.maxLog > 0 {
+		cmd = boundedLogScript.Run(ctx, l.client, []string{redisKey},
+			l.limit, l.window.Milliseconds(), now, n, l.maxLog, l.maxIdle.Milliseconds(), id)
+	} else {
+		cmd = slidingLogScript.Run(ctx, l.client, []string{redisKey},
+			l.limit, l.window.Milliseconds(), now, n, l.maxIdle.Milliseconds(), id)
+	}
+	result, err := cmd.Int64Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingLog, key, err)
+	}
+	// Redis Lua returns {allowed, count, retry after ms, reset at ms}
+	if len(result) != 4 {
+		return Result{}, replyError(AlgorithmSlidingLog, key, result)
+	}
+	return Result{
+		Allowed:    result[0] == 1,
+		Limit:      l.limit,
+		Remaining:  max(0, l.limit-result[1]),
+		ResetAt:    time.UnixMilli(result[3]),
+		RetryAfter: time.Duration(result[2]) * time.Millisecond,
+	}, nil
+}
+
+// allowTx is allowN for WithoutScripts: the same decision as
+// slidingLogScript, made in Go between WATCH and MULTI/EXEC.
+func (l *SlidingLog) allowTx(ctx context.Context, key string, n, now int64, id string) (Result, error) {
+	redisKey, err := l.key("log", key)
+	if err != nil {
+		return Result{}, err
+	}
+	windowStart := now - l.window.Milliseconds()
+	// Exclusive lower bound, matching the entries the script keeps
+	minScore := fmt.Sprintf("(%d", windowStart)
+	res := Result{Limit: l.limit, ResetAt: time.UnixMilli(now).Add(l.window)}
+	err = watch(ctx, l.client, func(tx *redis.Tx) error {
+		entries, err := tx.ZRangeByScoreWithScores(ctx, redisKey, &redis.ZRangeBy{Min: minScore, Max: "+inf"}).Result()
+		if err != nil {
+			return err
+		}
+		count := int64(len(entries))
+
+		if count+n > l.limit {
+			res.Allowed = false
+			res.ResetAt = time.UnixMilli(now)
+			// n slots are free once the entry at index count+n-limit-1 has expired
+			if count > 0 {
+				i := min(count+n-l.limit-1, count-1)
+				res.RetryAfter = time.Duration(int64(entries[i].Score)+l.window.Milliseconds()-now) * time.Millisecond
+				res.ResetAt = time.UnixMilli(int64(entries[count-1].Score)).Add(l.window)
+			}
+			return nil
+		}
+
+		members := make([]redis.Z, n)
+		for i := range members {
+			members[i] = redis.Z{Score: float64(now), Member: fmt.Sprintf("%d:%s:%d", now, id, i+1)}
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRemRangeByScore(ctx, redisKey, "-inf", strconv.FormatInt(windowStart, 10))
+			pipe.ZAdd(ctx, redisKey, members...)
+			pipe.PExpire(ctx, redisKey, l.idleTTL(l.window))
+			return nil
+		})
+		res.Allowed = true
+		res.Remaining = l.limit - count - n
+		return err
+	}, redisKey)
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingLog, key, err)
+	}
+	return res, nil
+}
+
+// allowStore is allowN for WithStore: the same decision as
+// boundedLogScript, made in Go on the log kept in the Store.
+func (l *SlidingLog) allowStore(ctx context.Context, key string, n, now int64) (Result, error) {
+	storeKey, err := l.key("log", key)
+	if err != nil {
+		return Result{}, err
+	}
+	window := l.window.Milliseconds()
+	var res Result
+	err = updateState(ctx, l.store, storeKey, func(log *logState, _ time.Duration) (bool, time.Duration) {
+		log.trim(now - window)
+		count := log.count()
+		if count+n > l.limit {
+			res = log.result(count+n-l.limit, l.limit, window, now)
+			return false, 0
+		}
+
+		log.Entries = append(log.Entries, logEntry{At: now, Cost: n})
+		if l.maxLog > 0 {
+			log.collapse(l.maxLog)
+		}
+		res = Result{
+			Allowed:   true,
+			Limit:     l.limit,
+			Remaining: l.limit - count - n,
+			ResetAt:   time.UnixMilli(now + window),
+		}
+		return true, l.idleTTL(l.window)
+	})
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingLog, key, err)
+	}
+	return res, nil
+}
+
+// Check reports the state for key without logging a request.
+func (l *SlidingLog) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	if l.store != nil {
+		now := l.clock.Now().UnixMilli()
+		log, _, err := l.storedLog(ctx, key, now)
+		if err != nil {
+			return Result{}, err
+		}
+		count := log.count()
+		if count < l.limit {
+			res := log.result(0, l.limit, l.window.Milliseconds(), now)
+			res.Allowed = true
+			return res, nil
+		}
+		return log.result(count+1-l.limit, l.limit, l.window.Milliseconds(), now), nil
+	}
+	if l.maxLog > 0 {
+		return l.checkBounded(ctx, key)
+	}
+	redisKey, err := l.key("log", key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now().UnixMilli()
+	// Exclusive lower bound, matching the entries Allow would keep
+	windowStart := fmt.Sprintf("(%d", now-l.window.Milliseconds())
+
+	pipe := l.client.Pipeline()
+	card := pipe.ZCount(ctx, redisKey, windowStart, "+inf")
+	oldest := pipe.ZRangeByScoreWithScores(ctx, redisKey, &redis.ZRangeBy{Min: windowStart, Max: "+inf", Count: 1})
+	newest := pipe.ZRangeWithScores(ctx, redisKey, -1, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Result{}, storeError(AlgorithmSlidingLog, key, err)
+	}
+	count := card.Val()
+
+	res := Result{
+		Allowed:   count < l.limit,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-count),
+		ResetAt:   time.UnixMilli(now),
+	}
+	if n := newest.Val(); count > 0 && len(n) > 0 {
+		res.ResetAt = time.UnixMilli(int64(n[0].Score)).Add(l.window)
+	}
+	if o := oldest.Val(); !res.Allowed && len(o) > 0 {
+		res.RetryAfter = time.Duration(int64(o[0].Score)+l.window.Milliseconds()-now) * time.Millisecond
+	}
+	return res, nil
+}
+
+// checkBounded is Check for a log bounded by WithMaxEntries, whose entries
+// may stand for several requests.
+func (l *SlidingLog) checkBounded(ctx context.Context, key string) (Result, error) {
+	now := l.clock.Now().UnixMilli()
+	redisKey, err := l.key("log", key)
+	if err != nil {
+		return Result{}, err
+	}
+	entries, err := l.client.ZRangeByScoreWithScores(ctx, redisKey, &redis.ZRangeBy{
+		Min: fmt.Sprintf("(%d", now-l.window.Milliseconds()),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return Result{}, storeError(AlgorithmSlidingLog, key, err)
+	}
+	count := logCount(entries)
+
+	res := Result{
+		Allowed:   count < l.limit,
+		Limit:     l.limit,
+		Remaining: max(0, l.limit-count),
+		ResetAt:   time.UnixMilli(now),
+	}
+	if len(entries) > 0 {
+		res.ResetAt = time.UnixMilli(int64(entries[len(entries)-1].Score)).Add(l.window)
+	}
+	if !res.Allowed {
+		// Free the oldest entries until one more request fits
+		excess := count + 1 - l.limit
+		for _, e := range entries {
+			excess -= logWeight(e.Member)
+			if excess <= 0 {
+				res.RetryAfter = time.Duration(int64(e.Score)+l.window.Milliseconds()-now) * time.Millisecond
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// logCount returns the number of requests the log entries stand for.
+func logCount(entries []redis.Z) int64 {
+	var count int64
+	for _, e := range entries {
+		count += logWeight(e.Member)
+	}
+	return count
+}
+
+// logWeight returns the number of requests a log entry stands for:
+// one, or the weight of a "<weight>x..." entry written by boundedLogScript.
+func logWeight(member any) int64 {
+	s := fmt.Sprint(member)
+	i := strings.IndexByte(s, 'x')
+	if i < 0 {
+		return 1
+	}
+	w, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 1
+	}
+	return w
+}
+
+// logState is the log of a key kept in a Store, oldest entry first.
+// An entry stands for one request, or for several once collapsed by
+// WithMaxEntries.
+type logState struct {
+	Entries []logEntry `json:"entries"`
+}
+
+// logEntry is the time of a request in unix ms and its cost.
+type logEntry struct {
+	At   int64 `json:"at"`
+	Cost int64 `json:"cost"`
+}
+
+// trim removes the entries logged at or before start.
+func (s *logState) trim(start int64) {
+	i := 0
+	for i < len(s.Entries) && s.Entries[i].At <= start {
+		i++
+	}
+	s.Entries = s.Entries[i:]
+}
+
+// count returns the number of requests the entries stand for.
+func (s *logState) count() int64 {
+	var count int64
+	for _, e := range s.Entries {
+		count += e.Cost
+	}
+	return count
+}
+
+// collapse merges the oldest entries into one until at most maxEntries are left.
+// Like in boundedLogScript, the merged entry takes the newest time of the
+// group, so it expires no earlier than the requests it stands for.
+func (s *logState) collapse(maxEntries int64) {
+	excess := int64(len(s.Entries)) - maxEntries
+	if excess <= 0 {
+		return
+	}
+	merged := logEntry{At: s.Entries[excess].At}
+	for _, e := range s.Entries[:excess+1] {
+		merged.Cost += e.Cost
+	}
+	s.Entries = append([]logEntry{merged}, s.Entries[excess+1:]...)
+}
+
+// result returns the Result denying a request at now that needs the oldest
+// excess requests of the log to expire first.
+func (s *logState) result(excess, limit, window, now int64) Result {
+	count := s.count()
+	res := Result{
+		Limit:     limit,
+		Remaining: max(0, limit-count),
+		ResetAt:   time.UnixMilli(now),
+	}
+	if len(s.Entries) == 0 {
+		return res
+	}
+	res.ResetAt = time.UnixMilli(s.Entries[len(s.Entries)-1].At + window)
+	if excess <= 0 {
+		return res
+	}
+	// A request costing more than the limit is given the time until the log is empty
+	oldest := s.Entries[len(s.Entries)-1]
+	for _, e := range s.Entries {
+		if excess -= e.Cost; excess <= 0 {
+			oldest = e
+			break
+		}
+	}
+	res.RetryAfter = time.Duration(oldest.At+window-now) * time.Millisecond
+	return res
+}
+
+// storedLog returns the entries of the log kept in the Store for key that
+// are still in the window at now, and how long until the log expires.
+func (l *SlidingLog) storedLog(ctx context.Context, key string, now int64) (logState, time.Duration, error) {
+	storeKey, err := l.key("log", key)
+	if err != nil {
+		return logState{}, 0, err
+	}
+	var log logState
+	var ttl time.Duration
+	err = updateState(ctx, l.store, storeKey, func(stored *logState, expiresIn time.Duration) (bool, time.Duration) {
+		log, ttl = *stored, expiresIn
+		return false, 0
+	})
+	if err != nil {
+		return logState{}, 0, storeError(AlgorithmSlidingLog, key, err)
+	}
+	log.trim(now - l.window.Milliseconds())
+	return log, ttl, nil
+}
+
+// Reset clears the sorted set for key.
+func (l *SlidingLog) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("log", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmSlidingLog, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmSlidingLog, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *SlidingLog) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	redisKey, err := l.key("log", key)
+	if err != nil {
+		return State{}, err
+	}
+	now := l.clock.Now()
+	windowStart := now.Add(-l.window)
+
+	if l.store != nil {
+		log, ttl, err := l.storedLog(ctx, key, now.UnixMilli())
+		if err != nil {
+			return State{}, err
+		}
+		return State{
+			Algorithm:   AlgorithmSlidingLog,
+			Key:         key,
+			Limit:       l.limit,
+			Window:      l.window,
+			Count:       log.count(),
+			WindowStart: windowStart,
+			TTL:         ttl,
+		}, nil
+	}
+
+	minScore := fmt.Sprintf("(%d", windowStart.UnixMilli())
+
+	pipe := l.client.Pipeline()
+	card := pipe.ZCount(ctx, redisKey, minScore, "+inf")
+	// Entries of a bounded log may stand for several requests
+	var entries *redis.ZSliceCmd
+	if l.maxLog > 0 {
+		entries = pipe.ZRangeByScoreWithScores(ctx, redisKey, &redis.ZRangeBy{Min: minScore, Max: "+inf"})
+	}
+	pttl := pipe.PTTL(ctx, redisKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return State{}, storeError(AlgorithmSlidingLog, key, err)
+	}
+	count := card.Val()
+	if entries != nil {
+		count = logCount(entries.Val())
+	}
+
+	return State{
+		Algorithm:   AlgorithmSlidingLog,
+		Key:         key,
+		Limit:       l.limit,
+		Window:      l.window,
+		Count:       count,
+		WindowStart: windowStart,
+		TTL:         max(0, pttl.Val()),
+	}, nil
+}
@@ -0,0 +1,37 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Every unit of a large cost is logged, beyond what Lua can unpack at once.
+func TestSlidingLogLargeCost(t *testing.T) {
+	ctx := context.Background()
+	for name, noScripts := range map[string]bool{"script": false, "transaction": true} {
+		t.Run(name, func(t *testing.T) {
+			mr, client := newRedis(t)
+			l := NewSlidingLog(client, WithLimit(50000), WithWindow(time.Minute))
+			l.noScripts = noScripts
+
+			res, err := l.AllowN(ctx, "k", 20000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Allowed || res.Remaining != 30000 {
+				t.Fatalf("AllowN(20000) = %+v, want allowed with 30000 remaining", res)
+			}
+			redisKey, err := l.key("log", "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if members, err := mr.ZMembers(redisKey); err != nil || len(members) != 20000 {
+				t.Errorf("log holds %d members, %v, want 20000", len(members), err)
+			}
+			if res, err := l.AllowN(ctx, "k", 30001); err != nil || res.Allowed {
+				t.Errorf("AllowN over the limit = %+v, %v, want denied", res, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Store keeps limiter state in a backend other than Redis, e.g. memory or
+// a database, set with WithStore. Each method is one atomic operation on
+// the state of one key, so a backend only implements these instead of
+// running the Lua scripts: windows are counters, and token, leaky and
+// multi-limit buckets are token buckets, updated one at a time and given
+// back when a request doesn't fit. Limiters keeping logs, leases or learned
+// rates encode them as opaque state updated with Update.
+// MemoryStore keeps the state in process memory.
+// Keys are the ones built by the key options, e.g. "api:bucket:user:123".
+//
+// Implementations must be safe for concurrent use. Limiters report their
+// errors wrapped in ErrStoreUnavailable, like Redis errors.
+type Store interface {
+	// Increment adds n to the counter at key and returns the new count and
+	// how long until the counter expires. A counter that doesn't exist
+	// starts from zero and expires after ttl.
+	Increment(ctx context.Context, key string, n int64, ttl time.Duration) (count int64, expiresIn time.Duration, err error)
+	// Counter returns the count at key and how long until it expires,
+	// both zero if the counter doesn't exist.
+	Counter(ctx context.Context, key string) (count int64, expiresIn time.Duration, err error)
+	// TakeTokens refills the bucket at key up to now and takes cost tokens
+	// from it as b.Take does, storing the new state only when they were
+	// taken. A bucket that doesn't exist is full. It returns whether the
+	// tokens were taken and the tokens left.
+	TakeTokens(ctx context.Context, key string, b Bucket, cost float64, now time.Time) (allowed bool, tokens float64, err error)
+	// Tokens returns the tokens in the bucket at key at now, without
+	// taking any.
+	Tokens(ctx context.Context, key string, b Bucket, now time.Time) (float64, error)
+	// Update runs fn on the state stored at key and stores what it returns.
+	// fn gets the state, nil if there is none or it expired, and how long
+	// until it expires; it returns the state to store and how long to keep
+	// it, or nil to leave the stored state as it was. fn may run several
+	// times when other updates of key conflict with it, and only its last
+	// run counts. state is only valid until fn returns.
+	Update(ctx context.Context, key string, fn func(state []byte, expiresIn time.Duration) ([]byte, time.Duration)) error
+	// Delete removes the state stored at keys. Missing keys are ignored.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Bucket describes the token bucket a Store operates on: it holds up to
+// Capacity tokens and refills Rate of them per second, continuously or in
+// steps of Interval. Requests may borrow up to Debt tokens beyond an empty
+// bucket, and reservations (Reserve) always take their tokens while the cost
+// fits. Stores keep an idle bucket until it would have refilled completely,
+// and for at least MaxIdle.
+type Bucket struct {
+	Capacity float64
+	Rate     float64
+	Interval time.Duration
+	Debt     float64
+	Reserve  bool
+	MaxIdle  time.Duration
+}
+
+// Take computes a request costing cost on a bucket holding tokens when it
+// was last refilled at last, the way the Lua scripts do, for Store
+// implementations. A negative cost gives tokens back, never beyond capacity.
+// It returns whether the tokens were taken, and the tokens and last refill
+// time to store; a denied request leaves the stored state as it was.
+func (b Bucket) Take(tokens float64, last time.Time, cost float64, now time.Time) (bool, float64, time.Time) {
+	tokens, last = b.refill(tokens, last, now)
+	if cost < 0 {
+		return true, min(b.Capacity, tokens-cost), last
+	}
+	if cost > b.Capacity+b.Debt || (tokens-cost < -b.Debt && !b.Reserve) {
+		return false, tokens, last
+	}
+	return true, tokens - cost, last
+}
+
+// Tokens returns the tokens in a bucket at now, given the tokens it held
+// when it was last refilled at last.
+func (b Bucket) Tokens(tokens float64, last, now time.Time) float64 {
+	tokens, _ = b.refill(tokens, last, now)
+	return tokens
+}
+
+// TTL returns how long a Store keeps a bucket left with tokens: until it
+// would have refilled completely, and at least MaxIdle.
+func (b Bucket) TTL(tokens float64) time.Duration {
+	refill := (b.Capacity - min(tokens, 0)) / b.Rate
+	if b.Interval > 0 {
+		iv := b.Interval.Seconds()
+		refill = math.Ceil(refill/iv)*iv + iv
+	}
+	return max(b.MaxIdle, durationOf(refill))
+}
+
+// refill returns the tokens and refill time of a bucket at now.
+// With an interval only whole intervals count, and last stays on the interval grid.
+func (b Bucket) refill(tokens float64, last, now time.Time) (float64, time.Time) {
+	elapsed := max(0, now.Sub(last))
+	if b.Interval > 0 {
+		elapsed = elapsed.Truncate(b.Interval)
+		last = last.Add(elapsed)
+	} else {
+		last = now
+	}
+	return min(b.Capacity, tokens+elapsed.Seconds()*b.Rate), last
+}
+
+// updateState runs fn on the state of type T stored as JSON at key with
+// store.Update. fn gets the zero T if nothing is stored or the stored state
+// can't be decoded, which starts over like the Lua scripts do, and how long
+// until it expires. It returns whether to store the state it changed and for
+// how long.
+func updateState[T any](ctx context.Context, store Store, key string, fn func(state *T, expiresIn time.Duration) (bool, time.Duration)) error {
+	var encodeErr error
+	err := store.Update(ctx, key, func(stored []byte, expiresIn time.Duration) ([]byte, time.Duration) {
+		var state T
+		if stored != nil && json.Unmarshal(stored, &state) != nil {
+			var zero T
+			state = zero
+		}
+		write, ttl := fn(&state, expiresIn)
+		if !write {
+			return nil, 0
+		}
+		next, err := json.Marshal(state)
+		encodeErr = err
+		if err != nil {
+			return nil, 0
+		}
+		return next, ttl
+	})
+	if err != nil {
+		return err
+	}
+	return encodeErr
+}
@@ -0,0 +1,489 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newStoreLimiters returns every limiter that runs on a Store, each
+// allowing 3 requests at once and refilled within a day.
+func newStoreLimiters(store Store, clock Clock) map[string]Limiter {
+	with := func(opts ...Option) []Option {
+		return append([]Option{WithStore(store), WithClock(clock)}, opts...)
+	}
+	perSecond := Limit{Requests: 3, Window: time.Second}
+
+	return map[string]Limiter{
+		"FixedWindow":    NewFixedWindow(nil, with(WithLimit(3), WithWindow(time.Second))...),
+		"SlidingCounter": NewSlidingCounter(nil, with(WithLimit(3), WithWindow(time.Second))...),
+		"SlidingBuckets": NewSlidingBuckets(nil, with(WithLimit(3), WithWindow(time.Second), WithPrecision(10))...),
+		"TokenBucket":    NewTokenBucket(nil, with(WithRate(3), WithBurst(3))...),
+		"GCRA":           NewGCRA(nil, with(WithRate(3), WithBurst(3))...),
+		"LeakyBucket":    NewLeakyBucket(nil, with(WithRate(3), WithBurst(3))...),
+		"Quota":          NewQuota(nil, Daily, with(WithLimit(3))...),
+		"MultiLimiter":   NewMultiLimiter(nil, []Limit{perSecond, {Requests: 100, Window: time.Hour}}, with()...),
+		"Bandwidth":      NewBandwidth(nil, perSecond, Limit{Requests: 1 << 20, Window: time.Second}, with()...),
+		"Hierarchical":   NewHierarchical(nil, Limit{Requests: 100, Window: time.Second}, perSecond, with()...).Child("tenant"),
+		"SlidingLog":     NewSlidingLog(nil, with(WithLimit(3), WithWindow(time.Second))...),
+		"EWMA":           NewEWMA(nil, with(WithLimit(3), WithWindow(time.Second))...),
+		"Adaptive":       NewAdaptive(nil, AIMD{MinRate: 1, MaxRate: 3, Increase: 1, Decrease: 0.5}, with(WithBurst(3))...),
+		"Priority":       NewPriority(nil, []float64{0.5, 0.5}, with(WithLimit(6), WithWindow(time.Second))...).Class(1),
+		"Penalized": NewPenalized(nil, NewFixedWindow(nil, with(WithLimit(3), WithWindow(time.Second))...),
+			PenaltyPolicy{Denials: 10, Within: time.Second, Cooldown: time.Second, MaxCooldown: time.Minute}, with()...),
+	}
+}
+
+func TestStoreLimiters(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+
+	for name, l := range newStoreLimiters(store, clock) {
+		t.Run(name, func(t *testing.T) {
+			for i := range 3 {
+				res, err := l.Allow(ctx, name)
+				if err != nil {
+					t.Fatalf("Allow %d: %v", i, err)
+				}
+				if !res.Allowed {
+					t.Fatalf("Allow %d denied, want allowed: %+v", i, res)
+				}
+			}
+
+			res, err := l.Allow(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed || res.RetryAfter <= 0 {
+				t.Fatalf("Allow over the limit = %+v, want denied with a RetryAfter", res)
+			}
+			if res, err := l.Check(ctx, name); err != nil || res.Allowed {
+				t.Fatalf("Check over the limit = %+v, %v, want denied", res, err)
+			}
+			if res, err := l.Allow(ctx, "other"); err != nil || !res.Allowed {
+				t.Fatalf("Allow for another key = %+v, %v, want allowed", res, err)
+			}
+
+			if err := l.Reset(ctx, name); err != nil {
+				t.Fatal(err)
+			}
+			if res, err := l.Allow(ctx, name); err != nil || !res.Allowed {
+				t.Fatalf("Allow after Reset = %+v, %v, want allowed", res, err)
+			}
+		})
+	}
+
+	// Every limit has refilled or moved on by then
+	clock.Advance(24 * time.Hour)
+	for name, l := range newStoreLimiters(store, clock) {
+		if res, err := l.Check(ctx, name); err != nil || !res.Allowed || res.Remaining != 3 {
+			t.Errorf("%s: Check a day later = %+v, %v, want all 3 requests remaining", name, res, err)
+		}
+	}
+}
+
+func TestStoreSlidingBucketsSlides(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	l := NewSlidingBuckets(nil, WithStore(store), WithClock(clock),
+		WithLimit(4), WithWindow(time.Second), WithPrecision(10))
+
+	// Two requests in the first sub-window, two half a window later
+	for _, at := range []time.Duration{0, 0, 500 * time.Millisecond, 0} {
+		clock.Advance(at)
+		if res, err := l.Allow(ctx, "k"); err != nil || !res.Allowed {
+			t.Fatalf("Allow = %+v, %v, want allowed", res, err)
+		}
+	}
+	res, err := l.Allow(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("Allow over the limit = %+v, want denied until the first sub-window slides out in 500ms", res)
+	}
+
+	clock.Advance(res.RetryAfter)
+	st, err := l.Inspect(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Count != 2 {
+		t.Errorf("Count after the first sub-window slid out = %d, want 2", st.Count)
+	}
+	if res, err := l.AllowN(ctx, "k", 2); err != nil || !res.Allowed {
+		t.Fatalf("AllowN(2) = %+v, %v, want allowed", res, err)
+	}
+}
+
+func TestStoreLeakyBucketReservation(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	l := NewLeakyBucket(nil, WithStore(store), WithClock(clock), WithRate(2), WithCapacity(4))
+
+	if _, err := l.AllowN(ctx, "k", 2); err != nil {
+		t.Fatal(err)
+	}
+	r, err := l.ReserveN(ctx, "k", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || r.Delay() != time.Second {
+		t.Fatalf("ReserveN = ok %v, delay %s, want ok after 1s for the requests queued before", r.OK(), r.Delay())
+	}
+	if r, _ := l.ReserveN(ctx, "k", 1); r.OK() {
+		t.Fatal("ReserveN on a full bucket is OK, want not OK")
+	}
+
+	r.Cancel(ctx)
+	st, err := l.Inspect(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Level != 2 {
+		t.Errorf("Level after Cancel = %v, want 2", st.Level)
+	}
+}
+
+func TestStoreMultiLimiterGivesBack(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	l := NewMultiLimiter(nil, []Limit{
+		{Requests: 10, Window: time.Second},
+		{Requests: 2, Window: time.Hour},
+	}, WithStore(store), WithClock(clock))
+
+	for range 2 {
+		if res, err := l.Allow(ctx, "k"); err != nil || !res.Allowed {
+			t.Fatalf("Allow = %+v, %v, want allowed", res, err)
+		}
+	}
+	// Denied by the hourly limit, which must not use up the per-second one
+	for range 5 {
+		if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed {
+			t.Fatalf("Allow over the hourly limit = %+v, %v, want denied", res, err)
+		}
+	}
+	st, err := l.Inspect(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := st.Limits[0].Tokens; got != 8 {
+		t.Errorf("per-second tokens = %v, want 8 after two allowed requests", got)
+	}
+}
+
+func TestStoreChain(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	l := NewChain(nil, []ChainLink{
+		{Name: "ip", Limit: Limit{Requests: 1, Window: time.Second}},
+		{Name: "user", Limit: Limit{Requests: 5, Window: time.Second}},
+	}, WithStore(store), WithClock(clock))
+
+	if res, err := l.Allow(ctx, "203.0.113.7", "alice"); err != nil || !res.Allowed {
+		t.Fatalf("Allow = %+v, %v, want allowed", res, err)
+	}
+	if res, err := l.Allow(ctx, "203.0.113.7", "alice"); err != nil || res.Allowed {
+		t.Fatalf("Allow over the ip limit = %+v, %v, want denied", res, err)
+	}
+	res, err := l.Check(ctx, "198.51.100.1", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Remaining != 1 {
+		t.Errorf("Check from another ip = %+v, want 1 remaining in the fresh ip link", res)
+	}
+	if err := l.Reset(ctx, "203.0.113.7", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := l.Allow(ctx, "203.0.113.7", "alice"); err != nil || !res.Allowed {
+		t.Fatalf("Allow after Reset = %+v, %v, want allowed", res, err)
+	}
+}
+
+// The limiters without Store operations of their own make the same
+// decisions on a Store as their Lua scripts make in Redis.
+func TestStoreMatchesScripts(t *testing.T) {
+	ctx := context.Background()
+	window := []Option{WithLimit(5), WithWindow(time.Second)}
+	limiters := map[string]func(client redis.UniversalClient, opts ...Option) Limiter{
+		"SlidingLog": func(client redis.UniversalClient, opts ...Option) Limiter {
+			return NewSlidingLog(client, append(opts, window...)...)
+		},
+		"EWMA": func(client redis.UniversalClient, opts ...Option) Limiter {
+			return NewEWMA(client, append(opts, window...)...)
+		},
+		"Adaptive": func(client redis.UniversalClient, opts ...Option) Limiter {
+			return NewAdaptive(client, AIMD{MinRate: 1, MaxRate: 4, Increase: 1, Decrease: 0.5}, append(opts, WithBurst(5))...)
+		},
+		"Priority": func(client redis.UniversalClient, opts ...Option) Limiter {
+			return NewPriority(client, []float64{0.4, 0.2}, append(opts, window...)...).Class(1)
+		},
+	}
+	steps := []struct {
+		advance time.Duration
+		cost    int64
+	}{
+		{0, 1}, {0, 2}, {100 * time.Millisecond, 1}, {0, 1}, {0, 1},
+		{300 * time.Millisecond, 2}, {0, 1}, {650 * time.Millisecond, 1},
+		{0, 3}, {0, 1}, {time.Second, 4}, {200 * time.Millisecond, 2}, {0, 6},
+	}
+
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			mr, client := newRedis(t)
+			store := NewMemoryStore()
+			store.SetClock(clock)
+			scripts := newLimiter(client, WithClock(clock))
+			stored := newLimiter(nil, WithStore(store), WithClock(clock))
+
+			for i, step := range steps {
+				clock.Advance(step.advance)
+				mr.FastForward(step.advance)
+				want, err := scripts.AllowN(ctx, "k", step.cost)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := stored.AllowN(ctx, "k", step.cost)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Allowed != want.Allowed || got.Remaining != want.Remaining ||
+					(got.RetryAfter-want.RetryAfter).Abs() > time.Millisecond {
+					t.Fatalf("step %d: AllowN(%d) on the Store = %+v, want %+v as in Redis", i, step.cost, got, want)
+				}
+			}
+		})
+	}
+}
+
+// Collapsed entries expire with the newest request they stand for.
+func TestStoreSlidingLogBounded(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	l := NewSlidingLog(nil, WithStore(store), WithClock(clock),
+		WithLimit(5), WithWindow(time.Second), WithMaxEntries(2))
+
+	for _, at := range []time.Duration{0, 100 * time.Millisecond, 100 * time.Millisecond} {
+		clock.Advance(at)
+		if res, err := l.Allow(ctx, "k"); err != nil || !res.Allowed {
+			t.Fatalf("Allow = %+v, %v, want allowed", res, err)
+		}
+	}
+	if st, err := l.Inspect(ctx, "k"); err != nil || st.Count != 3 {
+		t.Fatalf("Inspect = %+v, %v, want 3 requests in 2 entries", st, err)
+	}
+
+	// The first request is out of the window, but it was collapsed with the second
+	clock.Advance(850 * time.Millisecond)
+	res, err := l.AllowN(ctx, "k", 3)
+	if err != nil || res.Allowed || res.RetryAfter != 50*time.Millisecond {
+		t.Fatalf("AllowN(3) = %+v, %v, want denied until the collapsed entry expires in 50ms", res, err)
+	}
+	clock.Advance(res.RetryAfter)
+	if res, err := l.AllowN(ctx, "k", 3); err != nil || !res.Allowed || res.Remaining != 1 {
+		t.Fatalf("AllowN(3) once the collapsed entry expired = %+v, %v, want allowed with 1 remaining", res, err)
+	}
+}
+
+func TestStoreAdaptiveReports(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	_, client := newRedis(t)
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	aimd := AIMD{MinRate: 1, MaxRate: 8, Increase: 0.5, Decrease: 0.5}
+	scripts := NewAdaptive(client, aimd, WithClock(clock))
+	stored := NewAdaptive(nil, aimd, WithStore(store), WithClock(clock))
+
+	for i, failed := range []bool{true, true, false, false, true, false} {
+		var err error
+		if failed {
+			err = errors.New("upstream failed")
+		}
+		want, werr := scripts.ReportResult(ctx, "k", err)
+		got, gerr := stored.ReportResult(ctx, "k", err)
+		if werr != nil || gerr != nil {
+			t.Fatal(werr, gerr)
+		}
+		if got != want {
+			t.Fatalf("report %d: rate on the Store = %v, want %v as in Redis", i, got, want)
+		}
+	}
+	st, err := stored.Inspect(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Rate != 2 || st.TTL <= 0 {
+		t.Errorf("Inspect = %+v, want the learned rate 2 with a TTL", st)
+	}
+}
+
+func TestStoreConcurrency(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	l := NewConcurrency(nil, WithStore(store), WithClock(clock), WithLimit(2), WithLeaseTimeout(time.Second))
+
+	first, err := l.Acquire(ctx, "k")
+	if err != nil || !first.OK() {
+		t.Fatalf("Acquire = %+v, %v, want acquired", first, err)
+	}
+	second, err := l.Acquire(ctx, "k")
+	if err != nil || !second.OK() || second.Result().Remaining != 0 {
+		t.Fatalf("Acquire = %+v, %v, want the last slot", second.Result(), err)
+	}
+	if lease, err := l.Acquire(ctx, "k"); err != nil || lease.OK() {
+		t.Fatalf("Acquire with all slots held = %+v, %v, want denied", lease.Result(), err)
+	}
+
+	if err := second.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Heartbeat(ctx, "k", second.ID()); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Heartbeat after Release = %v, want ErrLeaseLost", err)
+	}
+	if st, err := l.Inspect(ctx, "k"); err != nil || st.Count != 1 || st.TTL <= 0 {
+		t.Errorf("Inspect after Release = %+v, %v, want 1 slot held", st, err)
+	}
+
+	// A holder sending heartbeats keeps its slot, one that stopped loses it
+	third, err := l.Acquire(ctx, "k")
+	if err != nil || !third.OK() {
+		t.Fatalf("Acquire after Release = %+v, %v, want acquired", third.Result(), err)
+	}
+	clock.Advance(600 * time.Millisecond)
+	if err := first.Heartbeat(ctx); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(600 * time.Millisecond)
+	if err := third.Heartbeat(ctx); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Heartbeat after the lease timeout = %v, want ErrLeaseLost", err)
+	}
+	if res, err := l.Check(ctx, "k"); err != nil || !res.Allowed || res.Remaining != 1 {
+		t.Errorf("Check = %+v, %v, want the slot of the silent holder freed", res, err)
+	}
+}
+
+func TestStoreAdaptiveConcurrency(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	_, client := newRedis(t)
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	gradient := Gradient{MinLimit: 1, MaxLimit: 20, Tolerance: 1.5, Smoothing: 0.5, Window: 10}
+	opts := []Option{WithClock(clock), WithLimit(4), WithLeaseTimeout(time.Minute)}
+	scripts := NewAdaptiveConcurrency(client, gradient, opts...)
+	stored := NewAdaptiveConcurrency(nil, gradient, append(opts, WithStore(store))...)
+
+	for i, d := range []time.Duration{10, 10, 12, 40, 80, 80, 15, 10} {
+		want, werr := scripts.ReportLatency(ctx, "k", d*time.Millisecond)
+		got, gerr := stored.ReportLatency(ctx, "k", d*time.Millisecond)
+		if werr != nil || gerr != nil {
+			t.Fatal(werr, gerr)
+		}
+		if got != want {
+			t.Fatalf("report %d: cap on the Store = %d, want %d as in Redis", i, got, want)
+		}
+	}
+
+	limit, err := stored.Check(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range limit.Limit {
+		if lease, err := stored.Acquire(ctx, "k"); err != nil || !lease.OK() {
+			t.Fatalf("Acquire %d under a cap of %d = %+v, %v, want acquired", i, limit.Limit, lease.Result(), err)
+		}
+	}
+	if lease, err := stored.Acquire(ctx, "k"); err != nil || lease.OK() {
+		t.Fatalf("Acquire over the cap = %+v, %v, want denied", lease.Result(), err)
+	}
+
+	if err := stored.Reset(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := stored.Inspect(ctx, "k"); err != nil || st.Count != 0 || st.Limit != 4 {
+		t.Errorf("Inspect after Reset = %+v, %v, want no slots held and the initial cap", st, err)
+	}
+}
+
+func TestStorePenalized(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	with := []Option{WithStore(store), WithClock(clock)}
+	l := NewPenalized(nil, NewFixedWindow(nil, append(with, WithLimit(1), WithWindow(time.Second))...),
+		PenaltyPolicy{Denials: 2, Within: time.Second, Cooldown: 10 * time.Second, MaxCooldown: time.Minute}, with...)
+
+	if res, err := l.Allow(ctx, "k"); err != nil || !res.Allowed {
+		t.Fatalf("Allow = %+v, %v, want allowed", res, err)
+	}
+	for range 2 {
+		if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed {
+			t.Fatalf("Allow over the limit = %+v, %v, want denied", res, err)
+		}
+	}
+
+	// The window is over, but the second denial started a cooldown
+	clock.Advance(time.Second)
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Allowed || res.RetryAfter != 9*time.Second {
+		t.Fatalf("Allow in cooldown = %+v, %v, want denied for the 9s left", res, err)
+	}
+	if res, err := l.Check(ctx, "k"); err != nil || res.Allowed {
+		t.Fatalf("Check in cooldown = %+v, %v, want denied", res, err)
+	}
+
+	if err := l.Reset(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := l.Allow(ctx, "k"); err != nil || !res.Allowed {
+		t.Fatalf("Allow after Reset = %+v, %v, want allowed", res, err)
+	}
+}
+
+func TestStoreIdempotent(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	store.SetClock(clock)
+	with := []Option{WithStore(store), WithClock(clock)}
+	l := NewIdempotent(nil, NewFixedWindow(nil, append(with, WithLimit(2), WithWindow(time.Minute))...), time.Minute, with...)
+
+	for i, delivery := range []struct {
+		id      string
+		allowed bool
+	}{
+		{"a", true}, {"a", true}, {"b", true}, {"c", false}, {"a", true}, {"c", false},
+	} {
+		res, err := l.Allow(WithRequestID(context.Background(), delivery.id), "k")
+		if err != nil || res.Allowed != delivery.allowed {
+			t.Fatalf("delivery %d of %q = %+v, %v, want allowed %v", i, delivery.id, res, err, delivery.allowed)
+		}
+	}
+
+	// Decisions are forgotten after the dedup TTL
+	clock.Advance(time.Minute)
+	if res, err := l.Allow(WithRequestID(context.Background(), "c"), "k"); err != nil || !res.Allowed {
+		t.Fatalf("redelivery of %q after the TTL = %+v, %v, want decided again", "c", res, err)
+	}
+}
@@ -0,0 +1,785 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TTLPolicy controls how long idle bucket keys are kept in Redis.
+// With Adaptive enabled, a key starts with a short TTL and every allowed request
+// extends it by Min, up to Max. One-off keys (e.g. sporadic scanner IPs) are
+// reclaimed quickly while keys with steady traffic keep their state around.
+// The TTL never drops below the time needed to refill the bucket completely,
+// so an expired key is indistinguishable from a full one.
+// Without a policy, keys are kept for WithMaxIdle.
+//
+// Ceiling, if set, caps the TTL even below the refill time, trading exactness
+// for memory on slow buckets (capacity 1000 at 0.1/s takes almost 3 hours
+// to refill): a key that expires early reads as a full bucket.
+type TTLPolicy struct {
+	Min      time.Duration
+	Max      time.Duration
+	Adaptive bool
+	Ceiling  time.Duration
+}
+
+// AdaptiveTTLPolicy scales bucket TTLs from a minute up to an hour with traffic.
+var AdaptiveTTLPolicy = TTLPolicy{
+	Min:      time.Minute,
+	Max:      time.Hour,
+	Adaptive: true,
+}
+
+// Version of the token bucket state layout and script, stored in the "v" field.
+// Bump it whenever the script starts writing new fields, and add a step to the
+// migrations in the script that fills them in for state written by the
+// previous version. Scripts must only add fields and must never lower the
+// stored marker, so during a rolling deploy older processes keep working on
+// state written by newer ones and vice versa. A layout change that old
+// scripts cannot read needs a new key prefix instead.
+//
+// Versions: 0 stored tokens and last; 1 added hits and the marker.
+const tokenBucketSchema = 1
+
+// Using Lua script to ensure race conditions don't occur
+// when multiple clients try to access the same resource at the same time.
+// The script is executed atomically, so only one client can execute it at a time.
+var tokenBucketScript = newScript(`
+	local key = KEYS[1]
+	local capacity = tonumber(ARGV[1])
+	local rate = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local min_ttl = tonumber(ARGV[4])
+	local max_ttl = tonumber(ARGV[5])
+	local schema = tonumber(ARGV[6])
+	-- The cost may be fractional, e.g. 0.5 for cheap requests
+	local cost = tonumber(ARGV[7])
+	-- Reservations always take their tokens and may leave the bucket in debt
+	local reserve = tonumber(ARGV[8]) == 1
+	-- Requests may borrow up to debt tokens, paid back by a longer refill
+	local debt = tonumber(ARGV[9] or 0)
+	-- With an interval, tokens are added in chunks of rate * interval at the
+	-- end of each interval instead of continuously
+	local interval = tonumber(ARGV[10] or 0)
+	local ceiling = tonumber(ARGV[11] or 0)
+
+	local state = redis.call('HMGET', key, 'tokens', 'last', 'hits', 'v')
+	local tokens = tonumber(state[1] or capacity)
+	local last = tonumber(state[2] or now)
+	local hits = tonumber(state[3] or 0)
+	-- State written before versioning has no marker and is treated as version 0
+	local version = tonumber(state[4] or 0)
+
+	-- Start over from a full bucket if the state is unusable: non-numeric or
+	-- non-finite fields, or only one of tokens and last. Negative balances are
+	-- legitimate, left by reservations and debt.
+	local function finite(x)
+		return x ~= nil and x == x and x ~= math.huge and x ~= -math.huge
+	end
+	local healed = 0
+	if not (finite(tokens) and finite(last) and finite(hits) and finite(version))
+		or (state[1] == false) ~= (state[2] == false) then
+		redis.call('DEL', key)
+		tokens, last, hits, version, healed = capacity, now, 0, 0, 1
+	end
+
+	-- Migrate state written by older versions, one version at a time
+	if version < 1 and state[1] and not state[3] then
+		-- Version 0 did not count hits. Treat its keys as established traffic,
+		-- so the adaptive TTL doesn't shrink them back to the minimum.
+		hits = math.ceil(max_ttl / math.max(min_ttl, 1))
+	end
+
+	local elapsed = now - last
+	if interval > 0 then
+		-- Only whole intervals count, and last stays on the interval grid
+		elapsed = math.floor(elapsed / interval) * interval
+		last = last + elapsed
+	else
+		last = now
+	end
+	tokens = math.min(capacity, tokens + elapsed * rate)
+
+	-- Floats are truncated when converted to Redis replies, so return tokens as a string
+	if cost > capacity + debt or (tokens - cost < -debt and not reserve) then
+		return {0, tostring(tokens), healed}
+	end
+
+	tokens = tokens - cost
+	-- Bounded so the adaptive TTL never overflows
+	hits = math.min(hits + 1, 1000000000)
+	redis.call('HMSET', key, 'tokens', tokens, 'last', last, 'hits', hits)
+	if version < schema then
+		redis.call('HSET', key, 'v', schema)
+	end
+
+	-- Never expire before the bucket would have refilled completely,
+	-- counted from last, which trails now by up to an interval
+	local refill = (capacity - math.min(tokens, 0)) / rate
+	if interval > 0 then
+		refill = math.ceil(refill / interval) * interval + interval
+	end
+	local ttl = max_ttl
+	if min_ttl < max_ttl then
+		ttl = math.min(max_ttl, min_ttl * hits)
+	end
+	ttl = math.max(ttl, math.ceil(refill))
+	if ceiling > 0 then
+		ttl = math.min(ttl, ceiling)
+	end
+	redis.call('EXPIRE', key, ttl)
+
+	return {1, tostring(tokens), healed}
+`)
+
+// Gives tokens back to a bucket, never exceeding its capacity.
+var tokenBucketRefundScript = newScript(`
+	local key = KEYS[1]
+	local capacity = tonumber(ARGV[1])
+	local rate = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+	local refund = tonumber(ARGV[4])
+	local interval = tonumber(ARGV[5] or 0)
+
+	local tokens = tonumber(redis.call('HGET', key, 'tokens'))
+	local last = tonumber(redis.call('HGET', key, 'last') or now)
+	if tokens == nil or tokens ~= tokens or last ~= last then
+		-- Nothing usable stored, so the bucket is full
+		redis.call('DEL', key)
+		return tostring(capacity)
+	end
+
+	local elapsed = now - last
+	if interval > 0 then
+		elapsed = math.floor(elapsed / interval) * interval
+		last = last + elapsed
+	else
+		last = now
+	end
+	tokens = math.min(capacity, tokens + elapsed * rate + refund)
+	redis.call('HSET', key, 'tokens', tokens, 'last', last)
+
+	return tostring(tokens)
+`)
+
+// TokenBucket algorithm
+// Implements a token bucket with a fixed capacity and a refill rate.
+// Allows bursts of traffic up to capacity but refills over time.
+// More accurate than Fixed Window and Sliding Window Counter.
+type TokenBucket struct {
+	client redis.UniversalClient
+	config
+}
+
+// NewTokenBucket returns a bucket holding up to WithBurst tokens
+// and refilling WithRate tokens per second.
+// It panics if the options are invalid.
+func NewTokenBucket(client redis.UniversalClient, opts ...Option) *TokenBucket {
+	cfg := newConfig(opts)
+	cfg.mustValidateBucket()
+	cfg.preloadScripts(client)
+	return &TokenBucket{client: client, config: cfg}
+}
+
+// forKey returns a copy of l using the limit that applies to key.
+func (l *TokenBucket) forKey(ctx context.Context, key string) (*TokenBucket, error) {
+	cfg, err := l.config.forKey(ctx, key)
+	if err != nil {
+		return nil, &Error{Algorithm: AlgorithmTokenBucket, Key: key, Err: err}
+	}
+	return &TokenBucket{client: l.client, config: cfg}, nil
+}
+
+// Allow implements Limiter.
+func (l *TokenBucket) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (l *TokenBucket) Wait(ctx context.Context, key string) error {
+	return waitN(ctx, l, l.clock, key, 1, l.maxDelay)
+}
+
+// WaitN is like Wait for a request costing n.
+func (l *TokenBucket) WaitN(ctx context.Context, key string, n int64) error {
+	return waitN(ctx, l, l.clock, key, n, l.maxDelay)
+}
+
+// AllowN implements Limiter.
+// The request costs n tokens.
+func (l *TokenBucket) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmTokenBucket, key, n); err != nil {
+		return Result{}, err
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err != nil {
+		return l.storeFailed(ctx, key, err)
+	}
+	return l.decide(ctx, AlgorithmTokenBucket, key, res), nil
+}
+
+func (l *TokenBucket) allowN(ctx context.Context, key string, n int64) (Result, error) {
+	_, res, err := l.allowCost(ctx, key, float64(n))
+	return res, err
+}
+
+// AllowCost is like AllowN for a fractional cost, so one bucket can govern
+// endpoints of different weight, e.g. 0.5 tokens for a cheap read and 5 for
+// an expensive export. It also returns the exact number of tokens left,
+// which Result.Remaining rounds down.
+func (l *TokenBucket) AllowCost(ctx context.Context, key string, cost float64) (Result, float64, error) {
+	if err := costError(AlgorithmTokenBucket, key, cost); err != nil {
+		return Result{}, 0, err
+	}
+	tokens, res, err := l.allowCost(ctx, key, cost)
+	if err != nil {
+		res, err = l.storeFailed(ctx, key, err)
+		return res, 0, err
+	}
+	return l.decide(ctx, AlgorithmTokenBucket, key, res), tokens, nil
+}
+
+func (l *TokenBucket) allowCost(ctx context.Context, key string, cost float64) (float64, Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return 0, Result{}, err
+	}
+	now := l.clock.Now()
+	allowed, tokens, err := l.take(ctx, key, cost, now, false)
+	if err != nil {
+		return 0, Result{}, err
+	}
+	return tokens, l.result(allowed, tokens, cost, now), nil
+}
+
+// result builds the Result of a request costing n that left tokens in the bucket.
+func (l *TokenBucket) result(allowed bool, tokens, n float64, now time.Time) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int64(l.capacity),
+		Remaining: max(0, int64(tokens)),
+		// Time to refill the missing tokens
+		ResetAt: now.Add(l.refillTime(l.capacity - tokens)),
+	}
+	if !res.Allowed {
+		res.RetryAfter = l.refillTime(n - l.debt - tokens)
+	}
+	return res
+}
+
+// AllowMany checks one request for each key, evaluating all of them in a
+// single pipelined round trip. Results are in the order of keys.
+func (l *TokenBucket) AllowMany(ctx context.Context, keys []string) ([]Result, error) {
+	if l.noScripts || l.store != nil {
+		return allowEach(ctx, l, keys)
+	}
+	now := l.clock.Now()
+	buckets := make([]*TokenBucket, len(keys))
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		b, err := l.forKey(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if redisKeys[i], err = b.key("bucket", key); err != nil {
+			return nil, err
+		}
+		buckets[i] = b
+	}
+
+	run := func() ([]*redis.Cmd, error) {
+		pipe := l.client.Pipeline()
+		cmds := make([]*redis.Cmd, len(keys))
+		for i, b := range buckets {
+			cmds[i] = tokenBucketScript.EvalSha(ctx, pipe, []string{redisKeys[i]}, b.scriptArgs(1, now, false)...)
+		}
+		_, err := pipe.Exec(ctx)
+		return cmds, err
+	}
+	cmds, err := run()
+	// Load the script once and retry rather than sending its source per key
+	if redis.HasErrorPrefix(err, "NOSCRIPT") {
+		if err := tokenBucketScript.Load(ctx, l.client).Err(); err != nil {
+			return nil, storeError(AlgorithmTokenBucket, keys[0], err)
+		}
+		cmds, _ = run()
+	}
+
+	results := make([]Result, len(keys))
+	for i, key := range keys {
+		res, err := buckets[i].batchResult(ctx, key, cmds[i], now)
+		if err != nil {
+			if results[i], err = l.storeFailed(ctx, key, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		results[i] = l.decide(ctx, AlgorithmTokenBucket, key, res)
+	}
+	return results, nil
+}
+
+// batchResult parses the reply of a pipelined tokenBucketScript call.
+func (l *TokenBucket) batchResult(ctx context.Context, key string, cmd *redis.Cmd, now time.Time) (Result, error) {
+	result, err := cmd.Slice()
+	if err != nil {
+		return Result{}, storeError(AlgorithmTokenBucket, key, err)
+	}
+	allowed, tokens, err := parseBucketReply(ctx, key, result)
+	if err != nil {
+		return Result{}, err
+	}
+	return l.result(allowed, tokens, 1, now), nil
+}
+
+// Check reports the state for key without taking tokens.
+func (l *TokenBucket) Check(ctx context.Context, key string) (Result, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	now := l.clock.Now()
+	redisKey, err := l.key("bucket", key)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var tokens float64
+	if l.store != nil {
+		tokens, err = l.store.Tokens(ctx, redisKey, l.bucket(false), now)
+	} else {
+		var state []any
+		if state, err = l.client.HMGet(ctx, redisKey, "tokens", "last").Result(); err == nil {
+			tokens = refill(state, l.capacity, l.rate, l.interval, now)
+		}
+	}
+	if err != nil {
+		return Result{}, storeError(AlgorithmTokenBucket, key, err)
+	}
+
+	return l.result(tokens-1 >= -l.debt, tokens, 1, now), nil
+}
+
+// Reset clears the bucket hash for key.
+func (l *TokenBucket) Reset(ctx context.Context, key string) error {
+	redisKey, err := l.key("bucket", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.Delete(ctx, redisKey); err != nil {
+			return storeError(AlgorithmTokenBucket, key, err)
+		}
+		return nil
+	}
+	if err := l.client.Del(ctx, redisKey).Err(); err != nil {
+		return storeError(AlgorithmTokenBucket, key, err)
+	}
+	return nil
+}
+
+// Inspect implements Inspector.
+func (l *TokenBucket) Inspect(ctx context.Context, key string) (State, error) {
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return State{}, err
+	}
+	if l.store != nil {
+		return l.inspectStore(ctx, key)
+	}
+	redisKey, err := l.key("bucket", key)
+	if err != nil {
+		return State{}, err
+	}
+	st, err := inspectBucket(ctx, l.client, redisKey, l.capacity, l.rate, l.interval, l.clock.Now())
+	if err != nil {
+		return State{}, storeError(AlgorithmTokenBucket, key, err)
+	}
+	st.Algorithm = AlgorithmTokenBucket
+	st.Key = key
+	return st, nil
+}
+
+// inspectStore is Inspect with WithStore, which only exposes the tokens
+// available now.
+func (l *TokenBucket) inspectStore(ctx context.Context, key string) (State, error) {
+	now := l.clock.Now()
+	redisKey, err := l.key("bucket", key)
+	if err != nil {
+		return State{}, err
+	}
+	tokens, err := l.store.Tokens(ctx, redisKey, l.bucket(false), now)
+	if err != nil {
+		return State{}, storeError(AlgorithmTokenBucket, key, err)
+	}
+	return State{
+		Algorithm:  AlgorithmTokenBucket,
+		Key:        key,
+		Limit:      int64(l.capacity),
+		Tokens:     tokens,
+		Available:  tokens,
+		LastRefill: now,
+	}, nil
+}
+
+// inspectBucket reads the state of the bucket stored at redisKey.
+func inspectBucket(ctx context.Context, client redis.UniversalClient, redisKey string, capacity, rate float64, interval time.Duration, now time.Time) (State, error) {
+	pipe := client.Pipeline()
+	hmget := pipe.HMGet(ctx, redisKey, "tokens", "last", "v")
+	pttl := pipe.PTTL(ctx, redisKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return State{}, err
+	}
+	state := hmget.Val()
+
+	st := State{
+		Limit:     int64(capacity),
+		Tokens:    capacity,
+		Available: refill(state, capacity, rate, interval, now),
+		TTL:       max(0, pttl.Val()),
+	}
+	if tokens, err := strconv.ParseFloat(fmt.Sprint(state[0]), 64); err == nil {
+		st.Tokens = tokens
+	}
+	if last, err := strconv.ParseFloat(fmt.Sprint(state[1]), 64); err == nil {
+		st.LastRefill = time.Unix(0, int64(last*1e9))
+	}
+	if v, err := strconv.Atoi(fmt.Sprint(state[2])); err == nil {
+		st.Version = v
+	}
+	return st, nil
+}
+
+// refill computes the tokens in a bucket at now from its stored
+// [tokens, last] state, the same way the Lua scripts do.
+// A positive interval refills in discrete steps.
+func refill(state []any, capacity, rate float64, interval time.Duration, now time.Time) float64 {
+	tokens, err := strconv.ParseFloat(fmt.Sprint(state[0]), 64)
+	if err != nil {
+		// Nothing stored, so the bucket is full
+		return capacity
+	}
+	last, err := strconv.ParseFloat(fmt.Sprint(state[1]), 64)
+	if err != nil {
+		return tokens
+	}
+	elapsed := max(0, float64(now.UnixNano())/1e9-last)
+	if interval > 0 {
+		elapsed = math.Floor(elapsed/interval.Seconds()) * interval.Seconds()
+	}
+	return min(capacity, tokens+elapsed*rate)
+}
+
+// Reserve takes a token for key now and reports when it may be used.
+// Unlike Allow, it always succeeds while the cost fits in the bucket; the caller
+// waits for Reservation.Delay before acting or gives the token back with Cancel.
+func (l *TokenBucket) Reserve(ctx context.Context, key string) (*Reservation, error) {
+	return l.ReserveN(ctx, key, 1)
+}
+
+// ReserveN is like Reserve for a request costing n tokens.
+func (l *TokenBucket) ReserveN(ctx context.Context, key string, n int64) (*Reservation, error) {
+	if err := costError(AlgorithmTokenBucket, key, n); err != nil {
+		return nil, err
+	}
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	now := l.clock.Now()
+	ok, tokens, err := l.take(ctx, key, float64(n), now, true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// The cost exceeds the capacity and can never be satisfied
+		return &Reservation{}, nil
+	}
+
+	return &Reservation{
+		ok:    true,
+		clock: l.clock,
+		// A negative balance is paid back by the refill
+		timeToAct: now.Add(l.refillTime(-min(tokens, 0))),
+		cancel: func(ctx context.Context) error {
+			return l.refund(ctx, key, float64(n))
+		},
+	}, nil
+}
+
+// take runs the bucket script for a request costing n tokens.
+// It returns whether the tokens were taken and the tokens left in the bucket.
+func (l *TokenBucket) take(ctx context.Context, key string, n float64, now time.Time, reserve bool) (bool, float64, error) {
+	redisKey, err := l.key("bucket", key)
+	if err != nil {
+		return false, 0, err
+	}
+	if l.store != nil {
+		allowed, tokens, err := l.store.TakeTokens(ctx, redisKey, l.bucket(reserve), n, now)
+		if err != nil {
+			return false, 0, storeError(AlgorithmTokenBucket, key, err)
+		}
+		return allowed, tokens, nil
+	}
+	if l.noScripts {
+		return l.takeTx(ctx, key, n, now, reserve)
+	}
+	result, err := tokenBucketScript.Run(ctx, l.client, []string{redisKey}, l.scriptArgs(n, now, reserve)...).Slice()
+	if err != nil {
+		return false, 0, storeError(AlgorithmTokenBucket, key, err)
+	}
+	return parseBucketReply(ctx, key, result)
+}
+
+// takeTx is take for WithoutScripts: the same computation as
+// tokenBucketScript, made in Go between WATCH and MULTI/EXEC.
+func (l *TokenBucket) takeTx(ctx context.Context, key string, n float64, now time.Time, reserve bool) (bool, float64, error) {
+	redisKey, err := l.key("bucket", key)
+	if err != nil {
+		return false, 0, err
+	}
+	nowSeconds := seconds(now)
+	var allowed bool
+	var tokens float64
+	err = watch(ctx, l.client, func(tx *redis.Tx) error {
+		state, err := tx.HMGet(ctx, redisKey, "tokens", "last", "hits", "v").Result()
+		if err != nil {
+			return err
+		}
+		tokens = parseField(state[0], l.capacity)
+		last := parseField(state[1], nowSeconds)
+		hits := int64(parseField(state[2], 0))
+		version := int64(parseField(state[3], 0))
+		// The migrations of tokenBucketScript
+		if version < 1 && state[0] != nil && state[2] == nil {
+			minTTL, maxTTL := l.ttlBounds()
+			hits = int64(math.Ceil(maxTTL.Seconds() / max(minTTL.Seconds(), 1)))
+		}
+
+		elapsed := nowSeconds - last
+		if l.interval > 0 {
+			elapsed = math.Floor(elapsed/l.interval.Seconds()) * l.interval.Seconds()
+			last += elapsed
+		} else {
+			last = nowSeconds
+		}
+		tokens = min(l.capacity, tokens+elapsed*l.rate)
+
+		if n > l.capacity+l.debt || (tokens-n < -l.debt && !reserve) {
+			allowed = false
+			return nil
+		}
+		tokens -= n
+		hits++
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redisKey, "tokens", tokens, "last", last, "hits", hits)
+			if version < tokenBucketSchema {
+				pipe.HSet(ctx, redisKey, "v", tokenBucketSchema)
+			}
+			pipe.Expire(ctx, redisKey, l.keyTTL(tokens, hits))
+			return nil
+		})
+		allowed = true
+		return err
+	}, redisKey)
+	if err != nil {
+		return false, 0, storeError(AlgorithmTokenBucket, key, err)
+	}
+	return allowed, tokens, nil
+}
+
+// keyTTL returns the TTL tokenBucketScript gives a bucket left with tokens
+// after hits allowed requests.
+func (l *TokenBucket) keyTTL(tokens float64, hits int64) time.Duration {
+	minTTL, maxTTL := l.ttlBounds()
+	// Never expire before the bucket would have refilled completely,
+	// counted from last, which trails now by up to an interval
+	refill := (l.capacity - min(tokens, 0)) / l.rate
+	if l.interval > 0 {
+		iv := l.interval.Seconds()
+		refill = math.Ceil(refill/iv)*iv + iv
+	}
+	ttl := int64(maxTTL.Seconds())
+	if minTTL < maxTTL {
+		ttl = min(ttl, int64(minTTL.Seconds())*hits)
+	}
+	ttl = max(ttl, int64(math.Ceil(refill)))
+	if ceiling := int64(math.Ceil(l.ttl.Ceiling.Seconds())); ceiling > 0 {
+		ttl = min(ttl, ceiling)
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// bucket describes the bucket for a Store.
+func (l *TokenBucket) bucket(reserve bool) Bucket {
+	return Bucket{
+		Capacity: l.capacity,
+		Rate:     l.rate,
+		Interval: l.interval,
+		Debt:     l.debt,
+		Reserve:  reserve,
+		MaxIdle:  l.maxIdle,
+	}
+}
+
+// parseField parses a numeric hash field, or returns def if it is missing.
+func parseField(v any, def float64) float64 {
+	f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// ttlBounds returns the TTL range of tokenBucketScript, which scales the TTL
+// with traffic between them but never expires a key before the bucket has refilled.
+func (l *TokenBucket) ttlBounds() (minTTL, maxTTL time.Duration) {
+	minTTL, maxTTL = l.maxIdle, l.maxIdle
+	if l.ttl.Max > 0 {
+		minTTL, maxTTL = l.ttl.Max, l.ttl.Max
+		if l.ttl.Adaptive {
+			minTTL = l.ttl.Min
+		}
+	}
+	return minTTL, maxTTL
+}
+
+// scriptArgs returns the tokenBucketScript arguments for a request costing n.
+func (l *TokenBucket) scriptArgs(n float64, now time.Time, reserve bool) []any {
+	// Convert the current time to a float64 in seconds
+	nowSeconds := float64(now.UnixNano()) / 1e9
+
+	minTTL, maxTTL := l.ttlBounds()
+	reserveArg := 0
+	if reserve {
+		reserveArg = 1
+	}
+
+	return []any{l.capacity, l.rate, nowSeconds,
+		int64(minTTL.Seconds()), int64(maxTTL.Seconds()), tokenBucketSchema, n, reserveArg, l.debt, l.interval.Seconds(),
+		int64(math.Ceil(l.ttl.Ceiling.Seconds()))}
+}
+
+// parseBucketReply parses the reply of tokenBucketScript.
+func parseBucketReply(ctx context.Context, key string, result []any) (bool, float64, error) {
+	// Redis Lua returns {allowed, tokens, healed} with allowed and healed as int64 and tokens as a string
+	if len(result) != 3 {
+		return false, 0, replyError(AlgorithmTokenBucket, key, result)
+	}
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return false, 0, replyError(AlgorithmTokenBucket, key, result)
+	}
+	tokens, err := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+	if err != nil {
+		return false, 0, replyError(AlgorithmTokenBucket, key, result)
+	}
+	if result[2] == int64(1) {
+		stateHealed(ctx, AlgorithmTokenBucket, key)
+	}
+	return allowed == 1, tokens, nil
+}
+
+// Refund gives n tokens back to the bucket for key, e.g. when the
+// downstream call failed before doing any work. The bucket never
+// exceeds its capacity, so over-refunding is harmless. n must be positive.
+func (l *TokenBucket) Refund(ctx context.Context, key string, n int64) error {
+	if err := costError(AlgorithmTokenBucket, key, n); err != nil {
+		return err
+	}
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	return l.refund(ctx, key, float64(n))
+}
+
+// RefundCost is like Refund for a fractional cost taken with AllowCost.
+func (l *TokenBucket) RefundCost(ctx context.Context, key string, cost float64) error {
+	if err := costError(AlgorithmTokenBucket, key, cost); err != nil {
+		return err
+	}
+	l, err := l.forKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	return l.refund(ctx, key, cost)
+}
+
+// refund gives n tokens back to the bucket for key.
+func (l *TokenBucket) refund(ctx context.Context, key string, n float64) error {
+	redisKey, err := l.key("bucket", key)
+	if err != nil {
+		return err
+	}
+	if l.store != nil {
+		// A negative cost gives the tokens back
+		if _, _, err := l.store.TakeTokens(ctx, redisKey, l.bucket(true), -n, l.clock.Now()); err != nil {
+			return storeError(AlgorithmTokenBucket, key, err)
+		}
+		return nil
+	}
+	nowSeconds := float64(l.clock.Now().UnixNano()) / 1e9
+	if l.noScripts {
+		return l.refundTx(ctx, key, n, nowSeconds)
+	}
+	err = tokenBucketRefundScript.Run(ctx, l.client, []string{redisKey}, l.capacity, l.rate, nowSeconds, n, l.interval.Seconds()).Err()
+	if err != nil {
+		return storeError(AlgorithmTokenBucket, key, err)
+	}
+	return nil
+}
+
+// refundTx is refund for WithoutScripts, like tokenBucketRefundScript.
+func (l *TokenBucket) refundTx(ctx context.Context, key string, n, nowSeconds float64) error {
+	redisKey, err := l.key("bucket", key)
+	if err != nil {
+		return err
+	}
+	err = watch(ctx, l.client, func(tx *redis.Tx) error {
+		state, err := tx.HMGet(ctx, redisKey, "tokens", "last").Result()
+		if err != nil {
+			return err
+		}
+		if state[0] == nil {
+			// Nothing stored, so the bucket is already full
+			return nil
+		}
+		tokens := parseField(state[0], l.capacity)
+		last := parseField(state[1], nowSeconds)
+
+		elapsed := nowSeconds - last
+		if l.interval > 0 {
+			elapsed = math.Floor(elapsed/l.interval.Seconds()) * l.interval.Seconds()
+			last += elapsed
+		} else {
+			last = nowSeconds
+		}
+		tokens = min(l.capacity, tokens+elapsed*l.rate+n)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redisKey, "tokens", tokens, "last", last)
+			return nil
+		})
+		return err
+	}, redisKey)
+	if err != nil {
+		return storeError(AlgorithmTokenBucket, key, err)
+	}
+	return nil
+}
+
+// refillTime returns how long it takes to refill the given number of tokens.
+// With discrete refills it is rounded up to whole intervals, which is at most
+// one interval later than the exact time as the phase of the interval isn't known.
+func (l *TokenBucket) refillTime(tokens float64) time.Duration {
+	if l.interval > 0 && tokens > 0 {
+		intervals := math.Ceil(tokens / (l.rate * l.interval.Seconds()))
+		return time.Duration(intervals) * l.interval
+	}
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
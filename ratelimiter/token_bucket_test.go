@@ -0,0 +1,76 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// Reservations of no tokens or fewer would give tokens back.
+func TestTokenBucketReserveInvalidCost(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	l := NewTokenBucket(client, WithRate(1), WithBurst(2))
+
+	for _, n := range []int64{0, -5} {
+		if _, err := l.ReserveN(ctx, "k", n); !errors.Is(err, ErrInvalidCost) {
+			t.Errorf("ReserveN(%d) error = %v, want ErrInvalidCost", n, err)
+		}
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("invalid reservations wrote %v", keys)
+	}
+	for i, want := range []bool{true, true, false} {
+		if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed != want {
+			t.Fatalf("Allow %d = %+v, %v, want allowed %v", i, res, err, want)
+		}
+	}
+}
+
+// A refund of no tokens or fewer would be a hidden charge.
+func TestTokenBucketRefundInvalidCost(t *testing.T) {
+	ctx := context.Background()
+	_, client := newRedis(t)
+	l := NewTokenBucket(client, WithRate(1), WithBurst(2))
+
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int64{0, -5} {
+		if err := l.Refund(ctx, "k", n); !errors.Is(err, ErrInvalidCost) {
+			t.Errorf("Refund(%d) error = %v, want ErrInvalidCost", n, err)
+		}
+	}
+	for _, cost := range []float64{0, -0.5, math.NaN(), math.Inf(1)} {
+		if err := l.RefundCost(ctx, "k", cost); !errors.Is(err, ErrInvalidCost) {
+			t.Errorf("RefundCost(%v) error = %v, want ErrInvalidCost", cost, err)
+		}
+	}
+
+	if err := l.Refund(ctx, "k", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, true, false} {
+		if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed != want {
+			t.Fatalf("Allow %d after Refund = %+v, %v, want allowed %v", i, res, err, want)
+		}
+	}
+}
+
+// A cost of zero would report allowed without taking anything.
+func TestTokenBucketAllowCostInvalid(t *testing.T) {
+	ctx := context.Background()
+	_, client := newRedis(t)
+	l := NewTokenBucket(client, WithRate(1), WithBurst(2))
+
+	for _, cost := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if _, _, err := l.AllowCost(ctx, "k", cost); !errors.Is(err, ErrInvalidCost) {
+			t.Errorf("AllowCost(%v) error = %v, want ErrInvalidCost", cost, err)
+		}
+	}
+	res, tokens, err := l.AllowCost(ctx, "k", 0.5)
+	if err != nil || !res.Allowed || tokens < 1.49 || tokens > 1.51 {
+		t.Errorf("AllowCost(0.5) = %+v, %v, %v, want allowed with 1.5 tokens left", res, tokens, err)
+	}
+}
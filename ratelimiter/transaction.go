@@ -0,0 +1,28 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// txRetries bounds how often a transaction is retried when another client
+// modified its keys first.
+const txRetries = 16
+
+// errTxConflict is returned when a transaction kept conflicting.
+var errTxConflict = errors.New("ratelimiter: too many conflicting transactions")
+
+// watch runs fn with keys watched, retrying while another client modifies
+// them before fn's MULTI/EXEC commits, which is how WithoutScripts limiters
+// get the atomicity the Lua scripts provide.
+func watch(ctx context.Context, client redis.UniversalClient, fn func(tx *redis.Tx) error, keys ...string) error {
+	for range txRetries {
+		err := client.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return errTxConflict
+}
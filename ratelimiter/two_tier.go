@@ -0,0 +1,234 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TwoTier limiter
+// Decides requests against local in-process state and reconciles it with a
+// shared FixedWindow in the background, trading strict global accuracy for
+// Allow calls that don't wait on Redis. Each process admits requests from
+// the global remaining count it saw at its last sync; requests it admitted
+// since are sent to Redis in one increment by Run, or as soon as they reach
+// the drift bound, so the fleet as a whole exceeds the limit by at most the
+// drift bound per process.
+type TwoTier struct {
+	remote   *FixedWindow
+	interval time.Duration
+	maxDrift int64
+	config
+
+	mu   sync.Mutex
+	keys map[string]*tierState
+}
+
+// tierState is the local view of the window of one key.
+type tierState struct {
+	limit     int64
+	remaining int64 // global remaining count at the last sync
+	resetAt   time.Time
+	pending   int64 // admitted locally since the last sync
+}
+
+// NewTwoTier returns a limiter admitting requests locally against remote,
+// synced every interval by Run and whenever a key admitted maxDrift requests
+// since its last sync. The first request for a key is decided by remote.
+// Only the decision and clock options are used.
+// It panics if interval is not positive or maxDrift is less than 1.
+func NewTwoTier(remote *FixedWindow, interval time.Duration, maxDrift int64, opts ...Option) *TwoTier {
+	if interval <= 0 {
+		panic(fmt.Sprintf("ratelimiter: sync interval must be positive, got %s", interval))
+	}
+	if maxDrift < 1 {
+		panic(fmt.Sprintf("ratelimiter: max drift must be at least 1, got %d", maxDrift))
+	}
+	return &TwoTier{
+		remote:   remote,
+		interval: interval,
+		maxDrift: maxDrift,
+		config:   newConfig(opts),
+		keys:     make(map[string]*tierState),
+	}
+}
+
+// Allow implements Limiter.
+func (l *TwoTier) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN implements Limiter.
+// Only the first request for a key, and requests reaching the drift bound,
+// wait on Redis; if that fails the request is decided on local state.
+func (l *TwoTier) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := costError(AlgorithmTwoTier, key, n); err != nil {
+		return Result{}, err
+	}
+	l.mu.Lock()
+	st, ok := l.keys[key]
+	if ok && st.pending+n > l.maxDrift {
+		l.mu.Unlock()
+		// Errors leave the requests pending for the next sync
+		_ = l.syncKey(ctx, key)
+		l.mu.Lock()
+		// Reset may have dropped or replaced the state meanwhile
+		st, ok = l.keys[key]
+	}
+	if !ok {
+		l.mu.Unlock()
+		res, err := l.remote.allowN(ctx, key, n)
+		if err != nil {
+			return l.storeFailed(ctx, key, err)
+		}
+		l.mu.Lock()
+		if _, ok := l.keys[key]; !ok {
+			l.keys[key] = &tierState{limit: res.Limit, remaining: res.Remaining, resetAt: res.ResetAt}
+		}
+		l.mu.Unlock()
+		return l.decide(ctx, AlgorithmTwoTier, key, res), nil
+	}
+
+	now := l.clock.Now()
+	st.rollover(now, l.remote.window)
+	res := Result{Limit: st.limit, ResetAt: st.resetAt}
+	if st.pending+n <= st.remaining {
+		st.pending += n
+		res.Allowed = true
+	} else {
+		res.RetryAfter = max(0, st.resetAt.Sub(now))
+	}
+	res.Remaining = max(0, st.remaining-st.pending)
+	l.mu.Unlock()
+	return l.decide(ctx, AlgorithmTwoTier, key, res), nil
+}
+
+// Check reports the local state for key without counting a request.
+// Keys not seen yet are checked against remote.
+func (l *TwoTier) Check(ctx context.Context, key string) (Result, error) {
+	now := l.clock.Now()
+	l.mu.Lock()
+	st, ok := l.keys[key]
+	var view tierState
+	if ok {
+		st.rollover(now, l.remote.window)
+		view = *st
+	}
+	l.mu.Unlock()
+	if !ok {
+		return l.remote.Check(ctx, key)
+	}
+
+	res := Result{
+		Allowed:   view.pending < view.remaining,
+		Limit:     view.limit,
+		Remaining: max(0, view.remaining-view.pending),
+		ResetAt:   view.resetAt,
+	}
+	if !res.Allowed {
+		res.RetryAfter = max(0, view.resetAt.Sub(now))
+	}
+	return res, nil
+}
+
+// Reset clears key in Redis and drops the local state of this process.
+// Other processes keep their local state until their next sync.
+func (l *TwoTier) Reset(ctx context.Context, key string) error {
+	l.mu.Lock()
+	delete(l.keys, key)
+	l.mu.Unlock()
+	return l.remote.Reset(ctx, key)
+}
+
+// Run syncs every key with Redis every interval until ctx is done, e.g.
+// go limiter.Run(ctx). Sync errors are retried on the next tick.
+// It returns ctx.Err() after a final sync.
+func (l *TwoTier) Run(ctx context.Context) error {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Flush what was admitted since the last tick, without ctx
+			_ = l.Sync(context.WithoutCancel(ctx))
+			return ctx.Err()
+		case <-ticker.C:
+			_ = l.Sync(ctx)
+		}
+	}
+}
+
+// Sync sends the requests admitted locally to Redis and refreshes the local
+// view of every key, dropping keys whose window ended with nothing pending.
+func (l *TwoTier) Sync(ctx context.Context) error {
+	now := l.clock.Now()
+	l.mu.Lock()
+	keys := make([]string, 0, len(l.keys))
+	for key, st := range l.keys {
+		if st.pending == 0 && !now.Before(st.resetAt) {
+			delete(l.keys, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	l.mu.Unlock()
+
+	var errs []error
+	for _, key := range keys {
+		if err := l.syncKey(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncKey sends the requests pending for key to Redis and replaces the
+// local view with the global one. Requests admitted meanwhile stay pending.
+func (l *TwoTier) syncKey(ctx context.Context, key string) error {
+	l.mu.Lock()
+	st, ok := l.keys[key]
+	if !ok {
+		l.mu.Unlock()
+		return nil
+	}
+	pending := st.pending
+	st.pending = 0
+	l.mu.Unlock()
+
+	var res Result
+	var err error
+	if pending > 0 {
+		// FixedWindow counts requests even past the limit, so the whole
+		// batch is recorded whether or not it fits
+		res, err = l.remote.allowN(ctx, key, pending)
+	} else {
+		res, err = l.remote.Check(ctx, key)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if cur, ok := l.keys[key]; !ok || cur != st {
+		// Reset or Sync dropped the key meanwhile, so it starts over from Redis
+		return err
+	}
+	if err != nil {
+		st.pending += pending
+		return err
+	}
+	st.limit = res.Limit
+	st.remaining = res.Remaining
+	st.resetAt = res.ResetAt
+	return nil
+}
+
+// rollover starts a new window once the synced one has ended, assuming the
+// full limit until the next sync tells the global count.
+func (st *tierState) rollover(now time.Time, window time.Duration) {
+	if now.Before(st.resetAt) {
+		return
+	}
+	st.remaining = st.limit
+	st.resetAt = now.Add(window)
+}
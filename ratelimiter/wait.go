@@ -0,0 +1,50 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// minWaitDelay bounds how often Wait retries when a limiter
+// reports no RetryAfter for a denied request.
+const minWaitDelay = 10 * time.Millisecond
+
+// waitN blocks until l allows a request costing n for key or ctx is done.
+// Instead of polling, it sleeps for the RetryAfter reported by the limiter.
+// It gives up with ErrWouldExceedDeadline as soon as the total wait would
+// exceed maxDelay (if positive), measured on clock, or the ctx deadline.
+func waitN(ctx context.Context, l Limiter, clock Clock, key string, n int64, maxDelay time.Duration) error {
+	start := clock.Now()
+	for {
+		res, err := l.AllowN(ctx, key, n)
+		if err != nil {
+			return err
+		}
+		if res.Allowed {
+			return nil
+		}
+		// Limit is zero when no limit applies to the denial, e.g. a
+		// FailClosed store error or a penalty cooldown, so waiting may help
+		if res.Limit > 0 && n > res.Limit {
+			return fmt.Errorf("%w: cost %d exceeds limit %d", ErrLimitExceeded, n, res.Limit)
+		}
+
+		delay := max(res.RetryAfter, minWaitDelay)
+		if maxDelay > 0 && clock.Since(start)+delay > maxDelay {
+			return fmt.Errorf("%w: wait of %s exceeds max delay %s", ErrWouldExceedDeadline, delay, maxDelay)
+		}
+		// Context deadlines are set on the system clock
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return fmt.Errorf("%w: wait of %s exceeds context deadline", ErrWouldExceedDeadline, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Records when a key was first used, and forgets it once the key has been
+// idle for the warm-up period so a cold key warms up again.
+var warmupScript = newScript(`
+	local key = KEYS[1]
+	local now = tonumber(ARGV[1])
+	local period = tonumber(ARGV[2])
+
+	local first = redis.call('GET', key)
+	if not first then
+		first = now
+		redis.call('SET', key, now)
+	end
+	redis.call('PEXPIRE', key, period)
+	return first
+`)
+
+// Warmup is a LimitProvider that ramps a key's limit up after first use,
+// to protect cold caches and newly scaled backends from instant full-rate
+// traffic. The limit starts at a fraction of the base limit and grows
+// linearly to the full limit over the warm-up period. A key that has been
+// idle for longer than the period is considered cold and warms up again.
+//
+// The first use of a key is recorded under a key of kind "warmup", built
+// like limiter keys, so WithKeyPrefix, WithNamespace, WithHashTags and
+// WithKeyFunc apply; WithClock sets the clock the warm-up is measured on.
+// Other options are ignored.
+//
+// Use it with WithLimitProvider:
+//
+//	warm := ratelimiter.NewWarmup(rdb, ratelimiter.StaticLimit(limit), time.Minute, 0.1)
+//	limiter := ratelimiter.NewTokenBucket(rdb, ratelimiter.WithLimitProvider(warm))
+type Warmup struct {
+	client redis.UniversalClient
+	base   LimitProvider
+	period time.Duration
+	from   float64
+	cfg    config
+}
+
+// NewWarmup returns a provider scaling the limits of base from the fraction
+// from (e.g. 0.1 for 10%) to the full limit over period.
+// It panics if period is not positive or from is not between 0 and 1.
+func NewWarmup(client redis.UniversalClient, base LimitProvider, period time.Duration, from float64, opts ...Option) *Warmup {
+	if period < time.Millisecond {
+		panic(fmt.Sprintf("ratelimiter: warm-up period must be at least 1ms, got %s", period))
+	}
+	if !(from > 0 && from <= 1) {
+		panic(fmt.Sprintf("ratelimiter: warm-up fraction must be in (0, 1], got %v", from))
+	}
+	return &Warmup{
+		client: client,
+		base:   base,
+		period: period,
+		from:   from,
+		cfg:    newConfig(opts),
+	}
+}
+
+// SetClock replaces the clock used to measure the warm-up.
+func (w *Warmup) SetClock(clock Clock) {
+	w.cfg.clock = clock
+}
+
+// Limits implements LimitProvider.
+// Requests and Burst are scaled, so token buckets ramp up both their rate
+// and their capacity. They never drop below 1.
+func (w *Warmup) Limits(ctx context.Context, key string) (Limit, error) {
+	limit, err := w.base.Limits(ctx, key)
+	if err != nil {
+		return Limit{}, err
+	}
+	now := w.cfg.clock.Now()
+	redisKey, err := w.cfg.key("warmup", key)
+	if err != nil {
+		return Limit{}, err
+	}
+
+	first, err := warmupScript.Run(ctx, w.client, []string{redisKey},
+		now.UnixMilli(), w.period.Milliseconds()).Int64()
+	if err != nil {
+		return Limit{}, fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+
+	elapsed := now.Sub(time.UnixMilli(first))
+	if elapsed >= w.period {
+		return limit, nil
+	}
+	factor := w.from + (1-w.from)*max(0, elapsed.Seconds())/w.period.Seconds()
+	limit.Requests = scaleLimit(limit.Requests, factor)
+	if limit.Burst > 0 {
+		limit.Burst = scaleLimit(limit.Burst, factor)
+	}
+	return limit, nil
+}
+
+// scaleLimit returns n scaled by factor, rounded up and at least 1.
+func scaleLimit(n int64, factor float64) int64 {
+	return max(1, int64(math.Ceil(float64(n)*factor)))
+}
+
+// StaticLimit returns a LimitProvider giving every key the same limit,
+// e.g. as the base of a Warmup.
+func StaticLimit(limit Limit) LimitProvider {
+	return LimitProviderFunc(func(context.Context, string) (Limit, error) {
+		return limit, nil
+	})
+}
@@ -0,0 +1,53 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// The first use of a key is recorded under a key built like limiter keys.
+func TestWarmupKeys(t *testing.T) {
+	ctx := context.Background()
+	base := StaticLimit(Limit{Requests: 100, Window: time.Second})
+	for name, tc := range map[string]struct {
+		opts []Option
+		want string
+	}{
+		"default":   {nil, "warmup:user:1"},
+		"namespace": {[]Option{WithNamespace("billing", "prod")}, "billing:prod:warmup:user:1"},
+		"hash tags": {[]Option{WithKeyPrefix("api"), WithHashTags()}, "api:warmup:{user:1}"},
+		"key func":  {[]Option{WithKeyFunc(func(kind, key string) string { return key + "/" + kind })}, "user:1/warmup"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mr, client := newRedis(t)
+			clock := newFakeClock()
+			w := NewWarmup(client, base, time.Minute, 0.1, append(tc.opts, WithClock(clock))...)
+
+			limit, err := w.Limits(ctx, "user:1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limit.Requests != 10 {
+				t.Errorf("Requests on first use = %d, want 10", limit.Requests)
+			}
+			if keys := mr.Keys(); len(keys) != 1 || keys[0] != tc.want {
+				t.Errorf("keys = %v, want [%s]", keys, tc.want)
+			}
+
+			clock.Advance(30 * time.Second)
+			if limit, err := w.Limits(ctx, "user:1"); err != nil || limit.Requests < 55 || limit.Requests > 56 {
+				t.Errorf("Limits halfway = %+v, %v, want 55%% of 100 requests", limit, err)
+			}
+		})
+	}
+}
+
+func TestWarmupInvalidKey(t *testing.T) {
+	_, client := newRedis(t)
+	w := NewWarmup(client, StaticLimit(Limit{Requests: 10, Window: time.Second}), time.Minute, 0.5, WithHashTags())
+	if _, err := w.Limits(context.Background(), "{user}"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Limits error = %v, want ErrInvalidKey", err)
+	}
+}